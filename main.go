@@ -1,21 +1,81 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/plugins"
 
 	"huawei.com/wasm-task-driver/wasm"
+	"huawei.com/wasm-task-driver/wasm/engines/wasmtime"
 
 	_ "huawei.com/wasm-task-driver/wasm/engines/wasmedge"
-	_ "huawei.com/wasm-task-driver/wasm/engines/wasmtime"
+	_ "huawei.com/wasm-task-driver/wasm/loaders/local"
 )
 
+// precompileCommand is the subcommand compiling modules into the disk cache
+// instead of serving the plugin.
+const precompileCommand = "precompile"
+
+// driver is the plugin instance created by factory.
+var driver interface{}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == precompileCommand {
+		os.Exit(precompile(os.Args[2:]))
+	}
+
 	// Serve the plugin
 	plugins.Serve(factory)
+
+	// Serve returns once the Nomad client asks the plugin to exit, which leaves
+	// a short window to stop the running tasks cleanly.
+	if d, ok := driver.(interface{ Shutdown() }); ok {
+		d.Shutdown()
+	}
 }
 
 // factory returns a new instance of a nomad driver plugin.
 func factory(log hclog.Logger) interface{} {
-	return wasm.NewPlugin(log)
+	driver = wasm.NewPlugin(log)
+
+	return driver
+}
+
+// precompile compiles the modules of a directory into the wasmtime disk cache,
+// so caches are warmed during deployment. It returns the process exit code.
+func precompile(args []string) int {
+	flags := flag.NewFlagSet(precompileCommand, flag.ContinueOnError)
+	diskMaxSize := flags.Int64("disk-max-size", 1073741824,
+		"total size in bytes of the disk cache entries above which the least recently used ones are removed")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] <modules dir> <disk path>\n", os.Args[0], precompileCommand)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+
+		return 2
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{Name: precompileCommand, Output: os.Stderr})
+
+	compiled, err := wasmtime.Precompile(logger, flags.Arg(0), flags.Arg(1), *diskMaxSize)
+	if err != nil {
+		logger.Error("unable to precompile WASM modules", "error", hclog.Fmt("%+v", err))
+
+		return 1
+	}
+
+	logger.Info("precompiled WASM modules", "modules", compiled, "dir", flags.Arg(1))
+
+	return 0
 }
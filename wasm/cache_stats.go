@@ -0,0 +1,181 @@
+package wasm
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// evictionStats counts modules cache evictions by reason. gcache reports
+// capacity evictions, expired entries and removed entries through the same
+// eviction callback, so the time each entry was added is tracked to tell
+// expired entries apart, and removed entries are marked by statsCache.
+type evictionStats struct {
+	addedAt    map[interface{}]time.Time
+	removed    map[interface{}]struct{}
+	ttl        time.Duration
+	capacity   atomic.Uint64
+	expiration atomic.Uint64
+	lock       sync.Mutex
+}
+
+func newEvictionStats(ttl time.Duration) *evictionStats {
+	return &evictionStats{
+		addedAt: make(map[interface{}]time.Time),
+		removed: make(map[interface{}]struct{}),
+		ttl:     ttl,
+	}
+}
+
+// setRemoving marks the entry as being removed, so it isn't counted when
+// gcache reports it as evicted.
+func (s *evictionStats) setRemoving(key interface{}, removing bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if removing {
+		s.removed[key] = struct{}{}
+	} else {
+		delete(s.removed, key)
+	}
+}
+
+// onAdded is used as gcache added callback.
+func (s *evictionStats) onAdded(key, _value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.addedAt[key] = time.Now()
+}
+
+// onEvicted is used as gcache evicted callback.
+func (s *evictionStats) onEvicted(key, _value interface{}) {
+	s.lock.Lock()
+	addedAt, ok := s.addedAt[key]
+	delete(s.addedAt, key)
+	_, removed := s.removed[key]
+	s.lock.Unlock()
+
+	if removed {
+		return
+	}
+
+	if ok && s.ttl > 0 && time.Since(addedAt) >= s.ttl {
+		s.expiration.Add(1)
+
+		return
+	}
+
+	s.capacity.Add(1)
+}
+
+// CapacityEvictions returns number of entries evicted due to cache size.
+func (s *evictionStats) CapacityEvictions() uint64 {
+	return s.capacity.Load()
+}
+
+// ExpirationEvictions returns number of entries removed after their TTL expired.
+func (s *evictionStats) ExpirationEvictions() uint64 {
+	return s.expiration.Load()
+}
+
+// statsCache is the modules cache passed to engines. It keeps entries removed
+// by engines, e.g. when VerifyCache drops corrupted modules, from being
+// counted as evictions, and counts hits and misses of Get only. Engines look
+// a module up with Get once per instantiation and check the cache again with
+// Has and GetIFPresent before compiling a missed module, which gcache would
+// count as another lookup.
+type statsCache struct {
+	gcache.Cache
+	evictions *evictionStats
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+}
+
+// Get gets the entry, counting the lookup as a hit or a miss.
+func (c *statsCache) Get(key interface{}) (interface{}, error) {
+	value, err := c.Cache.Get(key)
+
+	switch {
+	case err == nil:
+		c.hits.Add(1)
+	case errors.Is(err, gcache.KeyNotFoundError):
+		c.misses.Add(1)
+	}
+
+	return value, err
+}
+
+// HitCount returns the number of Get lookups served from the cache.
+func (c *statsCache) HitCount() uint64 {
+	return c.hits.Load()
+}
+
+// MissCount returns the number of Get lookups which missed the cache.
+func (c *statsCache) MissCount() uint64 {
+	return c.misses.Load()
+}
+
+// LookupCount returns the number of Get lookups.
+func (c *statsCache) LookupCount() uint64 {
+	return c.HitCount() + c.MissCount()
+}
+
+// HitRate returns the share of Get lookups served from the cache.
+func (c *statsCache) HitRate() float64 {
+	hits, lookups := c.HitCount(), c.LookupCount()
+	if lookups == 0 {
+		return 0
+	}
+
+	return float64(hits) / float64(lookups)
+}
+
+// Remove removes the entry without counting it as evicted.
+func (c *statsCache) Remove(key interface{}) bool {
+	c.evictions.setRemoving(key, true)
+	defer c.evictions.setRemoving(key, false)
+
+	return c.Cache.Remove(key)
+}
+
+// hitStats reports the modules cache hit rate over the lookups since it was
+// last sampled, so it follows the recent cache effectiveness rather than the
+// whole cache lifetime. It isn't safe for concurrent sampling.
+type hitStats struct {
+	cache      gcache.Cache
+	lastHits   uint64
+	lastMisses uint64
+	rate       float64
+}
+
+func newHitStats(cache gcache.Cache) *hitStats {
+	return &hitStats{cache: cache}
+}
+
+// Hits returns the number of lookups served from the cache.
+func (s *hitStats) Hits() uint64 {
+	return s.cache.HitCount()
+}
+
+// Misses returns the number of lookups which missed the cache.
+func (s *hitStats) Misses() uint64 {
+	return s.cache.MissCount()
+}
+
+// SampleHitRate returns the share of lookups served from the cache since the
+// previous sample, or the previous rate if there were no lookups since.
+func (s *hitStats) SampleHitRate() float64 {
+	hits, misses := s.cache.HitCount(), s.cache.MissCount()
+
+	if lookups := hits - s.lastHits + misses - s.lastMisses; lookups > 0 {
+		s.rate = float64(hits-s.lastHits) / float64(lookups)
+	}
+
+	s.lastHits, s.lastMisses = hits, misses
+
+	return s.rate
+}
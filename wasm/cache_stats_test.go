@@ -0,0 +1,93 @@
+package wasm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictionStatsByReason(t *testing.T) {
+	stats := newEvictionStats(time.Second)
+
+	cache, err := buildCache(CacheConfig{
+		Type:       "lru",
+		Size:       1,
+		Expiration: ExpirationConfig{Enabled: true, EntryTTL: 1},
+	}, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removed entries aren't evicted by any cache policy.
+	if err := cache.Set("removed", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Remove("removed")
+
+	if stats.CapacityEvictions() != 0 || stats.ExpirationEvictions() != 0 {
+		t.Fatalf("removed entry counted as evicted: capacity %d, expiration %d", stats.CapacityEvictions(),
+			stats.ExpirationEvictions())
+	}
+
+	if err := cache.Set("first", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Set("second", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.CapacityEvictions() != 1 || stats.ExpirationEvictions() != 0 {
+		t.Fatalf("expected a capacity eviction, but got capacity %d, expiration %d", stats.CapacityEvictions(),
+			stats.ExpirationEvictions())
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, err := cache.Get("second"); err == nil {
+		t.Fatal("expected the entry to expire")
+	}
+
+	if stats.CapacityEvictions() != 1 || stats.ExpirationEvictions() != 1 {
+		t.Fatalf("expected an expiration eviction, but got capacity %d, expiration %d", stats.CapacityEvictions(),
+			stats.ExpirationEvictions())
+	}
+}
+
+func TestHitStatsCountGetLookupsOnly(t *testing.T) {
+	cache, err := buildCache(CacheConfig{Type: "lru", Size: 5}, newEvictionStats(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := newHitStats(cache)
+
+	// An engine missing the module checks the cache again before compiling it.
+	if _, err = cache.Get("module"); err == nil {
+		t.Fatal("expected a miss")
+	}
+
+	if cache.Has("module") {
+		t.Fatal("expected the module not to be cached")
+	}
+
+	if err = cache.Set("module", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.GetIFPresent("module"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.Get("module"); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Hits() != 1 || stats.Misses() != 1 {
+		t.Errorf("expected 1 hit and 1 miss, but got %d hits and %d misses", stats.Hits(), stats.Misses())
+	}
+
+	if rate := stats.SampleHitRate(); rate != 0.5 {
+		t.Errorf("expected hit rate 0.5, but got %v", rate)
+	}
+}
@@ -0,0 +1,89 @@
+package wasm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// configWarnings returns the likely mistakes in the task configuration which
+// don't keep the task from running, like settings which have no effect. They
+// are collected in a single pass, so operators see all of them at once.
+func configWarnings(driverConfig TaskConfig, instance interfaces.WasmInstance) []string {
+	var warnings []string
+
+	if ioBuffer := driverConfig.IOBuffer; !ioBuffer.Enabled {
+		if ioBuffer.InputValue != "" {
+			warnings = append(warnings, "ioBuffer.inputValue is set, but ioBuffer is disabled")
+		}
+
+		if ioBuffer.ProcessFuncName != "" {
+			warnings = append(warnings, "ioBuffer.processFuncName is set, but ioBuffer is disabled")
+		}
+
+		if len(ioBuffer.Args) > 0 {
+			warnings = append(warnings, "ioBuffer.args are set, but ioBuffer is disabled")
+		}
+
+		if ioBuffer.OutputEvent {
+			warnings = append(warnings, "ioBuffer.outputEvent is set, but ioBuffer is disabled")
+		}
+	}
+
+	if wasi := driverConfig.Wasi; !wasi.Enabled {
+		if len(wasi.PreopenDirs) > 0 {
+			warnings = append(warnings, "wasi.preopenDirs are set, but wasi is disabled")
+		}
+
+		if wasi.StderrTailLines > 0 {
+			warnings = append(warnings, "wasi.stderrTailLines is set, but wasi is disabled")
+		}
+
+		if wasi.StdinPipe != "" || wasi.StdoutPipe != "" {
+			warnings = append(warnings, "wasi pipes are set, but wasi is disabled")
+		}
+	}
+
+	// The memory limit is taken from the task memory resource if it isn't set.
+	if memory := driverConfig.Memory; memory.LimitMB == 0 && memory.OvercommitMB > 0 {
+		warnings = append(warnings, "memory.overcommitMB is set, but module memory is unlimited")
+	}
+
+	// With the IO buffer enabled the buffer address and length are passed
+	// before the main arguments.
+	if mainFunc := driverConfig.Main; !driverConfig.IOBuffer.Enabled && len(mainFunc.Args) > 0 {
+		params, err := instance.FuncParams(mainFunc.MainFuncName)
+		if err == nil && len(params) == 0 {
+			warnings = append(warnings, fmt.Sprintf("main.args are set, but %s function takes no parameters",
+				mainFunc.MainFuncName))
+		}
+	}
+
+	return warnings
+}
+
+// emitConfigWarnings logs the task configuration warnings and emits a task
+// event for each of them.
+func (d *WasmTaskDriverPlugin) emitConfigWarnings(logger hclog.Logger, cfg *drivers.TaskConfig, warnings []string) {
+	for _, warning := range warnings {
+		logger.Warn("task configuration warning", "warning", warning)
+
+		err := d.eventer.EmitEvent(&drivers.TaskEvent{
+			TaskID:    cfg.ID,
+			AllocID:   cfg.AllocID,
+			TaskName:  cfg.Name,
+			Timestamp: time.Now(),
+			Message:   "Configuration warning: " + warning,
+			Annotations: map[string]string{
+				"warning": warning,
+			},
+		})
+		if err != nil {
+			logger.Warn("unable to emit configuration warning event", "error", hclog.Fmt("%+v", err))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package wasm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConfigWarningsEmitted(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", blockUntilStopped())
+	})
+
+	cfg := newTestTask(t, "warnings", `engine = "fake"
+ioBuffer {
+  inputValue = "unused"
+}
+main {
+  args = [1]
+}
+wasi {
+  preopenDirs {
+    hostPath  = "data"
+    guestPath = "/data"
+  }
+}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events are emitted while the task starts, so they are read meanwhile.
+	warnings := make(chan string, 10)
+
+	go func() {
+		for event := range events {
+			if event.TaskID != cfg.ID {
+				continue
+			}
+
+			select {
+			case warnings <- event.Annotations["warning"]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if _, _, err = d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	// The contradictory settings don't keep the task from running, they are
+	// all reported at once.
+	expected := map[string]bool{
+		"ioBuffer.inputValue is set, but ioBuffer is disabled":              true,
+		"wasi.preopenDirs are set, but wasi is disabled":                    true,
+		"main.args are set, but handle_buffer function takes no parameters": true,
+	}
+
+	for len(expected) > 0 {
+		select {
+		case warning := <-warnings:
+			if !expected[warning] {
+				t.Errorf("unexpected warning %q", warning)
+			}
+
+			delete(expected, warning)
+		case <-ctx.Done():
+			t.Fatalf("expected warnings weren't emitted: %v", expected)
+		}
+	}
+
+	if handle, ok := d.tasks.Get(cfg.ID); !ok || !handle.IsRunning() {
+		t.Error("expected the task to keep running")
+	}
+
+	if err = d.DestroyTask(cfg.ID, true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,37 @@
+package wasm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+const (
+	// correlationIDMetaKey is the job meta key a correlation ID is taken from.
+	correlationIDMetaKey = "correlation_id"
+
+	// correlationIDEnvVar is the WASI environment variable exposing the
+	// correlation ID to the module.
+	correlationIDEnvVar = "CORRELATION_ID"
+)
+
+// taskCorrelationID returns the correlation ID of the task, which ties the
+// task logs, events and results to the rest of a pipeline. It is taken from
+// the correlation_id meta of the job or generated if not set.
+func taskCorrelationID(cfg *drivers.TaskConfig) (string, error) {
+	// Nomad exposes meta keys both as is and upper cased.
+	for _, key := range []string{correlationIDMetaKey, strings.ToUpper(correlationIDMetaKey)} {
+		if id := cfg.Env["NOMAD_META_"+key]; id != "" {
+			return id, nil
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
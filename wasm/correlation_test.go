@@ -0,0 +1,70 @@
+package wasm
+
+import (
+	"testing"
+)
+
+// readCorrelationID returns a function sending the correlation ID it reads
+// through the host import to ids.
+func readCorrelationID(ids chan<- string) fakeFunc {
+	return func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+		results, err := instance.callHost("correlation_id", int32(0), int32(64))
+		if err != nil {
+			return nil, err
+		}
+
+		ids <- string(instance.memory[:results[0].(int32)])
+
+		return int32(0), nil
+	}
+}
+
+func TestCorrelationIDInLogsAndHostImport(t *testing.T) {
+	logs := &lockedBuffer{}
+	d := newTestDriver(t, testPluginConfig, logs)
+
+	ids := make(chan string, 2)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", readCorrelationID(ids))
+	})
+
+	taskConfig := `engine = "fake"
+hostImports {
+  enabled = true
+}`
+
+	cfg := newTestTask(t, "meta", taskConfig)
+	cfg.Env = map[string]string{"NOMAD_META_correlation_id": "pipeline-42"}
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	if id := <-ids; id != "pipeline-42" {
+		t.Errorf("expected the module to read correlation ID pipeline-42, but got %q", id)
+	}
+
+	// Tasks without the meta get a generated ID.
+	generated := newTestTask(t, "generated", taskConfig)
+
+	if result := runTask(t, d, generated); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	generatedID := <-ids
+	if len(generatedID) != 32 {
+		t.Errorf("expected a generated correlation ID, but got %q", generatedID)
+	}
+
+	records := logRecords(t, logs, "task finished")
+	if len(records) != 2 {
+		t.Fatalf("expected 2 task summaries, but got %v", records)
+	}
+
+	for i, expected := range []string{"pipeline-42", generatedID} {
+		if id := records[i]["correlation_id"]; id != expected {
+			t.Errorf("expected task logs to carry correlation ID %s, but got %v", expected, id)
+		}
+	}
+}
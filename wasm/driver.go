@@ -2,20 +2,37 @@ package wasm
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluele/gcache"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/nomad/drivers/shared/eventer"
 	"github.com/hashicorp/nomad/plugins/base"
 	"github.com/hashicorp/nomad/plugins/device"
 	"github.com/hashicorp/nomad/plugins/drivers"
 	"github.com/hashicorp/nomad/plugins/shared/hclspec"
 	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"golang.org/x/sync/singleflight"
 	"huawei.com/wasm-task-driver/wasm/engines"
+	"huawei.com/wasm-task-driver/wasm/hostimports"
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+	"huawei.com/wasm-task-driver/wasm/loaders"
+	"huawei.com/wasm-task-driver/wasm/modinfo"
 )
 
 const (
@@ -40,9 +57,85 @@ const (
 	// this is used to allow modification and migration of the task schema
 	// used by the plugin.
 	taskHandleVersion = 1
+
+	// wasmPageSize is the size of a WASM linear memory page in bytes.
+	wasmPageSize = 65536
+
+	// reactorInitFuncName is the function WASI reactor modules export to be
+	// initialized before any other export is called.
+	reactorInitFuncName = "_initialize"
+
+	// commandStartFuncName is the function WASI command modules export as
+	// their entrypoint.
+	commandStartFuncName = "_start"
+
+	// moduleSourceLocal is the source of modules loaded from the local file
+	// system.
+	moduleSourceLocal = "local"
+
+	// maxMemoryPages is the maximum number of pages of a 32-bit WASM memory.
+	maxMemoryPages = 65536
+
+	// ioBufferOverflowError fails the task when the input doesn't fit the IO buffer.
+	ioBufferOverflowError = "error"
+	// ioBufferOverflowTruncate writes as much of the input as fits the IO buffer.
+	ioBufferOverflowTruncate = "truncate"
+
+	// ioBufferNoOutputError fails the task when the module doesn't write a result
+	// to the IO buffer.
+	ioBufferNoOutputError = "error"
+	// ioBufferNoOutputEmpty completes the task with an empty output when the
+	// module doesn't write a result to the IO buffer.
+	ioBufferNoOutputEmpty = "empty"
+
+	// ioBufferOutputSizeResult reads as many bytes of the IO buffer as the main
+	// function returns.
+	ioBufferOutputSizeResult = "result"
+	// ioBufferOutputSizeBuffer reads the whole IO buffer without its trailing
+	// zeros, for modules whose main function doesn't return the output size.
+	ioBufferOutputSizeBuffer = "buffer"
+
+	// logWriteErrorDiscard discards the output the task log can't take and
+	// completes the task.
+	logWriteErrorDiscard = "discard"
+	// logWriteErrorFail fails the task when its output can't be written to the
+	// task log.
+	logWriteErrorFail = "fail"
+
+	// concurrentExecSerialize runs an exec command calling a function once the
+	// running one finishes.
+	concurrentExecSerialize = "serialize"
+	// concurrentExecReject fails an exec command calling a function while
+	// another one runs.
+	concurrentExecReject = "reject"
+
+	// outputEventMaxBytes bounds the IO buffer output annotated to the output
+	// task event.
+	outputEventMaxBytes = 1024
+
+	// defaultStopSignal is the signal tasks are stopped with if their kill
+	// signal isn't set, like Nomad does for process based drivers.
+	defaultStopSignal = "SIGINT"
+
+	// destroyWaitTimeout bounds how long a forced DestroyTask, or StopTask
+	// past the kill timeout, waits for the interrupted module to return. The
+	// task ID stays reserved until it does.
+	destroyWaitTimeout = 5 * time.Second
 )
 
 var (
+	// stopSignalNumbers maps the signals tasks can be stopped with to the
+	// numbers modules read through the stop_signal host import.
+	stopSignalNumbers = map[string]int32{
+		"SIGHUP":  1,
+		"SIGINT":  2,
+		"SIGQUIT": 3,
+		"SIGKILL": 9,
+		"SIGUSR1": 10,
+		"SIGUSR2": 12,
+		"SIGTERM": 15,
+	}
+
 	// pluginInfo describes the plugin.
 	pluginInfo = &base.PluginInfoResponse{
 		Type:              base.PluginTypeDriver,
@@ -86,6 +179,15 @@ var (
 		//            enabled = true
 		//         }
 		//       ]
+		//       statsd {
+		//         address = "127.0.0.1:8125"
+		//       }
+		//       resultDatabase {
+		//         dsn = "postgres://nomad@db.local/audit"
+		//       }
+		//       resultSink {
+		//         allowedURLs = ["http://127.0.0.1:8080/results"]
+		//       }
 		//     }
 		//   }
 		"engines": hclspec.NewBlockList("engines", hclspec.NewObject(map[string]*hclspec.Spec{
@@ -94,6 +196,18 @@ var (
 				hclspec.NewAttr("enabled", "bool", false),
 				hclspec.NewLiteral(`true`),
 			),
+			"lazyInit": hclspec.NewDefault(
+				hclspec.NewAttr("lazyInit", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"statistics": hclspec.NewDefault(
+				hclspec.NewAttr("statistics", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"minVersion": hclspec.NewDefault(
+				hclspec.NewAttr("minVersion", "string", false),
+				hclspec.NewLiteral(`""`),
+			),
 			"cache": hclspec.NewDefault(hclspec.NewBlock("cache", false, hclspec.NewObject(map[string]*hclspec.Spec{
 				"enabled": hclspec.NewDefault(
 					hclspec.NewAttr("enabled", "bool", false),
@@ -107,6 +221,22 @@ var (
 					hclspec.NewAttr("size", "number", false),
 					hclspec.NewLiteral(`5`),
 				),
+				"keyStrategy": hclspec.NewDefault(
+					hclspec.NewAttr("keyStrategy", "string", false),
+					hclspec.NewLiteral(`"content"`),
+				),
+				"maxModuleSize": hclspec.NewDefault(
+					hclspec.NewAttr("maxModuleSize", "number", false),
+					hclspec.NewLiteral(`0`),
+				),
+				"diskPath": hclspec.NewDefault(
+					hclspec.NewAttr("diskPath", "string", false),
+					hclspec.NewLiteral(`""`),
+				),
+				"diskMaxSize": hclspec.NewDefault(
+					hclspec.NewAttr("diskMaxSize", "number", false),
+					hclspec.NewLiteral(`1073741824`),
+				),
 				"expiration": hclspec.NewDefault(hclspec.NewBlock("expiration", false, hclspec.NewObject(map[string]*hclspec.Spec{
 					"enabled": hclspec.NewDefault(
 						hclspec.NewAttr("enabled", "bool", false),
@@ -131,10 +261,30 @@ var (
 						hclspec.NewAttr("modulesDir", "string", false),
 						hclspec.NewLiteral(`""`),
 					),
+					"onError": hclspec.NewDefault(
+						hclspec.NewAttr("onError", "string", false),
+						hclspec.NewLiteral(`"fail"`),
+					),
+					"retries": hclspec.NewDefault(
+						hclspec.NewAttr("retries", "number", false),
+						hclspec.NewLiteral(`3`),
+					),
+					"retryBackoff": hclspec.NewDefault(
+						hclspec.NewAttr("retryBackoff", "number", false),
+						hclspec.NewLiteral(`1`),
+					),
+					"healthCheck": hclspec.NewDefault(
+						hclspec.NewAttr("healthCheck", "bool", false),
+						hclspec.NewLiteral(`false`),
+					),
 				})),
 					hclspec.NewLiteral(`{
 							enabled = false
 							modulesDir = ""
+							onError = "fail"
+							retries = 3
+							retryBackoff = 1
+							healthCheck = false
 					}`),
 				),
 			})),
@@ -142,6 +292,10 @@ var (
 						enabled = true
 						type = "lfu"
 						size = 5
+						keyStrategy = "content"
+						maxModuleSize = 0
+						diskPath = ""
+						diskMaxSize = 1073741824
 						expiration = {
 							enabled = true
 							entryTTL = 600
@@ -149,10 +303,105 @@ var (
 						preCache = {
 							enabled = false
 							modulesDir = ""
+							onError = "fail"
+							retries = 3
+							retryBackoff = 1
+							healthCheck = false
 						}
 				}`),
 			),
 		})),
+		"shutdown": hclspec.NewDefault(hclspec.NewBlock("shutdown", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"taskTimeout": hclspec.NewDefault(
+				hclspec.NewAttr("taskTimeout", "number", false),
+				hclspec.NewLiteral(`1`),
+			),
+			"flushTimeout": hclspec.NewDefault(
+				hclspec.NewAttr("flushTimeout", "number", false),
+				hclspec.NewLiteral(`1`),
+			),
+		})),
+			hclspec.NewLiteral(`{
+				taskTimeout = 1
+				flushTimeout = 1
+			}`),
+		),
+		"debug": hclspec.NewDefault(hclspec.NewBlock("debug", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"memdump": hclspec.NewDefault(
+				hclspec.NewAttr("memdump", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"maxMemdumpBytes": hclspec.NewDefault(
+				hclspec.NewAttr("maxMemdumpBytes", "number", false),
+				hclspec.NewLiteral(`65536`),
+			),
+		})),
+			hclspec.NewLiteral(`{
+				memdump = false
+				maxMemdumpBytes = 65536
+			}`),
+		),
+		"fuelBudget": hclspec.NewDefault(hclspec.NewBlock("fuelBudget", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"total": hclspec.NewDefault(
+				hclspec.NewAttr("total", "string", false),
+				hclspec.NewLiteral(`0`),
+			),
+		})),
+			hclspec.NewLiteral(`{ total = 0 }`),
+		),
+		"statsd": hclspec.NewBlock("statsd", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"address": hclspec.NewAttr("address", "string", true),
+			"prefix": hclspec.NewDefault(
+				hclspec.NewAttr("prefix", "string", false),
+				hclspec.NewLiteral(`"wasm_task_driver"`),
+			),
+			"flushInterval": hclspec.NewDefault(
+				hclspec.NewAttr("flushInterval", "number", false),
+				hclspec.NewLiteral(`1`),
+			),
+		})),
+		"wasi": hclspec.NewDefault(hclspec.NewBlock("wasi", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"allowedPreopenDirs": hclspec.NewAttr("allowedPreopenDirs", "list(string)", false),
+			"defaultPreopenDirs": hclspec.NewBlockList("defaultPreopenDirs", hclspec.NewObject(map[string]*hclspec.Spec{
+				"hostPath":  hclspec.NewAttr("hostPath", "string", true),
+				"guestPath": hclspec.NewAttr("guestPath", "string", true),
+			})),
+		})),
+			hclspec.NewLiteral(`{}`),
+		),
+		"resultSink": hclspec.NewDefault(hclspec.NewBlock("resultSink", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"allowedURLs": hclspec.NewAttr("allowedURLs", "list(string)", false),
+		})),
+			hclspec.NewLiteral(`{}`),
+		),
+		"moduleFilePolicy": hclspec.NewDefault(hclspec.NewBlock("moduleFilePolicy", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"enabled": hclspec.NewDefault(
+				hclspec.NewAttr("enabled", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"allowedOwners": hclspec.NewAttr("allowedOwners", "list(number)", false),
+			"allowWorldWritable": hclspec.NewDefault(
+				hclspec.NewAttr("allowWorldWritable", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"allowGroupWritable": hclspec.NewDefault(
+				hclspec.NewAttr("allowGroupWritable", "bool", false),
+				hclspec.NewLiteral(`true`),
+			),
+		})),
+			hclspec.NewLiteral(`{ enabled = false }`),
+		),
+		"resultDatabase": hclspec.NewBlock("resultDatabase", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"driver": hclspec.NewDefault(
+				hclspec.NewAttr("driver", "string", false),
+				hclspec.NewLiteral(`"postgres"`),
+			),
+			"dsn": hclspec.NewAttr("dsn", "string", true),
+			"table": hclspec.NewDefault(
+				hclspec.NewAttr("table", "string", false),
+				hclspec.NewLiteral(`"task_results"`),
+			),
+		})),
 	})
 
 	// taskConfigSpec is the specification of the plugin's configuration for
@@ -171,6 +420,7 @@ var (
 		//         driver = "wasm-task-driver"
 		//         config {
 		//           engine = "wasmtime"
+		//           fallbackEngine = "wasmedge"
 		//           modulePath = "/absolute/path/to/wasm/module"
 		//           ioBuffer {
 		//             enabled = false
@@ -178,12 +428,45 @@ var (
 		//           main {
 		//             mainFuncName = "handle_buffer"
 		//           }
+		//           memory {
+		//             initialPages = 0
+		//           }
+		//           hooks {
+		//             enabled = false
+		//           }
+		//           hostImports {
+		//             enabled = false
+		//           }
+		//           alerts {
+		//             enabled = false
+		//           }
+		//           eventLog {
+		//             enabled = false
+		//           }
+		//           timeouts {
+		//             initTimeout = 0
+		//             runTimeout = 0
+		//             postTimeout = 0
+		//           }
+		//           resultSink {
+		//             url = "http://127.0.0.1:8080/results"
+		//           }
 		//         }
 		//       }
 		//     }
 		//   }
-		"engine":     hclspec.NewAttr("engine", "string", true),
-		"modulePath": hclspec.NewAttr("modulePath", "string", true),
+		"engine": hclspec.NewDefault(
+			hclspec.NewAttr("engine", "string", false),
+			hclspec.NewLiteral(`"wasmtime"`),
+		),
+		"modulePath":           hclspec.NewAttr("modulePath", "string", true),
+		"alternateModulePaths": hclspec.NewAttr("alternateModulePaths", "list(string)", false),
+		"moduleChecksum":       hclspec.NewAttr("moduleChecksum", "string", false),
+		"fallbackEngine":       hclspec.NewAttr("fallbackEngine", "string", false),
+		"fuelLimit": hclspec.NewDefault(
+			hclspec.NewAttr("fuelLimit", "string", false),
+			hclspec.NewLiteral(`0`),
+		),
 		"ioBuffer": hclspec.NewDefault(hclspec.NewBlock("ioBuffer", false, hclspec.NewObject(map[string]*hclspec.Spec{
 			"enabled": hclspec.NewDefault(
 				hclspec.NewAttr("enabled", "bool", false),
@@ -198,6 +481,31 @@ var (
 				hclspec.NewAttr("IOBufFuncName", "string", false),
 				hclspec.NewLiteral(`"alloc"`),
 			),
+			"processFuncName": hclspec.NewAttr("processFuncName", "string", false),
+			"autoSize": hclspec.NewDefault(
+				hclspec.NewAttr("autoSize", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"autoGrow": hclspec.NewDefault(
+				hclspec.NewAttr("autoGrow", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"overflow": hclspec.NewDefault(
+				hclspec.NewAttr("overflow", "string", false),
+				hclspec.NewLiteral(`"error"`),
+			),
+			"noOutput": hclspec.NewDefault(
+				hclspec.NewAttr("noOutput", "string", false),
+				hclspec.NewLiteral(`"error"`),
+			),
+			"outputSize": hclspec.NewDefault(
+				hclspec.NewAttr("outputSize", "string", false),
+				hclspec.NewLiteral(`"result"`),
+			),
+			"outputEvent": hclspec.NewDefault(
+				hclspec.NewAttr("outputEvent", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
 			"args": hclspec.NewAttr("args", "list(number)", false),
 		})),
 			hclspec.NewLiteral(`{ enabled = false }`),
@@ -208,20 +516,195 @@ var (
 				hclspec.NewLiteral(`"handle_buffer"`),
 			),
 			"args": hclspec.NewAttr("args", "list(number)", false),
+			"resultAsExitCode": hclspec.NewDefault(
+				hclspec.NewAttr("resultAsExitCode", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"exitCodeMessages": hclspec.NewAttr("exitCodeMessages", "map(string)", false),
 		})),
 			hclspec.NewLiteral(`{ mainFuncName = "handle_buffer" }`),
 		),
+		"memory": hclspec.NewDefault(hclspec.NewBlock("memory", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"initialPages": hclspec.NewDefault(
+				hclspec.NewAttr("initialPages", "number", false),
+				hclspec.NewLiteral(`0`),
+			),
+			"limitMB": hclspec.NewDefault(
+				hclspec.NewAttr("limitMB", "number", false),
+				hclspec.NewLiteral(`0`),
+			),
+			"overcommitMB": hclspec.NewDefault(
+				hclspec.NewAttr("overcommitMB", "number", false),
+				hclspec.NewLiteral(`0`),
+			),
+			"overcommitGrace": hclspec.NewDefault(
+				hclspec.NewAttr("overcommitGrace", "number", false),
+				hclspec.NewLiteral(`10`),
+			),
+		})),
+			hclspec.NewLiteral(`{
+				initialPages = 0
+				limitMB = 0
+				overcommitMB = 0
+				overcommitGrace = 10
+			}`),
+		),
+		"hooks": hclspec.NewDefault(hclspec.NewBlock("hooks", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"enabled": hclspec.NewDefault(
+				hclspec.NewAttr("enabled", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"preMainFuncName": hclspec.NewDefault(
+				hclspec.NewAttr("preMainFuncName", "string", false),
+				hclspec.NewLiteral(`"pre_main"`),
+			),
+			"postMainFuncName": hclspec.NewDefault(
+				hclspec.NewAttr("postMainFuncName", "string", false),
+				hclspec.NewLiteral(`"post_main"`),
+			),
+		})),
+			hclspec.NewLiteral(`{ enabled = false }`),
+		),
+		"hostImports": hclspec.NewDefault(hclspec.NewBlock("hostImports", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"enabled": hclspec.NewDefault(
+				hclspec.NewAttr("enabled", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"stateMaxBytes": hclspec.NewDefault(
+				hclspec.NewAttr("stateMaxBytes", "number", false),
+				hclspec.NewLiteral(`65536`),
+			),
+			"clockStart": hclspec.NewDefault(
+				hclspec.NewAttr("clockStart", "number", false),
+				hclspec.NewLiteral(`0`),
+			),
+			"clockTick": hclspec.NewDefault(
+				hclspec.NewAttr("clockTick", "number", false),
+				hclspec.NewLiteral(`1`),
+			),
+			"randSeed": hclspec.NewDefault(
+				hclspec.NewAttr("randSeed", "number", false),
+				hclspec.NewLiteral(`0`),
+			),
+			"config":     hclspec.NewAttr("config", "map(string)", false),
+			"callLimits": hclspec.NewAttr("callLimits", "map(number)", false),
+		})),
+			hclspec.NewLiteral(`{ enabled = false }`),
+		),
+		"alerts": hclspec.NewDefault(hclspec.NewBlock("alerts", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"enabled": hclspec.NewDefault(
+				hclspec.NewAttr("enabled", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"memoryThreshold": hclspec.NewDefault(
+				hclspec.NewAttr("memoryThreshold", "number", false),
+				hclspec.NewLiteral(`80`),
+			),
+		})),
+			hclspec.NewLiteral(`{ enabled = false }`),
+		),
+		"wasi": hclspec.NewDefault(hclspec.NewBlock("wasi", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"enabled": hclspec.NewDefault(
+				hclspec.NewAttr("enabled", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+			"preopenDirs": hclspec.NewBlockList("preopenDirs", hclspec.NewObject(map[string]*hclspec.Spec{
+				"hostPath":  hclspec.NewAttr("hostPath", "string", true),
+				"guestPath": hclspec.NewAttr("guestPath", "string", true),
+				"quotaBytes": hclspec.NewDefault(
+					hclspec.NewAttr("quotaBytes", "number", false),
+					hclspec.NewLiteral(`0`),
+				),
+				"fileMode": hclspec.NewAttr("fileMode", "string", false),
+			})),
+			"stdinPipe":  hclspec.NewAttr("stdinPipe", "string", false),
+			"stdoutPipe": hclspec.NewAttr("stdoutPipe", "string", false),
+			"stderrTailLines": hclspec.NewDefault(
+				hclspec.NewAttr("stderrTailLines", "number", false),
+				hclspec.NewLiteral(`0`),
+			),
+		})),
+			hclspec.NewLiteral(`{ enabled = false }`),
+		),
+		"verifyDeterminism": hclspec.NewDefault(
+			hclspec.NewAttr("verifyDeterminism", "bool", false),
+			hclspec.NewLiteral(`false`),
+		),
+		"logWriteError": hclspec.NewDefault(
+			hclspec.NewAttr("logWriteError", "string", false),
+			hclspec.NewLiteral(`"discard"`),
+		),
+		"concurrentExec": hclspec.NewDefault(
+			hclspec.NewAttr("concurrentExec", "string", false),
+			hclspec.NewLiteral(`"serialize"`),
+		),
+		"validation": hclspec.NewBlock("validation", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"cases": hclspec.NewBlockList("cases", hclspec.NewObject(map[string]*hclspec.Spec{
+				"name":           hclspec.NewAttr("name", "string", false),
+				"input":          hclspec.NewAttr("input", "string", false),
+				"expectedOutput": hclspec.NewAttr("expectedOutput", "string", false),
+			})),
+			"casesFile": hclspec.NewAttr("casesFile", "string", false),
+		})),
+		"eventLog": hclspec.NewDefault(hclspec.NewBlock("eventLog", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"enabled": hclspec.NewDefault(
+				hclspec.NewAttr("enabled", "bool", false),
+				hclspec.NewLiteral(`false`),
+			),
+		})),
+			hclspec.NewLiteral(`{ enabled = false }`),
+		),
+		"timeouts": hclspec.NewDefault(hclspec.NewBlock("timeouts", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"initTimeout": hclspec.NewDefault(
+				hclspec.NewAttr("initTimeout", "string", false),
+				hclspec.NewLiteral(`0`),
+			),
+			"runTimeout": hclspec.NewDefault(
+				hclspec.NewAttr("runTimeout", "string", false),
+				hclspec.NewLiteral(`0`),
+			),
+			"postTimeout": hclspec.NewDefault(
+				hclspec.NewAttr("postTimeout", "string", false),
+				hclspec.NewLiteral(`0`),
+			),
+		})),
+			hclspec.NewLiteral(`{
+				initTimeout = 0
+				runTimeout = 0
+				postTimeout = 0
+			}`),
+		),
+		"resultSink": hclspec.NewBlock("resultSink", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"url":        hclspec.NewAttr("url", "string", false),
+			"path":       hclspec.NewAttr("path", "string", false),
+			"authHeader": hclspec.NewAttr("authHeader", "string", false),
+			"retries": hclspec.NewDefault(
+				hclspec.NewAttr("retries", "number", false),
+				hclspec.NewLiteral(`3`),
+			),
+		})),
 	})
 
 	// capabilities indicates what optional features this driver supports
 	// this should be set according to the target run time.
-	capabilities = &drivers.Capabilities{}
+	capabilities = &drivers.Capabilities{
+		Exec: true,
+	}
 )
 
 type PreCacheConfig struct {
 	// ModulesDir specify path to directory from where all modules will be pre-cached.
 	ModulesDir string `codec:"modulesDir"`
-	Enabled    bool   `codec:"enabled"`
+	// OnError defines how module failures are handled: fail, skip or retry.
+	OnError string `codec:"onError"`
+	// Retries is the number of additional attempts per module in retry mode.
+	Retries int `codec:"retries"`
+	// RetryBackoff specify delay in seconds before the first retry, doubled on each attempt.
+	RetryBackoff int `codec:"retryBackoff"`
+	// HealthCheck enables verifying on every fingerprint that cached modules
+	// still load and re-caching the modules directory if any don't.
+	HealthCheck bool `codec:"healthCheck"`
+	Enabled     bool `codec:"enabled"`
 }
 
 type ExpirationConfig struct {
@@ -232,17 +715,37 @@ type ExpirationConfig struct {
 
 type CacheConfig struct {
 	// Cache type one of: lfu, lru, arc or simple.
-	Type       string           `codec:"type"`
+	Type string `codec:"type"`
+	// KeyStrategy defines how cached modules are keyed, one of: content (the
+	// default), path or mtime.
+	KeyStrategy string `codec:"keyStrategy"`
+	// DiskPath defines the directory compiled modules are persisted to.
+	DiskPath   string           `codec:"diskPath"`
 	PreCache   PreCacheConfig   `codec:"preCache"`
 	Expiration ExpirationConfig `codec:"expiration"`
-	Size       int              `codec:"size"`
-	Enabled    bool             `codec:"enabled"`
+	// MaxModuleSize defines the size in bytes above which modules aren't
+	// cached. Zero means no limit.
+	MaxModuleSize int64 `codec:"maxModuleSize"`
+	// DiskMaxSize defines the size in bytes above which the least recently
+	// used modules are removed from the disk cache. Zero means no limit.
+	DiskMaxSize int64 `codec:"diskMaxSize"`
+	Size        int   `codec:"size"`
+	Enabled     bool  `codec:"enabled"`
 }
 
 type EngineConfig struct {
-	Name    string      `codec:"name"`
-	Cache   CacheConfig `codec:"cache"`
-	Enabled bool        `codec:"enabled"`
+	Name string `codec:"name"`
+	// MinVersion is the minimum version of the runtime backing the engine. The
+	// driver reports unhealthy and refuses tasks if the runtime is older.
+	MinVersion string      `codec:"minVersion"`
+	Cache      CacheConfig `codec:"cache"`
+	Enabled    bool        `codec:"enabled"`
+	// LazyInit defers the engine initialization, i.e. the creation of its
+	// modules cache and pre-caching, until the first task using it starts.
+	LazyInit bool `codec:"lazyInit"`
+	// Statistics enables collecting instruction count, gas and execution time
+	// of task modules.
+	Statistics bool `codec:"statistics"`
 }
 
 // Config contains configuration information for the plugin.
@@ -250,7 +753,85 @@ type Config struct {
 	// This struct is the decoded version of the schema defined in the
 	// configSpec variable above. It's used to convert the HCL configuration
 	// passed by the Nomad agent into Go contructs.
-	Engines []EngineConfig `codec:"engines"`
+	Statsd           *StatsdConfig          `codec:"statsd"`
+	ResultDatabase   *ResultDatabaseConfig  `codec:"resultDatabase"`
+	Engines          []EngineConfig         `codec:"engines"`
+	Wasi             PluginWasiConfig       `codec:"wasi"`
+	ResultSink       PluginResultSinkConfig `codec:"resultSink"`
+	ModuleFilePolicy ModuleFilePolicyConfig `codec:"moduleFilePolicy"`
+	Shutdown         ShutdownConfig         `codec:"shutdown"`
+	Debug            DebugConfig            `codec:"debug"`
+	FuelBudget       FuelBudgetConfig       `codec:"fuelBudget"`
+}
+
+type PluginWasiConfig struct {
+	// AllowedPreopenDirs lists host directories outside of the task
+	// directories tasks may preopen.
+	AllowedPreopenDirs []string `codec:"allowedPreopenDirs"`
+	// DefaultPreopenDirs defines the host directories preopened for all tasks
+	// with WASI enabled, in addition to their own preopened directories.
+	DefaultPreopenDirs []PreopenDirConfig `codec:"defaultPreopenDirs"`
+}
+
+type PluginResultSinkConfig struct {
+	// AllowedURLs lists the URLs tasks may send their results to. A task URL
+	// is allowed if it has the scheme and host of an allowed URL and a path
+	// within its path.
+	AllowedURLs []string `codec:"allowedURLs"`
+}
+
+type ModuleFilePolicyConfig struct {
+	// AllowedOwners lists the UIDs module files may be owned by, any owner is
+	// allowed if empty.
+	AllowedOwners []int64 `codec:"allowedOwners"`
+	// Enabled makes the driver check module files against the policy before
+	// loading them.
+	Enabled bool `codec:"enabled"`
+	// AllowWorldWritable allows modules writable by any user.
+	AllowWorldWritable bool `codec:"allowWorldWritable"`
+	// AllowGroupWritable allows modules writable by their group.
+	AllowGroupWritable bool `codec:"allowGroupWritable"`
+}
+
+type ShutdownConfig struct {
+	// TaskTimeout defines in seconds how long shutdown waits for interrupted
+	// tasks to finish.
+	TaskTimeout int `codec:"taskTimeout"`
+	// FlushTimeout defines in seconds how long shutdown waits for metrics to
+	// be flushed.
+	FlushTimeout int `codec:"flushTimeout"`
+}
+
+type DebugConfig struct {
+	// Memdump enables dumping the memory of running modules with the memdump
+	// exec command.
+	Memdump bool `codec:"memdump"`
+	// MaxMemdumpBytes defines the maximum size of a single memory dump.
+	MaxMemdumpBytes int32 `codec:"maxMemdumpBytes"`
+}
+
+type FuelBudgetConfig struct {
+	// Total defines the maximum sum of the fuel limits of the tasks running on
+	// the node, e.g. 10M. Zero disables the budget.
+	Total Quantity `codec:"total"`
+}
+
+type StatsdConfig struct {
+	// Address defines the host:port of the statsd server metrics are sent to.
+	Address string `codec:"address"`
+	// Prefix is prepended to all metric names.
+	Prefix string `codec:"prefix"`
+	// FlushInterval defines in seconds how often batched metrics are sent.
+	FlushInterval int `codec:"flushInterval"`
+}
+
+type ResultDatabaseConfig struct {
+	// Driver defines the database/sql driver, only postgres is supported.
+	Driver string `codec:"driver"`
+	// DSN defines the data source name the database is connected with.
+	DSN string `codec:"dsn"`
+	// Table defines the table task results are inserted into.
+	Table string `codec:"table"`
 }
 
 // TaskConfig contains configuration information for a task that runs with
@@ -259,10 +840,183 @@ type TaskConfig struct {
 	// This struct is the decoded version of the schema defined in the
 	// taskConfigSpec variable above. It's used to convert the string
 	// configuration for the task into Go constructs.
-	Engine     string         `codec:"engine"`
-	ModulePath string         `codec:"modulePath"`
-	Main       Main           `codec:"main"`
-	IOBuffer   IOBufferConfig `codec:"ioBuffer"`
+	ResultSink     *ResultSinkConfig `codec:"resultSink"`
+	Validation     ValidationConfig  `codec:"validation"`
+	Engine         string            `codec:"engine"`
+	FallbackEngine string            `codec:"fallbackEngine"`
+	ModulePath     string            `codec:"modulePath"`
+	ModuleChecksum string            `codec:"moduleChecksum"`
+	// LogWriteError defines how a failure to write the output to the task
+	// stdout log is handled: discard or fail.
+	LogWriteError string `codec:"logWriteError"`
+	// ConcurrentExec defines how an exec command calling a function while
+	// another one runs is handled: serialize or reject.
+	ConcurrentExec string      `codec:"concurrentExec"`
+	Hooks          HooksConfig `codec:"hooks"`
+	// AlternateModulePaths are tried in order if the module isn't found at
+	// ModulePath, e.g. on nodes keeping modules in another location.
+	AlternateModulePaths []string          `codec:"alternateModulePaths"`
+	IOBuffer             IOBufferConfig    `codec:"ioBuffer"`
+	Wasi                 WasiConfig        `codec:"wasi"`
+	Main                 Main              `codec:"main"`
+	HostImports          HostImportsConfig `codec:"hostImports"`
+	Memory               MemoryConfig      `codec:"memory"`
+	Alerts               AlertsConfig      `codec:"alerts"`
+	Timeouts             TimeoutsConfig    `codec:"timeouts"`
+	// FuelLimit is the fuel the module can consume before it traps, zero
+	// disables fuel metering.
+	FuelLimit Quantity       `codec:"fuelLimit"`
+	EventLog  EventLogConfig `codec:"eventLog"`
+	// VerifyDeterminism runs the module twice with the same inputs and fails
+	// the task if the outputs differ.
+	VerifyDeterminism bool `codec:"verifyDeterminism"`
+}
+
+type WasiConfig struct {
+	// StdinPipe and StdoutPipe define the named pipes in the shared
+	// allocation directory the module stdin is read from and its stdout is
+	// written to, connecting tasks of the allocation.
+	StdinPipe  string `codec:"stdinPipe"`
+	StdoutPipe string `codec:"stdoutPipe"`
+	// PreopenDirs defines the host directories the module can access.
+	PreopenDirs []PreopenDirConfig `codec:"preopenDirs"`
+	// StderrTailLines defines the number of last module stderr lines included
+	// in the error of a failed module call, zero disables it.
+	StderrTailLines int `codec:"stderrTailLines"`
+	// Enabled links WASI into the module instance with the module stdout and
+	// stderr written to the task logs.
+	Enabled bool `codec:"enabled"`
+}
+
+type ValidationConfig struct {
+	// CasesFile defines a JSON file with additional cases, relative paths are
+	// relative to the task directory.
+	CasesFile string `codec:"casesFile"`
+	// Cases defines the runs the module must pass before the task starts.
+	Cases []ValidationCase `codec:"cases"`
+}
+
+type ValidationCase struct {
+	// Name identifies the case in logs and errors.
+	Name string `codec:"name" json:"name"`
+	// Input defines the IO buffer input the module is run with.
+	Input string `codec:"input" json:"input"`
+	// ExpectedOutput defines the output the module must produce.
+	ExpectedOutput string `codec:"expectedOutput" json:"expectedOutput"`
+}
+
+type PreopenDirConfig struct {
+	// HostPath defines the host directory, relative paths are relative to the
+	// allocation directory.
+	HostPath string `codec:"hostPath"`
+	// GuestPath defines the path the module accesses the directory with.
+	GuestPath string `codec:"guestPath"`
+	// FileMode defines the octal permissions applied to the files the module
+	// writes to the directory, empty keeps the ones the engine creates them
+	// with.
+	FileMode string `codec:"fileMode"`
+	// QuotaBytes defines the size the files in the directory can't exceed
+	// while the task runs, zero means no quota.
+	QuotaBytes int64 `codec:"quotaBytes"`
+}
+
+type MemoryConfig struct {
+	// InitialPages defines the number of pages the module memory is grown to
+	// before any module function is called.
+	InitialPages uint64 `codec:"initialPages"`
+	// LimitMB defines the size the module memory can't grow past. Zero means
+	// the task memory resource.
+	LimitMB int64 `codec:"limitMB"`
+	// OvercommitMB defines how far past the limit the module memory can grow
+	// for a burst of at most OvercommitGrace seconds.
+	OvercommitMB    int64 `codec:"overcommitMB"`
+	OvercommitGrace int   `codec:"overcommitGrace"`
+}
+
+// limitPages returns the memory limit in pages, or 0 if memory is unlimited.
+func (c MemoryConfig) limitPages() uint64 {
+	if c.LimitMB <= 0 {
+		return 0
+	}
+
+	//nolint:gosec
+	return min(uint64(c.LimitMB)*1024*1024/wasmPageSize, maxMemoryPages)
+}
+
+// maxPages returns the number of pages engines don't let the module memory
+// grow past: the memory limit and the overcommit, or 0 if memory is
+// unlimited.
+func (c MemoryConfig) maxPages() uint64 {
+	if c.LimitMB <= 0 {
+		return 0
+	}
+
+	//nolint:gosec
+	return min(uint64(c.LimitMB+c.OvercommitMB)*1024*1024/wasmPageSize, maxMemoryPages)
+}
+
+type ResultSinkConfig struct {
+	// URL defines the HTTP endpoint the task result is POSTed to as JSON.
+	URL string `codec:"url"`
+	// Path defines the file the task result is appended to as a JSON line,
+	// relative to the task directory.
+	Path string `codec:"path"`
+	// AuthHeader is sent as the Authorization header value of HTTP requests.
+	AuthHeader string `codec:"authHeader"`
+	// Retries is the number of additional delivery attempts.
+	Retries int `codec:"retries"`
+}
+
+type HooksConfig struct {
+	// PreMainFuncName defines the function called before the main function.
+	PreMainFuncName string `codec:"preMainFuncName"`
+	// PostMainFuncName defines the function called after the main function.
+	PostMainFuncName string `codec:"postMainFuncName"`
+	Enabled          bool   `codec:"enabled"`
+}
+
+type HostImportsConfig struct {
+	// Config defines the values modules can read through the config host import.
+	Config map[string]string `codec:"config"`
+	// CallLimits maps host import names to the maximum number of calls per
+	// second.
+	CallLimits map[string]int64 `codec:"callLimits"`
+	// StateMaxBytes bounds the total size of keys and values a task can keep
+	// in the host state.
+	StateMaxBytes int `codec:"stateMaxBytes"`
+	// ClockStart defines the first value returned by the logical clock.
+	ClockStart int64 `codec:"clockStart"`
+	// ClockTick defines how much the logical clock advances on every read.
+	ClockTick int64 `codec:"clockTick"`
+	// RandSeed seeds the pseudo-random number generator.
+	RandSeed int64 `codec:"randSeed"`
+	Enabled  bool  `codec:"enabled"`
+}
+
+type AlertsConfig struct {
+	// MemoryThreshold defines the percentage of the task memory limit used by
+	// the module memory at which an alert event is emitted.
+	MemoryThreshold int  `codec:"memoryThreshold"`
+	Enabled         bool `codec:"enabled"`
+}
+
+type EventLogConfig struct {
+	// Enabled makes the driver write task lifecycle and resource events to a
+	// JSON lines file in the allocation log directory.
+	Enabled bool `codec:"enabled"`
+}
+
+type TimeoutsConfig struct {
+	// InitTimeout defines how long the module initialization (memory
+	// pre-growing, reactor initialization, IO buffer allocation and pre main
+	// hook) may take. Zero disables the limit.
+	InitTimeout Duration `codec:"initTimeout"`
+	// RunTimeout defines how long the main function may take. Zero disables
+	// the limit.
+	RunTimeout Duration `codec:"runTimeout"`
+	// PostTimeout defines how long the post main hook may take. Zero disables
+	// the limit.
+	PostTimeout Duration `codec:"postTimeout"`
 }
 
 type IOBufferConfig struct {
@@ -271,18 +1025,42 @@ type IOBufferConfig struct {
 	// IOBufFuncName defines the name of the exported function in the WASM module
 	// that returns the address of the start of the buffer created in the WASM module.
 	IOBufFuncName string `codec:"IOBufFuncName"`
+	// ProcessFuncName defines the function called with the filled buffer
+	// instead of the main function.
+	ProcessFuncName string `codec:"processFuncName"`
+	// Overflow defines how an input larger than the buffer is handled: error or truncate.
+	Overflow string `codec:"overflow"`
+	// NoOutput defines how a module which doesn't write a result to the buffer
+	// is handled: error or empty.
+	NoOutput string `codec:"noOutput"`
+	// OutputSize defines how much of the buffer is read back as the output:
+	// result or buffer.
+	OutputSize string `codec:"outputSize"`
 	// Args stores args that can be passed to the corresponding function.
 	Args []int32 `codec:"args"`
 	// Size defines the length of the buffer created in the WASM module.
-	Size    int32 `codec:"size"`
-	Enabled bool  `codec:"enabled"`
+	Size int32 `codec:"size"`
+	// AutoSize makes the buffer length the input length if it exceeds Size.
+	AutoSize bool `codec:"autoSize"`
+	// AutoGrow makes the driver grow the module memory when the allocated buffer
+	// doesn't fit it instead of failing the task.
+	AutoGrow bool `codec:"autoGrow"`
+	// OutputEvent emits a task event annotated with the output.
+	OutputEvent bool `codec:"outputEvent"`
+	Enabled     bool `codec:"enabled"`
 }
 
 type Main struct {
 	// MainFuncName defines the function that will be called to handle the input.
 	MainFuncName string `codec:"mainFuncName"`
+	// ExitCodeMessages maps the non-zero exit codes of the main function
+	// result to the messages the task exit result is reported with.
+	ExitCodeMessages map[string]string `codec:"exitCodeMessages"`
 	// Args stores args that can be passed to the corresponding function.
 	Args []int32 `codec:"args"`
+	// ResultAsExitCode reports the integer result of the main function as the
+	// task exit code.
+	ResultAsExitCode bool `codec:"resultAsExitCode"`
 }
 
 // TaskState is the runtime state which is encoded in the handle returned to
@@ -293,6 +1071,7 @@ type TaskState struct {
 	ReattachConfig *structs.ReattachConfig
 	TaskConfig     *drivers.TaskConfig
 	StartedAt      time.Time
+	CorrelationID  string
 }
 
 type WasmTaskDriverPlugin struct {
@@ -309,6 +1088,32 @@ type WasmTaskDriverPlugin struct {
 	// tasks is the in memory datastore mapping taskIDs to driver handles
 	tasks *taskStore
 
+	// evictionStats maps engine names to their modules cache eviction counters
+	evictionStats map[string]*evictionStats
+
+	// hitStats maps engine names to their modules cache hit rates
+	hitStats map[string]*hitStats
+
+	// lazyEngines maps names of lazily initialized engines, which no task has
+	// used yet, to their configuration
+	lazyEngines map[string]EngineConfig
+
+	// preCacheHealth maps engine names to the error of their last pre-cache
+	// health check, nil if it passed
+	preCacheHealth map[string]error
+
+	// engineInits deduplicates concurrent lazy initializations of an engine
+	engineInits singleflight.Group
+
+	// statsd sends task metrics to a statsd server, if configured
+	statsd *statsdSink
+
+	// resultDB writes task results to a database, if configured
+	resultDB *resultDatabase
+
+	// fuelBudget bounds the total fuel limit of running tasks, if configured
+	fuelBudget *fuelBudget
+
 	// ctx is the context for the driver. It is passed to other subsystems to
 	// coordinate shutdown
 	ctx context.Context
@@ -319,6 +1124,13 @@ type WasmTaskDriverPlugin struct {
 
 	// logger will log to the Nomad agent
 	logger hclog.Logger
+
+	// enginesLock syncs evictionStats, hitStats, lazyEngines and
+	// preCacheHealth
+	enginesLock sync.Mutex
+
+	// shuttingDown is set once shutdown starts, new tasks are rejected after it
+	shuttingDown atomic.Bool
 }
 
 // NewPlugin returns a new example driver plugin.
@@ -336,6 +1148,56 @@ func NewPlugin(logger hclog.Logger) drivers.DriverPlugin {
 	}
 }
 
+// Shutdown stops the driver in a fixed order: new tasks are rejected, running
+// tasks are interrupted and waited for, batched metrics and pending task
+// results are flushed and finally the driver context is canceled. Waiting for
+// tasks and flushing are each bounded by the configured shutdown timeouts.
+// Only the first call shuts the driver down.
+func (d *WasmTaskDriverPlugin) Shutdown() {
+	if d.shuttingDown.Swap(true) {
+		return
+	}
+
+	d.logger.Info("shutting down driver")
+
+	conf := d.config.Shutdown
+	handles := d.tasks.List()
+
+	for _, handle := range handles {
+		handle.cancel()
+	}
+
+	taskDeadline := time.After(time.Second * time.Duration(conf.TaskTimeout))
+
+waitTasks:
+	for _, handle := range handles {
+		select {
+		case <-handle.completionCh:
+		case <-taskDeadline:
+			d.logger.Warn("tasks did not stop before shutdown timeout", "timeout", conf.TaskTimeout)
+
+			break waitTasks
+		}
+	}
+
+	flushed := make(chan struct{})
+
+	go func() {
+		defer close(flushed)
+
+		d.statsd.Close()
+		d.resultDB.Close()
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second * time.Duration(conf.FlushTimeout)):
+		d.logger.Warn("metrics and results were not flushed before shutdown timeout", "timeout", conf.FlushTimeout)
+	}
+
+	d.signalShutdown()
+}
+
 // PluginInfo returns information describing the plugin.
 func (d *WasmTaskDriverPlugin) PluginInfo() (*base.PluginInfoResponse, error) {
 	return pluginInfo, nil
@@ -370,6 +1232,74 @@ func (d *WasmTaskDriverPlugin) SetConfig(cfg *base.Config) error {
 		if cacheConf.Expiration.Enabled && cacheConf.Expiration.EntryTTL <= 0 {
 			return fmt.Errorf("%s engine: cache entry time-to-live must be > 0, but specified %v", engineConf.Name, cacheConf.Expiration.EntryTTL)
 		}
+
+		if cacheConf.MaxModuleSize < 0 {
+			return fmt.Errorf("%s engine: cache max module size must be >= 0, but specified %v", engineConf.Name, cacheConf.MaxModuleSize)
+		}
+
+		switch cacheConf.KeyStrategy {
+		case interfaces.CacheKeyPath, interfaces.CacheKeyMtime, interfaces.CacheKeyContent:
+		default:
+			return fmt.Errorf("%s engine: unexpected cache key strategy, expected strategies: [path, mtime, content], but specified %s",
+				engineConf.Name, cacheConf.KeyStrategy)
+		}
+
+		if err := validatePreCacheConfig(cacheConf.PreCache); err != nil {
+			return fmt.Errorf("%s engine: %v", engineConf.Name, err)
+		}
+
+		if cacheConf.DiskMaxSize < 0 {
+			return fmt.Errorf("%s engine: cache diskMaxSize must not be negative, but specified %d", engineConf.Name,
+				cacheConf.DiskMaxSize)
+		}
+
+		// WasmEdge modules are cached as loaded ASTs, which can't be persisted.
+		if cacheConf.DiskPath != "" && engineConf.Name != "wasmtime" {
+			return fmt.Errorf("%s engine: cache diskPath is only supported by wasmtime engine", engineConf.Name)
+		}
+
+		if engineConf.MinVersion != "" {
+			if _, err := version.NewVersion(engineConf.MinVersion); err != nil {
+				return fmt.Errorf("%s engine: invalid min version %q: %v", engineConf.Name, engineConf.MinVersion, err)
+			}
+		}
+
+		if engineConf.Statistics && engineConf.Name != "wasmedge" {
+			return fmt.Errorf("%s engine: statistics are only supported by wasmedge engine", engineConf.Name)
+		}
+	}
+
+	if shutdownConf := d.config.Shutdown; shutdownConf.TaskTimeout < 0 || shutdownConf.FlushTimeout < 0 {
+		return fmt.Errorf("shutdown timeouts must be >= 0, but specified task %v and flush %v",
+			shutdownConf.TaskTimeout, shutdownConf.FlushTimeout)
+	}
+
+	for _, owner := range d.config.ModuleFilePolicy.AllowedOwners {
+		if owner < 0 || owner > math.MaxUint32 {
+			return fmt.Errorf("module file policy allowed owner must be a valid UID, but specified %v", owner)
+		}
+	}
+
+	guestPaths := make(map[string]bool, len(d.config.Wasi.DefaultPreopenDirs))
+
+	for _, dir := range d.config.Wasi.DefaultPreopenDirs {
+		if !filepath.IsAbs(dir.HostPath) {
+			return fmt.Errorf("wasi default preopen dir host path must be absolute, but specified %q", dir.HostPath)
+		}
+
+		if guestPaths[dir.GuestPath] {
+			return fmt.Errorf("wasi default preopen dir guest path %q is specified more than once", dir.GuestPath)
+		}
+
+		guestPaths[dir.GuestPath] = true
+	}
+
+	if d.config.Debug.MaxMemdumpBytes <= 0 {
+		return fmt.Errorf("debug max memdump bytes must be > 0, but specified %v", d.config.Debug.MaxMemdumpBytes)
+	}
+
+	if statsdConf := d.config.Statsd; statsdConf != nil && statsdConf.FlushInterval <= 0 {
+		return fmt.Errorf("statsd flush interval must be > 0, but specified %v", statsdConf.FlushInterval)
 	}
 
 	// Save the Nomad agent configuration
@@ -379,31 +1309,149 @@ func (d *WasmTaskDriverPlugin) SetConfig(cfg *base.Config) error {
 
 	// Here you can use the config values to initialize any resources that are
 	// shared by all tasks that use this driver, such as a daemon process.
+	d.enginesLock.Lock()
+
+	d.evictionStats = make(map[string]*evictionStats)
+	d.hitStats = make(map[string]*hitStats)
+	d.lazyEngines = make(map[string]EngineConfig)
+	d.preCacheHealth = make(map[string]error)
+
 	for _, engineConf := range d.config.Engines {
-		if err := initializeEngine(d.logger, engineConf); err != nil {
-			return err
+		if engineConf.Enabled && engineConf.LazyInit {
+			d.logger.Debug("deferring engine initialization until first use", "engine", engineConf.Name)
+
+			d.lazyEngines[engineConf.Name] = engineConf
 		}
 	}
 
+	d.enginesLock.Unlock()
+
+	// Engines are initialized in the order they are configured.
+	for _, engineConf := range d.config.Engines {
+		if engineConf.Enabled && engineConf.LazyInit {
+			continue
+		}
+
+		if err := d.initializeEngine(engineConf); err != nil {
+			return err
+		}
+	}
+
+	d.statsd.Close()
+	d.statsd = nil
+
+	if d.config.Statsd != nil {
+		sink, err := newStatsdSink(d.logger, *d.config.Statsd)
+		if err != nil {
+			return err
+		}
+
+		d.statsd = sink
+	}
+
+	// Running tasks release their fuel to the budget they reserved it from.
+	d.fuelBudget = newFuelBudget(uint64(d.config.FuelBudget.Total))
+
+	d.resultDB.Close()
+	d.resultDB = nil
+
+	if d.config.ResultDatabase != nil {
+		resultDB, err := newResultDatabase(d.logger, *d.config.ResultDatabase)
+		if err != nil {
+			return err
+		}
+
+		d.resultDB = resultDB
+	}
+
 	return nil
 }
 
-func initializeEngine(logger hclog.Logger, engineConf EngineConfig) error {
+// ensureEngineInitialized initializes the engine if it is lazily initialized
+// and hasn't been used yet. Tasks starting concurrently share a single
+// initialization of the engine, which doesn't hold enginesLock, so pre-caching
+// doesn't block tasks and fingerprints of other engines.
+func (d *WasmTaskDriverPlugin) ensureEngineInitialized(engineName string) error {
+	d.enginesLock.Lock()
+	engineConf, ok := d.lazyEngines[engineName]
+	d.enginesLock.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	_, err, _ := d.engineInits.Do(engineName, func() (interface{}, error) {
+		// A previous initialization may have finished since the engine was
+		// looked up.
+		if d.engineInitialized(engineName) {
+			return nil, nil
+		}
+
+		d.logger.Info("initializing engine on first use", "engine", engineName)
+
+		if err := d.initializeEngine(engineConf); err != nil {
+			return nil, err
+		}
+
+		d.enginesLock.Lock()
+		delete(d.lazyEngines, engineName)
+		d.enginesLock.Unlock()
+
+		return nil, nil
+	})
+
+	return err
+}
+
+// engineInitialized reports whether the engine isn't waiting for its lazy
+// initialization.
+func (d *WasmTaskDriverPlugin) engineInitialized(engineName string) bool {
+	d.enginesLock.Lock()
+	defer d.enginesLock.Unlock()
+
+	_, lazy := d.lazyEngines[engineName]
+
+	return !lazy
+}
+
+// initializeEngine creates the engine modules cache and pre-caches modules.
+func (d *WasmTaskDriverPlugin) initializeEngine(engineConf EngineConfig) error {
+	logger := d.logger
+
 	engine, err := engines.Get(engineConf.Name)
 	if err != nil {
 		return fmt.Errorf("unable to get engine %s: %v", engineConf.Name, err)
 	}
 
 	if engineConf.Cache.Enabled {
-		newCache, err := buildCache(engineConf.Cache)
+		var ttl time.Duration
+		if engineConf.Cache.Expiration.Enabled {
+			ttl = time.Second * time.Duration(engineConf.Cache.Expiration.EntryTTL)
+		}
+
+		stats := newEvictionStats(ttl)
+
+		newCache, err := buildCache(engineConf.Cache, stats)
 		if err != nil {
 			return fmt.Errorf("unable to create cache for engine %s: %v", engineConf.Name, err)
 		}
 
-		engine.Init(logger, newCache)
+		d.enginesLock.Lock()
+		d.evictionStats[engineConf.Name] = stats
+		d.hitStats[engineConf.Name] = newHitStats(newCache)
+		d.enginesLock.Unlock()
+
+		engine.Init(logger, newCache, interfaces.CacheOptions{
+			KeyStrategy:   engineConf.Cache.KeyStrategy,
+			MaxModuleSize: engineConf.Cache.MaxModuleSize,
+			DiskPath:      engineConf.Cache.DiskPath,
+			DiskMaxSize:   engineConf.Cache.DiskMaxSize,
+		})
 
 		if engineConf.Cache.PreCache.Enabled {
-			preCachedModulesNum, err := engine.PrePopulateCache(engineConf.Cache.PreCache.ModulesDir)
+			preCacheConf := engineConf.Cache.PreCache
+
+			preCachedModulesNum, err := engine.PrePopulateCache(preCacheConf.ModulesDir, preCachePolicy(preCacheConf))
 			if err != nil {
 				return fmt.Errorf("unable to pre populate modules for engine %s from directory %s: %v", engineConf.Name, engineConf.Cache.PreCache.ModulesDir, err)
 			}
@@ -417,16 +1465,49 @@ func initializeEngine(logger hclog.Logger, engineConf EngineConfig) error {
 				logger.Warn("since expiration enabled for cache all pre-cached modules also will be removed from cache after TTL",
 					"TTL", hclog.Fmt("%d seconds", engineConf.Cache.Expiration.EntryTTL), "engine", engineConf.Name)
 			}
+
+			if preCacheConf.HealthCheck {
+				go d.runPreCacheHealthCheck(engineConf)
+			}
 		}
 	} else {
-		engine.Init(logger, nil)
+		engine.Init(logger, nil, interfaces.CacheOptions{})
+	}
+
+	return nil
+}
+
+func preCachePolicy(preCacheConf PreCacheConfig) interfaces.PreCachePolicy {
+	return interfaces.PreCachePolicy{
+		OnError: preCacheConf.OnError,
+		Retries: preCacheConf.Retries,
+		Backoff: time.Second * time.Duration(preCacheConf.RetryBackoff),
+	}
+}
+
+func validatePreCacheConfig(preCacheConf PreCacheConfig) error {
+	switch preCacheConf.OnError {
+	case interfaces.PreCacheOnErrorFail, interfaces.PreCacheOnErrorSkip, interfaces.PreCacheOnErrorRetry:
+	default:
+		return fmt.Errorf("unexpected pre-cache onError mode, expected modes: [fail, skip, retry], but specified %s",
+			preCacheConf.OnError)
+	}
+
+	if preCacheConf.Retries < 0 {
+		return fmt.Errorf("pre-cache retries must be >= 0, but specified %v", preCacheConf.Retries)
+	}
+
+	if preCacheConf.RetryBackoff < 0 {
+		return fmt.Errorf("pre-cache retry backoff must be >= 0, but specified %v", preCacheConf.RetryBackoff)
 	}
 
 	return nil
 }
 
-func buildCache(cacheConf CacheConfig) (gcache.Cache, error) {
-	cacheBuilder := gcache.New(cacheConf.Size)
+func buildCache(cacheConf CacheConfig, stats *evictionStats) (gcache.Cache, error) {
+	cacheBuilder := gcache.New(cacheConf.Size).
+		AddedFunc(stats.onAdded).
+		EvictedFunc(stats.onEvicted)
 
 	if cacheConf.Expiration.Enabled {
 		cacheBuilder.Expiration(time.Second * time.Duration(cacheConf.Expiration.EntryTTL))
@@ -446,7 +1527,7 @@ func buildCache(cacheConf CacheConfig) (gcache.Cache, error) {
 			cacheConf.Type)
 	}
 
-	return cacheBuilder.Build(), nil
+	return &statsCache{Cache: cacheBuilder.Build(), evictions: stats}, nil
 }
 
 // TaskConfigSchema returns the HCL schema for the configuration of a task.
@@ -512,222 +1593,1436 @@ func (d *WasmTaskDriverPlugin) buildFingerprint() *drivers.Fingerprint {
 	supportedEngineNames := make([]string, 0, len(d.config.Engines))
 
 	for _, engine := range d.config.Engines {
-		supportedEngineNames = append(supportedEngineNames, engine.Name)
+		if engine.Enabled {
+			supportedEngineNames = append(supportedEngineNames, engine.Name)
+		}
 	}
 
 	fp.Attributes[fmt.Sprintf("%s.%s", fingerprintPrefix, "supported_runtimes")] = structs.NewStringAttribute(
 		strings.Join(supportedEngineNames, ","))
 
-	return fp
-}
+	fp.Attributes[fmt.Sprintf("%s.%s", fingerprintPrefix, "arch")] = structs.NewStringAttribute(runtime.GOARCH)
 
-// StartTask returns a task handle and a driver network if necessary.
-func (d *WasmTaskDriverPlugin) StartTask(cfg *drivers.TaskConfig) (*drivers.TaskHandle, *drivers.DriverNetwork, error) {
-	if _, ok := d.tasks.Get(cfg.ID); ok {
-		return nil, nil, fmt.Errorf("task with ID %q already started", cfg.ID)
+	if budget := d.fuelBudget; budget != nil {
+		//nolint:gosec
+		fp.Attributes[fingerprintPrefix+".fuel_budget.total"] = structs.NewIntAttribute(int64(budget.total), "")
+		//nolint:gosec
+		fp.Attributes[fingerprintPrefix+".fuel_budget.remaining"] = structs.NewIntAttribute(int64(budget.remaining()), "")
 	}
 
-	var driverConfig TaskConfig
-	if err := cfg.DecodeDriverConfig(&driverConfig); err != nil {
-		return nil, nil, fmt.Errorf("failed to decode driver config: %v", err)
+	for _, engine := range d.config.Engines {
+		addCacheAttributes(fp.Attributes, engine)
+		addEngineInfoAttributes(fp.Attributes, engine.Name)
+
+		fp.Attributes[fmt.Sprintf("%s.%s.initialized", fingerprintPrefix, engine.Name)] = structs.NewBoolAttribute(
+			d.engineInitialized(engine.Name))
 	}
 
-	d.logger.Info("starting task", "driver_cfg", hclog.Fmt("%+v", driverConfig))
+	for _, engine := range d.config.Engines {
+		if !engine.Enabled {
+			continue
+		}
 
-	handle := drivers.NewTaskHandle(taskHandleVersion)
-	handle.Config = cfg
+		if err := checkEngineVersion(engine); err != nil {
+			fp.Health = drivers.HealthStateUnhealthy
+			fp.HealthDescription = err.Error()
+		}
+	}
 
-	engine, err := engines.Get(driverConfig.Engine)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get %s engine: %v", driverConfig.Engine, err)
+	d.enginesLock.Lock()
+	defer d.enginesLock.Unlock()
+
+	// Pre-cache health checks load every cached module, so they run in the
+	// background and only their last result is reported.
+	for _, engine := range d.config.Engines {
+		if err := d.preCacheHealth[engine.Name]; err != nil {
+			fp.Health = drivers.HealthStateUnhealthy
+			fp.HealthDescription = err.Error()
+		}
+	}
+
+	for engineName, stats := range d.evictionStats {
+		//nolint:gosec
+		fp.Attributes[fmt.Sprintf("%s.%s.cache.evictions.capacity", fingerprintPrefix, engineName)] = structs.NewIntAttribute(
+			int64(stats.CapacityEvictions()), "")
+		//nolint:gosec
+		fp.Attributes[fmt.Sprintf("%s.%s.cache.evictions.expiration", fingerprintPrefix, engineName)] = structs.NewIntAttribute(
+			int64(stats.ExpirationEvictions()), "")
+	}
+
+	for engineName, stats := range d.hitStats {
+		prefix := fmt.Sprintf("%s.%s.cache", fingerprintPrefix, engineName)
+
+		//nolint:gosec
+		fp.Attributes[prefix+".hits"] = structs.NewIntAttribute(int64(stats.Hits()), "")
+		//nolint:gosec
+		fp.Attributes[prefix+".misses"] = structs.NewIntAttribute(int64(stats.Misses()), "")
+		fp.Attributes[prefix+".hit_rate"] = structs.NewFloatAttribute(stats.SampleHitRate(), "")
+	}
+
+	return fp
+}
+
+// runPreCacheHealthCheck runs the pre-cache health check of the engine every
+// fingerprint period until the driver shuts down.
+func (d *WasmTaskDriverPlugin) runPreCacheHealthCheck(engineConf EngineConfig) {
+	ticker := time.NewTicker(fingerprintPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			d.checkPreCache(engineConf)
+		}
 	}
+}
 
-	newInstance, err := engine.InstantiateModule(driverConfig.ModulePath)
+// checkPreCache runs the pre-cache health check of the engine and stores its
+// result for the following fingerprints.
+func (d *WasmTaskDriverPlugin) checkPreCache(engineConf EngineConfig) {
+	err := d.verifyPreCache(engineConf)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to instantiate module %s: %v", driverConfig.ModulePath, err)
+		d.logger.Warn("pre-cache health check failed", "engine", engineConf.Name, "error", err)
 	}
 
-	// Once the task is started you will need to store any relevant runtime
-	// information in a taskHandle and TaskState. The taskHandle will be
-	// stored in-memory in the plugin and will be used to interact with the
-	// task.
-	//
-	// The TaskState will be returned to the Nomad client inside a
-	// drivers.TaskHandle instance. This TaskHandle will be sent back to plugin
-	// if the task ever needs to be recovered, so the TaskState should contain
-	// enough information to handle that.
+	d.enginesLock.Lock()
+	defer d.enginesLock.Unlock()
 
-	h := &taskHandle{
-		taskConfig:   cfg,
-		procState:    drivers.TaskStateRunning,
-		startedAt:    time.Now().Round(time.Millisecond),
-		logger:       d.logger,
-		ioBufferConf: driverConfig.IOBuffer,
-		mainFunc:     driverConfig.Main,
-		instance:     newInstance,
-		completionCh: make(chan struct{}),
+	d.preCacheHealth[engineConf.Name] = err
+}
+
+// verifyPreCache verifies that the engine's cached modules still load when the
+// pre-cache health check is enabled. Modules which don't are removed from the
+// cache and the modules directory is pre-cached again.
+func (d *WasmTaskDriverPlugin) verifyPreCache(engineConf EngineConfig) error {
+	preCacheConf := engineConf.Cache.PreCache
+	if !engineConf.Cache.Enabled || !preCacheConf.Enabled || !preCacheConf.HealthCheck {
+		return nil
 	}
 
-	driverState := TaskState{
-		ReattachConfig: &structs.ReattachConfig{},
-		TaskConfig:     cfg,
-		StartedAt:      h.startedAt,
+	engine, err := engines.Get(engineConf.Name)
+	if err != nil {
+		return fmt.Errorf("unable to get engine %s: %v", engineConf.Name, err)
 	}
 
-	if err := handle.SetDriverState(&driverState); err != nil {
-		// need to cleanup resources.
-		h.instance.Cleanup()
+	corrupted, err := engine.VerifyCache()
+	if err != nil {
+		return fmt.Errorf("unable to verify %s engine modules cache: %v", engineConf.Name, err)
+	}
 
-		return nil, nil, fmt.Errorf("failed to set driver state: %v", err)
+	if corrupted == 0 {
+		return nil
 	}
 
-	d.tasks.Set(cfg.ID, h)
-	go h.run()
+	d.logger.Warn("cached modules failed to load, pre-caching modules again", "engine", engineConf.Name,
+		"modules", corrupted)
 
-	return handle, nil, nil
-}
+	if _, err := engine.PrePopulateCache(preCacheConf.ModulesDir, preCachePolicy(preCacheConf)); err != nil {
+		return fmt.Errorf("unable to pre-cache modules for engine %s again: %v", engineConf.Name, err)
+	}
 
-// RecoverTask recreates the in-memory state of a task from a TaskHandle.
-func (d *WasmTaskDriverPlugin) RecoverTask(_handle *drivers.TaskHandle) error {
 	return nil
 }
 
-// WaitTask returns a channel used to notify Nomad when a task exits.
-func (d *WasmTaskDriverPlugin) WaitTask(ctx context.Context, taskID string) (<-chan *drivers.ExitResult, error) {
-	handle, ok := d.tasks.Get(taskID)
-	if !ok {
-		return nil, drivers.ErrTaskNotFound
+// addCacheAttributes reports the effective modules cache configuration of the
+// engine, so operators can check what is running on each node.
+func addCacheAttributes(attrs map[string]*structs.Attribute, engine EngineConfig) {
+	prefix := fmt.Sprintf("%s.%s.cache", fingerprintPrefix, engine.Name)
+	cacheConf := engine.Cache
+
+	attrs[prefix+".enabled"] = structs.NewBoolAttribute(cacheConf.Enabled)
+
+	if !cacheConf.Enabled {
+		return
 	}
 
-	ch := make(chan *drivers.ExitResult)
-	go d.handleWait(ctx, handle, ch)
+	attrs[prefix+".type"] = structs.NewStringAttribute(cacheConf.Type)
+	attrs[prefix+".size"] = structs.NewIntAttribute(int64(cacheConf.Size), "")
+	attrs[prefix+".key_strategy"] = structs.NewStringAttribute(cacheConf.KeyStrategy)
+	attrs[prefix+".max_module_size"] = structs.NewIntAttribute(cacheConf.MaxModuleSize, "B")
+	attrs[prefix+".disk.enabled"] = structs.NewBoolAttribute(cacheConf.DiskPath != "")
+	attrs[prefix+".expiration.enabled"] = structs.NewBoolAttribute(cacheConf.Expiration.Enabled)
 
-	return ch, nil
+	if cacheConf.Expiration.Enabled {
+		attrs[prefix+".expiration.entry_ttl"] = structs.NewIntAttribute(int64(cacheConf.Expiration.EntryTTL), "s")
+	}
+
+	attrs[prefix+".precache.enabled"] = structs.NewBoolAttribute(cacheConf.PreCache.Enabled)
 }
 
-func (d *WasmTaskDriverPlugin) handleWait(ctx context.Context, handle *taskHandle, ch chan *drivers.ExitResult) {
-	defer close(ch)
+// addEngineInfoAttributes adds the runtime version, the fuel calibration and
+// the supported WASM proposals of the engine to the fingerprint attributes.
+func addEngineInfoAttributes(attrs map[string]*structs.Attribute, engineName string) {
+	engine, err := engines.Get(engineName)
+	if err != nil {
+		return
+	}
 
-	// When a result is sent in the result channel Nomad will stop the task and
-	// emit an event that an operator can use to get an insight on why the task
-	// stopped.
-	//
+	info := engine.Info()
+	prefix := fmt.Sprintf("%s.%s", fingerprintPrefix, engineName)
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-d.ctx.Done():
-			return
-		case <-handle.completionCh:
-			ch <- handle.exitResult
-		}
+	attrs[prefix+".version"] = structs.NewStringAttribute(info.Version)
+
+	if info.FuelPerMs > 0 {
+		//nolint:gosec
+		attrs[prefix+".fuel_per_ms"] = structs.NewIntAttribute(int64(info.FuelPerMs), "")
+	}
+
+	for feature, supported := range info.Features {
+		attrs[prefix+".feature."+feature] = structs.NewBoolAttribute(supported)
 	}
 }
 
-// StopTask stops a running task with the given signal and within the timeout window.
-func (d *WasmTaskDriverPlugin) StopTask(taskID string, _timeout time.Duration, _signal string) error {
-	handle, ok := d.tasks.Get(taskID)
-	if !ok {
-		return drivers.ErrTaskNotFound
+// checkEngineVersion fails if the runtime backing the engine is older than the
+// configured minimum version, or if its version is unknown.
+func checkEngineVersion(engineConf EngineConfig) error {
+	if engineConf.MinVersion == "" {
+		return nil
+	}
+
+	engine, err := engines.Get(engineConf.Name)
+	if err != nil {
+		return err
+	}
+
+	// The min version is validated on SetConfig.
+	minVersion, _ := version.NewVersion(engineConf.MinVersion)
+	info := engine.Info()
+
+	current, err := version.NewVersion(info.Version)
+	if err != nil {
+		return fmt.Errorf("%s engine: unable to check version %q against required min version %s",
+			engineConf.Name, info.Version, engineConf.MinVersion)
 	}
 
-	handle.instance.Stop()
+	if current.LessThan(minVersion) {
+		return fmt.Errorf("%s engine: version %s is older than required min version %s",
+			engineConf.Name, info.Version, engineConf.MinVersion)
+	}
 
 	return nil
 }
 
-// DestroyTask cleans up and removes a task that has terminated.
-func (d *WasmTaskDriverPlugin) DestroyTask(taskID string, force bool) error {
-	handle, ok := d.tasks.Get(taskID)
-	if !ok {
-		return drivers.ErrTaskNotFound
+// StartTask returns a task handle and a driver network if necessary.
+func (d *WasmTaskDriverPlugin) StartTask(cfg *drivers.TaskConfig) (*drivers.TaskHandle, *drivers.DriverNetwork, error) {
+	if d.shuttingDown.Load() {
+		return nil, nil, errors.New("driver is shutting down")
 	}
 
-	if handle.IsRunning() && !force {
-		return errors.New("cannot destroy running task")
+	if h, ok := d.tasks.Get(cfg.ID); ok {
+		if h.isDestroyed() {
+			return nil, nil, fmt.Errorf("task with ID %q is destroyed, but its module is still running", cfg.ID)
+		}
+
+		return nil, nil, fmt.Errorf("task with ID %q already started", cfg.ID)
 	}
 
-	// Destroying a task includes removing any resources used by task and any
-	// local references in the plugin. If force is set to true the task should
-	// be destroyed even if it's currently running.
-	//
+	var driverConfig TaskConfig
+	if err := cfg.DecodeDriverConfig(&driverConfig); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode driver config: %v", err)
+	}
 
-	if handle.IsRunning() && force {
-		handle.instance.Stop()
+	correlationID, err := taskCorrelationID(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate correlation ID: %v", err)
 	}
 
-	d.tasks.Delete(taskID)
+	// All logs of the task carry its correlation ID.
+	logger := d.logger.With("correlation_id", correlationID)
 
-	return nil
-}
+	logger.Info("starting task", "driver_cfg", hclog.Fmt("%+v", driverConfig))
 
-// InspectTask returns detailed status information for the referenced taskID.
-func (d *WasmTaskDriverPlugin) InspectTask(taskID string) (*drivers.TaskStatus, error) {
-	handle, ok := d.tasks.Get(taskID)
-	if !ok {
-		return nil, drivers.ErrTaskNotFound
+	if err = validateMemoryConfig(cfg, driverConfig.Memory); err != nil {
+		return nil, nil, err
 	}
 
-	return handle.TaskStatus(), nil
-}
+	driverConfig.Memory.LimitMB = taskMemoryLimitMB(cfg, driverConfig.Memory)
 
-// TaskStats returns a channel which the driver should send stats to at the given interval.
-func (d *WasmTaskDriverPlugin) TaskStats(ctx context.Context, taskID string, interval time.Duration) (<-chan *drivers.TaskResourceUsage, error) {
-	_, ok := d.tasks.Get(taskID)
-	if !ok {
-		return nil, drivers.ErrTaskNotFound
+	if limitPages := driverConfig.Memory.limitPages(); limitPages > 0 && driverConfig.Memory.InitialPages > limitPages {
+		return nil, nil, fmt.Errorf("memory initial pages (%d) exceed memory limit of %d MB",
+			driverConfig.Memory.InitialPages, driverConfig.Memory.LimitMB)
 	}
 
-	// This function returns a channel that Nomad will use to listen for task
-	// stats (e.g., CPU and memory usage) in a given interval. It should send
-	// stats until the context is canceled or the task stops running.
-	ch := make(chan *drivers.TaskResourceUsage)
-	go d.handleTaskStats(ctx, interval, ch)
+	if ioBuffer := driverConfig.IOBuffer; ioBuffer.Enabled && ioBuffer.Size <= 0 {
+		return nil, nil, fmt.Errorf("IO buffer size must be > 0, but specified %v", ioBuffer.Size)
+	}
 
-	return ch, nil
-}
+	if ioBuffer := driverConfig.IOBuffer; ioBuffer.Enabled &&
+		ioBuffer.Overflow != ioBufferOverflowError && ioBuffer.Overflow != ioBufferOverflowTruncate {
+		return nil, nil, fmt.Errorf("unexpected IO buffer overflow policy, expected policies: [error, truncate], but specified %s",
+			ioBuffer.Overflow)
+	}
 
-func (d *WasmTaskDriverPlugin) handleTaskStats(ctx context.Context, interval time.Duration, ch chan<- *drivers.TaskResourceUsage) {
-	defer close(ch)
+	if ioBuffer := driverConfig.IOBuffer; ioBuffer.Enabled &&
+		ioBuffer.NoOutput != ioBufferNoOutputError && ioBuffer.NoOutput != ioBufferNoOutputEmpty {
+		return nil, nil, fmt.Errorf("unexpected IO buffer no output policy, expected policies: [error, empty], but specified %s",
+			ioBuffer.NoOutput)
+	}
+
+	if ioBuffer := driverConfig.IOBuffer; ioBuffer.Enabled &&
+		ioBuffer.OutputSize != ioBufferOutputSizeResult && ioBuffer.OutputSize != ioBufferOutputSizeBuffer {
+		return nil, nil, fmt.Errorf("unexpected IO buffer output size, expected sizes: [result, buffer], but specified %s",
+			ioBuffer.OutputSize)
+	}
 
-	ticker := time.NewTicker(interval)
+	if driverConfig.LogWriteError != logWriteErrorDiscard && driverConfig.LogWriteError != logWriteErrorFail {
+		return nil, nil, fmt.Errorf("unexpected log write error policy, expected policies: [discard, fail], but specified %s",
+			driverConfig.LogWriteError)
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-d.ctx.Done():
-			return
-		case <-ticker.C:
-			ch <- &drivers.TaskResourceUsage{
-				ResourceUsage: &drivers.ResourceUsage{
-					MemoryStats: &drivers.MemoryStats{},
-					CpuStats:    &drivers.CpuStats{},
-					DeviceStats: make([]*device.DeviceGroupStats, 0),
-				},
-			}
-		}
+	if driverConfig.ConcurrentExec != concurrentExecSerialize && driverConfig.ConcurrentExec != concurrentExecReject {
+		return nil, nil, fmt.Errorf(
+			"unexpected concurrent exec policy, expected policies: [serialize, reject], but specified %s",
+			driverConfig.ConcurrentExec)
 	}
-}
 
-// TaskEvents returns a channel that the plugin can use to emit task related events.
-func (d *WasmTaskDriverPlugin) TaskEvents(ctx context.Context) (<-chan *drivers.TaskEvent, error) {
-	return d.eventer.TaskEvents(ctx)
-}
+	if driverConfig.Main.MainFuncName == "" && !(driverConfig.IOBuffer.Enabled && driverConfig.IOBuffer.ProcessFuncName != "") {
+		return nil, nil, errors.New("main function name or IO buffer process function name must be specified")
+	}
 
-// SignalTask forwards a signal to a task.
-func (d *WasmTaskDriverPlugin) SignalTask(taskID string, _signal string) error {
-	_, ok := d.tasks.Get(taskID)
-	if !ok {
-		return drivers.ErrTaskNotFound
+	if alerts := driverConfig.Alerts; alerts.Enabled && (alerts.MemoryThreshold <= 0 || alerts.MemoryThreshold > 100) {
+		return nil, nil, fmt.Errorf("alerts memory threshold must be in range (0, 100], but specified %v", alerts.MemoryThreshold)
 	}
 
-	return errors.New("this driver does not support signal forwarding")
-}
+	if timeouts := driverConfig.Timeouts; timeouts.InitTimeout < 0 || timeouts.RunTimeout < 0 || timeouts.PostTimeout < 0 {
+		return nil, nil, fmt.Errorf("timeouts must be >= 0, but specified init %v, run %v and post %v",
+			timeouts.InitTimeout, timeouts.RunTimeout, timeouts.PostTimeout)
+	}
 
-// ExecTask returns the result of executing the given command inside a task.
-func (d *WasmTaskDriverPlugin) ExecTask(_taskID string, _cmd []string, _timeout time.Duration) (*drivers.ExecTaskResult, error) {
-	// TODO: implement driver specific logic to execute commands in a task.
-	return nil, errors.New("this driver does not support exec")
+	if driverConfig.Main.ResultAsExitCode && driverConfig.IOBuffer.Enabled {
+		return nil, nil, errors.New("main resultAsExitCode can't be used with ioBuffer, the result is the output size")
+	}
+
+	exitMessages, err := parseExitCodeMessages(driverConfig.Main)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	validation := driverConfig.Validation
+	if (len(validation.Cases) > 0 || validation.CasesFile != "") && !driverConfig.IOBuffer.Enabled {
+		return nil, nil, errors.New("validation requires ioBuffer to be enabled")
+	}
+
+	var sink *resultSink
+	if driverConfig.ResultSink != nil {
+		sink, err = newResultSink(d.ctx, *driverConfig.ResultSink, cfg.TaskDir().Dir, d.config.ResultSink.AllowedURLs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if driverConfig.FuelLimit > 0 {
+		for _, engineName := range []string{driverConfig.Engine, driverConfig.FallbackEngine} {
+			if engineName != "" && !engineSupportsFuel(engineName) {
+				return nil, nil, fmt.Errorf("fuelLimit is not supported by %s engine", engineName)
+			}
+		}
+
+		if budget := d.config.FuelBudget.Total; budget > 0 && driverConfig.FuelLimit > budget {
+			return nil, nil, fmt.Errorf("fuelLimit %d exceeds the node fuel budget of %d", driverConfig.FuelLimit, budget)
+		}
+	}
+
+	if sum := driverConfig.ModuleChecksum; sum != "" {
+		if _, err = hex.DecodeString(sum); err != nil || len(sum) != 2*sha256.Size {
+			return nil, nil, fmt.Errorf("moduleChecksum must be a hex encoded SHA-256, but specified %q", sum)
+		}
+	}
+
+	handle := drivers.NewTaskHandle(taskHandleVersion)
+	handle.Config = cfg
+
+	// The plugin configuration may change after the job was placed, so make
+	// sure the engines are still available instead of failing on instantiation.
+	if err = d.checkEngineAvailable(driverConfig.Engine); err != nil {
+		return nil, nil, err
+	}
+
+	if driverConfig.FallbackEngine != "" {
+		if err = d.checkEngineAvailable(driverConfig.FallbackEngine); err != nil {
+			return nil, nil, fmt.Errorf("fallback %v", err)
+		}
+	}
+
+	if err = d.ensureEngineInitialized(driverConfig.Engine); err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize engine %s: %v", driverConfig.Engine, err)
+	}
+
+	modulePath, loader, err := loadModule(logger, cfg, driverConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The module file is read once, so the module file policy and the module
+	// inspection check the same content.
+	moduleData, moduleStat, err := readModuleFile(d.config.ModuleFilePolicy, modulePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Engines and module inspection work with the loaded module file.
+	driverConfig.ModulePath = modulePath
+
+	// Module info is mostly informational, a module which can't be inspected
+	// still runs.
+	moduleInfo, err := inspectModule(moduleData, moduleStat, moduleSource(loader))
+	if err != nil {
+		logger.Warn("unable to inspect module", "module", driverConfig.ModulePath, "error", hclog.Fmt("%+v", err))
+	}
+
+	if err = checkModuleChecksum(driverConfig, moduleInfo); err != nil {
+		return nil, nil, err
+	}
+
+	if err = checkModuleFeatures(driverConfig, moduleInfo); err != nil {
+		return nil, nil, err
+	}
+
+	if err = detectFuncNames(logger, &driverConfig, moduleInfo); err != nil {
+		return nil, nil, err
+	}
+
+	if err = checkEntrypoint(driverConfig, moduleInfo); err != nil {
+		return nil, nil, err
+	}
+
+	stopSignal := hostimports.NewStopSignal()
+
+	hostFuncs, hostCalls, err := buildHostFuncs(driverConfig.HostImports, correlationID, stopSignal)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dumps *memdumps
+	if d.config.Debug.Memdump {
+		dumps = newMemdumps()
+		hostFuncs = dumps.wrapHostFuncs(hostFuncs)
+	}
+
+	overcommit := newMemoryOvercommit(logger.With("task_id", cfg.ID), driverConfig.Memory)
+	hostFuncs = overcommit.wrapHostFuncs(hostFuncs)
+
+	quotas, err := newPreopenQuotas(logger.With("task_id", cfg.ID), cfg, driverConfig.Wasi)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileModes, err := newPreopenFileModes(logger.With("task_id", cfg.ID), cfg, driverConfig.Wasi)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wasi, err := buildWasiOptions(cfg, driverConfig.Wasi, d.config.Wasi, correlationID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stdio, err := newTaskStdio(logger, cfg, driverConfig.Wasi, wasi)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instanceConf := interfaces.InstanceConfig{
+		Wasi:           wasi,
+		HostFuncs:      hostFuncs,
+		Module:         moduleData,
+		MaxMemoryPages: driverConfig.Memory.maxPages(),
+		ModuleSHA256:   moduleInfo.sha256,
+		FuelLimit:      uint64(driverConfig.FuelLimit),
+	}
+
+	d.statsd.incr("tasks.started")
+
+	instantiateStart := time.Now()
+
+	newInstance, engineName, err := d.instantiateModule(logger, driverConfig, instanceConf)
+	if err != nil {
+		d.statsd.incr("tasks.instantiate_failed")
+
+		if stdio != nil {
+			stdio.close()
+		}
+
+		return nil, nil, err
+	}
+
+	if stdio != nil {
+		newInstance = stdioInstance{WasmInstance: newInstance, stdio: stdio}
+	}
+
+	d.statsd.timing("tasks.instantiate", time.Since(instantiateStart))
+
+	validationCases, err := loadValidationCases(cfg, driverConfig.Validation)
+	if err != nil {
+		newInstance.Cleanup()
+
+		return nil, nil, err
+	}
+
+	var verifyInstance interfaces.WasmInstance
+
+	if driverConfig.VerifyDeterminism {
+		verifyInstance, err = instantiateIsolatedInstance(driverConfig, engineName, instanceConf, correlationID, "", "")
+		if err != nil {
+			newInstance.Cleanup()
+
+			return nil, nil, fmt.Errorf("determinism verification: %v", err)
+		}
+	}
+
+	var events *eventLog
+
+	if driverConfig.EventLog.Enabled {
+		events, err = openEventLog(eventLogPath(cfg.TaskDir().LogDir, cfg.Name), cfg.ID, correlationID)
+		if err != nil {
+			newInstance.Cleanup()
+
+			if verifyInstance != nil {
+				verifyInstance.Cleanup()
+			}
+
+			return nil, nil, fmt.Errorf("failed to open event log: %v", err)
+		}
+	}
+
+	// Once the task is started you will need to store any relevant runtime
+	// information in a taskHandle and TaskState. The taskHandle will be
+	// stored in-memory in the plugin and will be used to interact with the
+	// task.
+	//
+	// The TaskState will be returned to the Nomad client inside a
+	// drivers.TaskHandle instance. This TaskHandle will be sent back to plugin
+	// if the task ever needs to be recovered, so the TaskState should contain
+	// enough information to handle that.
+
+	taskCtx, cancelTask := context.WithCancel(d.ctx)
+
+	h := &taskHandle{
+		ctx:            taskCtx,
+		cancel:         cancelTask,
+		taskConfig:     cfg,
+		procState:      drivers.TaskStateRunning,
+		startedAt:      time.Now().Round(time.Millisecond),
+		logger:         logger,
+		correlationID:  correlationID,
+		engineName:     engineName,
+		modulePath:     driverConfig.ModulePath,
+		ioBufferConf:   driverConfig.IOBuffer,
+		mainFunc:       driverConfig.Main,
+		exitMessages:   exitMessages,
+		hooks:          driverConfig.Hooks,
+		memoryConf:     driverConfig.Memory,
+		timeouts:       driverConfig.Timeouts,
+		logWriteError:  driverConfig.LogWriteError,
+		execPolicy:     driverConfig.ConcurrentExec,
+		execSlot:       make(chan struct{}, 1),
+		fuelLimit:      uint64(driverConfig.FuelLimit),
+		fuelBudget:     d.fuelBudget,
+		alerts:         driverConfig.Alerts,
+		eventLog:       events,
+		metrics:        d.statsd,
+		eventer:        d.eventer,
+		hostCalls:      hostCalls,
+		stopSignal:     stopSignal,
+		memdumps:       dumps,
+		overcommit:     overcommit,
+		stdio:          stdio,
+		preopenQuotas:  quotas,
+		fileModes:      fileModes,
+		moduleInfo:     moduleInfo,
+		resultSink:     sink,
+		resultDB:       d.resultDB,
+		instance:       newInstance,
+		moduleCached:   newInstance.ModuleCached(),
+		verifyInstance: verifyInstance,
+		completionCh:   make(chan struct{}),
+		destroyedCh:    make(chan struct{}),
+	}
+
+	// Sustained memory overuse and exceeded quotas interrupt the module
+	// wherever it runs.
+	if overcommit != nil {
+		overcommit.stop = h.stop
+	}
+
+	if quotas != nil {
+		quotas.stop = h.stop
+	}
+
+	if len(validationCases) > 0 {
+		h.validate = func(ctx context.Context) error {
+			return runValidationSuite(ctx, logger, cfg, driverConfig, engineName, moduleInfo, instanceConf,
+				correlationID, validationCases)
+		}
+	}
+
+	h.newExecInstance = func(stdoutPath, stderrPath string) (interfaces.WasmInstance, error) {
+		return instantiateIsolatedInstance(driverConfig, engineName, instanceConf, correlationID, stdoutPath, stderrPath)
+	}
+
+	driverState := TaskState{
+		ReattachConfig: &structs.ReattachConfig{},
+		TaskConfig:     cfg,
+		StartedAt:      h.startedAt,
+		CorrelationID:  correlationID,
+	}
+
+	if err := handle.SetDriverState(&driverState); err != nil {
+		// need to cleanup resources.
+		h.cancel()
+		h.cleanupInstances()
+		_ = h.eventLog.Close()
+
+		return nil, nil, fmt.Errorf("failed to set driver state: %v", err)
+	}
+
+	d.emitConfigWarnings(logger, cfg, configWarnings(driverConfig, newInstance))
+
+	d.tasks.Set(cfg.ID, h)
+	go h.run()
+
+	return handle, nil, nil
+}
+
+// engineSupportsFuel reports whether the engine supports fuel limits.
+func engineSupportsFuel(engineName string) bool {
+	engine, err := engines.Get(engineName)
+	if err != nil {
+		return false
+	}
+
+	return engine.Info().Fuel
+}
+
+// checkModuleFeatures returns an error if neither the task engine nor its
+// fallback engine supports all the WASM proposals the module uses, so the task
+// fails with a clear error rather than on instantiation. Proposals an engine
+// doesn't report on aren't checked.
+func checkModuleFeatures(driverConfig TaskConfig, moduleInfo moduleInfo) error {
+	unsupported := unsupportedFeatures(driverConfig.Engine, moduleInfo.features)
+	if len(unsupported) == 0 {
+		return nil
+	}
+
+	fallback := driverConfig.FallbackEngine
+	if fallback != "" && len(unsupportedFeatures(fallback, moduleInfo.features)) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("module uses WASM features not supported by %s engine: %s", driverConfig.Engine,
+		strings.Join(unsupported, ", "))
+}
+
+// unsupportedFeatures returns the features the engine reports it doesn't
+// support.
+func unsupportedFeatures(engineName string, features []string) []string {
+	engine, err := engines.Get(engineName)
+	if err != nil {
+		return nil
+	}
+
+	supported := engine.Info().Features
+
+	var unsupported []string
+
+	for _, feature := range features {
+		if ok, known := supported[feature]; known && !ok {
+			unsupported = append(unsupported, feature)
+		}
+	}
+
+	return unsupported
+}
+
+// checkEngineAvailable returns an error if the engine is not configured or is
+// disabled in the current plugin configuration.
+func (d *WasmTaskDriverPlugin) checkEngineAvailable(engineName string) error {
+	for _, engineConf := range d.config.Engines {
+		if engineConf.Name != engineName {
+			continue
+		}
+
+		if !engineConf.Enabled {
+			return fmt.Errorf("engine %s is disabled on this node", engineName)
+		}
+
+		return checkEngineVersion(engineConf)
+	}
+
+	return fmt.Errorf("engine %s is not configured on this node", engineName)
+}
+
+// loadModule loads the task module from the first of the module path and the
+// alternate module paths it is found at. It returns the loaded module file and
+// the loader which loaded it.
+func loadModule(logger hclog.Logger, cfg *drivers.TaskConfig, driverConfig TaskConfig,
+) (string, interfaces.ModuleLoader, error) {
+	paths := append([]string{driverConfig.ModulePath}, driverConfig.AlternateModulePaths...)
+	errs := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		loader, err := loaders.Get(path)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// A module missing from the task directory may be found at an alternate
+		// path, paths escaping the task directory fail the task.
+		resolved, err := resolveModulePath(cfg, path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", nil, err
+		}
+
+		if err == nil {
+			resolved, err = loader.Load(resolved)
+		}
+
+		if err != nil {
+			logger.Debug("unable to load module", "module", path, "error", hclog.Fmt("%+v", err))
+
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+
+			continue
+		}
+
+		if path != driverConfig.ModulePath {
+			logger.Info("module loaded from alternate path", "module", path, "module_path", driverConfig.ModulePath)
+		} else {
+			logger.Debug("module loaded", "module", path)
+		}
+
+		return resolved, loader, nil
+	}
+
+	if len(errs) == 1 {
+		return "", nil, fmt.Errorf("failed to load module %s", errs[0])
+	}
+
+	return "", nil, fmt.Errorf("failed to load module from any of its paths: %s", strings.Join(errs, "; "))
+}
+
+// resolveModulePath resolves a relative module path against the task
+// directory, where modules delivered by artifacts are downloaded. Such a path
+// must stay within the task directory, it is returned with symlinks resolved,
+// so the checked path is the one the module is loaded from. Relative paths of
+// tasks without an allocation directory are rejected, they would resolve
+// against the plugin working directory. Other paths are returned unchanged.
+func resolveModulePath(cfg *drivers.TaskConfig, modulePath string) (string, error) {
+	if strings.Contains(modulePath, "://") || filepath.IsAbs(modulePath) {
+		return modulePath, nil
+	}
+
+	if cfg.AllocDir == "" {
+		return "", fmt.Errorf("relative module path %s needs a task directory to resolve against", modulePath)
+	}
+
+	taskDir := cfg.TaskDir().Dir
+	path := filepath.Join(taskDir, modulePath)
+
+	if !isWithin(path, taskDir) {
+		return "", fmt.Errorf("module path %s is outside of the task directory", modulePath)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve module path %s: %w", modulePath, err)
+	}
+
+	// Modules linked from outside of the task directory are rejected like
+	// paths escaping it.
+	if resolvedTaskDir, err := filepath.EvalSymlinks(taskDir); err != nil || !isWithin(resolved, resolvedTaskDir) {
+		return "", fmt.Errorf("module path %s is outside of the task directory", modulePath)
+	}
+
+	return resolved, nil
+}
+
+// instantiateModule instantiates the task module with the task engine and, if
+// that fails, with the fallback engine. It returns the instance and the name of
+// the engine which created it.
+func (d *WasmTaskDriverPlugin) instantiateModule(logger hclog.Logger, driverConfig TaskConfig,
+	instanceConf interfaces.InstanceConfig,
+) (interfaces.WasmInstance, string, error) {
+	engine, err := engines.Get(driverConfig.Engine)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get %s engine: %v", driverConfig.Engine, err)
+	}
+
+	instanceConf.Statistics = d.statisticsEnabled(driverConfig.Engine)
+
+	instance, err := engine.InstantiateModule(driverConfig.ModulePath, instanceConf)
+	if err == nil {
+		return instance, driverConfig.Engine, nil
+	}
+
+	if driverConfig.FallbackEngine == "" {
+		return nil, "", fmt.Errorf("failed to instantiate module %s: %v", driverConfig.ModulePath, err)
+	}
+
+	logger.Warn("unable to instantiate module, trying fallback engine", "module", driverConfig.ModulePath,
+		"engine", driverConfig.Engine, "fallback_engine", driverConfig.FallbackEngine, "error", hclog.Fmt("%+v", err))
+
+	if fallbackErr := d.ensureEngineInitialized(driverConfig.FallbackEngine); fallbackErr != nil {
+		return nil, "", fmt.Errorf("failed to initialize %s fallback engine: %v", driverConfig.FallbackEngine, fallbackErr)
+	}
+
+	fallback, fallbackErr := engines.Get(driverConfig.FallbackEngine)
+	if fallbackErr != nil {
+		return nil, "", fmt.Errorf("failed to get %s fallback engine: %v", driverConfig.FallbackEngine, fallbackErr)
+	}
+
+	instanceConf.Statistics = d.statisticsEnabled(driverConfig.FallbackEngine)
+
+	instance, fallbackErr = fallback.InstantiateModule(driverConfig.ModulePath, instanceConf)
+	if fallbackErr != nil {
+		return nil, "", fmt.Errorf("failed to instantiate module %s with %s engine: %v, and with %s fallback engine: %v",
+			driverConfig.ModulePath, driverConfig.Engine, err, driverConfig.FallbackEngine, fallbackErr)
+	}
+
+	logger.Info("module instantiated with fallback engine", "module", driverConfig.ModulePath,
+		"engine", driverConfig.FallbackEngine)
+
+	return instance, driverConfig.FallbackEngine, nil
+}
+
+// statisticsEnabled reports whether the engine collects execution statistics.
+func (d *WasmTaskDriverPlugin) statisticsEnabled(engineName string) bool {
+	for _, engineConf := range d.config.Engines {
+		if engineConf.Name == engineName {
+			return engineConf.Statistics
+		}
+	}
+
+	return false
+}
+
+// instantiateIsolatedInstance creates an instance the module is run in apart
+// from the task instance, e.g. to verify its determinism. It is instantiated
+// from the module binary of the task instance configuration with the engine
+// that runs the task and gets its own store and host imports, so its runs
+// start from the same state as the task run. Its WASI output is written to the
+// given paths, or discarded if they are empty, and its preopened directories
+// are scratch copies of the task ones, removed when the instance is cleaned
+// up, so its runs don't change the task files.
+func instantiateIsolatedInstance(driverConfig TaskConfig, engineName string, taskConf interfaces.InstanceConfig,
+	correlationID, stdoutPath, stderrPath string,
+) (interfaces.WasmInstance, error) {
+	// Isolated instances run apart from the task and aren't stopped with it.
+	hostFuncs, _, err := buildHostFuncs(driverConfig.HostImports, correlationID, hostimports.NewStopSignal())
+	if err != nil {
+		return nil, err
+	}
+
+	engine, err := engines.Get(engineName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s engine: %v", engineName, err)
+	}
+
+	instanceConf := interfaces.InstanceConfig{
+		HostFuncs:      hostFuncs,
+		Module:         taskConf.Module,
+		MaxMemoryPages: taskConf.MaxMemoryPages,
+		ModuleSHA256:   taskConf.ModuleSHA256,
+		FuelLimit:      taskConf.FuelLimit,
+	}
+
+	var scratchDir string
+
+	// The output of isolated runs isn't written to the task logs.
+	if wasi := taskConf.Wasi; wasi != nil {
+		instanceConf.Wasi = &interfaces.WasiOptions{
+			Env:        wasi.Env,
+			StdoutPath: stdoutPath,
+			StderrPath: stderrPath,
+		}
+
+		if len(wasi.PreopenDirs) > 0 {
+			instanceConf.Wasi.PreopenDirs, scratchDir, err = scratchPreopenDirs(wasi.PreopenDirs)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	instance, err := engine.InstantiateModule(driverConfig.ModulePath, instanceConf)
+	if err != nil {
+		if scratchDir != "" {
+			os.RemoveAll(scratchDir)
+		}
+
+		return nil, fmt.Errorf("failed to instantiate module %s: %v", driverConfig.ModulePath, err)
+	}
+
+	if scratchDir != "" {
+		return &scratchInstance{WasmInstance: instance, dir: scratchDir}, nil
+	}
+
+	return instance, nil
+}
+
+// scratchInstance is an isolated instance removing its scratch directory when
+// it is cleaned up.
+type scratchInstance struct {
+	interfaces.WasmInstance
+	dir string
+}
+
+func (i *scratchInstance) Cleanup() {
+	i.WasmInstance.Cleanup()
+	os.RemoveAll(i.dir)
+}
+
+// moduleInfo describes the module file a task runs, so operators can audit
+// exactly what is running.
+type moduleInfo struct {
+	modTime time.Time
+	sha256  string
+	// source is where the module was loaded from: local for the local file
+	// system, the loader scheme otherwise.
+	source string
+	// features lists WASM proposals used by the module.
+	features []string
+	// funcExports lists the functions exported by the module, nil if unknown.
+	funcExports []string
+}
+
+// moduleSource returns the source modules loaded with the loader are reported
+// with.
+func moduleSource(loader interfaces.ModuleLoader) string {
+	if loader.Scheme() == loaders.DefaultScheme {
+		return moduleSourceLocal
+	}
+
+	return loader.Scheme()
+}
+
+// isReactor reports whether the module is a WASI reactor, which exports
+// _initialize to be called before any other export, rather than a command,
+// which exports _start and initializes itself.
+func (info moduleInfo) isReactor() bool {
+	return slices.Contains(info.funcExports, reactorInitFuncName) &&
+		!slices.Contains(info.funcExports, commandStartFuncName)
+}
+
+// inspectModule returns the info of the module read from a file of the given
+// info, recording the source the module was loaded from. Fields which were
+// collected before a failure are still returned.
+func inspectModule(wasm []byte, stat fs.FileInfo, source string) (moduleInfo, error) {
+	info := moduleInfo{source: source, modTime: stat.ModTime()}
+
+	hash := sha256.Sum256(wasm)
+	info.sha256 = hex.EncodeToString(hash[:])
+
+	var featuresErr, exportsErr error
+
+	info.features, featuresErr = modinfo.Features(wasm)
+	info.funcExports, exportsErr = modinfo.FuncExports(wasm)
+
+	return info, errors.Join(featuresErr, exportsErr)
+}
+
+// checkModuleChecksum fails if the module checksum is set and doesn't match the
+// module content hash computed when it was inspected.
+func checkModuleChecksum(driverConfig TaskConfig, info moduleInfo) error {
+	if driverConfig.ModuleChecksum == "" {
+		return nil
+	}
+
+	if info.sha256 == "" {
+		return fmt.Errorf("unable to verify checksum of module %s: module can't be read", driverConfig.ModulePath)
+	}
+
+	if !strings.EqualFold(info.sha256, driverConfig.ModuleChecksum) {
+		return fmt.Errorf("checksum mismatch for module %s: expected sha256 %s, but module has %s",
+			driverConfig.ModulePath, strings.ToLower(driverConfig.ModuleChecksum), info.sha256)
+	}
+
+	return nil
+}
+
+// checkEntrypoint fails if the module is known not to export the function the
+// task calls, listing the functions it exports instead of failing later with
+// an opaque error.
+func checkEntrypoint(driverConfig TaskConfig, info moduleInfo) error {
+	// Exports are unknown if the module couldn't be inspected.
+	if info.funcExports == nil {
+		return nil
+	}
+
+	entrypoint, param := driverConfig.Main.MainFuncName, "main.mainFuncName"
+	if driverConfig.IOBuffer.Enabled && driverConfig.IOBuffer.ProcessFuncName != "" {
+		entrypoint, param = driverConfig.IOBuffer.ProcessFuncName, "ioBuffer.processFuncName"
+	}
+
+	for _, export := range info.funcExports {
+		if export == entrypoint {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("module %s doesn't export %s function, set %s to one of the exported functions: [%s]",
+		driverConfig.ModulePath, entrypoint, param, strings.Join(info.funcExports, ", "))
+}
+
+func validateMemoryConfig(cfg *drivers.TaskConfig, memoryConf MemoryConfig) error {
+	if memoryConf.InitialPages > maxMemoryPages {
+		return fmt.Errorf("memory initial pages must be <= %d, but specified %d", maxMemoryPages, memoryConf.InitialPages)
+	}
+
+	if memoryConf.LimitMB < 0 {
+		return fmt.Errorf("memory limit must be >= 0, but specified %d", memoryConf.LimitMB)
+	}
+
+	if memoryConf.OvercommitMB < 0 || memoryConf.OvercommitGrace < 0 {
+		return fmt.Errorf("memory overcommit and its grace must be >= 0, but specified %d MB and %ds",
+			memoryConf.OvercommitMB, memoryConf.OvercommitGrace)
+	}
+
+	if cfg.Resources == nil || cfg.Resources.NomadResources == nil {
+		return nil
+	}
+
+	memoryLimit := cfg.Resources.NomadResources.Memory.MemoryMB * 1024 * 1024
+
+	//nolint:gosec
+	if memoryLimit > 0 && int64(memoryConf.InitialPages)*wasmPageSize > memoryLimit {
+		return fmt.Errorf("memory initial pages (%d) exceed task memory limit of %d MB",
+			memoryConf.InitialPages, cfg.Resources.NomadResources.Memory.MemoryMB)
+	}
+
+	return nil
+}
+
+// taskMemoryLimitMB returns the module memory limit of the task: the
+// configured limit, or the task memory resource if it isn't set.
+func taskMemoryLimitMB(cfg *drivers.TaskConfig, memoryConf MemoryConfig) int64 {
+	if memoryConf.LimitMB > 0 || cfg.Resources == nil || cfg.Resources.NomadResources == nil {
+		return memoryConf.LimitMB
+	}
+
+	return cfg.Resources.NomadResources.Memory.MemoryMB
+}
+
+// RecoverTask recreates the in-memory state of a task from a TaskHandle.
+// Modules run inside the plugin process, so a task can't survive a plugin
+// restart: it is recovered as exited with an error, which lets Nomad reconcile
+// it and apply the task restart policy.
+func (d *WasmTaskDriverPlugin) RecoverTask(handle *drivers.TaskHandle) error {
+	if handle == nil || handle.Config == nil {
+		return errors.New("handle and its task config cannot be nil")
+	}
+
+	if handle.Version != taskHandleVersion {
+		return fmt.Errorf("unable to recover task handle version %d, expected version %d", handle.Version, taskHandleVersion)
+	}
+
+	if _, ok := d.tasks.Get(handle.Config.ID); ok {
+		return nil
+	}
+
+	var taskState TaskState
+	if err := handle.GetDriverState(&taskState); err != nil {
+		return fmt.Errorf("failed to decode task state from handle: %v", err)
+	}
+
+	if taskState.TaskConfig == nil {
+		taskState.TaskConfig = handle.Config
+	}
+
+	logger := d.logger.With("correlation_id", taskState.CorrelationID)
+
+	logger.Info("recovering task lost on plugin restart", "task_id", handle.Config.ID)
+
+	// The recovered task has nothing to interrupt, so its context is canceled
+	// right away.
+	taskCtx, cancelTask := context.WithCancel(d.ctx)
+	cancelTask()
+
+	h := &taskHandle{
+		ctx:           taskCtx,
+		cancel:        cancelTask,
+		taskConfig:    taskState.TaskConfig,
+		procState:     drivers.TaskStateExited,
+		startedAt:     taskState.StartedAt,
+		completedAt:   time.Now(),
+		logger:        logger,
+		correlationID: taskState.CorrelationID,
+		exitResult: &drivers.ExitResult{
+			Err: errors.New("task execution was lost when the plugin restarted"),
+		},
+		completionCh: make(chan struct{}),
+		destroyedCh:  make(chan struct{}),
+	}
+
+	close(h.completionCh)
+
+	d.tasks.Set(handle.Config.ID, h)
+
+	return nil
+}
+
+// WaitTask returns a channel used to notify Nomad when a task exits.
+func (d *WasmTaskDriverPlugin) WaitTask(ctx context.Context, taskID string) (<-chan *drivers.ExitResult, error) {
+	handle, ok := d.tasks.Get(taskID)
+	if !ok {
+		return nil, drivers.ErrTaskNotFound
+	}
+
+	ch := make(chan *drivers.ExitResult)
+
+	handle.watch(func() { d.handleWait(ctx, handle, ch) })
+
+	return ch, nil
+}
+
+func (d *WasmTaskDriverPlugin) handleWait(ctx context.Context, handle *taskHandle, ch chan *drivers.ExitResult) {
+	defer close(ch)
+
+	// When a result is sent in the result channel Nomad will stop the task and
+	// emit an event that an operator can use to get an insight on why the task
+	// stopped.
+	//
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-d.ctx.Done():
+		return
+	case <-handle.destroyedCh:
+		return
+	case <-handle.completionCh:
+	}
+
+	// The result is sent once. Waiting stops if nobody receives it, instead of
+	// leaking the goroutine.
+	select {
+	case <-ctx.Done():
+	case <-d.ctx.Done():
+	case <-handle.destroyedCh:
+	case ch <- handle.exitResult:
+	}
+}
+
+// StopTask stops a running task with the given signal and within the timeout window.
+func (d *WasmTaskDriverPlugin) StopTask(taskID string, timeout time.Duration, signal string) error {
+	handle, ok := d.tasks.Get(taskID)
+	if !ok {
+		return drivers.ErrTaskNotFound
+	}
+
+	if signal == "" {
+		signal = defaultStopSignal
+	}
+
+	signum, ok := stopSignalNumbers[signal]
+	if !ok {
+		return fmt.Errorf("unsupported stop signal %s", signal)
+	}
+
+	// Signals can't be delivered to a WASM module, so the module is told it is
+	// stopped through the stop_signal host import and given the kill timeout
+	// to finish on its own, like a process that handles the stop signal, before
+	// it is force-killed. SIGKILL force-kills it right away.
+	if signal == "SIGKILL" {
+		timeout = 0
+	} else {
+		handle.raiseStopSignal(signum)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-handle.completionCh:
+		return nil
+	case <-timer.C:
+	}
+
+	handle.logger.Debug("kill timeout reached, interrupting task", "task_id", taskID, "timeout", timeout, "signal", signal)
+
+	handle.recordEvent(eventTypeInterrupted, "kill timeout reached", map[string]string{
+		"signal":  signal,
+		"timeout": timeout.String(),
+	})
+
+	handle.cancel()
+
+	// The module is interrupted wherever it runs, StopTask returns once it
+	// did, so Nomad doesn't consider the task stopped while it still runs.
+	select {
+	case <-handle.completionCh:
+		return nil
+	case <-time.After(destroyWaitTimeout):
+		return fmt.Errorf("task %s did not stop within %v of being interrupted", taskID, destroyWaitTimeout)
+	}
+}
+
+// DestroyTask cleans up and removes a task that has terminated.
+func (d *WasmTaskDriverPlugin) DestroyTask(taskID string, force bool) error {
+	handle, ok := d.tasks.Get(taskID)
+	if !ok {
+		return drivers.ErrTaskNotFound
+	}
+
+	if handle.IsRunning() && !force {
+		return errors.New("cannot destroy running task")
+	}
+
+	// Destroying a task includes removing any resources used by task and any
+	// local references in the plugin. If force is set to true the task should
+	// be destroyed even if it's currently running.
+	//
+
+	stopped := true
+
+	if handle.IsRunning() && force {
+		handle.cancel()
+
+		// The task ID may be started again right after it is destroyed, so make
+		// sure the interrupted run has released its instance before forgetting
+		// the handle.
+		select {
+		case <-handle.completionCh:
+		case <-time.After(destroyWaitTimeout):
+			handle.logger.Warn("task did not stop before being destroyed, keeping its ID reserved until it does",
+				"task_id", taskID, "timeout", destroyWaitTimeout)
+
+			stopped = false
+		}
+	}
+
+	// Goroutines serving the task stats and wait channels would otherwise keep
+	// running until Nomad cancels their contexts, accumulating over the agent
+	// lifetime.
+	if !handle.destroy(destroyWaitTimeout) {
+		handle.logger.Warn("task stats and wait channels did not close before being destroyed", "task_id", taskID,
+			"timeout", destroyWaitTimeout)
+	}
+
+	if stopped {
+		d.tasks.DeleteHandle(taskID, handle)
+
+		return nil
+	}
+
+	// A module which doesn't return when interrupted still uses its instance,
+	// so the ID can't be started again until it does.
+	go func() {
+		<-handle.completionCh
+
+		d.tasks.DeleteHandle(taskID, handle)
+	}()
+
+	return nil
+}
+
+// InspectTask returns detailed status information for the referenced taskID.
+func (d *WasmTaskDriverPlugin) InspectTask(taskID string) (*drivers.TaskStatus, error) {
+	handle, ok := d.tasks.Get(taskID)
+	if !ok {
+		return nil, drivers.ErrTaskNotFound
+	}
+
+	return handle.TaskStatus(), nil
+}
+
+// TaskStats returns a channel which the driver should send stats to at the given interval.
+func (d *WasmTaskDriverPlugin) TaskStats(ctx context.Context, taskID string, interval time.Duration) (<-chan *drivers.TaskResourceUsage, error) {
+	handle, ok := d.tasks.Get(taskID)
+	if !ok {
+		return nil, drivers.ErrTaskNotFound
+	}
+
+	// This function returns a channel that Nomad will use to listen for task
+	// stats (e.g., CPU and memory usage) in a given interval. It should send
+	// stats until the context is canceled or the task stops running.
+	ch := make(chan *drivers.TaskResourceUsage)
+
+	handle.watch(func() { d.handleTaskStats(ctx, handle, interval, ch) })
+
+	return ch, nil
+}
+
+func (d *WasmTaskDriverPlugin) handleTaskStats(ctx context.Context, handle *taskHandle, interval time.Duration,
+	ch chan<- *drivers.TaskResourceUsage,
+) {
+	defer close(ch)
+
+	// The first sample is sent immediately, so tasks shorter than the interval
+	// still report their usage.
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	// memoryAlerted is set once the memory alert is emitted, so an alert is sent
+	// once per threshold crossing instead of on every stats collection.
+	var memoryAlerted bool
+
+	lastExecTime, lastCollected := handle.mainExecTime(), time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.ctx.Done():
+			return
+		case <-handle.destroyedCh:
+			return
+		case <-timer.C:
+			timer.Reset(interval)
+		}
+
+		memoryAlerted = d.checkMemoryAlert(handle, memoryAlerted)
+
+		// The module memory is the only memory a task allocates, and the CPU usage
+		// is approximated by the share of the interval the main function ran for.
+		memory, _ := handle.memoryUsage()
+		execTime, now := handle.mainExecTime(), time.Now()
+
+		var cpuPercent float64
+		if elapsed := now.Sub(lastCollected); elapsed > 0 {
+			cpuPercent = float64(execTime-lastExecTime) / float64(elapsed) * 100
+		}
+
+		lastExecTime, lastCollected = execTime, now
+
+		usage := &drivers.TaskResourceUsage{
+			ResourceUsage: &drivers.ResourceUsage{
+				MemoryStats: &drivers.MemoryStats{
+					RSS:      memory,
+					Usage:    memory,
+					Measured: []string{"RSS", "Usage"},
+				},
+				CpuStats: &drivers.CpuStats{
+					Percent:  cpuPercent,
+					Measured: []string{"Percent"},
+				},
+				DeviceStats: make([]*device.DeviceGroupStats, 0),
+			},
+			Timestamp: now.UnixNano(),
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.ctx.Done():
+			return
+		case <-handle.destroyedCh:
+			return
+		case ch <- usage:
+		}
+	}
+}
+
+// checkMemoryAlert emits a task event when the module memory crosses the
+// configured share of the task memory limit and returns whether the memory is
+// above the threshold.
+func (d *WasmTaskDriverPlugin) checkMemoryAlert(handle *taskHandle, alerted bool) bool {
+	if !handle.alerts.Enabled {
+		return false
+	}
+
+	cfg := handle.taskConfig
+	if cfg.Resources == nil || cfg.Resources.NomadResources == nil || cfg.Resources.NomadResources.Memory.MemoryMB <= 0 {
+		return false
+	}
+
+	used, ok := handle.memoryUsage()
+	if !ok {
+		return alerted
+	}
+
+	//nolint:gosec
+	limit := uint64(cfg.Resources.NomadResources.Memory.MemoryMB) * 1024 * 1024
+	//nolint:gosec
+	above := used*100 >= limit*uint64(handle.alerts.MemoryThreshold)
+
+	if above && !alerted {
+		err := d.eventer.EmitEvent(&drivers.TaskEvent{
+			TaskID:    cfg.ID,
+			AllocID:   cfg.AllocID,
+			TaskName:  cfg.Name,
+			Timestamp: time.Now(),
+			Message: fmt.Sprintf("module memory usage %d MB exceeds %d%% of the task memory limit",
+				used/1024/1024, handle.alerts.MemoryThreshold),
+			Annotations: map[string]string{
+				"memory_used_bytes":  strconv.FormatUint(used, 10),
+				"memory_limit_bytes": strconv.FormatUint(limit, 10),
+				"correlation_id":     handle.correlationID,
+			},
+		})
+		if err != nil {
+			handle.logger.Warn("failed to emit memory alert event", "task_id", cfg.ID, "error", err)
+		}
+
+		handle.recordEvent(eventTypeMemoryAlert, fmt.Sprintf("module memory usage exceeds %d%% of the task memory limit",
+			handle.alerts.MemoryThreshold), map[string]string{
+			"memory_used_bytes":  strconv.FormatUint(used, 10),
+			"memory_limit_bytes": strconv.FormatUint(limit, 10),
+		})
+	}
+
+	return above
+}
+
+// TaskEvents returns a channel that the plugin can use to emit task related events.
+func (d *WasmTaskDriverPlugin) TaskEvents(ctx context.Context) (<-chan *drivers.TaskEvent, error) {
+	return d.eventer.TaskEvents(ctx)
+}
+
+// SignalTask forwards a signal to a task.
+func (d *WasmTaskDriverPlugin) SignalTask(taskID string, _signal string) error {
+	_, ok := d.tasks.Get(taskID)
+	if !ok {
+		return drivers.ErrTaskNotFound
+	}
+
+	return errors.New("this driver does not support signal forwarding")
+}
+
+// ExecTask returns the result of executing the given command inside a task.
+// The command is either a driver debugging command or the name of an exported
+// function of the module followed by its integer arguments.
+func (d *WasmTaskDriverPlugin) ExecTask(taskID string, cmd []string, timeout time.Duration) (*drivers.ExecTaskResult, error) {
+	handle, ok := d.tasks.Get(taskID)
+	if !ok {
+		return nil, drivers.ErrTaskNotFound
+	}
+
+	ctx := d.ctx
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return d.exec(ctx, handle, cmd)
+}
+
+// ExecTaskStreaming runs an exec command for `nomad alloc exec`. Commands
+// aren't interactive, so their output is written once they finish.
+func (d *WasmTaskDriverPlugin) ExecTaskStreaming(ctx context.Context, taskID string, opts *drivers.ExecOptions,
+) (*drivers.ExitResult, error) {
+	handle, ok := d.tasks.Get(taskID)
+	if !ok {
+		return nil, drivers.ErrTaskNotFound
+	}
+
+	result, err := d.exec(ctx, handle, opts.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := opts.Stdout.Write(result.Stdout); err != nil {
+		return nil, err
+	}
+
+	if _, err := opts.Stderr.Write(result.Stderr); err != nil {
+		return nil, err
+	}
+
+	return result.ExitResult, nil
 }
@@ -0,0 +1,1248 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclspecutils"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/hashicorp/nomad/plugins/shared/hclspec"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+	"huawei.com/wasm-task-driver/wasm/loaders"
+	"huawei.com/wasm-task-driver/wasm/modinfo"
+
+	_ "huawei.com/wasm-task-driver/wasm/loaders/local"
+)
+
+// testTimeout bounds how long tests wait for tasks.
+const testTimeout = 5 * time.Second
+
+// testPluginConfig configures the fake engine only.
+const testPluginConfig = `engines = [{ name = "fake" }]`
+
+// lockedBuffer collects the plugin logs written by task goroutines.
+type lockedBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.String()
+}
+
+// newTestDriver returns a driver configured with the plugin configuration
+// body, with its logs written to logs if not nil.
+func newTestDriver(t *testing.T, pluginConfig string, logs *lockedBuffer) *WasmTaskDriverPlugin {
+	t.Helper()
+
+	logger := hclog.NewNullLogger()
+	if logs != nil {
+		logger = hclog.New(&hclog.LoggerOptions{Output: logs, JSONFormat: true, Level: hclog.Debug})
+	}
+
+	d, _ := NewPlugin(logger).(*WasmTaskDriverPlugin)
+
+	if err := setTestConfig(t, d, pluginConfig); err != nil {
+		t.Fatalf("unable to set plugin config: %v", err)
+	}
+
+	t.Cleanup(d.Shutdown)
+
+	return d
+}
+
+// setTestConfig sets the plugin configuration body, applying the defaults of
+// the configuration schema like Nomad does.
+func setTestConfig(t *testing.T, d *WasmTaskDriverPlugin, pluginConfig string) error {
+	t.Helper()
+
+	var config Config
+
+	parseTestConfig(t, configSpec, pluginConfig, &config)
+
+	var data []byte
+	if err := base.MsgPackEncode(&data, &config); err != nil {
+		t.Fatalf("unable to encode plugin config: %v", err)
+	}
+
+	return d.SetConfig(&base.Config{PluginConfig: data})
+}
+
+// parseTestConfig decodes the configuration body into out like Nomad does: the
+// body is parsed as HCL1, like job specifications, and decoded with the
+// configuration schema, applying its defaults.
+func parseTestConfig(t *testing.T, spec *hclspec.Spec, body string, out interface{}) {
+	t.Helper()
+
+	var config map[string]interface{}
+	if err := hcl.Decode(&config, body); err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decSpec, diags := hclspecutils.Convert(spec)
+	if diags.HasErrors() {
+		t.Fatal(diags)
+	}
+
+	file, diags := hcljson.Parse(configJSON, "config.json")
+	if diags.HasErrors() {
+		t.Fatal(diags)
+	}
+
+	value, diags := hcldec.Decode(file.Body, decSpec, nil)
+	if diags.HasErrors() {
+		t.Fatalf("unable to decode config: %v", diags)
+	}
+
+	var encoded drivers.TaskConfig
+	if err = encoded.EncodeDriverConfig(value); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = encoded.DecodeDriverConfig(out); err != nil {
+		t.Fatalf("unable to decode config: %v", err)
+	}
+}
+
+// newTestTask returns a task running the module file module.wasm of its task
+// directory, configured with the task configuration body. The task stdout is
+// written to a regular file.
+func newTestTask(t *testing.T, id, taskConfig string) *drivers.TaskConfig {
+	t.Helper()
+
+	cfg := &drivers.TaskConfig{
+		ID:       id,
+		Name:     "task",
+		AllocID:  "alloc",
+		AllocDir: t.TempDir(),
+	}
+
+	taskDir := cfg.TaskDir()
+
+	for _, dir := range []string{taskDir.Dir, taskDir.LogDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeTestFile(t, filepath.Join(taskDir.Dir, "module.wasm"), "fake module")
+
+	cfg.StdoutPath = filepath.Join(taskDir.LogDir, "task.stdout.0")
+	cfg.StderrPath = filepath.Join(taskDir.LogDir, "task.stderr.0")
+
+	writeTestFile(t, cfg.StdoutPath, "")
+	writeTestFile(t, cfg.StderrPath, "")
+
+	var driverConfig TaskConfig
+
+	parseTestConfig(t, taskConfigSpec, "modulePath = \"module.wasm\"\n"+taskConfig, &driverConfig)
+
+	if err := cfg.EncodeConcreteDriverConfig(&driverConfig); err != nil {
+		t.Fatalf("unable to encode task config: %v", err)
+	}
+
+	return cfg
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useFakeInstances makes the fake engine instantiate modules with newInstance
+// for the rest of the test.
+func useFakeInstances(t *testing.T, newInstance func() *fakeInstance) {
+	t.Helper()
+
+	instantiateWith(t, func(interfaces.InstanceConfig) (*fakeInstance, error) {
+		return newInstance(), nil
+	})
+}
+
+// instantiateWith makes the fake engine instantiate modules with newInstance,
+// which can fail, for the rest of the test.
+func instantiateWith(t *testing.T, newInstance func(conf interfaces.InstanceConfig) (*fakeInstance, error)) {
+	t.Helper()
+
+	testEngine.lock.Lock()
+	testEngine.newInstance = newInstance
+	testEngine.confs = nil
+	testEngine.lock.Unlock()
+
+	t.Cleanup(func() {
+		testEngine.lock.Lock()
+		testEngine.newInstance = nil
+		testEngine.confs = nil
+		testEngine.lock.Unlock()
+	})
+}
+
+// waitTask waits for the task to exit and returns its exit result.
+func waitTask(t *testing.T, d *WasmTaskDriverPlugin, taskID string) *drivers.ExitResult {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	ch, err := d.WaitTask(ctx, taskID)
+	if err != nil {
+		t.Fatalf("unable to wait for task: %v", err)
+	}
+
+	result, ok := <-ch
+	if !ok {
+		t.Fatal("task didn't exit before timeout")
+	}
+
+	return result
+}
+
+// runTask starts the task, waits for it to exit and returns its exit result.
+func runTask(t *testing.T, d *WasmTaskDriverPlugin, cfg *drivers.TaskConfig) *drivers.ExitResult {
+	t.Helper()
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	return waitTask(t, d, cfg.ID)
+}
+
+// readStdout returns the task output written to the task stdout.
+func readStdout(t *testing.T, cfg *drivers.TaskConfig) string {
+	t.Helper()
+
+	data, err := os.ReadFile(cfg.StdoutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestStartTaskAfterDestroy(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	cfg := newTestTask(t, "restart", `engine = "fake"`)
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	if err := d.DestroyTask(cfg.ID, false); err != nil {
+		t.Fatalf("unable to destroy task: %v", err)
+	}
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result of the restarted task %+v", result)
+	}
+}
+
+func TestDestroyedTaskIDReservedUntilModuleReturns(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	release := make(chan struct{})
+
+	useFakeInstances(t, func() *fakeInstance {
+		// The module ignores Stop, like a host call blocking the instance.
+		return newFakeInstance(1).withFunc("handle_buffer", func(*fakeInstance, []interface{}) (interface{}, error) {
+			<-release
+
+			return int32(0), nil
+		})
+	})
+
+	cfg := newTestTask(t, "stuck", `engine = "fake"`)
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	if err := d.DestroyTask(cfg.ID, true); err != nil {
+		t.Fatalf("unable to destroy task: %v", err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err == nil {
+		t.Fatal("expected the ID of the still running module to stay reserved")
+	}
+
+	close(release)
+
+	deadline := time.Now().Add(testTimeout)
+
+	for {
+		if _, ok := d.tasks.Get(cfg.ID); !ok {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("task ID wasn't released after the module returned")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDestroyTaskStopsStatsAndWaitGoroutines(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", blockUntilStopped())
+	})
+
+	baseline := runtime.NumGoroutine()
+
+	// Nobody reads the channels and their contexts are never canceled, like
+	// Nomad clients which went away, so only destroying the tasks stops them.
+	var channels []<-chan *drivers.TaskResourceUsage
+
+	for i := 0; i < 50; i++ {
+		cfg := newTestTask(t, fmt.Sprintf("leak-%d", i), `engine = "fake"`)
+
+		if _, _, err := d.StartTask(cfg); err != nil {
+			t.Fatalf("unable to start task: %v", err)
+		}
+
+		stats, err := d.TaskStats(context.Background(), cfg.ID, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		channels = append(channels, stats)
+
+		if _, err = d.WaitTask(context.Background(), cfg.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = d.DestroyTask(cfg.ID, true); err != nil {
+			t.Fatalf("unable to destroy task: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(testTimeout)
+
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d goroutines after destroying the tasks, but got %d", baseline, runtime.NumGoroutine())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, stats := range channels {
+		if _, ok := <-stats; ok {
+			t.Fatal("expected the stats channel of the destroyed task to be closed")
+		}
+	}
+}
+
+func TestStartTaskFailsCleanlyOnInstantiationError(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	fail := true
+
+	instantiateWith(t, func(interfaces.InstanceConfig) (*fakeInstance, error) {
+		if fail {
+			return nil, errors.New("invalid engine configuration")
+		}
+
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0))), nil
+	})
+
+	cfg := newTestTask(t, "engine-error", `engine = "fake"`)
+
+	_, _, err := d.StartTask(cfg)
+	if err == nil || !strings.Contains(err.Error(), "invalid engine configuration") {
+		t.Fatalf("expected the engine error to be returned, but got %v", err)
+	}
+
+	if _, ok := d.tasks.Get(cfg.ID); ok {
+		t.Fatal("failed task wasn't cleaned up")
+	}
+
+	fail = false
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result of the started again task %+v", result)
+	}
+}
+
+func TestFallbackEngineRunsModuleFailingToInstantiate(t *testing.T) {
+	d := newTestDriver(t, `engines = [{ name = "fake" }, { name = "fake-fallback" }]`, nil)
+
+	instantiateWith(t, func(interfaces.InstanceConfig) (*fakeInstance, error) {
+		return nil, errors.New("unsupported proposal")
+	})
+
+	testFallbackEngine.lock.Lock()
+	testFallbackEngine.newInstance = func(interfaces.InstanceConfig) (*fakeInstance, error) {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0))), nil
+	}
+	testFallbackEngine.confs = nil
+	testFallbackEngine.lock.Unlock()
+
+	t.Cleanup(func() {
+		testFallbackEngine.lock.Lock()
+		testFallbackEngine.newInstance = nil
+		testFallbackEngine.confs = nil
+		testFallbackEngine.lock.Unlock()
+	})
+
+	cfg := newTestTask(t, "fallback", `engine = "fake"
+fallbackEngine = "fake-fallback"`)
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	if len(testEngine.instanceConfs()) != 1 || len(testFallbackEngine.instanceConfs()) != 1 {
+		t.Errorf("expected the module to be instantiated by both engines once, but got %d and %d instantiations",
+			len(testEngine.instanceConfs()), len(testFallbackEngine.instanceConfs()))
+	}
+
+	status, err := d.InspectTask(cfg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if engine := status.DriverAttributes["engine"]; engine != fakeFallbackEngineName {
+		t.Errorf("expected the task to run with the fallback engine, but got %s", engine)
+	}
+
+	// Both errors are reported if the fallback engine fails as well.
+	testFallbackEngine.lock.Lock()
+	testFallbackEngine.newInstance = nil
+	testFallbackEngine.lock.Unlock()
+
+	cfg = newTestTask(t, "both-failing", `engine = "fake"
+fallbackEngine = "fake-fallback"`)
+
+	if _, _, err = d.StartTask(cfg); err == nil ||
+		!strings.Contains(err.Error(), "with fake engine: unsupported proposal, and with fake-fallback fallback engine") {
+		t.Errorf("expected the task to fail with both engine errors, but got %v", err)
+	}
+}
+
+func TestModuleUsingUnsupportedFeatureRejected(t *testing.T) {
+	d := newTestDriver(t, `engines = [{ name = "fake" }, { name = "fake-fallback" }]`, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	testEngine.reportFeatures(t, map[string]bool{modinfo.FeatureSIMD: false})
+
+	// A function type taking a v128 value makes the module use SIMD, the type
+	// section goes between the module header and the export section.
+	module := wasmModule("handle_buffer")
+	simdModule := module[:8] + "\x01\x05\x01\x60\x01\x7b\x00" + module[8:]
+
+	cfg := newTestTask(t, "simd", `engine = "fake"`)
+	writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), simdModule)
+
+	if _, _, err := d.StartTask(cfg); err == nil ||
+		!strings.Contains(err.Error(), "module uses WASM features not supported by fake engine: simd") {
+		t.Fatalf("expected a module using SIMD to be rejected, but got %v", err)
+	}
+
+	if confs := testEngine.instanceConfs(); len(confs) != 0 {
+		t.Errorf("expected the module not to be instantiated, but got %d instantiations", len(confs))
+	}
+
+	// The task runs if its fallback engine supports the module features.
+	cfg = newTestTask(t, "simd-fallback", `engine = "fake"
+fallbackEngine = "fake-fallback"`)
+	writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), simdModule)
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Errorf("unexpected exit result %+v", result)
+	}
+}
+
+func TestEnginesInstantiateChecksummedModuleContent(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	hash := sha256.Sum256([]byte("fake module"))
+	checksum := hex.EncodeToString(hash[:])
+
+	cfg := newTestTask(t, "checksum", `engine = "fake"
+moduleChecksum = "`+checksum+`"
+verifyDeterminism = true`)
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	// The task instance and the determinism verification instance are
+	// instantiated from the content whose checksum was verified.
+	confs := testEngine.instanceConfs()
+	if len(confs) != 2 {
+		t.Fatalf("expected 2 instances, but got %d", len(confs))
+	}
+
+	for _, conf := range confs {
+		if string(conf.Module) != "fake module" || conf.ModuleSHA256 != checksum {
+			t.Errorf("expected the checked module content, but got %q with sha256 %s", conf.Module, conf.ModuleSHA256)
+		}
+	}
+
+	mismatched := newTestTask(t, "checksum-mismatch", `engine = "fake"
+moduleChecksum = "`+strings.Repeat("0", len(checksum))+`"`)
+
+	if _, _, err := d.StartTask(mismatched); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected the module to be rejected, but got %v", err)
+	}
+}
+
+func TestModuleProvenanceReported(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	cfg := newTestTask(t, "provenance", `engine = "fake"`)
+
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(cfg.TaskDir().Dir, "module.wasm"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	status, err := d.InspectTask(cfg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256([]byte("fake module"))
+
+	for name, expected := range map[string]string{
+		"module_sha256": hex.EncodeToString(hash[:]),
+		"module_mtime":  "2024-05-01T12:00:00Z",
+		"module_source": "local",
+	} {
+		if value := status.DriverAttributes[name]; value != expected {
+			t.Errorf("expected %s attribute %s, but got %s", name, expected, value)
+		}
+	}
+
+	// The engine keys the module by the reported digest instead of hashing
+	// the module again.
+	if confs := testEngine.instanceConfs(); len(confs) != 1 || confs[0].ModuleSHA256 != hex.EncodeToString(hash[:]) {
+		t.Errorf("expected the engine to get the reported digest, but got %+v", confs)
+	}
+}
+
+func TestModulePathConfinedToTaskDir(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	outside := filepath.Join(t.TempDir(), "outside.wasm")
+	writeTestFile(t, outside, wasmModule("handle_buffer"))
+
+	// A module missing from the task directory isn't looked up in the plugin
+	// working directory.
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Chdir(filepath.Dir(outside)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if chdirErr := os.Chdir(workDir); chdirErr != nil {
+			t.Error(chdirErr)
+		}
+	})
+
+	for _, test := range []struct {
+		link string
+		err  string
+	}{
+		{link: outside, err: "outside of the task directory"},
+		{err: "no such file or directory"},
+	} {
+		cfg := newTestTask(t, "confined", `engine = "fake"
+modulePath = "outside.wasm"`)
+
+		if test.link != "" {
+			if err = os.Symlink(test.link, filepath.Join(cfg.TaskDir().Dir, "outside.wasm")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, _, err = d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected module linked to %q to fail with %q, but got %v", test.link, test.err, err)
+		}
+	}
+
+	// Without a task directory, relative paths aren't resolved against the
+	// plugin working directory either.
+	cfg := newTestTask(t, "no-alloc-dir", `engine = "fake"
+modulePath = "outside.wasm"`)
+	cfg.AllocDir = ""
+
+	if _, _, err = d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), "needs a task directory") {
+		t.Errorf("expected relative module path without task directory to be rejected, but got %v", err)
+	}
+
+	// Modules linked within the task directory are loaded from the link target.
+	cfg = newTestTask(t, "linked", `engine = "fake"
+modulePath = "linked.wasm"`)
+	taskDir := cfg.TaskDir().Dir
+
+	if err = os.Symlink("module.wasm", filepath.Join(taskDir, "linked.wasm")); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	handle, ok := d.tasks.Get(cfg.ID)
+	if !ok {
+		t.Fatal("task not found")
+	}
+
+	if resolved, err := filepath.EvalSymlinks(filepath.Join(taskDir, "module.wasm")); err != nil ||
+		handle.modulePath != resolved {
+		t.Errorf("expected module loaded from %s, but got %s", resolved, handle.modulePath)
+	}
+}
+
+func TestAlternateModulePathUsedWhenModuleMissing(t *testing.T) {
+	logs := &lockedBuffer{}
+	d := newTestDriver(t, testPluginConfig, logs)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	cfg := newTestTask(t, "alternate", `engine = "fake"
+modulePath = "missing.wasm"
+alternateModulePaths = ["also-missing.wasm", "module.wasm"]`)
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	handle, ok := d.tasks.Get(cfg.ID)
+	if !ok {
+		t.Fatal("task not found")
+	}
+
+	if filepath.Base(handle.modulePath) != "module.wasm" {
+		t.Errorf("expected module loaded from the alternate path module.wasm, but got %s", handle.modulePath)
+	}
+
+	records := logRecords(t, logs, "module loaded from alternate path")
+	if len(records) != 1 || records[0]["module"] != "module.wasm" {
+		t.Errorf("expected the alternate path to be logged once, but got %v", records)
+	}
+
+	// The task fails naming every path if none has the module.
+	cfg = newTestTask(t, "all-missing", `engine = "fake"
+modulePath = "missing.wasm"
+alternateModulePaths = ["also-missing.wasm"]`)
+
+	if _, _, err := d.StartTask(cfg); err == nil ||
+		!strings.Contains(err.Error(), "failed to load module from any of its paths: missing.wasm") ||
+		!strings.Contains(err.Error(), "also-missing.wasm") {
+		t.Errorf("expected the task to fail naming the module paths, but got %v", err)
+	}
+}
+
+func TestModuleWithoutEntrypointRejected(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("other", returnValue(int32(0)))
+	})
+
+	for _, test := range []struct {
+		name       string
+		taskConfig string
+		err        string
+	}{
+		{
+			name:       "main",
+			taskConfig: `engine = "fake"`,
+			err:        "doesn't export handle_buffer function, set main.mainFuncName to one of the exported functions: [alloc, other]",
+		},
+		{
+			name: "process",
+			taskConfig: `engine = "fake"
+ioBuffer {
+  enabled = true
+  processFuncName = "process"
+}`,
+			err: "doesn't export process function, set ioBuffer.processFuncName to one of the exported functions: [alloc, other]",
+		},
+	} {
+		cfg := newTestTask(t, test.name, test.taskConfig)
+		writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), wasmModule("alloc", "other"))
+
+		if _, _, err := d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected task %s to fail with %q, but got %v", test.name, test.err, err)
+		}
+
+		if _, ok := d.tasks.Get(cfg.ID); ok {
+			t.Errorf("expected task %s not to be started", test.name)
+		}
+	}
+}
+
+func TestPreCacheHealthCheckResultFingerprinted(t *testing.T) {
+	d := newTestDriver(t, `engines = [{
+  name = "fake"
+  cache {
+    preCache {
+      enabled = true
+      modulesDir = "`+t.TempDir()+`"
+      healthCheck = true
+    }
+  }
+}]`, nil)
+
+	if fp := d.buildFingerprint(); fp.Health != drivers.HealthStateHealthy {
+		t.Fatalf("expected the driver to be healthy before any check, but got %s", fp.HealthDescription)
+	}
+
+	testEngine.corruptCache(t, 1, errors.New("modules directory is gone"))
+	d.checkPreCache(d.config.Engines[0])
+
+	fp := d.buildFingerprint()
+	if fp.Health != drivers.HealthStateUnhealthy || !strings.Contains(fp.HealthDescription, "modules directory is gone") {
+		t.Fatalf("expected the failed check to be reported, but got %s: %s", fp.Health, fp.HealthDescription)
+	}
+
+	testEngine.corruptCache(t, 1, nil)
+	d.checkPreCache(d.config.Engines[0])
+
+	if fp := d.buildFingerprint(); fp.Health != drivers.HealthStateHealthy {
+		t.Fatalf("expected the recovered cache to be reported healthy, but got %s", fp.HealthDescription)
+	}
+}
+
+func TestCacheAttributesFingerprinted(t *testing.T) {
+	d := newTestDriver(t, `engines = [{
+  name = "fake"
+  cache {
+    type = "arc"
+    size = 20
+  }
+}]`, nil)
+
+	attrs := d.buildFingerprint().Attributes
+
+	if enabled, ok := attrs["wasm.fake.cache.enabled"].GetBool(); !ok || !enabled {
+		t.Errorf("expected the cache to be fingerprinted enabled, but got %v", attrs["wasm.fake.cache.enabled"])
+	}
+
+	if cacheType, ok := attrs["wasm.fake.cache.type"].GetString(); !ok || cacheType != "arc" {
+		t.Errorf("expected cache type arc, but got %v", attrs["wasm.fake.cache.type"])
+	}
+
+	if size, ok := attrs["wasm.fake.cache.size"].GetInt(); !ok || size != 20 {
+		t.Errorf("expected cache size 20, but got %v", attrs["wasm.fake.cache.size"])
+	}
+
+	// A disabled cache has no type and size.
+	d = newTestDriver(t, `engines = [{
+  name = "fake"
+  cache {
+    enabled = false
+  }
+}]`, nil)
+
+	attrs = d.buildFingerprint().Attributes
+
+	if enabled, ok := attrs["wasm.fake.cache.enabled"].GetBool(); !ok || enabled {
+		t.Errorf("expected the cache to be fingerprinted disabled, but got %v", attrs["wasm.fake.cache.enabled"])
+	}
+
+	for _, name := range []string{"wasm.fake.cache.type", "wasm.fake.cache.size"} {
+		if attribute, ok := attrs[name]; ok {
+			t.Errorf("expected no %s attribute for a disabled cache, but got %v", name, attribute)
+		}
+	}
+}
+
+func TestEngineOlderThanMinVersionUnhealthy(t *testing.T) {
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	// The fake engine runtime is version 1.0.0.
+	for _, test := range []struct {
+		minVersion string
+		health     drivers.HealthState
+	}{
+		{minVersion: "1.0.0", health: drivers.HealthStateHealthy},
+		{minVersion: "1.2.0", health: drivers.HealthStateUnhealthy},
+	} {
+		d := newTestDriver(t, `engines = [{
+  name = "fake"
+  minVersion = "`+test.minVersion+`"
+}]`, nil)
+
+		fp := d.buildFingerprint()
+		if fp.Health != test.health {
+			t.Errorf("expected min version %s to be %s, but got %s: %s", test.minVersion, test.health, fp.Health,
+				fp.HealthDescription)
+		}
+
+		_, _, err := d.StartTask(newTestTask(t, test.minVersion, `engine = "fake"`))
+
+		if test.health == drivers.HealthStateHealthy && err != nil {
+			t.Errorf("unexpected error starting task with min version %s: %v", test.minVersion, err)
+		}
+
+		if test.health == drivers.HealthStateUnhealthy {
+			expected := "fake engine: version 1.0.0 is older than required min version 1.2.0"
+
+			if !strings.Contains(fp.HealthDescription, expected) {
+				t.Errorf("expected the health description to name the versions, but got %q", fp.HealthDescription)
+			}
+
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected the task to be refused, but got %v", err)
+			}
+		}
+	}
+}
+
+func TestFuelCalibrationFingerprinted(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	attribute := d.buildFingerprint().Attributes["wasm.fake.fuel_per_ms"]
+	if value, ok := attribute.GetInt(); !ok || value != testFuelPerMs {
+		t.Errorf("expected fuel calibration attribute %d, but got %v", testFuelPerMs, attribute)
+	}
+}
+
+func TestCancellationStopsModule(t *testing.T) {
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", blockUntilStopped())
+	})
+
+	for _, test := range []struct {
+		cancel func(d *WasmTaskDriverPlugin, taskID string)
+		name   string
+	}{
+		{name: "stop", cancel: func(d *WasmTaskDriverPlugin, taskID string) {
+			_ = d.StopTask(taskID, 0, "SIGTERM")
+		}},
+		{name: "destroy", cancel: func(d *WasmTaskDriverPlugin, taskID string) {
+			_ = d.DestroyTask(taskID, true)
+		}},
+		{name: "shutdown", cancel: func(d *WasmTaskDriverPlugin, _ string) {
+			d.Shutdown()
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestDriver(t, testPluginConfig, nil)
+			cfg := newTestTask(t, test.name, `engine = "fake"`)
+
+			if _, _, err := d.StartTask(cfg); err != nil {
+				t.Fatalf("unable to start task: %v", err)
+			}
+
+			handle, _ := d.tasks.Get(cfg.ID)
+
+			test.cancel(d, cfg.ID)
+
+			select {
+			case <-handle.completionCh:
+			case <-time.After(testTimeout):
+				t.Fatal("module wasn't stopped after the task was canceled")
+			}
+
+			if result := handle.exitResult; result == nil || result.Err == nil {
+				t.Errorf("expected the interrupted module to fail, but got exit result %+v", result)
+			}
+		})
+	}
+}
+
+func TestShutdownPhasesOrdered(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// Metrics are only sent when they are flushed on shutdown.
+	d := newTestDriver(t, `engines = [{ name = "fake" }]
+statsd {
+  address = "`+listener.LocalAddr().String()+`"
+  prefix = "test"
+  flushInterval = 3600
+}`, nil)
+
+	var (
+		phases []string
+		lock   sync.Mutex
+	)
+
+	record := func(phase string) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		phases = append(phases, phase)
+	}
+
+	running := make(chan struct{})
+
+	runUntilStopped := func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+		close(running)
+		<-instance.stopCh
+
+		if _, _, startErr := d.StartTask(newTestTask(t, "late", `engine = "fake"`)); startErr != nil &&
+			strings.Contains(startErr.Error(), "driver is shutting down") {
+			record("new tasks rejected")
+		}
+
+		record("task interrupted")
+
+		return nil, errInterrupted
+	}
+
+	useFakeInstances(t, func() *fakeInstance {
+		instance := newFakeInstance(1).withFunc("handle_buffer", runUntilStopped)
+		instance.onCleanup = func() {
+			// The driver context outlives the tasks.
+			if d.ctx.Err() == nil {
+				record("task cleaned up")
+			}
+		}
+
+		return instance
+	})
+
+	if _, _, err = d.StartTask(newTestTask(t, "running", `engine = "fake"`)); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	<-running
+
+	d.Shutdown()
+
+	if d.ctx.Err() != nil {
+		record("driver stopped")
+	}
+
+	expected := []string{"new tasks rejected", "task interrupted", "task cleaned up", "driver stopped"}
+	if !slices.Equal(phases, expected) {
+		t.Errorf("expected shutdown phases %v, but got %v", expected, phases)
+	}
+
+	// The metrics of the interrupted task are flushed.
+	if err = listener.SetReadDeadline(time.Now().Add(testTimeout)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, statsdMaxPacketSize)
+
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil || !hasMetrics(string(buf[:n]), []string{"test.tasks.failed:1|c"}) {
+		t.Errorf("expected the interrupted task metrics to be flushed, but received %q: %v", buf[:n], err)
+	}
+}
+
+// awaitStopSignal returns a function polling the stop_signal host import, which
+// returns the signal once it is raised.
+func awaitStopSignal(running chan<- struct{}) fakeFunc {
+	return func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+		close(running)
+
+		for {
+			results, err := instance.callHost("stop_signal")
+			if err != nil {
+				return nil, err
+			}
+
+			if signal := results[0].(int32); signal != 0 {
+				return signal, nil
+			}
+
+			select {
+			case <-instance.stopCh:
+				return nil, errInterrupted
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
+
+func TestStopTaskForceKillsAtKillTimeout(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	const killTimeout = 300 * time.Millisecond
+
+	for _, test := range []struct {
+		fn     func(running chan<- struct{}) fakeFunc
+		name   string
+		signal string
+		// killed is whether the module is force-killed, at the kill timeout
+		// unless the signal is SIGKILL.
+		killed bool
+		// exitCode is the exit code of a module finishing on its own.
+		exitCode int
+	}{
+		{name: "graceful", fn: awaitStopSignal, signal: "SIGTERM", exitCode: 15},
+		{name: "default-signal", fn: awaitStopSignal, exitCode: 2},
+		{name: "ignored", fn: func(running chan<- struct{}) fakeFunc {
+			return func(instance *fakeInstance, args []interface{}) (interface{}, error) {
+				close(running)
+
+				return blockUntilStopped()(instance, args)
+			}
+		}, signal: "SIGTERM", killed: true},
+		{name: "sigkill", fn: awaitStopSignal, signal: "SIGKILL", killed: true},
+	} {
+		running := make(chan struct{})
+
+		useFakeInstances(t, func() *fakeInstance {
+			return newFakeInstance(1).withFunc("handle_buffer", test.fn(running))
+		})
+
+		cfg := newTestTask(t, test.name, `engine = "fake"
+main {
+  resultAsExitCode = true
+}
+hostImports {
+  enabled = true
+}`)
+
+		if _, _, err := d.StartTask(cfg); err != nil {
+			t.Fatalf("unable to start task: %v", err)
+		}
+
+		<-running
+
+		started := time.Now()
+
+		if err := d.StopTask(cfg.ID, killTimeout, test.signal); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		elapsed := time.Since(started)
+
+		handle, _ := d.tasks.Get(cfg.ID)
+
+		// StopTask returns once the module stopped.
+		select {
+		case <-handle.completionCh:
+		default:
+			t.Fatalf("%s: expected the task to be stopped when StopTask returns", test.name)
+		}
+
+		result := handle.exitResult
+
+		switch {
+		case !test.killed:
+			if elapsed >= killTimeout || result.Err != nil || result.ExitCode != test.exitCode {
+				t.Errorf("%s: expected the module to finish on its own with exit code %d, but got %+v after %v",
+					test.name, test.exitCode, result, elapsed)
+			}
+		case test.signal == "SIGKILL":
+			if elapsed >= killTimeout || result.Err == nil {
+				t.Errorf("%s: expected the module to be force-killed right away, but got %+v after %v", test.name,
+					result, elapsed)
+			}
+		default:
+			if elapsed < killTimeout || result.Err == nil {
+				t.Errorf("%s: expected the module to be force-killed at the kill timeout, but got %+v after %v",
+					test.name, result, elapsed)
+			}
+		}
+	}
+
+	if err := d.StopTask("ignored", killTimeout, "SIGWINCH"); err == nil {
+		t.Error("expected an unsupported stop signal to be rejected")
+	}
+}
+
+// dirLoader loads modules of the testdir scheme from a directory.
+type dirLoader struct {
+	dir string
+}
+
+func (l *dirLoader) Scheme() string {
+	return "testdir"
+}
+
+func (l *dirLoader) Load(modulePath string) (string, error) {
+	return filepath.Join(l.dir, strings.TrimPrefix(modulePath, "testdir://")), nil
+}
+
+func TestModuleLoadedThroughCustomLoader(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	loader := &dirLoader{dir: t.TempDir()}
+	loaders.Register(loader)
+
+	writeTestFile(t, filepath.Join(loader.dir, "custom.wasm"), wasmModule("handle_buffer"))
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	for _, test := range []struct {
+		modulePath string
+		source     string
+	}{
+		{modulePath: "testdir://custom.wasm", source: "testdir"},
+		{modulePath: "module.wasm", source: "local"},
+	} {
+		cfg := newTestTask(t, test.source, `engine = "fake"
+modulePath = "`+test.modulePath+`"`)
+
+		if result := runTask(t, d, cfg); result.Err != nil {
+			t.Fatalf("unexpected exit result of module %s %+v", test.modulePath, result)
+		}
+
+		status, err := d.InspectTask(cfg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if source := status.DriverAttributes["module_source"]; source != test.source {
+			t.Errorf("expected module %s source %s, but got %s", test.modulePath, test.source, source)
+		}
+	}
+}
+
+func TestLazyEngineInitializedOnceOnFirstTask(t *testing.T) {
+	inits := testEngine.initCount()
+
+	d := newTestDriver(t, `engines = [{
+  name = "fake"
+  lazyInit = true
+  cache {
+    preCache {
+      enabled = true
+      modulesDir = "`+t.TempDir()+`"
+    }
+  }
+}]`, nil)
+
+	if testEngine.initCount() != inits {
+		t.Fatal("lazily initialized engine was initialized before its first task")
+	}
+
+	gate := make(chan struct{})
+
+	testEngine.lock.Lock()
+	testEngine.preCacheGate = gate
+	testEngine.lock.Unlock()
+
+	t.Cleanup(func() {
+		testEngine.lock.Lock()
+		testEngine.preCacheGate = nil
+		testEngine.lock.Unlock()
+	})
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	// Concurrent first tasks share the engine initialization.
+	var wg sync.WaitGroup
+
+	for _, id := range []string{"first", "second"} {
+		cfg := newTestTask(t, id, `engine = "fake"`)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, _, err := d.StartTask(cfg); err != nil {
+				t.Errorf("unable to start task %s: %v", cfg.ID, err)
+			}
+		}()
+	}
+
+	// Pre-caching, which follows the engine initialization, doesn't block
+	// fingerprinting.
+	for testEngine.initCount() == inits {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fingerprinted := make(chan struct{})
+
+	go func() {
+		defer close(fingerprinted)
+
+		d.buildFingerprint()
+	}()
+
+	select {
+	case <-fingerprinted:
+	case <-time.After(testTimeout):
+		t.Fatal("fingerprint blocked by the engine initialization")
+	}
+
+	close(gate)
+	wg.Wait()
+
+	if count := testEngine.initCount() - inits; count != 1 {
+		t.Errorf("expected the engine initialized once, but it was initialized %d times", count)
+	}
+}
@@ -0,0 +1,46 @@
+package engines
+
+import (
+	"fmt"
+	"os"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// CacheKey returns the modules cache key of the module according to the key
+// strategy. The mtime strategy includes the file size and modification time,
+// so a module replaced on disk is loaded again instead of served from cache.
+// The content strategy doesn't include the path at all, it uses the hash of
+// the module content the engine compiles, so the module isn't read and hashed
+// again to key it.
+func CacheKey(modulePath, strategy, contentHash string) (string, error) {
+	switch strategy {
+	case interfaces.CacheKeyMtime:
+		info, err := os.Stat(modulePath)
+		if err != nil {
+			return "", fmt.Errorf("unable to stat WASM module %s: %w", modulePath, err)
+		}
+
+		return fmt.Sprintf("%s@%d-%d", modulePath, info.Size(), info.ModTime().UnixNano()), nil
+	case interfaces.CacheKeyContent:
+		return "sha256:" + contentHash, nil
+	default:
+		return modulePath, nil
+	}
+}
+
+// TooLargeToCache reports whether the module is larger than maxSize, in which
+// case it is loaded from file instead of occupying the modules cache. Zero
+// maxSize means no limit.
+func TooLargeToCache(modulePath string, maxSize int64) (bool, error) {
+	if maxSize == 0 {
+		return false, nil
+	}
+
+	info, err := os.Stat(modulePath)
+	if err != nil {
+		return false, fmt.Errorf("unable to stat WASM module %s: %w", modulePath, err)
+	}
+
+	return info.Size() > maxSize, nil
+}
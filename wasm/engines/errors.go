@@ -4,4 +4,7 @@ import "github.com/pkg/errors"
 
 var (
 	ErrNotFound = errors.New("not found")
+	// ErrNoResult is returned by instances calling a function which returns
+	// nothing, if the engine can't return a nil result for it.
+	ErrNoResult = errors.New("no result")
 )
@@ -0,0 +1,50 @@
+package engines
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// Module is the binary of a WASM module file engines compile.
+type Module struct {
+	// Path is the module file the binary was read from.
+	Path string
+	// SHA256 is the hex encoded SHA-256 of the binary.
+	SHA256 string
+	Wasm   []byte
+}
+
+// ReadModule returns the module engines instantiate. The module the driver
+// already read and checked is taken from the instance configuration, so the
+// compiled binary and its cache key are the checked content even if the file
+// was replaced since. The module file is read otherwise.
+func ReadModule(modulePath string, conf interfaces.InstanceConfig) (Module, error) {
+	module := Module{Path: modulePath, SHA256: conf.ModuleSHA256, Wasm: conf.Module}
+
+	if module.Wasm == nil {
+		wasm, err := os.ReadFile(modulePath)
+		if err != nil {
+			return Module{}, fmt.Errorf("unable to read WASM module %s: %w", modulePath, err)
+		}
+
+		module.Wasm, module.SHA256 = wasm, ""
+	}
+
+	if module.SHA256 == "" {
+		hash := sha256.Sum256(module.Wasm)
+		module.SHA256 = hex.EncodeToString(hash[:])
+	}
+
+	return module, nil
+}
+
+// TooLargeToCache reports whether the module is larger than maxSize, in which
+// case it is compiled on every instantiation instead of occupying the modules
+// cache. Zero maxSize means no limit.
+func (m Module) TooLargeToCache(maxSize int64) bool {
+	return maxSize != 0 && int64(len(m.Wasm)) > maxSize
+}
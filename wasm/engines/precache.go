@@ -0,0 +1,90 @@
+package engines
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// PrePopulate calls cacheModule for every WASM module in modulesDir (including
+// subdirectories) and handles failures according to policy. It returns the
+// number of modules cached.
+func PrePopulate(logger hclog.Logger, modulesDir string, policy interfaces.PreCachePolicy,
+	cacheModule func(modulePath string) error,
+) (int, error) {
+	var (
+		modulesPath            []string
+		failedModules          []string
+		preCachedModulesNumber int
+	)
+
+	err := filepath.Walk(modulesDir, func(path string, info fs.FileInfo, _err error) error {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".wasm") {
+			modulesPath = append(modulesPath, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to get WASM modules for pre-cache from %s directory: %v",
+			modulesDir, err)
+	}
+
+	for _, modulePath := range modulesPath {
+		err := cacheWithPolicy(logger, modulePath, policy, cacheModule)
+		if err != nil {
+			if policy.OnError == interfaces.PreCacheOnErrorFail {
+				return 0, err
+			}
+
+			logger.Error("unable to pre-cache WASM module, skipping", "module", modulePath, "error", hclog.Fmt("%+v", err))
+
+			failedModules = append(failedModules, modulePath)
+
+			continue
+		}
+
+		preCachedModulesNumber++
+
+		logger.Trace("WASM module pre-cached", "module", modulePath)
+	}
+
+	if len(failedModules) > 0 {
+		logger.Warn("some WASM modules were not pre-cached",
+			"cached", preCachedModulesNumber, "failed", len(failedModules), "modules", strings.Join(failedModules, ","))
+	}
+
+	return preCachedModulesNumber, nil
+}
+
+func cacheWithPolicy(logger hclog.Logger, modulePath string, policy interfaces.PreCachePolicy,
+	cacheModule func(modulePath string) error,
+) error {
+	err := cacheModule(modulePath)
+	if err == nil || policy.OnError != interfaces.PreCacheOnErrorRetry {
+		return err
+	}
+
+	backoff := policy.Backoff
+
+	for attempt := 1; attempt <= policy.Retries; attempt++ {
+		logger.Debug("retrying WASM module pre-cache", "module", modulePath, "attempt", attempt, "backoff", backoff,
+			"error", hclog.Fmt("%+v", err))
+
+		time.Sleep(backoff)
+		backoff *= 2
+
+		if err = cacheModule(modulePath); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
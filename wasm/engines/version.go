@@ -0,0 +1,32 @@
+package engines
+
+import (
+	"runtime/debug"
+)
+
+// unknownVersion is reported when a module version isn't part of the build
+// info, e.g. in binaries built without module support.
+const unknownVersion = "unknown"
+
+// ModuleVersion returns the version of the Go module the plugin was built
+// with.
+func ModuleVersion(modulePath string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return unknownVersion
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+
+		if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+
+		return dep.Version
+	}
+
+	return unknownVersion
+}
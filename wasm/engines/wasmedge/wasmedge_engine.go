@@ -2,21 +2,24 @@ package wasmedge
 
 import (
 	"fmt"
-	"io/fs"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/bluele/gcache"
 	"github.com/hashicorp/go-hclog"
 	"github.com/pkg/errors"
 	"github.com/second-state/WasmEdge-go/wasmedge"
+	"golang.org/x/sync/singleflight"
 
 	"huawei.com/wasm-task-driver/wasm/engines"
 	"huawei.com/wasm-task-driver/wasm/interfaces"
+	"huawei.com/wasm-task-driver/wasm/modinfo"
 )
 
-const engineExtensionName = "wasmedge"
+const (
+	engineExtensionName = "wasmedge"
+
+	// wasmPageSize is the size of a WASM linear memory page in bytes.
+	wasmPageSize = 65536
+)
 
 func init() {
 	engines.Register(&wasmedgeEngine{})
@@ -25,73 +28,163 @@ func init() {
 type wasmedgeEngine struct {
 	logger       hclog.Logger
 	modulesCache gcache.Cache
+	// loads deduplicates concurrent loading of the same uncached module.
+	loads singleflight.Group
+	// cacheOpts defines how modules cache keys are built and which modules
+	// are cached.
+	cacheOpts interfaces.CacheOptions
 }
 
 func (e *wasmedgeEngine) Name() string {
 	return engineExtensionName
 }
 
-func (e *wasmedgeEngine) Init(logger hclog.Logger, moduleCache gcache.Cache) {
+func (e *wasmedgeEngine) Init(logger hclog.Logger, moduleCache gcache.Cache, cacheOpts interfaces.CacheOptions) {
 	e.logger = logger
 	e.modulesCache = moduleCache
+	e.cacheOpts = cacheOpts
+}
+
+// Info reports the version of the WasmEdge library and the proposals enabled by
+// the default WasmEdge configuration the engine uses.
+func (e *wasmedgeEngine) Info() interfaces.EngineInfo {
+	return interfaces.EngineInfo{
+		Version: wasmedge.GetVersion(),
+		Features: map[string]bool{
+			modinfo.FeatureSIMD:              true,
+			modinfo.FeatureBulkMemory:        true,
+			modinfo.FeatureReferenceTypes:    true,
+			modinfo.FeatureMultiValue:        true,
+			modinfo.FeatureThreads:           false,
+			modinfo.FeatureMemory64:          false,
+			modinfo.FeatureMultiMemory:       false,
+			modinfo.FeatureExceptionHandling: false,
+			modinfo.FeatureComponentModel:    false,
+		},
+	}
 }
 
-func (e *wasmedgeEngine) PrePopulateCache(modulesDir string) (int, error) {
+func (e *wasmedgeEngine) PrePopulateCache(modulesDir string, policy interfaces.PreCachePolicy) (int, error) {
 	if e.modulesCache == nil {
 		return 0, fmt.Errorf("unable to pre populate modules: cache is not created")
 	}
 
-	var (
-		modulesPath            []string
-		preCachedModulesNumber int
-	)
+	store := wasmedge.NewStore()
+	if store == nil {
+		return 0, fmt.Errorf("unable to create wasmedge store")
+	}
+	defer store.Release()
+
+	vm := wasmedge.NewVMWithStore(store)
+	if vm == nil {
+		return 0, fmt.Errorf("unable to create wasmedge VM")
+	}
+	defer vm.Release()
 
-	err := filepath.Walk(modulesDir, func(path string, info fs.FileInfo, _err error) error {
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".wasm") {
-			modulesPath = append(modulesPath, path)
+	return engines.PrePopulate(e.logger, modulesDir, policy, func(modulePath string) error {
+		tooLarge, err := engines.TooLargeToCache(modulePath, e.cacheOpts.MaxModuleSize)
+		if err != nil {
+			return err
 		}
 
-		return nil
-	})
+		if tooLarge {
+			e.logger.Debug("WASM module exceeds cache max module size, not pre-caching it", "module", modulePath)
 
-	if err != nil {
-		return 0, fmt.Errorf("unable to get WASM modules for pre-cache from %s directory: %v",
-			modulesDir, err)
-	}
+			return nil
+		}
 
-	store := wasmedge.NewStore()
-	defer store.Release()
+		wasm, err := engines.ReadModule(modulePath, interfaces.InstanceConfig{})
+		if err != nil {
+			return err
+		}
 
-	vm := wasmedge.NewVMWithStore(store)
-	defer vm.Release()
+		cacheKey, err := engines.CacheKey(modulePath, e.cacheOpts.KeyStrategy, wasm.SHA256)
+		if err != nil {
+			return err
+		}
 
-	for _, modulePath := range modulesPath {
-		wasmModule, err := loadModule(vm, modulePath)
+		wasmModule, err := loadModule(vm, wasm.Wasm)
 		if err != nil {
-			return 0, fmt.Errorf("unable to load WASM module (%v) from file: %v", modulePath, err)
+			return fmt.Errorf("unable to load WASM module (%v) from file: %v", modulePath, err)
 		}
 
-		if err := e.modulesCache.Set(modulePath, wasmModule); err != nil {
-			return 0, fmt.Errorf("unable to cache WASM module (%v)", modulePath)
+		if err := e.modulesCache.Set(cacheKey, wasmModule); err != nil {
+			return fmt.Errorf("unable to cache WASM module (%v)", modulePath)
 		}
 
-		preCachedModulesNumber++
+		return nil
+	})
+}
+
+func (e *wasmedgeEngine) VerifyCache() (int, error) {
+	if e.modulesCache == nil {
+		return 0, nil
+	}
 
-		e.logger.Trace("WASM module pre-cached", "module", modulePath)
+	validator := wasmedge.NewValidator()
+	if validator == nil {
+		return 0, fmt.Errorf("unable to create wasmedge validator")
 	}
+	defer validator.Release()
 
-	return preCachedModulesNumber, nil
+	var corrupted int
+
+	for key, mod := range e.modulesCache.GetALL(false) {
+		if err := validator.Validate(mod.(*wasmedge.AST)); err != nil {
+			e.logger.Warn("cached WASM module doesn't validate, removing it from cache", "key", key,
+				"error", hclog.Fmt("%+v", err))
+
+			e.modulesCache.Remove(key)
+
+			corrupted++
+		}
+	}
+
+	return corrupted, nil
 }
 
-func (e *wasmedgeEngine) InstantiateModule(modulePath string) (interfaces.WasmInstance, error) {
+func (e *wasmedgeEngine) InstantiateModule(modulePath string, conf interfaces.InstanceConfig) (interfaces.WasmInstance, error) {
 	e.logger.Debug("instantiate new module", "module path", modulePath)
 
+	// WasmEdge writes WASI output to the plugin process stdio, which can't be
+	// redirected to the task logs.
+	if conf.Wasi != nil {
+		return nil, fmt.Errorf("WASI is not supported by %s engine", engineExtensionName)
+	}
+
 	store := wasmedge.NewStore()
-	vm := wasmedge.NewVMWithStore(store)
+	if store == nil {
+		return nil, fmt.Errorf("unable to create wasmedge store")
+	}
+
+	vm := newVM(store, conf)
+	if vm == nil {
+		store.Release()
+
+		return nil, fmt.Errorf("unable to create wasmedge VM")
+	}
 
-	module, err := e.getModule(vm, modulePath)
+	hostModules, err := e.registerHostFuncs(vm, conf.HostFuncs)
+	if err != nil {
+		vm.Release()
+		store.Release()
+
+		return nil, fmt.Errorf("unable to register host functions: %w", err)
+	}
+
+	wasmModule, err := engines.ReadModule(modulePath, conf)
+	if err != nil {
+		releaseModules(hostModules)
+		vm.Release()
+		store.Release()
+
+		return nil, err
+	}
+
+	module, cached, err := e.getModule(vm, wasmModule)
 	if err != nil {
 		// in case of error during module getting we have to clean up created resources.
+		releaseModules(hostModules)
 		vm.Release()
 		store.Release()
 
@@ -99,62 +192,218 @@ func (e *wasmedgeEngine) InstantiateModule(modulePath string) (interfaces.WasmIn
 	}
 
 	return &wasmedgeInstance{
-		module: module,
-		vm:     vm,
+		module:      module,
+		hostModules: hostModules,
+		vm:          vm,
+		statistics:  conf.Statistics,
+		cached:      cached,
 	}, nil
 }
 
-func (e *wasmedgeEngine) getModule(vm *wasmedge.VM, modulePath string) (*wasmedge.Module, error) {
+// newVM creates a VM whose memories can't grow past the instance max memory
+// pages, memory.grow beyond it fails, and which collects execution statistics
+// if enabled.
+func newVM(store *wasmedge.Store, instanceConf interfaces.InstanceConfig) *wasmedge.VM {
+	if instanceConf.MaxMemoryPages == 0 && !instanceConf.Statistics {
+		return wasmedge.NewVMWithStore(store)
+	}
+
+	conf := wasmedge.NewConfigure()
+	if conf == nil {
+		return nil
+	}
+	defer conf.Release()
+
+	if instanceConf.MaxMemoryPages != 0 {
+		conf.SetMaxMemoryPage(uint(instanceConf.MaxMemoryPages))
+	}
+
+	if instanceConf.Statistics {
+		conf.SetStatisticsInstructionCounting(true)
+		conf.SetStatisticsCostMeasuring(true)
+		conf.SetStatisticsTimeMeasuring(true)
+	}
+
+	return wasmedge.NewVMWithConfigAndStore(conf, store)
+}
+
+// registerHostFuncs groups host functions by import module name and registers
+// each group as a host module of the VM.
+func (e *wasmedgeEngine) registerHostFuncs(vm *wasmedge.VM, hostFuncs []interfaces.HostFunc) ([]*wasmedge.Module, error) {
+	var (
+		hostModules []*wasmedge.Module
+		byName      = make(map[string]*wasmedge.Module)
+	)
+
+	for _, hostFunc := range hostFuncs {
+		hostFunc := hostFunc
+
+		hostModule, ok := byName[hostFunc.Module]
+		if !ok {
+			hostModule = wasmedge.NewModule(hostFunc.Module)
+			byName[hostFunc.Module] = hostModule
+			hostModules = append(hostModules, hostModule)
+		}
+
+		funcType := wasmedge.NewFunctionType(toValTypes(hostFunc.Params), toValTypes(hostFunc.Results))
+
+		function := wasmedge.NewFunction(funcType,
+			func(_data interface{}, callframe *wasmedge.CallingFrame, params []interface{}) ([]interface{}, wasmedge.Result) {
+				var memory []byte
+
+				if mem := callframe.GetMemoryByIndex(0); mem != nil {
+					memory, _ = mem.GetData(0, mem.GetPageSize()*wasmPageSize)
+				}
+
+				results, err := hostFunc.Call(memory, params)
+				if err != nil {
+					e.logger.Error("host function failed", "function", hostFunc.Name, "error", hclog.Fmt("%+v", err))
+
+					return nil, wasmedge.Result_Fail
+				}
+
+				return results, wasmedge.Result_Success
+			}, nil, 0)
+
+		funcType.Release()
+
+		hostModule.AddFunction(hostFunc.Name, function)
+	}
+
+	for _, hostModule := range hostModules {
+		if err := vm.RegisterModule(hostModule); err != nil {
+			releaseModules(hostModules)
+
+			return nil, fmt.Errorf("unable to register %s module: %w", hostModule.GetName(), err)
+		}
+	}
+
+	return hostModules, nil
+}
+
+func releaseModules(modules []*wasmedge.Module) {
+	for _, module := range modules {
+		module.Release()
+	}
+}
+
+func toValTypes(types []interfaces.ValueType) []wasmedge.ValType {
+	result := make([]wasmedge.ValType, len(types))
+
+	for i, valueType := range types {
+		switch valueType {
+		case interfaces.ValueTypeI64:
+			result[i] = wasmedge.ValType_I64
+		default:
+			result[i] = wasmedge.ValType_I32
+		}
+	}
+
+	return result
+}
+
+// getModule instantiates the module in the VM store and reports whether it was
+// served from the modules cache.
+func (e *wasmedgeEngine) getModule(vm *wasmedge.VM, wasmModule engines.Module) (*wasmedge.Module, bool, error) {
 	var (
-		err       error
 		astModule *wasmedge.AST
+		cached    bool
+		err       error
 	)
 
-	if e.modulesCache != nil {
-		mod, getCacheErr := e.modulesCache.Get(modulePath)
+	if e.useCache(wasmModule) {
+		var cacheKey string
+
+		cacheKey, err = engines.CacheKey(wasmModule.Path, e.cacheOpts.KeyStrategy, wasmModule.SHA256)
+		if err != nil {
+			return nil, false, err
+		}
+
+		mod, getCacheErr := e.modulesCache.Get(cacheKey)
 
 		switch {
 		case getCacheErr == nil:
-			astModule = mod.(*wasmedge.AST)
+			astModule, cached = mod.(*wasmedge.AST), true
 		case errors.Is(getCacheErr, gcache.KeyNotFoundError):
-			astModule, err = loadModule(vm, modulePath)
+			// Tasks starting at the same time with the same uncached module share a
+			// single load of it.
+			mod, err, _ = e.loads.Do(cacheKey, func() (interface{}, error) {
+				return e.loadAndCache(vm, wasmModule, cacheKey)
+			})
 			if err != nil {
-				e.logger.Error("unable to load WASM module", "error", hclog.Fmt("%+v", err))
-
-				return nil, fmt.Errorf("unable to load WASM module: %w", err)
-			}
-
-			if err = e.modulesCache.Set(modulePath, astModule); err != nil {
-				e.logger.Error("unable to cache WASM module", "error", hclog.Fmt("%+v", err))
-
-				return nil, fmt.Errorf("unable to cache: %w", err)
+				return nil, false, err
 			}
 
-			e.logger.Debug("cached WASM module", "module", modulePath)
+			astModule = mod.(*wasmedge.AST)
 		default:
 			e.logger.Error("unable to get module from cache", "error", hclog.Fmt("%+v", getCacheErr))
 
-			return nil, fmt.Errorf("unable to cache WASM module: %w", getCacheErr)
+			return nil, false, fmt.Errorf("unable to cache WASM module: %w", getCacheErr)
 		}
+	} else {
+		e.logger.Debug("loading WASM module without cache", "module", wasmModule.Path)
+
+		astModule, err = loadModule(vm, wasmModule.Wasm)
+		if err != nil {
+			e.logger.Error("unable to load WASM module", "error", hclog.Fmt("%+v", err))
+
+			return nil, false, fmt.Errorf("unable to load WASM module: %w", err)
+		}
+
+		// The instantiated module doesn't reference the AST, so an uncached AST
+		// is released right away.
+		defer astModule.Release()
 	}
 
-	wasmModule, err := vm.GetExecutor().Instantiate(vm.GetStore(), astModule)
+	module, err := vm.GetExecutor().Instantiate(vm.GetStore(), astModule)
 	if err != nil {
-		return nil, fmt.Errorf("unable to instantiate executor: %w", err)
+		return nil, false, fmt.Errorf("unable to instantiate executor: %w", err)
 	}
 
-	return wasmModule, nil
+	return module, cached, nil
+}
+
+// useCache reports whether the module is loaded through the modules cache.
+func (e *wasmedgeEngine) useCache(wasmModule engines.Module) bool {
+	return e.modulesCache != nil && !wasmModule.TooLargeToCache(e.cacheOpts.MaxModuleSize)
 }
 
-func loadModule(vm *wasmedge.VM, filePath string) (*wasmedge.AST, error) {
-	moduleByte, err := os.ReadFile(filePath)
+// loadAndCache loads and validates the module and stores it in the modules
+// cache under the cache key. The cache is checked again first: a task which
+// missed the cache while another load of the module was finishing would
+// otherwise load it again.
+func (e *wasmedgeEngine) loadAndCache(vm *wasmedge.VM, wasmModule engines.Module, cacheKey string) (*wasmedge.AST, error) {
+	// The driver counts lookups of Get only, so checking the cache again
+	// doesn't count the instantiation twice in the cache hit rate.
+	if e.modulesCache.Has(cacheKey) {
+		if mod, err := e.modulesCache.GetIFPresent(cacheKey); err == nil {
+			return mod.(*wasmedge.AST), nil
+		}
+	}
+
+	astModule, err := loadModule(vm, wasmModule.Wasm)
 	if err != nil {
-		return nil, err
+		e.logger.Error("unable to load WASM module", "error", hclog.Fmt("%+v", err))
+
+		return nil, fmt.Errorf("unable to load WASM module: %w", err)
+	}
+
+	if err = e.modulesCache.Set(cacheKey, astModule); err != nil {
+		e.logger.Error("unable to cache WASM module", "error", hclog.Fmt("%+v", err))
+
+		return nil, fmt.Errorf("unable to cache: %w", err)
 	}
 
+	e.logger.Debug("cached WASM module", "module", wasmModule.Path)
+
+	return astModule, nil
+}
+
+// loadModule loads and validates the module binary.
+func loadModule(vm *wasmedge.VM, wasm []byte) (*wasmedge.AST, error) {
 	loader := vm.GetLoader()
 
-	module, err := loader.LoadBuffer(moduleByte)
+	module, err := loader.LoadBuffer(wasm)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load modulebyte buffer: %w", err)
 	}
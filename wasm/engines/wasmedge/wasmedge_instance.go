@@ -1,15 +1,30 @@
 package wasmedge
 
 import (
+	"sync"
+
 	"github.com/pkg/errors"
 	"github.com/second-state/WasmEdge-go/wasmedge"
 
 	"huawei.com/wasm-task-driver/wasm/engines"
+	"huawei.com/wasm-task-driver/wasm/interfaces"
 )
 
 type wasmedgeInstance struct {
 	module *wasmedge.Module
 	vm     *wasmedge.VM
+	// running is the function call in progress, canceled by Stop.
+	running     *wasmedge.Async
+	hostModules []*wasmedge.Module
+	// runningLock guards running and stopped, as Stop is called concurrently
+	// with function calls.
+	runningLock sync.Mutex
+	statistics  bool
+	// cached reports whether the module was served from the modules cache.
+	cached bool
+	// stopped is set once the instance is stopped, so it doesn't run further
+	// function calls.
+	stopped bool
 }
 
 func (i *wasmedgeInstance) CallFunc(funcName string, args ...interface{}) (interface{}, error) {
@@ -18,16 +33,23 @@ func (i *wasmedgeInstance) CallFunc(funcName string, args ...interface{}) (inter
 		return nil, errors.Wrapf(engines.ErrNotFound, "WASM module doesn't conform calling conventions: no %s func", funcName)
 	}
 
-	funcResult, err := i.vm.GetExecutor().Invoke(moduleFunc, args...)
+	funcResult, err := i.invoke(moduleFunc, args)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to call function: %s", funcName)
 	}
 
+	if len(funcResult) == 0 {
+		return nil, errors.Wrapf(engines.ErrNoResult, "%s func returns nothing", funcName)
+	}
+
 	return funcResult[0], nil
 }
 
 func (i *wasmedgeInstance) GetMemoryRange(start, size int32) ([]byte, error) {
 	memory := i.module.FindMemory("memory")
+	if memory == nil {
+		return nil, errors.Wrap(engines.ErrNotFound, "WASM module doesn't export memory")
+	}
 
 	//nolint:gosec
 	ioBuf, err := memory.GetData(uint(start), uint(size))
@@ -38,12 +60,125 @@ func (i *wasmedgeInstance) GetMemoryRange(start, size int32) ([]byte, error) {
 	return ioBuf, nil
 }
 
-// TODO: find way to interrupt wasmedge instance execution.
-func (i *wasmedgeInstance) Stop() {}
+func (i *wasmedgeInstance) MemoryPages() (uint64, error) {
+	memory := i.module.FindMemory("memory")
+	if memory == nil {
+		return 0, errors.Wrap(engines.ErrNotFound, "WASM module doesn't export memory")
+	}
+
+	return uint64(memory.GetPageSize()), nil
+}
+
+func (i *wasmedgeInstance) GrowMemory(deltaPages uint64) error {
+	memory := i.module.FindMemory("memory")
+	if memory == nil {
+		return errors.Wrap(engines.ErrNotFound, "WASM module doesn't export memory")
+	}
+
+	//nolint:gosec
+	if err := memory.GrowPage(uint(deltaPages)); err != nil {
+		return errors.Wrapf(err, "unable to grow memory by %d pages", deltaPages)
+	}
+
+	return nil
+}
+
+func (i *wasmedgeInstance) FuncParams(funcName string) ([]interfaces.ValueType, error) {
+	moduleFunc := i.module.FindFunction(funcName)
+	if moduleFunc == nil {
+		return nil, errors.Wrapf(engines.ErrNotFound, "WASM module doesn't export %s func", funcName)
+	}
+
+	return valueTypes(moduleFunc.GetFunctionType().GetParameters()), nil
+}
+
+func (i *wasmedgeInstance) FuncResults(funcName string) ([]interfaces.ValueType, error) {
+	moduleFunc := i.module.FindFunction(funcName)
+	if moduleFunc == nil {
+		return nil, errors.Wrapf(engines.ErrNotFound, "WASM module doesn't export %s func", funcName)
+	}
+
+	return valueTypes(moduleFunc.GetFunctionType().GetReturns()), nil
+}
+
+// valueTypes converts WasmEdge value types to the types reported to the
+// driver.
+func valueTypes(types []wasmedge.ValType) []interfaces.ValueType {
+	result := make([]interfaces.ValueType, len(types))
+
+	for j, valType := range types {
+		switch valType {
+		case wasmedge.ValType_I32:
+			result[j] = interfaces.ValueTypeI32
+		case wasmedge.ValType_I64:
+			result[j] = interfaces.ValueTypeI64
+		default:
+			result[j] = interfaces.ValueTypeOther
+		}
+	}
+
+	return result
+}
+
+func (i *wasmedgeInstance) ModuleCached() bool {
+	return i.cached
+}
+
+func (i *wasmedgeInstance) Statistics() (interfaces.ExecutionStatistics, bool) {
+	if !i.statistics {
+		return interfaces.ExecutionStatistics{}, false
+	}
+
+	stat := i.vm.GetStatistics()
+
+	return interfaces.ExecutionStatistics{
+		Instructions:   true,
+		InstrCount:     uint64(stat.GetInstrCount()),
+		TotalCost:      uint64(stat.GetTotalCost()),
+		InstrPerSecond: stat.GetInstrPerSecond(),
+	}, true
+}
+
+// invoke calls the function asynchronously, so Stop can cancel it.
+func (i *wasmedgeInstance) invoke(moduleFunc *wasmedge.Function, args []interface{}) ([]interface{}, error) {
+	i.runningLock.Lock()
+
+	if i.stopped {
+		i.runningLock.Unlock()
+
+		return nil, errors.New("instance is stopped")
+	}
+
+	async := i.vm.GetExecutor().AsyncInvoke(moduleFunc, args...)
+	i.running = async
+	i.runningLock.Unlock()
+
+	defer func() {
+		i.runningLock.Lock()
+		i.running = nil
+		i.runningLock.Unlock()
+
+		async.Release()
+	}()
+
+	return async.GetResult()
+}
+
+func (i *wasmedgeInstance) Stop() {
+	i.runningLock.Lock()
+	defer i.runningLock.Unlock()
+
+	i.stopped = true
+
+	if i.running != nil {
+		i.running.Cancel()
+	}
+}
 
 func (i *wasmedgeInstance) Cleanup() {
 	defer i.vm.GetStore().Release()
 
 	i.module.Release()
+	releaseModules(i.hostModules)
 	i.vm.Release()
 }
@@ -0,0 +1,307 @@
+package wasmtime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/engines"
+)
+
+const (
+	// diskCacheExt is the extension of serialized modules in the disk cache.
+	diskCacheExt = ".cwasm"
+
+	// diskCacheFormat identifies the layout of disk cache entries. It must be
+	// changed whenever the layout changes, so entries written by other plugin
+	// versions are discarded instead of misread.
+	diskCacheFormat = "wasm-task-driver-cache/1"
+)
+
+// diskCache persists serialized modules, so a plugin restart doesn't force
+// every module to be compiled again. Entries are keyed by the SHA-256 of the
+// module content and start with a header holding the entry format, the
+// wasmtime-go version the module was serialized with and the module path:
+//
+//	<format>\n<version>\n<module path>\n<serialized module>
+//
+// Entries of another format or serialized with another version are discarded,
+// as wasmtime only deserializes modules serialized by the same version, so
+// they are compiled again.
+//
+// Serialized modules are native code run without verification, so the
+// directory and its entries must be owned by the plugin user and not writable
+// by other users.
+type diskCache struct {
+	logger  hclog.Logger
+	dir     string
+	version string
+	// maxSize is the total size in bytes of the entries above which the least
+	// recently used ones are removed. Zero means no limit.
+	maxSize int64
+}
+
+func newDiskCache(logger hclog.Logger, dir string, maxSize int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create disk cache directory %s: %w", dir, err)
+	}
+
+	stat, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("disk cache directory %s is not a directory", dir)
+	}
+
+	if err = checkTrusted(stat); err != nil {
+		return nil, fmt.Errorf("disk cache directory %s: %w", dir, err)
+	}
+
+	return &diskCache{
+		logger:  logger,
+		dir:     dir,
+		version: engines.ModuleVersion(wasmtimeModulePath),
+		maxSize: maxSize,
+	}, nil
+}
+
+// checkTrusted fails if the file isn't owned by the plugin user or is writable
+// by other users, so its content may have been written by someone else.
+func checkTrusted(stat fs.FileInfo) error {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("unexpected file info %T", stat.Sys())
+	}
+
+	//nolint:gosec
+	if uid := os.Geteuid(); sys.Uid != uint32(uid) {
+		return fmt.Errorf("owned by uid %d instead of the plugin uid %d", sys.Uid, uid)
+	}
+
+	if perm := stat.Mode().Perm(); perm&0o022 != 0 {
+		return fmt.Errorf("writable by other users (%v)", perm)
+	}
+
+	return nil
+}
+
+func (c *diskCache) path(hash string) string {
+	return filepath.Join(c.dir, hash+diskCacheExt)
+}
+
+// get returns the serialized module stored under the hash and the path of the
+// module it was serialized from. Stale, untrusted and unreadable entries are
+// removed.
+func (c *diskCache) get(hash string) ([]byte, string, bool) {
+	path := c.path(hash)
+
+	data, err := c.read(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.Warn("discarding unreadable disk cache entry", "path", path, "error", hclog.Fmt("%+v", err))
+			c.remove(hash)
+		}
+
+		return nil, "", false
+	}
+
+	parts := bytes.SplitN(data, []byte("\n"), 4)
+	if len(parts) != 4 || string(parts[0]) != diskCacheFormat {
+		c.logger.Debug("discarding disk cache entry of unknown format", "path", path)
+		c.remove(hash)
+
+		return nil, "", false
+	}
+
+	// Entries of another version are expected after a wasmtime upgrade, the
+	// modules are compiled again instead of failing to deserialize.
+	if string(parts[1]) != c.version {
+		c.logger.Info("discarding disk cache entry of another wasmtime version, the module is compiled again",
+			"path", path, "version", string(parts[1]), "current_version", c.version)
+		c.remove(hash)
+
+		return nil, "", false
+	}
+
+	// The modification time orders entries by last use for eviction.
+	now := time.Now()
+	if err = os.Chtimes(path, now, now); err != nil {
+		c.logger.Debug("unable to touch disk cache entry", "path", path, "error", hclog.Fmt("%+v", err))
+	}
+
+	return parts[3], string(parts[2]), true
+}
+
+// read returns the content of the entry, checked to be trusted through the
+// opened file, so it can't be replaced between the check and the read.
+func (c *diskCache) read(path string) ([]byte, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkTrusted(stat); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(file)
+}
+
+// set stores the serialized module under the hash. The entry is written to a
+// temporary file first, so a crash never leaves a partial entry behind.
+func (c *diskCache) set(hash, modulePath string, serModule []byte) error {
+	file, err := os.CreateTemp(c.dir, hash+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(file, "%s\n%s\n%s\n", diskCacheFormat, c.version, modulePath)
+	if err == nil {
+		_, err = file.Write(serModule)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), c.path(hash))
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+
+		return err
+	}
+
+	c.evict()
+
+	return nil
+}
+
+// evict removes the least recently used entries while the entries take more
+// than the maximum size.
+func (c *diskCache) evict() {
+	if c.maxSize <= 0 {
+		return
+	}
+
+	hashes, err := c.entries()
+	if err != nil {
+		c.logger.Warn("unable to list disk cache entries", "error", hclog.Fmt("%+v", err))
+
+		return
+	}
+
+	type entry struct {
+		modTime time.Time
+		hash    string
+		size    int64
+	}
+
+	var (
+		entries []entry
+		size    int64
+	)
+
+	for _, hash := range hashes {
+		stat, err := os.Lstat(c.path(hash))
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, entry{modTime: stat.ModTime(), hash: hash, size: stat.Size()})
+		size += stat.Size()
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	for _, entry := range entries {
+		if size <= c.maxSize {
+			return
+		}
+
+		c.logger.Debug("evicting disk cache entry", "hash", entry.hash, "size", entry.size)
+		c.remove(entry.hash)
+
+		size -= entry.size
+	}
+}
+
+func (c *diskCache) remove(hash string) {
+	if err := os.Remove(c.path(hash)); err != nil && !os.IsNotExist(err) {
+		c.logger.Warn("unable to remove disk cache entry", "hash", hash, "error", hclog.Fmt("%+v", err))
+	}
+}
+
+// entries returns the hashes of the modules stored in the disk cache.
+func (c *diskCache) entries() ([]string, error) {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+
+	for _, file := range files {
+		if name := file.Name(); !file.IsDir() && strings.HasSuffix(name, diskCacheExt) {
+			hashes = append(hashes, strings.TrimSuffix(name, diskCacheExt))
+		}
+	}
+
+	return hashes, nil
+}
+
+// serialize returns the serialized module, taking it from the disk cache if
+// present there and compiling and storing it otherwise.
+func (c *diskCache) serialize(engine *wasmtime.Engine, wasmModule engines.Module) ([]byte, error) {
+	hash, modulePath := wasmModule.SHA256, wasmModule.Path
+
+	if serModule, _, ok := c.get(hash); ok {
+		// Entries written by a build of the same version with another engine
+		// configuration don't deserialize, so they are compiled again.
+		if _, err := wasmtime.NewModuleDeserialize(engine, serModule); err == nil {
+			c.logger.Debug("loaded WASM module from disk cache", "module", modulePath)
+
+			return serModule, nil
+		}
+
+		c.remove(hash)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasmModule.Wasm)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load WASM module: %w", err)
+	}
+
+	serModule, err := module.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize WASM module: %w", err)
+	}
+
+	if err := c.set(hash, modulePath, serModule); err != nil {
+		c.logger.Warn("unable to write WASM module to disk cache", "module", modulePath, "error", hclog.Fmt("%+v", err))
+	}
+
+	return serModule, nil
+}
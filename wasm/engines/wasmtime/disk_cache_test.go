@@ -0,0 +1,156 @@
+package wasmtime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/engines"
+)
+
+func TestDiskCacheRejectsUntrustedFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	if err := os.Mkdir(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	// The directory is created with the umask applied.
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newDiskCache(hclog.NewNullLogger(), dir, 0); err == nil ||
+		!strings.Contains(err.Error(), "writable by other users") {
+		t.Fatalf("expected world-writable directory to be rejected, but got %v", err)
+	}
+
+	if err := os.Chmod(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := newDiskCache(hclog.NewNullLogger(), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cache.set("hash", "module.wasm", []byte("module")); err != nil {
+		t.Fatal(err)
+	}
+
+	if serModule, _, ok := cache.get("hash"); !ok || string(serModule) != "module" {
+		t.Fatalf("expected the cached module, but got %q", serModule)
+	}
+
+	if err = os.Chmod(cache.path("hash"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, ok := cache.get("hash"); ok {
+		t.Error("expected the world-writable entry to be discarded")
+	}
+
+	if _, err = os.Stat(cache.path("hash")); !os.IsNotExist(err) {
+		t.Errorf("expected the world-writable entry to be removed, but got %v", err)
+	}
+}
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entry := []byte(strings.Repeat("a", 100))
+
+	cache, err := newDiskCache(hclog.NewNullLogger(), t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, hash := range []string{"first", "second"} {
+		if err = cache.set(hash, "module.wasm", entry); err != nil {
+			t.Fatal(err)
+		}
+
+		// The entries are ordered by modification time.
+		used := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err = os.Chtimes(cache.path(hash), used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stat, err := os.Stat(cache.path("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cache fits two entries.
+	cache.maxSize = 2 * stat.Size()
+
+	// The first entry is used last, so the second one is evicted.
+	if _, _, ok := cache.get("first"); !ok {
+		t.Fatal("expected the first entry to be cached")
+	}
+
+	if err = cache.set("third", "module.wasm", entry); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, err := cache.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(hashes, ",") != "first,third" {
+		t.Errorf("expected the least recently used entry to be evicted, but got entries %v", hashes)
+	}
+}
+
+func TestDiskCacheRecompilesEntryOfAnotherVersion(t *testing.T) {
+	cache, err := newDiskCache(hclog.NewNullLogger(), t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wasm, err := wasmtime.Wat2Wasm(`(module (func (export "run")))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256(wasm)
+	module := engines.Module{Path: "module.wasm", SHA256: hex.EncodeToString(hash[:]), Wasm: wasm}
+
+	// The entry was serialized by an older wasmtime, whose format the current
+	// one can't deserialize.
+	stale := []byte(diskCacheFormat + "\n0.0.1\nmodule.wasm\nserialized by another version")
+	if err = os.WriteFile(cache.path(module.SHA256), stale, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := wasmtime.NewEngine()
+
+	serModule, err := cache.serialize(engine, module)
+	if err != nil {
+		t.Fatalf("expected the module to be compiled again, but got %v", err)
+	}
+
+	if _, err = wasmtime.NewModuleDeserialize(engine, serModule); err != nil {
+		t.Fatalf("expected a module serialized by the current version, but got %v", err)
+	}
+
+	// The entry is replaced by the one of the current version.
+	entry, err := os.ReadFile(cache.path(module.SHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := diskCacheFormat + "\n" + cache.version + "\nmodule.wasm\n"
+	if !bytes.HasPrefix(entry, []byte(header)) || !bytes.Equal(entry[len(header):], serModule) {
+		t.Errorf("expected the entry to be rewritten with version %s, but got header %q", cache.version,
+			entry[:min(len(entry), len(header))])
+	}
+}
@@ -0,0 +1,95 @@
+package wasmtime
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/hashicorp/go-hclog"
+)
+
+// fuelCalibrationFuel is the fuel the calibration benchmark consumes, enough
+// for its run time to dwarf the timer resolution while keeping it brief.
+const fuelCalibrationFuel = 20_000_000
+
+// fuelCalibrationModule is the calibration benchmark: a loop consuming fuel
+// until it runs out.
+const fuelCalibrationModule = `(module (func (export "spin") (loop $spin (br $spin))))`
+
+// fuelCalibration estimates the fuel instances consume per millisecond on the
+// node, so operators can translate fuel limits into approximate run times. The
+// estimate only depends on the node, so the benchmark runs once.
+type fuelCalibration struct {
+	fuelPerMs atomic.Uint64
+	once      sync.Once
+}
+
+// start runs the benchmark in the background, so it doesn't delay the engine
+// initialization, unless it already ran.
+func (c *fuelCalibration) start(logger hclog.Logger) {
+	c.once.Do(func() {
+		go func() {
+			fuelPerMs, err := calibrateFuel()
+			if err != nil {
+				logger.Warn("unable to calibrate fuel", "error", hclog.Fmt("%+v", err))
+
+				return
+			}
+
+			c.fuelPerMs.Store(fuelPerMs)
+
+			logger.Debug("calibrated fuel", "fuel_per_ms", fuelPerMs)
+		}()
+	})
+}
+
+// get returns the fuel consumed per millisecond, zero until the benchmark ran.
+func (c *fuelCalibration) get() uint64 {
+	return c.fuelPerMs.Load()
+}
+
+// calibrateFuel runs the benchmark and returns the fuel it consumed per
+// millisecond.
+func calibrateFuel() (uint64, error) {
+	store, err := newStore(true)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = store.AddFuel(fuelCalibrationFuel); err != nil {
+		return 0, fmt.Errorf("unable to add fuel: %w", err)
+	}
+
+	wasm, err := wasmtime.Wat2Wasm(fuelCalibrationModule)
+	if err != nil {
+		return 0, err
+	}
+
+	module, err := wasmtime.NewModule(store.Engine, wasm)
+	if err != nil {
+		return 0, err
+	}
+
+	instance, err := wasmtime.NewInstance(store, module, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	spin := instance.GetFunc(store, "spin")
+
+	startedAt := time.Now()
+
+	// The benchmark only returns once it runs out of fuel.
+	if _, err = spin.Call(store); err == nil {
+		return 0, errors.New("benchmark returned before running out of fuel")
+	}
+
+	elapsed := time.Since(startedAt)
+
+	consumed, _ := store.FuelConsumed()
+
+	return uint64(float64(consumed) / (float64(elapsed) / float64(time.Millisecond))), nil
+}
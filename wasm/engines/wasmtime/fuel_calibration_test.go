@@ -0,0 +1,39 @@
+package wasmtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+func TestFuelCalibratedOnInit(t *testing.T) {
+	engine := &wasmtimeEngine{}
+
+	if fuelPerMs := engine.Info().FuelPerMs; fuelPerMs != 0 {
+		t.Fatalf("expected no fuel calibration before the engine is initialized, but got %d", fuelPerMs)
+	}
+
+	engine.Init(hclog.NewNullLogger(), nil, interfaces.CacheOptions{})
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for engine.Info().FuelPerMs == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("fuel wasn't calibrated")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The calibration is cached across initializations.
+	fuelPerMs := engine.Info().FuelPerMs
+
+	engine.Init(hclog.NewNullLogger(), nil, interfaces.CacheOptions{})
+
+	if cached := engine.Info().FuelPerMs; cached != fuelPerMs {
+		t.Errorf("expected the cached fuel calibration %d, but got %d", fuelPerMs, cached)
+	}
+}
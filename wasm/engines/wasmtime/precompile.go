@@ -0,0 +1,51 @@
+package wasmtime
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/engines"
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// Precompile compiles the WASM modules of modulesDir (including
+// subdirectories) into the disk cache at diskPath, so caches are warmed during
+// deployment instead of on the first run of the modules. The plugin loads the
+// entries of modules which are unchanged at the same paths on startup, so
+// modules are recorded by their absolute path. It returns the number of
+// compiled modules and fails on the first module which doesn't compile.
+func Precompile(logger hclog.Logger, modulesDir, diskPath string, diskMaxSize int64) (int, error) {
+	modulesDir, err := filepath.Abs(modulesDir)
+	if err != nil {
+		return 0, err
+	}
+
+	cache, err := newDiskCache(logger, diskPath, diskMaxSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// Modules are compiled for the engine configuration of instances without
+	// fuel metering and memory limits, which are the ones the disk cache holds.
+	engineConfig := wasmtime.NewConfig()
+	engineConfig.SetEpochInterruption(true)
+	engine := wasmtime.NewEngineWithConfig(engineConfig)
+
+	policy := interfaces.PreCachePolicy{OnError: interfaces.PreCacheOnErrorFail}
+
+	return engines.PrePopulate(logger, modulesDir, policy, func(modulePath string) error {
+		wasmModule, err := engines.ReadModule(modulePath, interfaces.InstanceConfig{})
+		if err != nil {
+			return err
+		}
+
+		if _, err = cache.serialize(engine, wasmModule); err != nil {
+			return fmt.Errorf("unable to compile WASM module (%v): %v", modulePath, err)
+		}
+
+		return nil
+	})
+}
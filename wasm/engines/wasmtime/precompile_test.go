@@ -0,0 +1,76 @@
+package wasmtime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+func TestPrecompiledModulesLoadedFromDiskCache(t *testing.T) {
+	modulesDir, diskPath := t.TempDir(), t.TempDir()
+
+	var hashes []string
+
+	for _, module := range []struct {
+		path string
+		wat  string
+	}{
+		{path: "first.wasm", wat: `(module (func (export "first")))`},
+		{path: filepath.Join("nested", "second.wasm"), wat: `(module (func (export "second")))`},
+	} {
+		wasm, err := wasmtime.Wat2Wasm(module.wat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		modulePath := filepath.Join(modulesDir, module.path)
+		if err = os.MkdirAll(filepath.Dir(modulePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = os.WriteFile(modulePath, wasm, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		hash := sha256.Sum256(wasm)
+		hashes = append(hashes, hex.EncodeToString(hash[:]))
+	}
+
+	compiled, err := Precompile(hclog.NewNullLogger(), modulesDir, diskPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if compiled != len(hashes) {
+		t.Errorf("expected %d compiled modules, but got %d", len(hashes), compiled)
+	}
+
+	for _, hash := range hashes {
+		if _, err = os.Stat(filepath.Join(diskPath, hash+diskCacheExt)); err != nil {
+			t.Errorf("expected a serialized module in the disk cache, but got %v", err)
+		}
+	}
+
+	// The plugin populates its modules cache with the precompiled modules.
+	cache := gcache.New(5).LRU().Build()
+
+	engine := &wasmtimeEngine{}
+	engine.Init(hclog.NewNullLogger(), cache, interfaces.CacheOptions{
+		KeyStrategy: interfaces.CacheKeyContent,
+		DiskPath:    diskPath,
+	})
+
+	for _, hash := range hashes {
+		if !cache.Has("sha256:" + hash) {
+			t.Errorf("expected the precompiled module to be loaded, but got keys %v", cache.Keys(false))
+		}
+	}
+}
@@ -2,19 +2,33 @@ package wasmtime
 
 import (
 	"fmt"
-	"io/fs"
-	"path/filepath"
+	"os"
+	"sort"
 	"strings"
 
 	"github.com/bluele/gcache"
 	"github.com/bytecodealliance/wasmtime-go"
 	"github.com/hashicorp/go-hclog"
+	"golang.org/x/sync/singleflight"
 
 	"huawei.com/wasm-task-driver/wasm/engines"
 	"huawei.com/wasm-task-driver/wasm/interfaces"
+	"huawei.com/wasm-task-driver/wasm/modinfo"
 )
 
-const engineExtensionName = "wasmtime"
+const (
+	engineExtensionName = "wasmtime"
+
+	wasmtimeModulePath = "github.com/bytecodealliance/wasmtime-go"
+
+	// fuelCacheKeySuffix marks cache keys of modules compiled for fuel
+	// metering, which don't deserialize into engines without it and vice versa.
+	fuelCacheKeySuffix = "+fuel"
+
+	// maxMemoryCacheKeySuffix followed by the memory limit in pages marks cache
+	// keys of modules compiled with their memories capped to the limit.
+	maxMemoryCacheKeySuffix = "+max"
+)
 
 func init() {
 	engines.Register(&wasmtimeEngine{})
@@ -23,149 +37,560 @@ func init() {
 type wasmtimeEngine struct {
 	logger       hclog.Logger
 	modulesCache gcache.Cache
+	// diskCache persists serialized modules across plugin restarts, if
+	// enabled.
+	diskCache *diskCache
+	// loads deduplicates concurrent compilations of the same uncached module.
+	loads singleflight.Group
+	// cacheOpts defines how modules cache keys are built and which modules
+	// are cached.
+	cacheOpts interfaces.CacheOptions
+	// fuelCalibration estimates the fuel instances consume per millisecond.
+	fuelCalibration fuelCalibration
 }
 
 func (e *wasmtimeEngine) Name() string {
 	return engineExtensionName
 }
 
-func (e *wasmtimeEngine) Init(logger hclog.Logger, moduleCache gcache.Cache) {
+func (e *wasmtimeEngine) Init(logger hclog.Logger, moduleCache gcache.Cache, cacheOpts interfaces.CacheOptions) {
 	e.logger = logger
 	e.modulesCache = moduleCache
+	e.cacheOpts = cacheOpts
+	e.diskCache = nil
+
+	e.fuelCalibration.start(logger)
+
+	if moduleCache == nil || cacheOpts.DiskPath == "" {
+		return
+	}
+
+	diskCache, err := newDiskCache(logger, cacheOpts.DiskPath, cacheOpts.DiskMaxSize)
+	if err != nil {
+		logger.Error("unable to enable modules disk cache", "error", hclog.Fmt("%+v", err))
+
+		return
+	}
+
+	e.diskCache = diskCache
+
+	e.loadDiskCache()
 }
 
-// PrePopulateCache precache all wasm modules in specified directory
-// and return number of precached modules and error.
-func (e *wasmtimeEngine) PrePopulateCache(modulesDir string) (int, error) {
-	if e.modulesCache == nil {
-		return 0, fmt.Errorf("unable to pre populate modules: cache is not created")
+// loadDiskCache populates the modules cache with the modules of the disk
+// cache which are unchanged since they were serialized.
+func (e *wasmtimeEngine) loadDiskCache() {
+	hashes, err := e.diskCache.entries()
+	if err != nil {
+		e.logger.Error("unable to list modules disk cache", "error", hclog.Fmt("%+v", err))
+
+		return
 	}
 
-	var (
-		modulesPath            []string
-		preCachedModulesNumber int
-	)
+	store, err := newStore(false)
+	if err != nil {
+		e.logger.Error("unable to load modules disk cache", "error", hclog.Fmt("%+v", err))
+
+		return
+	}
+
+	var loaded int
 
-	err := filepath.Walk(modulesDir, func(path string, info fs.FileInfo, _err error) error {
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".wasm") {
-			modulesPath = append(modulesPath, path)
+	for _, hash := range hashes {
+		serModule, modulePath, ok := e.diskCache.get(hash)
+		if !ok {
+			continue
 		}
 
-		return nil
-	})
+		// The entry is kept if the module changed, it may be changed back.
+		wasmModule, err := engines.ReadModule(modulePath, interfaces.InstanceConfig{})
+		if err != nil || wasmModule.SHA256 != hash || !e.useCache(wasmModule) {
+			continue
+		}
+
+		if _, err = wasmtime.NewModuleDeserialize(store.Engine, serModule); err != nil {
+			e.logger.Debug("discarding disk cache entry which doesn't deserialize", "module", modulePath)
+			e.diskCache.remove(hash)
+
+			continue
+		}
+
+		cacheKey, err := engines.CacheKey(modulePath, e.cacheOpts.KeyStrategy, hash)
+		if err != nil {
+			continue
+		}
+
+		if err = e.modulesCache.Set(cacheKey, serModule); err != nil {
+			e.logger.Warn("unable to cache WASM module from disk cache", "module", modulePath, "error", hclog.Fmt("%+v", err))
+
+			continue
+		}
+
+		loaded++
+	}
 
+	e.logger.Info("loaded modules from disk cache", "modules", loaded, "dir", e.cacheOpts.DiskPath)
+}
+
+// compile returns the serialized module, taking it from the disk cache if
+// enabled. The disk cache only holds modules compiled without fuel metering
+// and memory limit.
+func (e *wasmtimeEngine) compile(engine *wasmtime.Engine, wasmModule engines.Module, consumeFuel bool,
+	maxMemoryPages uint64,
+) ([]byte, error) {
+	if e.diskCache != nil && !consumeFuel && maxMemoryPages == 0 {
+		return e.diskCache.serialize(engine, wasmModule)
+	}
+
+	module, err := loadModule(engine, wasmModule.Wasm, maxMemoryPages)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load WASM module: %w", err)
+	}
+
+	serModule, err := module.Serialize()
 	if err != nil {
-		return 0, fmt.Errorf("unable to get WASM modules for pre-cache from %s directory: %v",
-			modulesDir, err)
+		return nil, fmt.Errorf("unable to serialize WASM module: %w", err)
+	}
+
+	return serModule, nil
+}
+
+// Info reports the wasmtime-go version, the proposals enabled by the default
+// wasmtime configuration the engine uses and the fuel calibration, once the
+// engine is initialized. The Go bindings don't support components.
+func (e *wasmtimeEngine) Info() interfaces.EngineInfo {
+	return interfaces.EngineInfo{
+		Version:   engines.ModuleVersion(wasmtimeModulePath),
+		Fuel:      true,
+		FuelPerMs: e.fuelCalibration.get(),
+		Features: map[string]bool{
+			modinfo.FeatureSIMD:              true,
+			modinfo.FeatureBulkMemory:        true,
+			modinfo.FeatureReferenceTypes:    true,
+			modinfo.FeatureMultiValue:        true,
+			modinfo.FeatureThreads:           false,
+			modinfo.FeatureMemory64:          false,
+			modinfo.FeatureMultiMemory:       false,
+			modinfo.FeatureExceptionHandling: false,
+			modinfo.FeatureComponentModel:    false,
+		},
+	}
+}
+
+// PrePopulateCache precache all wasm modules in specified directory
+// and return number of precached modules and error.
+func (e *wasmtimeEngine) PrePopulateCache(modulesDir string, policy interfaces.PreCachePolicy) (int, error) {
+	if e.modulesCache == nil {
+		return 0, fmt.Errorf("unable to pre populate modules: cache is not created")
 	}
 
 	loadEngineConfig := wasmtime.NewConfig()
 	loadEngineConfig.SetEpochInterruption(true)
 	loadEngine := wasmtime.NewEngineWithConfig(loadEngineConfig)
 
-	for _, modulePath := range modulesPath {
-		wasmModule, err := wasmtime.NewModuleFromFile(loadEngine, modulePath)
+	return engines.PrePopulate(e.logger, modulesDir, policy, func(modulePath string) error {
+		tooLarge, err := engines.TooLargeToCache(modulePath, e.cacheOpts.MaxModuleSize)
+		if err != nil {
+			return err
+		}
+
+		if tooLarge {
+			e.logger.Debug("WASM module exceeds cache max module size, not pre-caching it", "module", modulePath)
+
+			return nil
+		}
+
+		wasmModule, err := engines.ReadModule(modulePath, interfaces.InstanceConfig{})
+		if err != nil {
+			return err
+		}
+
+		cacheKey, err := engines.CacheKey(modulePath, e.cacheOpts.KeyStrategy, wasmModule.SHA256)
 		if err != nil {
-			return 0, fmt.Errorf("unable to load WASM module (%v) from file: %v", modulePath, err)
+			return err
 		}
 
-		serModule, err := wasmModule.Serialize()
+		serModule, err := e.compile(loadEngine, wasmModule, false, 0)
 		if err != nil {
-			return 0, fmt.Errorf("unable to serialize WASM module (%v): %v", modulePath, err)
+			return fmt.Errorf("unable to compile WASM module (%v): %v", modulePath, err)
 		}
 
-		if err := e.modulesCache.Set(modulePath, serModule); err != nil {
-			return 0, fmt.Errorf("unable to cache WASM module (%v)", modulePath)
+		if err := e.modulesCache.Set(cacheKey, serModule); err != nil {
+			return fmt.Errorf("unable to cache WASM module (%v)", modulePath)
 		}
 
-		preCachedModulesNumber++
+		return nil
+	})
+}
 
-		e.logger.Trace("WASM module pre-cached", "module", modulePath)
+func (e *wasmtimeEngine) VerifyCache() (int, error) {
+	if e.modulesCache == nil {
+		return 0, nil
 	}
 
-	return preCachedModulesNumber, nil
+	// Modules are deserialized into engines created by newStore, so the cached
+	// modules are verified against the same engine configuration.
+	store, err := newStore(false)
+	if err != nil {
+		return 0, err
+	}
+
+	fuelStore, err := newStore(true)
+	if err != nil {
+		return 0, err
+	}
+
+	var corrupted int
+
+	for key, mod := range e.modulesCache.GetALL(false) {
+		engine := store.Engine
+		if cacheKey, ok := key.(string); ok && strings.HasSuffix(cacheKey, fuelCacheKeySuffix) {
+			engine = fuelStore.Engine
+		}
+
+		if _, err := wasmtime.NewModuleDeserialize(engine, mod.([]byte)); err != nil {
+			e.logger.Warn("cached WASM module doesn't deserialize, removing it from cache", "key", key,
+				"error", hclog.Fmt("%+v", err))
+
+			e.modulesCache.Remove(key)
+
+			corrupted++
+		}
+	}
+
+	return corrupted, nil
 }
 
-func (e *wasmtimeEngine) InstantiateModule(modulePath string) (interfaces.WasmInstance, error) {
+func (e *wasmtimeEngine) InstantiateModule(modulePath string, conf interfaces.InstanceConfig) (interfaces.WasmInstance, error) {
 	e.logger.Debug("instantiate new module", "module path", modulePath)
 
-	engineConfig := wasmtime.NewConfig()
-	engineConfig.SetEpochInterruption(true)
+	consumeFuel := conf.FuelLimit > 0
 
-	engine := wasmtime.NewEngineWithConfig(engineConfig)
+	store, err := newStore(consumeFuel)
+	if err != nil {
+		return nil, err
+	}
 
-	store := wasmtime.NewStore(engine)
-	store.SetEpochDeadline(1)
+	if consumeFuel {
+		if err = store.AddFuel(conf.FuelLimit); err != nil {
+			return nil, fmt.Errorf("unable to add fuel: %w", err)
+		}
+	}
+
+	wasmModule, err := engines.ReadModule(modulePath, conf)
+	if err != nil {
+		return nil, err
+	}
 
-	module, err := e.getModule(store, modulePath)
+	module, cached, err := e.getModule(store, wasmModule, consumeFuel, conf.MaxMemoryPages)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get module %s: %w", modulePath, err)
 	}
 
-	instance, err := wasmtime.NewInstance(store, module, []wasmtime.AsExtern{})
+	linker := wasmtime.NewLinker(store.Engine)
+
+	if err = e.defineHostFuncs(linker, conf.HostFuncs); err != nil {
+		return nil, fmt.Errorf("unable to define host functions: %w", err)
+	}
+
+	if conf.Wasi != nil {
+		if err = defineWasi(store, linker, conf.Wasi); err != nil {
+			return nil, fmt.Errorf("unable to define WASI: %w", err)
+		}
+	}
+
+	instance, err := linker.Instantiate(store, module)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create new instance from module %s: %w", modulePath, err)
 	}
 
 	return &wasmtimeInstance{
-		store:    store,
-		instance: instance,
+		engine:    store.Engine,
+		store:     store,
+		instance:  instance,
+		fuelLimit: conf.FuelLimit,
+		cached:    cached,
 	}, nil
 }
 
-func (e *wasmtimeEngine) getModule(store *wasmtime.Store, modulePath string) (*wasmtime.Module, error) {
+// newStore creates an engine and a store for a single instance, consuming fuel
+// if enabled. wasmtime-go panics on invalid engine configuration, so the panic
+// is turned into an error to keep a bad configuration from crashing the plugin.
+func newStore(consumeFuel bool) (store *wasmtime.Store, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			store = nil
+			err = fmt.Errorf("unable to create wasmtime engine: %v", r)
+		}
+	}()
+
+	engineConfig := wasmtime.NewConfig()
+	engineConfig.SetEpochInterruption(true)
+	engineConfig.SetConsumeFuel(consumeFuel)
+
+	engine := wasmtime.NewEngineWithConfig(engineConfig)
+
+	store = wasmtime.NewStore(engine)
+	store.SetEpochDeadline(1)
+
+	return store, nil
+}
+
+// getModule returns the module compiled for the store engine and whether it
+// was served from the modules cache. Modules of instances with a memory limit
+// are compiled with their memories capped to it, as wasmtime-go has no store
+// resource limiter.
+func (e *wasmtimeEngine) getModule(store *wasmtime.Store, wasmModule engines.Module, consumeFuel bool,
+	maxMemoryPages uint64,
+) (*wasmtime.Module, bool, error) {
 	var (
-		err    error
 		module *wasmtime.Module
+		cached bool
+		err    error
 	)
 
-	if e.modulesCache != nil {
-		mod, getCacheErr := e.modulesCache.Get(modulePath)
+	if e.useCache(wasmModule) {
+		var cacheKey string
+
+		cacheKey, err = engines.CacheKey(wasmModule.Path, e.cacheOpts.KeyStrategy, wasmModule.SHA256)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if maxMemoryPages > 0 {
+			cacheKey += fmt.Sprintf("%s%d", maxMemoryCacheKeySuffix, maxMemoryPages)
+		}
+
+		if consumeFuel {
+			cacheKey += fuelCacheKeySuffix
+		}
+
+		mod, getCacheErr := e.modulesCache.Get(cacheKey)
 		switch getCacheErr {
 		case nil:
+			cached = true
+
 			module, err = wasmtime.NewModuleDeserialize(store.Engine, mod.([]byte))
 			if err != nil {
 				e.logger.Error("unable to deserialize WASM module", "error", hclog.Fmt("%+v", err))
 
-				return nil, fmt.Errorf("unable to deserialize WASM module: %w", err)
+				return nil, false, fmt.Errorf("unable to deserialize WASM module: %w", err)
 			}
 		case gcache.KeyNotFoundError:
-			module, err = wasmtime.NewModuleFromFile(store.Engine, modulePath)
-			if err != nil {
-				e.logger.Error("unable to load WASM module", "error", hclog.Fmt("%+v", err))
-
-				return nil, fmt.Errorf("unable to load WASM module: %w", err)
-			}
+			// Tasks starting at the same time with the same uncached module share a
+			// single compilation and deserialize its result into their own engine.
+			var serModule interface{}
 
-			serModule, err2 := module.Serialize()
-			if err2 != nil {
-				e.logger.Error("unable to serialize WASM module", "error", hclog.Fmt("%+v", err))
-
-				return nil, fmt.Errorf("unable to serialize WASM module: %w", err)
+			serModule, err, _ = e.loads.Do(cacheKey, func() (interface{}, error) {
+				return e.compileAndCache(store.Engine, wasmModule, cacheKey, consumeFuel, maxMemoryPages)
+			})
+			if err != nil {
+				return nil, false, err
 			}
 
-			if err2 := e.modulesCache.Set(modulePath, serModule); err2 != nil {
-				e.logger.Error("unable to cache WASM module", "error", hclog.Fmt("%+v", err2))
+			module, err = wasmtime.NewModuleDeserialize(store.Engine, serModule.([]byte))
+			if err != nil {
+				e.logger.Error("unable to deserialize WASM module", "error", hclog.Fmt("%+v", err))
 
-				return nil, fmt.Errorf("unable to cache WASM module: %w", err2)
+				return nil, false, fmt.Errorf("unable to deserialize WASM module: %w", err)
 			}
-
-			e.logger.Debug("cached WASM module", "module", modulePath)
 		default:
 			e.logger.Error("unable to get module from cache", "error", hclog.Fmt("%+v", getCacheErr))
 
-			return nil, fmt.Errorf("unable to cache WASM module: %w", getCacheErr)
+			return nil, false, fmt.Errorf("unable to cache WASM module: %w", getCacheErr)
 		}
 	} else {
-		e.logger.Debug("modules cache disabled loading WASM module from file", "module", modulePath)
+		e.logger.Debug("loading WASM module without cache", "module", wasmModule.Path)
 
-		module, err = wasmtime.NewModuleFromFile(store.Engine, modulePath)
+		module, err = loadModule(store.Engine, wasmModule.Wasm, maxMemoryPages)
 		if err != nil {
 			e.logger.Error("unable to load WASM module", "error", hclog.Fmt("%+v", err))
 
-			return nil, fmt.Errorf("unable to load WASM module: %w", err)
+			return nil, false, fmt.Errorf("unable to load WASM module: %w", err)
+		}
+	}
+
+	return module, cached, nil
+}
+
+// loadModule compiles the module binary, with its memories capped to the
+// memory limit if any.
+func loadModule(engine *wasmtime.Engine, wasm []byte, maxMemoryPages uint64) (*wasmtime.Module, error) {
+	if maxMemoryPages == 0 {
+		return wasmtime.NewModule(engine, wasm)
+	}
+
+	capped, err := modinfo.CapMemory(wasm, maxMemoryPages)
+	if err != nil {
+		return nil, err
+	}
+
+	return wasmtime.NewModule(engine, capped)
+}
+
+// useCache reports whether the module is loaded through the modules cache.
+func (e *wasmtimeEngine) useCache(wasmModule engines.Module) bool {
+	return e.modulesCache != nil && !wasmModule.TooLargeToCache(e.cacheOpts.MaxModuleSize)
+}
+
+func (e *wasmtimeEngine) defineHostFuncs(linker *wasmtime.Linker, hostFuncs []interfaces.HostFunc) error {
+	for _, hostFunc := range hostFuncs {
+		hostFunc := hostFunc
+
+		funcType := wasmtime.NewFuncType(toValTypes(hostFunc.Params), toValTypes(hostFunc.Results))
+
+		err := linker.FuncNew(hostFunc.Module, hostFunc.Name, funcType,
+			func(caller *wasmtime.Caller, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+				var memory []byte
+				if export := caller.GetExport("memory"); export != nil && export.Memory() != nil {
+					memory = export.Memory().UnsafeData(caller)
+				}
+
+				params := make([]interface{}, len(args))
+				for i, arg := range args {
+					params[i] = arg.Get()
+				}
+
+				results, err := hostFunc.Call(memory, params)
+				if err != nil {
+					e.logger.Error("host function failed", "function", hostFunc.Name, "error", hclog.Fmt("%+v", err))
+
+					return nil, wasmtime.NewTrap(fmt.Sprintf("%s.%s: %v", hostFunc.Module, hostFunc.Name, err))
+				}
+
+				return toVals(results), nil
+			})
+		if err != nil {
+			return fmt.Errorf("unable to define %s.%s: %w", hostFunc.Module, hostFunc.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// defineWasi links WASI into the instance with the module stdin read from and
+// its stdout and stderr written to the configured files. The output isn't buffered, and the files
+// are closed once the released store is garbage collected.
+func defineWasi(store *wasmtime.Store, linker *wasmtime.Linker, opts *interfaces.WasiOptions) error {
+	wasiConfig := wasmtime.NewWasiConfig()
+	wasiConfig.SetEnv(envVars(opts.Env))
+
+	if err := wasiConfig.SetStdoutFile(outputPath(opts.StdoutPath)); err != nil {
+		return fmt.Errorf("unable to open stdout %s: %w", opts.StdoutPath, err)
+	}
+
+	if err := wasiConfig.SetStderrFile(outputPath(opts.StderrPath)); err != nil {
+		return fmt.Errorf("unable to open stderr %s: %w", opts.StderrPath, err)
+	}
+
+	if opts.StdinPath != "" {
+		if err := wasiConfig.SetStdinFile(opts.StdinPath); err != nil {
+			return fmt.Errorf("unable to open stdin %s: %w", opts.StdinPath, err)
+		}
+	}
+
+	for _, dir := range opts.PreopenDirs {
+		if err := wasiConfig.PreopenDir(dir.HostPath, dir.GuestPath); err != nil {
+			return fmt.Errorf("unable to preopen %s as %s: %w", dir.HostPath, dir.GuestPath, err)
+		}
+	}
+
+	store.SetWasi(wasiConfig)
+
+	return linker.DefineWasi()
+}
+
+// envVars returns the names and values of the environment variables sorted by
+// name, so modules always observe the same order. Variables with empty names
+// are skipped.
+func envVars(env map[string]string) ([]string, []string) {
+	keys := make([]string, 0, len(env))
+
+	for key := range env {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = env[key]
+	}
+
+	return keys, values
+}
+
+// outputPath returns the path module output is written to, discarding it if
+// the path is empty.
+func outputPath(path string) string {
+	if path == "" {
+		return os.DevNull
+	}
+
+	return path
+}
+
+// compileAndCache compiles the module, stores its serialized form in the
+// modules cache under the cache key and returns it. The cache is checked again
+// first: a task which missed the cache while another compilation of the module
+// was finishing would otherwise compile it again.
+func (e *wasmtimeEngine) compileAndCache(engine *wasmtime.Engine, wasmModule engines.Module, cacheKey string,
+	consumeFuel bool, maxMemoryPages uint64,
+) ([]byte, error) {
+	// The driver counts lookups of Get only, so checking the cache again
+	// doesn't count the instantiation twice in the cache hit rate.
+	if e.modulesCache.Has(cacheKey) {
+		if mod, err := e.modulesCache.GetIFPresent(cacheKey); err == nil {
+			return mod.([]byte), nil
+		}
+	}
+
+	serModule, err := e.compile(engine, wasmModule, consumeFuel, maxMemoryPages)
+	if err != nil {
+		e.logger.Error("unable to compile WASM module", "error", hclog.Fmt("%+v", err))
+
+		return nil, err
+	}
+
+	if err := e.modulesCache.Set(cacheKey, serModule); err != nil {
+		e.logger.Error("unable to cache WASM module", "error", hclog.Fmt("%+v", err))
+
+		return nil, fmt.Errorf("unable to cache WASM module: %w", err)
+	}
+
+	e.logger.Debug("cached WASM module", "module", wasmModule.Path)
+
+	return serModule, nil
+}
+
+func toValTypes(types []interfaces.ValueType) []*wasmtime.ValType {
+	result := make([]*wasmtime.ValType, len(types))
+
+	for i, valueType := range types {
+		switch valueType {
+		case interfaces.ValueTypeI64:
+			result[i] = wasmtime.NewValType(wasmtime.KindI64)
+		default:
+			result[i] = wasmtime.NewValType(wasmtime.KindI32)
+		}
+	}
+
+	return result
+}
+
+func toVals(values []interface{}) []wasmtime.Val {
+	result := make([]wasmtime.Val, len(values))
+
+	for i, value := range values {
+		switch v := value.(type) {
+		case int64:
+			result[i] = wasmtime.ValI64(v)
+		default:
+			result[i] = wasmtime.ValI32(v.(int32))
 		}
 	}
 
-	return module, nil
+	return result
 }
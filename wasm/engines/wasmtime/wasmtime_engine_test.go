@@ -0,0 +1,445 @@
+package wasmtime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// writeModule writes the module in the WebAssembly text format as a binary
+// module file and returns its path.
+func writeModule(t *testing.T, wat string) string {
+	t.Helper()
+
+	wasm, err := wasmtime.Wat2Wasm(wat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modulePath := filepath.Join(t.TempDir(), "module.wasm")
+	if err = os.WriteFile(modulePath, wasm, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return modulePath
+}
+
+func TestMemoryCappedToLimit(t *testing.T) {
+	for _, test := range []struct {
+		cache gcache.Cache
+		name  string
+	}{
+		{name: "uncached"},
+		{name: "cached", cache: gcache.New(5).LRU().Build()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine := &wasmtimeEngine{}
+			engine.Init(hclog.NewNullLogger(), test.cache, interfaces.CacheOptions{KeyStrategy: interfaces.CacheKeyPath})
+
+			modulePath := writeModule(t, `(module
+  (memory (export "memory") 1)
+  (func (export "grow") (param i32) (result i32)
+    (memory.grow (local.get 0))))`)
+
+			instance, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{MaxMemoryPages: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer instance.Cleanup()
+
+			for _, expected := range []int32{1, -1} {
+				var result interface{}
+
+				result, err = instance.CallFunc("grow", int32(1))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if result != expected {
+					t.Errorf("expected memory.grow to return %d, but got %v", expected, result)
+				}
+			}
+
+			// The module compiled with the limit isn't used without it.
+			unlimited, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer unlimited.Cleanup()
+
+			if result, err := unlimited.CallFunc("grow", int32(2)); err != nil || result != int32(1) {
+				t.Errorf("expected the memory without limit to grow, but got %v (%v)", result, err)
+			}
+		})
+	}
+}
+
+func TestCacheKeysCoverCompilationSettings(t *testing.T) {
+	cache := gcache.New(10).LRU().Build()
+
+	engine := &wasmtimeEngine{}
+	engine.Init(hclog.NewNullLogger(), cache, interfaces.CacheOptions{KeyStrategy: interfaces.CacheKeyPath})
+
+	modulePath := writeModule(t, `(module (memory 1) (func (export "run")))`)
+
+	for _, conf := range []interfaces.InstanceConfig{
+		{},
+		{FuelLimit: 1000},
+		{MaxMemoryPages: 2},
+		{MaxMemoryPages: 2, FuelLimit: 1000},
+		// Settings which don't affect the compiled module reuse its entry.
+		{Statistics: true, Wasi: &interfaces.WasiOptions{Env: map[string]string{"KEY": "value"}}},
+		{MaxMemoryPages: 2, FuelLimit: 5000},
+	} {
+		instance, err := engine.InstantiateModule(modulePath, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		instance.Cleanup()
+	}
+
+	var keys []string
+
+	for _, key := range cache.Keys(false) {
+		keys = append(keys, key.(string))
+	}
+
+	slices.Sort(keys)
+
+	expected := []string{modulePath, modulePath + "+fuel", modulePath + "+max2", modulePath + "+max2+fuel"}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected cache keys %v, but got %v", expected, keys)
+	}
+}
+
+func TestModuleNeedingMoreThanMemoryLimitRejected(t *testing.T) {
+	engine := &wasmtimeEngine{}
+	engine.Init(hclog.NewNullLogger(), nil, interfaces.CacheOptions{})
+
+	modulePath := writeModule(t, `(module (memory 3))`)
+
+	_, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{MaxMemoryPages: 2})
+	if err == nil || !strings.Contains(err.Error(), "memory 0 needs 3 pages initially, more than the limit of 2 pages") {
+		t.Errorf("expected the module to be rejected, but got %v", err)
+	}
+}
+
+func TestModuleCompiledFromInstanceConfigContent(t *testing.T) {
+	cache := gcache.New(5).LRU().Build()
+
+	engine := &wasmtimeEngine{}
+	engine.Init(hclog.NewNullLogger(), cache, interfaces.CacheOptions{KeyStrategy: interfaces.CacheKeyContent})
+
+	// The module file was replaced after the driver read and checked it.
+	modulePath := writeModule(t, `(module (func (export "replaced")))`)
+
+	checked, err := wasmtime.Wat2Wasm(`(module (func (export "checked")))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256(checked)
+	checksum := hex.EncodeToString(hash[:])
+
+	instance, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{
+		Module:       checked,
+		ModuleSHA256: checksum,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Cleanup()
+
+	if _, err = instance.FuncParams("checked"); err != nil {
+		t.Errorf("expected the checked module to be instantiated, but got %v", err)
+	}
+
+	if !cache.Has("sha256:" + checksum) {
+		t.Errorf("expected the checked module to be cached under its checksum, but got keys %v", cache.Keys(false))
+	}
+}
+
+func TestConcurrentInstantiationsCompileModuleOnce(t *testing.T) {
+	var added atomic.Int32
+
+	cache := gcache.New(5).LRU().AddedFunc(func(interface{}, interface{}) { added.Add(1) }).Build()
+
+	engine := &wasmtimeEngine{}
+	engine.Init(hclog.NewNullLogger(), cache, interfaces.CacheOptions{KeyStrategy: interfaces.CacheKeyContent})
+
+	modulePath := writeModule(t, `(module (func (export "run") (result i32) (i32.const 1)))`)
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 8)
+
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			instance, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{})
+			if err != nil {
+				errs <- err
+
+				return
+			}
+			defer instance.Cleanup()
+
+			if result, err := instance.CallFunc("run"); err != nil || result != int32(1) {
+				errs <- fmt.Errorf("unexpected result %v (%v)", result, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if added.Load() != 1 {
+		t.Errorf("expected the module to be compiled and cached once, but it was cached %d times", added.Load())
+	}
+}
+
+func TestModuleChangeInvalidatesMtimeCacheEntry(t *testing.T) {
+	cache := gcache.New(5).LRU().Build()
+
+	engine := &wasmtimeEngine{}
+	engine.Init(hclog.NewNullLogger(), cache, interfaces.CacheOptions{KeyStrategy: interfaces.CacheKeyMtime})
+
+	modulePath := writeModule(t, `(module (func (export "before")))`)
+
+	instance, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance.Cleanup()
+
+	replaced, err := wasmtime.Wat2Wasm(`(module (func (export "after")))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(modulePath, replaced, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replaced module may have the same size and, on coarse file systems,
+	// the same modification time.
+	modTime := time.Now().Add(time.Hour)
+	if err = os.Chtimes(modulePath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err = engine.InstantiateModule(modulePath, interfaces.InstanceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Cleanup()
+
+	if _, err = instance.FuncParams("after"); err != nil {
+		t.Errorf("expected the replaced module to be instantiated instead of the cached one, but got %v", err)
+	}
+
+	if keys := cache.Keys(false); len(keys) != 2 {
+		t.Errorf("expected the replaced module to be cached under a new key, but got keys %v", keys)
+	}
+
+	if instance.ModuleCached() {
+		t.Error("expected the replaced module to be reported as compiled rather than cached")
+	}
+
+	cachedInstance, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cachedInstance.Cleanup()
+
+	if !cachedInstance.ModuleCached() {
+		t.Error("expected the replaced module to be served from cache once cached")
+	}
+}
+
+func TestModuleAboveMaxSizeBypassesCache(t *testing.T) {
+	cache := gcache.New(5).LRU().Build()
+
+	engine := &wasmtimeEngine{}
+	engine.Init(hclog.NewNullLogger(), cache, interfaces.CacheOptions{
+		KeyStrategy:   interfaces.CacheKeyPath,
+		MaxModuleSize: 64,
+	})
+
+	small := writeModule(t, `(module (func (export "run") (result i32) (i32.const 1)))`)
+	large := writeModule(t, `(module
+  (func (export "run") (result i32) (i32.const 1))
+  (data (memory 0) (i32.const 0) "padding the module beyond the cache max module size")
+  (memory 1))`)
+
+	for _, modulePath := range []string{small, large} {
+		instance, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Modules which bypass the cache still run, compiled from file.
+		if result, err := instance.CallFunc("run"); err != nil || result != int32(1) {
+			t.Errorf("unexpected result of module %s %v (%v)", modulePath, result, err)
+		}
+
+		instance.Cleanup()
+	}
+
+	if !cache.Has(small) || cache.Has(large) {
+		t.Errorf("expected only the module below the max module size to be cached, but got keys %v",
+			cache.Keys(false))
+	}
+
+	// Pre-caching skips them as well.
+	cache.Purge()
+
+	policy := interfaces.PreCachePolicy{OnError: interfaces.PreCacheOnErrorFail}
+
+	if _, err := engine.PrePopulateCache(filepath.Dir(large), policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Len(false) != 0 {
+		t.Errorf("expected the module above the max module size not to be pre-cached, but got keys %v",
+			cache.Keys(false))
+	}
+}
+
+func TestBadModuleSkippedWhilePreCaching(t *testing.T) {
+	modulesDir := t.TempDir()
+
+	for name, wat := range map[string]string{
+		"first.wasm":  `(module (func (export "first")))`,
+		"second.wasm": `(module (func (export "second")))`,
+	} {
+		wasm, err := wasmtime.Wat2Wasm(wat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = os.WriteFile(filepath.Join(modulesDir, name), wasm, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(modulesDir, "bad.wasm"), []byte("not a module"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		err    string
+		policy interfaces.PreCachePolicy
+		cached int
+	}{
+		{name: "skip", policy: interfaces.PreCachePolicy{OnError: interfaces.PreCacheOnErrorSkip}, cached: 2},
+		{name: "retry", policy: interfaces.PreCachePolicy{OnError: interfaces.PreCacheOnErrorRetry, Retries: 2}, cached: 2},
+		{name: "fail", policy: interfaces.PreCachePolicy{OnError: interfaces.PreCacheOnErrorFail}, err: "bad.wasm"},
+	} {
+		cache := gcache.New(5).LRU().Build()
+
+		engine := &wasmtimeEngine{}
+		engine.Init(hclog.NewNullLogger(), cache, interfaces.CacheOptions{KeyStrategy: interfaces.CacheKeyPath})
+
+		cached, err := engine.PrePopulateCache(modulesDir, test.policy)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected pre-caching to fail on %s, but got %v", test.name, test.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if cached != test.cached || cache.Len(false) != test.cached || cache.Has(filepath.Join(modulesDir, "bad.wasm")) {
+			t.Errorf("%s: expected the good modules to be pre-cached, but got %d modules with keys %v", test.name,
+				cached, cache.Keys(false))
+		}
+
+		// The pre-cached modules load.
+		for _, name := range []string{"first", "second"} {
+			instance, err := engine.InstantiateModule(filepath.Join(modulesDir, name+".wasm"), interfaces.InstanceConfig{})
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+
+			if _, err = instance.FuncParams(name); err != nil {
+				t.Errorf("%s: expected module %s to load, but got %v", test.name, name, err)
+			}
+
+			instance.Cleanup()
+		}
+	}
+}
+
+func TestWasiOutputWithoutLogPathsDiscarded(t *testing.T) {
+	engine := &wasmtimeEngine{}
+	engine.Init(hclog.NewNullLogger(), nil, interfaces.CacheOptions{})
+
+	// write writes "hello" to the file descriptor and returns the WASI errno.
+	modulePath := writeModule(t, `(module
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 16) "hello")
+  (func (export "write") (param i32) (result i32)
+    (i32.store (i32.const 0) (i32.const 16))
+    (i32.store (i32.const 4) (i32.const 5))
+    (call $fd_write (local.get 0) (i32.const 0) (i32.const 1) (i32.const 8))))`)
+
+	stdoutPath := filepath.Join(t.TempDir(), "stdout")
+
+	for _, test := range []struct {
+		name string
+		wasi interfaces.WasiOptions
+	}{
+		{name: "no log paths"},
+		{name: "stdout only", wasi: interfaces.WasiOptions{StdoutPath: stdoutPath}},
+	} {
+		wasi := test.wasi
+
+		instance, err := engine.InstantiateModule(modulePath, interfaces.InstanceConfig{Wasi: &wasi})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		for _, fd := range []int32{1, 2} {
+			if result, err := instance.CallFunc("write", fd); err != nil || result != int32(0) {
+				t.Errorf("%s: expected writing to fd %d to succeed, but got %v (%v)", test.name, fd, result, err)
+			}
+		}
+
+		instance.Cleanup()
+	}
+
+	if data, err := os.ReadFile(stdoutPath); err != nil || string(data) != "hello" {
+		t.Errorf("expected the stdout written to its log path, but got %q (%v)", data, err)
+	}
+}
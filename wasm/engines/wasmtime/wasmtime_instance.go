@@ -5,11 +5,19 @@ import (
 	"github.com/pkg/errors"
 
 	"huawei.com/wasm-task-driver/wasm/engines"
+	"huawei.com/wasm-task-driver/wasm/interfaces"
 )
 
 type wasmtimeInstance struct {
+	// engine is kept apart from the store, so the instance can still be
+	// stopped once its store is released.
+	engine   *wasmtime.Engine
 	store    *wasmtime.Store
 	instance *wasmtime.Instance
+	// fuelLimit is the fuel added to the store, zero if fuel isn't consumed.
+	fuelLimit uint64
+	// cached reports whether the module was served from the modules cache.
+	cached bool
 }
 
 func (i *wasmtimeInstance) CallFunc(funcName string, args ...interface{}) (interface{}, error) {
@@ -23,15 +31,132 @@ func (i *wasmtimeInstance) CallFunc(funcName string, args ...interface{}) (inter
 		return nil, errors.Wrapf(err, "unable to call function: %s", funcName)
 	}
 
+	// Functions returning multiple values return the first one, like with
+	// wasmedge.
+	if results, ok := funcResult.([]wasmtime.Val); ok {
+		if len(results) == 0 {
+			return nil, nil
+		}
+
+		return results[0].Get(), nil
+	}
+
 	return funcResult, nil
 }
 
 func (i *wasmtimeInstance) GetMemoryRange(start, size int32) ([]byte, error) {
-	return i.instance.GetExport(i.store, "memory").Memory().UnsafeData(i.store)[start : start+size], nil
+	memory, err := i.memory()
+	if err != nil {
+		return nil, err
+	}
+
+	data := memory.UnsafeData(i.store)
+	if start < 0 || size < 0 || int64(start)+int64(size) > int64(len(data)) {
+		return nil, errors.Errorf("memory range [%d, %d) is out of bounds (memory size %d)",
+			start, int64(start)+int64(size), len(data))
+	}
+
+	return data[start : start+size], nil
+}
+
+func (i *wasmtimeInstance) MemoryPages() (uint64, error) {
+	memory, err := i.memory()
+	if err != nil {
+		return 0, err
+	}
+
+	return memory.Size(i.store), nil
+}
+
+func (i *wasmtimeInstance) GrowMemory(deltaPages uint64) error {
+	memory, err := i.memory()
+	if err != nil {
+		return err
+	}
+
+	if _, err := memory.Grow(i.store, deltaPages); err != nil {
+		return errors.Wrapf(err, "unable to grow memory by %d pages", deltaPages)
+	}
+
+	return nil
+}
+
+func (i *wasmtimeInstance) FuncParams(funcName string) ([]interfaces.ValueType, error) {
+	moduleFunc := i.instance.GetFunc(i.store, funcName)
+	if moduleFunc == nil {
+		return nil, errors.Wrapf(engines.ErrNotFound, "WASM module doesn't export %s func", funcName)
+	}
+
+	return valueTypes(moduleFunc.Type(i.store).Params()), nil
+}
+
+func (i *wasmtimeInstance) FuncResults(funcName string) ([]interfaces.ValueType, error) {
+	moduleFunc := i.instance.GetFunc(i.store, funcName)
+	if moduleFunc == nil {
+		return nil, errors.Wrapf(engines.ErrNotFound, "WASM module doesn't export %s func", funcName)
+	}
+
+	return valueTypes(moduleFunc.Type(i.store).Results()), nil
+}
+
+// valueTypes converts wasmtime value types to the types reported to the
+// driver.
+func valueTypes(types []*wasmtime.ValType) []interfaces.ValueType {
+	result := make([]interfaces.ValueType, len(types))
+
+	for j, valType := range types {
+		switch valType.Kind() {
+		case wasmtime.KindI32:
+			result[j] = interfaces.ValueTypeI32
+		case wasmtime.KindI64:
+			result[j] = interfaces.ValueTypeI64
+		default:
+			result[j] = interfaces.ValueTypeOther
+		}
+	}
+
+	return result
+}
+
+func (i *wasmtimeInstance) memory() (*wasmtime.Memory, error) {
+	export := i.instance.GetExport(i.store, "memory")
+	if export == nil || export.Memory() == nil {
+		return nil, errors.Wrap(engines.ErrNotFound, "WASM module doesn't export memory")
+	}
+
+	return export.Memory(), nil
+}
+
+func (i *wasmtimeInstance) ModuleCached() bool {
+	return i.cached
+}
+
+// Statistics reports the consumed and remaining fuel if the instance consumes
+// fuel, wasmtime doesn't collect other execution statistics.
+func (i *wasmtimeInstance) Statistics() (interfaces.ExecutionStatistics, bool) {
+	if i.fuelLimit == 0 {
+		return interfaces.ExecutionStatistics{}, false
+	}
+
+	consumed, ok := i.store.FuelConsumed()
+	if !ok {
+		return interfaces.ExecutionStatistics{}, false
+	}
+
+	return interfaces.ExecutionStatistics{
+		Fuel:          true,
+		FuelConsumed:  consumed,
+		FuelRemaining: i.fuelLimit - min(consumed, i.fuelLimit),
+	}, true
 }
 
 func (i *wasmtimeInstance) Stop() {
-	i.store.Engine.IncrementEpoch()
+	i.engine.IncrementEpoch()
 }
 
-func (i *wasmtimeInstance) Cleanup() {}
+// Cleanup releases the store, so its WASI stdout and stderr files are closed
+// once the garbage collector finalizes it. wasmtime-go doesn't allow deleting
+// a store explicitly.
+func (i *wasmtimeInstance) Cleanup() {
+	i.store, i.instance = nil, nil
+}
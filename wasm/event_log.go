@@ -0,0 +1,102 @@
+package wasm
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Event types recorded to the task event log.
+const (
+	eventTypeStarted     = "started"
+	eventTypeFinished    = "finished"
+	eventTypeInterrupted = "interrupted"
+	eventTypeMemoryAlert = "memory_alert"
+)
+
+// eventLogEntry is a single line of the task event log.
+type eventLogEntry struct {
+	Timestamp     time.Time         `json:"timestamp"`
+	Details       map[string]string `json:"details,omitempty"`
+	TaskID        string            `json:"task_id"`
+	CorrelationID string            `json:"correlation_id"`
+	Type          string            `json:"type"`
+	Message       string            `json:"message,omitempty"`
+}
+
+// eventLog writes task lifecycle and resource events as JSON lines, so they
+// can be consumed by tools analyzing finished runs. A nil eventLog discards
+// all events.
+type eventLog struct {
+	file          *os.File
+	taskID        string
+	correlationID string
+	mu            sync.Mutex
+}
+
+// eventLogPath returns the path of the task event log, which is kept next to
+// the task stdout and stderr logs.
+func eventLogPath(logDir, taskName string) string {
+	return filepath.Join(logDir, taskName+".events.jsonl")
+}
+
+func openEventLog(path, taskID, correlationID string) (*eventLog, error) {
+	//nolint:gosec
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventLog{file: file, taskID: taskID, correlationID: correlationID}, nil
+}
+
+// record appends an event to the log. Events recorded after the log is closed
+// are dropped.
+func (l *eventLog) record(eventType, message string, details map[string]string) error {
+	if l == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(&eventLogEntry{
+		Timestamp:     time.Now(),
+		TaskID:        l.taskID,
+		CorrelationID: l.correlationID,
+		Type:          eventType,
+		Message:       message,
+		Details:       details,
+	})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	_, err = l.file.Write(append(line, '\n'))
+
+	return err
+}
+
+func (l *eventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
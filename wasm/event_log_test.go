@@ -0,0 +1,119 @@
+package wasm
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readEventLog returns the entries of the task event log.
+func readEventLog(t *testing.T, path string) []eventLogEntry {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []eventLogEntry
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry eventLogEntry
+		if err = json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("unable to parse event log line %q: %v", line, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestEventLogRecordsTaskLifecycle(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	// The task is stopped once its module runs, after it started.
+	running := make(chan struct{}, 1)
+	runUntilStopped := func(instance *fakeInstance, args []interface{}) (interface{}, error) {
+		running <- struct{}{}
+
+		return blockUntilStopped()(instance, args)
+	}
+
+	for _, test := range []struct {
+		fn     fakeFunc
+		name   string
+		events []string
+		stop   bool
+	}{
+		{
+			name:   "finished",
+			fn:     returnValue(int32(0)),
+			events: []string{eventTypeStarted, eventTypeFinished},
+		},
+		{
+			name:   "interrupted",
+			fn:     runUntilStopped,
+			events: []string{eventTypeStarted, eventTypeInterrupted, eventTypeFinished},
+			stop:   true,
+		},
+	} {
+		useFakeInstances(t, func() *fakeInstance {
+			return newFakeInstance(1).withFunc("handle_buffer", test.fn)
+		})
+
+		cfg := newTestTask(t, test.name, `engine = "fake"
+eventLog {
+  enabled = true
+}`)
+		cfg.Env = map[string]string{"NOMAD_META_correlation_id": "pipeline-" + test.name}
+
+		if _, _, err := d.StartTask(cfg); err != nil {
+			t.Fatalf("unable to start task: %v", err)
+		}
+
+		if test.stop {
+			<-running
+
+			if err := d.StopTask(cfg.ID, 0, "SIGKILL"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		waitTask(t, d, cfg.ID)
+
+		entries := readEventLog(t, eventLogPath(cfg.TaskDir().LogDir, cfg.Name))
+
+		var events []string
+
+		for _, entry := range entries {
+			events = append(events, entry.Type)
+
+			if entry.TaskID != cfg.ID || entry.CorrelationID != "pipeline-"+test.name || entry.Timestamp.IsZero() {
+				t.Errorf("%s: expected event %s to identify the task, but got %+v", test.name, entry.Type, entry)
+			}
+		}
+
+		if !slices.Equal(events, test.events) {
+			t.Errorf("%s: expected events %v, but got %v", test.name, test.events, events)
+
+			continue
+		}
+
+		started, finished := entries[0], entries[len(entries)-1]
+
+		if started.Details["engine"] != "fake" || started.Details["module"] == "" {
+			t.Errorf("%s: expected the started event to name the engine and module, but got %+v", test.name, started)
+		}
+
+		if finished.Details["exit_code"] == "" || finished.Details["state"] == "" || finished.Details["duration"] == "" {
+			t.Errorf("%s: expected the finished event to describe the exit, but got %+v", test.name, finished)
+		}
+
+		if test.stop && finished.Message == "" {
+			t.Errorf("%s: expected the finished event to carry the interruption error", test.name)
+		}
+	}
+}
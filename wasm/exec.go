@@ -0,0 +1,307 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// execCmdMemdump dumps a range of the module memory: memdump <offset> <len>.
+const execCmdMemdump = "memdump"
+
+// exec runs an exec command: a driver debugging command or a call of an
+// exported function of the module, e.g. `myfunc 1 2 3`.
+func (d *WasmTaskDriverPlugin) exec(ctx context.Context, h *taskHandle, cmd []string) (*drivers.ExecTaskResult, error) {
+	if len(cmd) == 0 {
+		return nil, errors.New("command must be specified")
+	}
+
+	if cmd[0] == execCmdMemdump {
+		return d.execMemdump(ctx, h, cmd[1:])
+	}
+
+	return h.execCall(ctx, cmd[0], cmd[1:])
+}
+
+// execMemdump returns the hex encoded range of the running module memory, so
+// buffer issues can be diagnosed in place.
+func (d *WasmTaskDriverPlugin) execMemdump(ctx context.Context, h *taskHandle, args []string,
+) (*drivers.ExecTaskResult, error) {
+	if !d.config.Debug.Memdump {
+		return nil, errors.New("memdump is disabled, it must be enabled with the debug.memdump plugin option")
+	}
+
+	if len(args) != 2 {
+		return nil, fmt.Errorf("usage: %s <offset> <len>", execCmdMemdump)
+	}
+
+	offset, err := strconv.ParseInt(args[0], 0, 32)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("memdump offset must be a non-negative 32-bit integer, but specified %s", args[0])
+	}
+
+	length, err := strconv.ParseInt(args[1], 0, 32)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("memdump length must be a non-negative 32-bit integer, but specified %s", args[1])
+	}
+
+	if maxBytes := d.config.Debug.MaxMemdumpBytes; length > int64(maxBytes) {
+		return nil, fmt.Errorf("memdump length must be <= %d, but specified %d", maxBytes, length)
+	}
+
+	data, err := h.memdump(ctx, int32(offset), int32(length))
+	if err != nil {
+		return nil, err
+	}
+
+	return &drivers.ExecTaskResult{
+		Stdout:     []byte(hex.EncodeToString(data) + "\n"),
+		ExitResult: &drivers.ExitResult{},
+	}, nil
+}
+
+// memdumps serves memdump exec commands on the goroutine running the module.
+// Instances aren't safe for concurrent use, so the module memory is only read
+// while the module doesn't run: between execution phases and while it calls a
+// host function.
+type memdumps struct {
+	requests chan memdumpRequest
+}
+
+type memdumpRequest struct {
+	result chan<- memdumpResult
+	offset int32
+	length int32
+}
+
+type memdumpResult struct {
+	err  error
+	data []byte
+}
+
+func newMemdumps() *memdumps {
+	return &memdumps{requests: make(chan memdumpRequest)}
+}
+
+// wrapHostFuncs returns the host functions serving pending memdumps before
+// each call, so the memory of a module which runs for long can be dumped too.
+func (m *memdumps) wrapHostFuncs(hostFuncs []interfaces.HostFunc) []interfaces.HostFunc {
+	if m == nil {
+		return hostFuncs
+	}
+
+	wrapped := make([]interfaces.HostFunc, len(hostFuncs))
+
+	for i, hostFunc := range hostFuncs {
+		call := hostFunc.Call
+
+		wrapped[i] = hostFunc
+		wrapped[i].Call = func(memory []byte, args []interface{}) ([]interface{}, error) {
+			m.serve(func(offset, length int32) ([]byte, error) {
+				if int64(offset)+int64(length) > int64(len(memory)) {
+					return nil, fmt.Errorf("memory range [%d, %d) is out of bounds (memory size %d)",
+						offset, int64(offset)+int64(length), len(memory))
+				}
+
+				return memory[offset : offset+length], nil
+			})
+
+			return call(memory, args)
+		}
+	}
+
+	return wrapped
+}
+
+// serve answers the pending memdump requests with copies of the memory ranges
+// returned by read. It must be called by the goroutine running the module.
+func (m *memdumps) serve(read func(offset, length int32) ([]byte, error)) {
+	if m == nil {
+		return
+	}
+
+	for {
+		select {
+		case req := <-m.requests:
+			data, err := read(req.offset, req.length)
+			req.result <- memdumpResult{data: bytes.Clone(data), err: err}
+		default:
+			return
+		}
+	}
+}
+
+// dump requests the memory range and waits until the goroutine running the
+// module serves it, ctx is done or the task finishes.
+func (m *memdumps) dump(ctx context.Context, finished <-chan struct{}, offset, length int32) ([]byte, error) {
+	result := make(chan memdumpResult, 1)
+
+	select {
+	case m.requests <- memdumpRequest{result: result, offset: offset, length: length}:
+	case <-ctx.Done():
+		return nil, errors.New("module didn't return or call a host function before the exec command timed out")
+	case <-finished:
+		return nil, errors.New("task finished before its memory was dumped")
+	}
+
+	res := <-result
+
+	return res.data, res.err
+}
+
+// execCall calls the exported function with the integer arguments and returns
+// its WASI output and result. The task instance runs the main function and
+// can't be called concurrently, so the function is called in a new instance of
+// the module, which doesn't share the task instance state and gets scratch
+// copies of the task preopened directories. The call is interrupted once the
+// context is done.
+func (h *taskHandle) execCall(ctx context.Context, funcName string, args []string) (*drivers.ExecTaskResult, error) {
+	if !h.IsRunning() || h.newExecInstance == nil {
+		return nil, errors.New("functions can only be called while the task is running")
+	}
+
+	release, err := h.acquireExec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	stdout, err := os.CreateTemp("", "wasm-exec-stdout-*")
+	if err != nil {
+		return nil, err
+	}
+	defer removeTempFile(stdout)
+
+	stderr, err := os.CreateTemp("", "wasm-exec-stderr-*")
+	if err != nil {
+		return nil, err
+	}
+	defer removeTempFile(stderr)
+
+	instance, err := h.newExecInstance(stdout.Name(), stderr.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer instance.Cleanup()
+
+	params, err := instance.FuncParams(funcName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to call %s function: %w", funcName, err)
+	}
+
+	callArgs, err := parseCallArgs(funcName, params, args)
+	if err != nil {
+		return nil, err
+	}
+
+	stopInterrupt := context.AfterFunc(ctx, instance.Stop)
+	defer stopInterrupt()
+
+	h.logger.Debug("calling function for exec command", "function", funcName, "args", args)
+
+	result, callErr := callFunc(instance, funcName, callArgs...)
+
+	// WASI output is written unbuffered to the files, which are read through
+	// their own descriptors.
+	stdoutData, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	stderrData, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if callErr != nil {
+		return &drivers.ExecTaskResult{
+			Stdout:     stdoutData,
+			Stderr:     append(stderrData, fmt.Sprintf("failed to call %s: %v\n", funcName, callErr)...),
+			ExitResult: &drivers.ExitResult{ExitCode: 1},
+		}, nil
+	}
+
+	if result != nil {
+		stdoutData = append(stdoutData, fmt.Sprintf("%v\n", result)...)
+	}
+
+	return &drivers.ExecTaskResult{
+		Stdout:     stdoutData,
+		Stderr:     stderrData,
+		ExitResult: &drivers.ExitResult{},
+	}, nil
+}
+
+// acquireExec waits for the exec command calling a function which is running
+// to finish, unless the task rejects concurrent calls, and returns the
+// function releasing the call slot. Exec instances and their scratch copies of
+// the preopened directories consume memory and disk, so calls of the same task
+// don't pile up.
+func (h *taskHandle) acquireExec(ctx context.Context) (func(), error) {
+	release := func() { <-h.execSlot }
+
+	select {
+	case h.execSlot <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if h.execPolicy == concurrentExecReject {
+		return nil, errors.New("another exec command is calling a function of the task, concurrent calls are rejected")
+	}
+
+	select {
+	case h.execSlot <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, errors.New("exec command timed out waiting for the running exec command to finish")
+	}
+}
+
+// removeTempFile closes and removes the temporary file.
+func removeTempFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// parseCallArgs converts the exec command arguments to the parameter types of
+// the function.
+func parseCallArgs(funcName string, params []interfaces.ValueType, args []string) ([]interface{}, error) {
+	if len(args) != len(params) {
+		return nil, fmt.Errorf("%s function takes %d arguments, but %d specified", funcName, len(params), len(args))
+	}
+
+	result := make([]interface{}, len(args))
+
+	for i, arg := range args {
+		switch params[i] {
+		case interfaces.ValueTypeI32:
+			value, err := strconv.ParseInt(arg, 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("argument %d of %s function must be an i32, but specified %s", i+1, funcName, arg)
+			}
+
+			result[i] = int32(value)
+		case interfaces.ValueTypeI64:
+			value, err := strconv.ParseInt(arg, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("argument %d of %s function must be an i64, but specified %s", i+1, funcName, arg)
+			}
+
+			result[i] = value
+		default:
+			return nil, fmt.Errorf("parameter %d of %s function is a %s, only integer parameters are supported",
+				i+1, funcName, params[i])
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,237 @@
+package wasm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// memdumpTestConfig enables memdump exec commands.
+const memdumpTestConfig = `engines = [{ name = "fake" }]
+debug {
+  memdump = true
+}`
+
+func TestMemdump(t *testing.T) {
+	d := newTestDriver(t, memdumpTestConfig, nil)
+
+	started := make(chan struct{})
+
+	useFakeInstances(t, func() *fakeInstance {
+		// The module writes to its memory and keeps calling a host import until
+		// it is stopped, like a module serving requests.
+		instance := newFakeInstance(1).withFunc("handle_buffer", func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+			close(started)
+
+			for {
+				select {
+				case <-instance.stopCh:
+					return nil, errInterrupted
+				case <-time.After(time.Millisecond):
+				}
+
+				if _, err := instance.callHost("state_get", int32(0), int32(0), int32(0), int32(0)); err != nil {
+					return nil, err
+				}
+			}
+		})
+		copy(instance.memory[100:], "hello")
+
+		return instance
+	})
+
+	cfg := newTestTask(t, "memdump", `engine = "fake"
+hostImports {
+  enabled = true
+}`)
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	defer func() { _ = d.DestroyTask(cfg.ID, true) }()
+
+	// The memory is dumped while the main function runs.
+	<-started
+
+	result, err := d.ExecTask(cfg.ID, []string{"memdump", "100", "5"}, testTimeout)
+	if err != nil {
+		t.Fatalf("unable to dump memory: %v", err)
+	}
+
+	if got := string(result.Stdout); got != "68656c6c6f\n" {
+		t.Errorf("unexpected memdump %q", got)
+	}
+
+	if _, err = d.ExecTask(cfg.ID, []string{"memdump", "65530", "10"}, testTimeout); err == nil {
+		t.Error("expected memdump out of memory bounds to fail")
+	}
+}
+
+func TestMemdumpTimesOutWhileModuleRuns(t *testing.T) {
+	d := newTestDriver(t, memdumpTestConfig, nil)
+
+	started := make(chan struct{})
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", func(instance *fakeInstance, args []interface{}) (interface{}, error) {
+			close(started)
+
+			return blockUntilStopped()(instance, args)
+		})
+	})
+
+	cfg := newTestTask(t, "memdump", `engine = "fake"`)
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	defer func() { _ = d.DestroyTask(cfg.ID, true) }()
+
+	<-started
+
+	if _, err := d.ExecTask(cfg.ID, []string{"memdump", "0", "4"}, 100*time.Millisecond); err == nil {
+		t.Error("expected memdump of a module which doesn't return to time out")
+	}
+}
+
+func TestExecCallInterruptedByTimeout(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("handle_buffer", blockUntilStopped()).
+			withFunc("spin", blockUntilStopped())
+	})
+
+	cfg := newTestTask(t, "exec", `engine = "fake"`)
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	defer func() { _ = d.DestroyTask(cfg.ID, true) }()
+
+	done := make(chan struct{})
+
+	var (
+		result *drivers.ExecTaskResult
+		err    error
+	)
+
+	go func() {
+		defer close(done)
+
+		result, err = d.ExecTask(cfg.ID, []string{"spin"}, 100*time.Millisecond)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("exec call wasn't interrupted by its timeout")
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ExitResult.ExitCode != 1 || !strings.Contains(string(result.Stderr), "failed to call spin") {
+		t.Errorf("expected the interrupted call to fail, but got %+v", result)
+	}
+}
+
+func TestConcurrentExecCalls(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		name     string
+		policy   string
+		rejected bool
+	}{
+		{name: "serialized", policy: "serialize"},
+		{name: "rejected", policy: "reject", rejected: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var running, maxRunning atomic.Int32
+
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(1).
+					withFunc("handle_buffer", blockUntilStopped()).
+					withFunc("work", func(*fakeInstance, []interface{}) (interface{}, error) {
+						n := running.Add(1)
+						defer running.Add(-1)
+
+						for current := maxRunning.Load(); n > current; current = maxRunning.Load() {
+							if maxRunning.CompareAndSwap(current, n) {
+								break
+							}
+						}
+
+						time.Sleep(50 * time.Millisecond)
+
+						return int32(0), nil
+					})
+			})
+
+			cfg := newTestTask(t, test.name, `engine = "fake"
+concurrentExec = "`+test.policy+`"`)
+
+			if _, _, err := d.StartTask(cfg); err != nil {
+				t.Fatalf("unable to start task: %v", err)
+			}
+
+			defer func() { _ = d.DestroyTask(cfg.ID, true) }()
+
+			const calls = 3
+
+			var (
+				wg   sync.WaitGroup
+				errs = make(chan error, calls)
+			)
+
+			for i := 0; i < calls; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+
+					result, err := d.ExecTask(cfg.ID, []string{"work"}, testTimeout)
+					if err == nil && result.ExitResult.ExitCode != 0 {
+						err = fmt.Errorf("call failed: %s", result.Stderr)
+					}
+
+					errs <- err
+				}()
+			}
+
+			wg.Wait()
+			close(errs)
+
+			failed := 0
+
+			for err := range errs {
+				switch {
+				case err == nil:
+				case test.rejected && strings.Contains(err.Error(), "concurrent calls are rejected"):
+					failed++
+				default:
+					t.Errorf("unexpected exec error: %v", err)
+				}
+			}
+
+			if maxRunning.Load() != 1 {
+				t.Errorf("expected a single call to run at a time, but %d ran concurrently", maxRunning.Load())
+			}
+
+			if !test.rejected && failed != 0 || test.rejected && failed == 0 {
+				t.Errorf("expected concurrent calls to be %s, but %d of %d were rejected", test.name, failed, calls)
+			}
+		})
+	}
+}
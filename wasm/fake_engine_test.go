@@ -0,0 +1,385 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/hashicorp/go-hclog"
+	pkgerrors "github.com/pkg/errors"
+
+	"huawei.com/wasm-task-driver/wasm/engines"
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+const (
+	// fakeEngineName is the name tests configure to run tasks with the fake
+	// engine.
+	fakeEngineName = "fake"
+	// fakeFallbackEngineName is the name of the fake engine tests configure as
+	// fallback engine.
+	fakeFallbackEngineName = "fake-fallback"
+)
+
+// testFuelPerMs is the fuel calibration the fake engine reports.
+const testFuelPerMs = 250_000
+
+// errInterrupted is returned by fake functions interrupted by Stop.
+var errInterrupted = errors.New("interrupted")
+
+var (
+	// testEngine is the fake engine registered for tests. Tests set the
+	// instances it creates with useFakeInstances.
+	testEngine = &fakeEngine{name: fakeEngineName}
+	// testFallbackEngine is a second fake engine, so tasks can fall back from
+	// the fake engine.
+	testFallbackEngine = &fakeEngine{name: fakeFallbackEngineName}
+)
+
+func init() {
+	engines.Register(testEngine)
+	engines.Register(testFallbackEngine)
+}
+
+// fakeEngine creates fake instances, so the driver is tested without a WASM
+// runtime.
+type fakeEngine struct {
+	cache gcache.Cache
+	name  string
+	// newInstance creates the instance of every instantiated module.
+	newInstance func(conf interfaces.InstanceConfig) (*fakeInstance, error)
+	// preCacheErr fails pre-caching.
+	preCacheErr error
+	// preCacheGate blocks pre-caching until it is closed, if not nil.
+	preCacheGate chan struct{}
+	// features are the WASM proposal support Info reports.
+	features map[string]bool
+	// confs are the configurations of the instantiated modules.
+	confs []interfaces.InstanceConfig
+	// corrupted is the number of cached modules VerifyCache reports failing to
+	// load.
+	corrupted int
+	// inits counts the engine initializations.
+	inits int
+	lock  sync.Mutex
+}
+
+func (e *fakeEngine) Name() string {
+	return e.name
+}
+
+func (e *fakeEngine) Init(_ hclog.Logger, moduleCache gcache.Cache, _ interfaces.CacheOptions) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.cache = moduleCache
+	e.inits++
+}
+
+func (e *fakeEngine) InstantiateModule(_ string, conf interfaces.InstanceConfig) (interfaces.WasmInstance, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.confs = append(e.confs, conf)
+
+	if e.newInstance == nil {
+		return nil, errors.New("no fake instance configured")
+	}
+
+	instance, err := e.newInstance(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	instance.hostFuncs = conf.HostFuncs
+
+	return instance, nil
+}
+
+func (e *fakeEngine) PrePopulateCache(string, interfaces.PreCachePolicy) (int, error) {
+	e.lock.Lock()
+	gate, err := e.preCacheGate, e.preCacheErr
+	e.lock.Unlock()
+
+	if gate != nil {
+		<-gate
+	}
+
+	return 0, err
+}
+
+func (e *fakeEngine) VerifyCache() (int, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.corrupted, nil
+}
+
+// corruptCache makes VerifyCache report corrupted modules and pre-caching fail
+// with the error for the rest of the test.
+func (e *fakeEngine) corruptCache(t *testing.T, corrupted int, preCacheErr error) {
+	t.Helper()
+
+	e.lock.Lock()
+	e.corrupted, e.preCacheErr = corrupted, preCacheErr
+	e.lock.Unlock()
+
+	t.Cleanup(func() {
+		e.lock.Lock()
+		e.corrupted, e.preCacheErr = 0, nil
+		e.lock.Unlock()
+	})
+}
+
+func (e *fakeEngine) Info() interfaces.EngineInfo {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return interfaces.EngineInfo{Version: "1.0.0", Fuel: true, FuelPerMs: testFuelPerMs, Features: e.features}
+}
+
+// reportFeatures makes Info report the WASM proposal support for the rest of
+// the test.
+func (e *fakeEngine) reportFeatures(t *testing.T, features map[string]bool) {
+	t.Helper()
+
+	e.lock.Lock()
+	e.features = features
+	e.lock.Unlock()
+
+	t.Cleanup(func() {
+		e.lock.Lock()
+		e.features = nil
+		e.lock.Unlock()
+	})
+}
+
+// initCount returns the number of engine initializations so far.
+func (e *fakeEngine) initCount() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.inits
+}
+
+// instanceConfs returns the configurations of the modules instantiated so far.
+func (e *fakeEngine) instanceConfs() []interfaces.InstanceConfig {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return append([]interfaces.InstanceConfig(nil), e.confs...)
+}
+
+// fakeFunc implements an exported function of a fake instance.
+type fakeFunc func(instance *fakeInstance, args []interface{}) (interface{}, error)
+
+// fakeInstance runs exported functions implemented in Go. Like the stores of
+// real engines it isn't safe for concurrent use, except for Stop, so the race
+// detector catches the driver touching an instance while a function runs.
+type fakeInstance struct {
+	funcs   map[string]fakeFunc
+	params  map[string][]interfaces.ValueType
+	results map[string][]interfaces.ValueType
+	stats   *interfaces.ExecutionStatistics
+	stopCh  chan struct{}
+	// onCleanup is called when the instance is cleaned up, if not nil.
+	onCleanup func()
+	memory    []byte
+	calls     []string
+	// hostFuncs are the host functions the module was instantiated with.
+	hostFuncs []interfaces.HostFunc
+
+	stopOnce  sync.Once
+	cleanedUp atomic.Bool
+	cached    bool
+}
+
+func newFakeInstance(memoryPages int) *fakeInstance {
+	return &fakeInstance{
+		funcs:   make(map[string]fakeFunc),
+		params:  make(map[string][]interfaces.ValueType),
+		results: make(map[string][]interfaces.ValueType),
+		memory:  make([]byte, memoryPages*wasmPageSize),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// withFunc exports the function taking the given parameters.
+func (i *fakeInstance) withFunc(name string, fn fakeFunc, params ...interfaces.ValueType) *fakeInstance {
+	i.funcs[name] = fn
+	i.params[name] = params
+
+	return i
+}
+
+// withResults makes the exported function return the given types instead of a
+// single i32.
+func (i *fakeInstance) withResults(name string, results ...interfaces.ValueType) *fakeInstance {
+	i.results[name] = results
+
+	return i
+}
+
+func (i *fakeInstance) CallFunc(funcName string, args ...interface{}) (interface{}, error) {
+	i.calls = append(i.calls, funcName)
+
+	fn, ok := i.funcs[funcName]
+	if !ok {
+		return nil, pkgerrors.Wrapf(engines.ErrNotFound, "no %s func", funcName)
+	}
+
+	return fn(i, args)
+}
+
+func (i *fakeInstance) GetMemoryRange(start, size int32) ([]byte, error) {
+	if start < 0 || size < 0 || int(start)+int(size) > len(i.memory) {
+		return nil, fmt.Errorf("memory range [%d, %d) is out of bounds", start, int(start)+int(size))
+	}
+
+	return i.memory[start : start+size], nil
+}
+
+func (i *fakeInstance) MemoryPages() (uint64, error) {
+	return uint64(len(i.memory) / wasmPageSize), nil
+}
+
+func (i *fakeInstance) GrowMemory(deltaPages uint64) error {
+	i.memory = append(i.memory, make([]byte, int(deltaPages)*wasmPageSize)...)
+
+	return nil
+}
+
+func (i *fakeInstance) FuncParams(funcName string) ([]interfaces.ValueType, error) {
+	params, ok := i.params[funcName]
+	if !ok {
+		return nil, pkgerrors.Wrapf(engines.ErrNotFound, "no %s func", funcName)
+	}
+
+	return params, nil
+}
+
+func (i *fakeInstance) FuncResults(funcName string) ([]interfaces.ValueType, error) {
+	if _, ok := i.funcs[funcName]; !ok {
+		return nil, pkgerrors.Wrapf(engines.ErrNotFound, "no %s func", funcName)
+	}
+
+	if results, ok := i.results[funcName]; ok {
+		return results, nil
+	}
+
+	return []interfaces.ValueType{interfaces.ValueTypeI32}, nil
+}
+
+func (i *fakeInstance) Statistics() (interfaces.ExecutionStatistics, bool) {
+	if i.stats == nil {
+		return interfaces.ExecutionStatistics{}, false
+	}
+
+	return *i.stats, true
+}
+
+func (i *fakeInstance) ModuleCached() bool {
+	return i.cached
+}
+
+func (i *fakeInstance) Stop() {
+	i.stopOnce.Do(func() { close(i.stopCh) })
+}
+
+func (i *fakeInstance) Cleanup() {
+	i.cleanedUp.Store(true)
+
+	if i.onCleanup != nil {
+		i.onCleanup()
+	}
+}
+
+// callHost calls the host function the instance was instantiated with, like a
+// module calling its import.
+func (i *fakeInstance) callHost(name string, args ...interface{}) ([]interface{}, error) {
+	for _, hostFunc := range i.hostFuncs {
+		if hostFunc.Name == name {
+			return hostFunc.Call(i.memory, args)
+		}
+	}
+
+	return nil, fmt.Errorf("no %s host function", name)
+}
+
+// returnValue returns a function returning the value.
+func returnValue(value interface{}) fakeFunc {
+	return func(*fakeInstance, []interface{}) (interface{}, error) {
+		return value, nil
+	}
+}
+
+// blockUntilStopped returns a function which runs until the instance is
+// stopped, like a module stuck in a loop.
+func blockUntilStopped() fakeFunc {
+	return func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+		<-instance.stopCh
+
+		return nil, errInterrupted
+	}
+}
+
+// wasmModule returns a WASM module binary with an export section exporting
+// functions of the given names, enough for the driver to inspect its exports.
+func wasmModule(funcExports ...string) string {
+	var section []byte
+
+	section = binary.AppendUvarint(section, uint64(len(funcExports)))
+
+	for _, name := range funcExports {
+		section = binary.AppendUvarint(section, uint64(len(name)))
+		section = append(section, name...)
+		section = append(section, 0x00, 0x00)
+	}
+
+	module := []byte("\x00asm\x01\x00\x00\x00\x07")
+	module = binary.AppendUvarint(module, uint64(len(section)))
+
+	return string(append(module, section...))
+}
+
+// returnNothing returns a function which returns nothing, reported like
+// engines which can't return a nil result do.
+func returnNothing() fakeFunc {
+	return func(*fakeInstance, []interface{}) (interface{}, error) {
+		return nil, pkgerrors.Wrap(engines.ErrNoResult, "func returns nothing")
+	}
+}
+
+// allocAt returns an IO buffer alloc function placing the buffer at the
+// offset. The requested buffer sizes are appended to sizes if not nil.
+func allocAt(offset int32, sizes *[]int32) fakeFunc {
+	return func(_ *fakeInstance, args []interface{}) (interface{}, error) {
+		if sizes != nil {
+			*sizes = append(*sizes, args[0].(int32))
+		}
+
+		return offset, nil
+	}
+}
+
+// upperCase returns a buffer processing function replacing the input in the IO
+// buffer with its upper case and returning the output size.
+func upperCase() fakeFunc {
+	return func(instance *fakeInstance, args []interface{}) (interface{}, error) {
+		offset, size := args[0].(int32), args[1].(int32)
+
+		buffer, err := instance.GetMemoryRange(offset, size)
+		if err != nil {
+			return nil, err
+		}
+
+		copy(buffer, bytes.ToUpper(buffer))
+
+		return size, nil
+	}
+}
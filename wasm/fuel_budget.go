@@ -0,0 +1,83 @@
+package wasm
+
+import (
+	"context"
+	"sync"
+)
+
+// fuelBudget bounds the total fuel limit of the tasks running on the node, so
+// WASM workloads can't monopolize it. Tasks reserve their fuel limit before
+// their module runs and release it once they finish, tasks whose fuel limit
+// exceeds the budget left wait for it. A nil budget is unlimited.
+type fuelBudget struct {
+	// released is closed and replaced whenever fuel is released, waking up the
+	// tasks waiting for it.
+	released chan struct{}
+	total    uint64
+	reserved uint64
+	lock     sync.Mutex
+}
+
+// newFuelBudget returns a budget of the total fuel, or nil if total is zero.
+func newFuelBudget(total uint64) *fuelBudget {
+	if total == 0 {
+		return nil
+	}
+
+	return &fuelBudget{total: total, released: make(chan struct{})}
+}
+
+// reserve reserves the fuel, waiting until the budget left covers it or the
+// context is done. waiting is called with the budget left before waiting, once.
+func (b *fuelBudget) reserve(ctx context.Context, fuel uint64, waiting func(remaining uint64)) error {
+	if b == nil || fuel == 0 {
+		return nil
+	}
+
+	for notified := false; ; notified = true {
+		b.lock.Lock()
+
+		remaining, released := b.total-b.reserved, b.released
+		if remaining >= fuel {
+			b.reserved += fuel
+			b.lock.Unlock()
+
+			return nil
+		}
+
+		b.lock.Unlock()
+
+		if !notified {
+			waiting(remaining)
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release returns the reserved fuel to the budget.
+func (b *fuelBudget) release(fuel uint64) {
+	if b == nil || fuel == 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.reserved -= fuel
+
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// remaining returns the fuel not reserved by running tasks.
+func (b *fuelBudget) remaining() uint64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.total - b.reserved
+}
@@ -0,0 +1,85 @@
+package wasm
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTasksThrottledByNodeFuelBudget(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig+`
+fuelBudget {
+  total = 100
+}`, nil)
+
+	started := make(chan struct{}, 2)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+			started <- struct{}{}
+
+			<-instance.stopCh
+
+			return nil, errInterrupted
+		})
+	})
+
+	tooLarge := newTestTask(t, "too-large", `engine = "fake"
+fuelLimit = 200`)
+
+	if _, _, err := d.StartTask(tooLarge); err == nil || !strings.Contains(err.Error(), "exceeds the node fuel budget") {
+		t.Fatalf("expected a fuel limit above the budget to be rejected, but got %v", err)
+	}
+
+	first := newTestTask(t, "first", `engine = "fake"
+fuelLimit = 60`)
+	second := newTestTask(t, "second", `engine = "fake"
+fuelLimit = 60`)
+
+	if _, _, err := d.StartTask(first); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	<-started
+
+	if _, _, err := d.StartTask(second); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	// The budget left doesn't cover the second task, so it waits for the first
+	// one to finish.
+	select {
+	case <-started:
+		t.Fatal("expected the second task to wait for the node fuel budget")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	attribute := d.buildFingerprint().Attributes["wasm.fuel_budget.remaining"]
+	if attribute == nil {
+		t.Fatal("expected the fuel budget left to be fingerprinted")
+	}
+
+	if remaining, ok := attribute.GetInt(); !ok || remaining != 40 {
+		t.Errorf("expected 40 fuel left in the budget, but got %v", attribute)
+	}
+
+	if err := d.StopTask(first.ID, time.Second, "SIGINT"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(testTimeout):
+		t.Fatal("expected the second task to run once the first one released its fuel")
+	}
+
+	if err := d.StopTask(second.ID, time.Second, "SIGINT"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitTask(t, d, second.ID)
+
+	if remaining := d.fuelBudget.remaining(); remaining != 100 {
+		t.Errorf("expected the whole budget to be released, but %d fuel is left", remaining)
+	}
+}
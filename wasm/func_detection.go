@@ -0,0 +1,75 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// autoFuncName makes the driver detect a function name by convention.
+const autoFuncName = "auto"
+
+var (
+	// conventionalIOBufFuncNames are the buffer allocation functions probed in
+	// order when the IO buffer function name is detected.
+	conventionalIOBufFuncNames = []string{"alloc", "malloc"}
+	// conventionalMainFuncNames are the main functions probed in order when the
+	// main function name is detected.
+	conventionalMainFuncNames = []string{"handle_buffer", "main", "_start"}
+)
+
+// detectFuncNames replaces the function names set to auto with the first
+// conventional name the module exports. Names set explicitly are kept.
+func detectFuncNames(logger hclog.Logger, driverConfig *TaskConfig, info moduleInfo) error {
+	detectIOBuf := driverConfig.IOBuffer.Enabled && driverConfig.IOBuffer.IOBufFuncName == autoFuncName
+	detectMain := driverConfig.Main.MainFuncName == autoFuncName
+
+	if !detectIOBuf && !detectMain {
+		return nil
+	}
+
+	// Exports are unknown if the module couldn't be inspected.
+	if info.funcExports == nil {
+		return errors.New("unable to detect function names by convention: module exports are unknown")
+	}
+
+	if detectIOBuf {
+		name, err := detectFuncName(info.funcExports, conventionalIOBufFuncNames, "ioBuffer.IOBufFuncName")
+		if err != nil {
+			return err
+		}
+
+		logger.Info("detected IO buffer function by convention", "function", name)
+
+		driverConfig.IOBuffer.IOBufFuncName = name
+	}
+
+	if detectMain {
+		name, err := detectFuncName(info.funcExports, conventionalMainFuncNames, "main.mainFuncName")
+		if err != nil {
+			return err
+		}
+
+		logger.Info("detected main function by convention", "function", name)
+
+		driverConfig.Main.MainFuncName = name
+	}
+
+	return nil
+}
+
+// detectFuncName returns the first candidate the module exports.
+func detectFuncName(exports, candidates []string, param string) (string, error) {
+	for _, candidate := range candidates {
+		for _, export := range exports {
+			if export == candidate {
+				return candidate, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("module doesn't export any of the conventional functions [%s], set %s to one of the exported functions: [%s]",
+		strings.Join(candidates, ", "), param, strings.Join(exports, ", "))
+}
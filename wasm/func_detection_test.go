@@ -0,0 +1,67 @@
+package wasm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestFuncNamesDetectedByConvention(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		ioBufFunc string
+		mainFunc  string
+		ioBuf     string
+		main      string
+		err       string
+		exports   []string
+	}{
+		{
+			name: "first conventional names", ioBufFunc: autoFuncName, mainFunc: autoFuncName,
+			exports: []string{"_start", "malloc", "main", "alloc"}, ioBuf: "alloc", main: "main",
+		},
+		{
+			name: "later conventional names", ioBufFunc: autoFuncName, mainFunc: autoFuncName,
+			exports: []string{"malloc", "_start"}, ioBuf: "malloc", main: "_start",
+		},
+		{
+			name: "explicit names kept", ioBufFunc: "my_alloc", mainFunc: "run",
+			exports: []string{"alloc", "handle_buffer"}, ioBuf: "my_alloc", main: "run",
+		},
+		{
+			name: "no conventional main", ioBufFunc: "alloc", mainFunc: autoFuncName,
+			exports: []string{"alloc", "run"},
+			err: "module doesn't export any of the conventional functions [handle_buffer, main, _start], " +
+				"set main.mainFuncName to one of the exported functions: [alloc, run]",
+		},
+		{
+			name: "no conventional IO buffer", ioBufFunc: autoFuncName, mainFunc: "run",
+			exports: []string{"run"},
+			err:     "conventional functions [alloc, malloc], set ioBuffer.IOBufFuncName",
+		},
+		{
+			name: "unknown exports", ioBufFunc: "alloc", mainFunc: autoFuncName,
+			err: "module exports are unknown",
+		},
+	} {
+		driverConfig := TaskConfig{
+			IOBuffer: IOBufferConfig{Enabled: true, IOBufFuncName: test.ioBufFunc},
+			Main:     Main{MainFuncName: test.mainFunc},
+		}
+
+		err := detectFuncNames(hclog.NewNullLogger(), &driverConfig, moduleInfo{funcExports: test.exports})
+
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q, but got %v", test.name, test.err, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case driverConfig.IOBuffer.IOBufFuncName != test.ioBuf || driverConfig.Main.MainFuncName != test.main:
+			t.Errorf("%s: expected functions %s and %s, but got %s and %s", test.name, test.ioBuf, test.main,
+				driverConfig.IOBuffer.IOBufFuncName, driverConfig.Main.MainFuncName)
+		}
+	}
+}
@@ -1,33 +1,133 @@
 package wasm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/client/lib/fifo"
+	"github.com/hashicorp/nomad/drivers/shared/eventer"
 	"github.com/hashicorp/nomad/plugins/drivers"
 
+	"huawei.com/wasm-task-driver/wasm/engines"
+	"huawei.com/wasm-task-driver/wasm/hostimports"
 	"huawei.com/wasm-task-driver/wasm/interfaces"
 )
 
+// errNoOutput is reported when the module returns without writing a result to
+// the IO buffer, as opposed to a trap or a failed call.
+var errNoOutput = errors.New("no output produced")
+
 // taskHandle should store all relevant runtime information
 // such as process ID if this is a local task or other meta
 // data if this driver deals with external APIs.
 type taskHandle struct {
 	logger hclog.Logger
 
+	// ctx is canceled to interrupt the task. It is canceled when the task is
+	// stopped, destroyed or the driver shuts down, and once the task finishes.
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	startedAt   time.Time
 	completedAt time.Time
 	taskConfig  *drivers.TaskConfig
 	exitResult  *drivers.ExitResult
 	procState   drivers.TaskState
 
-	instance     interfaces.WasmInstance
-	completionCh chan struct{}
-	mainFunc     Main
-	ioBufferConf IOBufferConfig
+	// execStartedAt is set while the main function runs.
+	execStartedAt time.Time
+
+	// execStats are the engine execution statistics of the task run, recorded
+	// by the goroutine running the module after each execution phase.
+	execStats *interfaces.ExecutionStatistics
+
+	// fuelBudget is the node fuel budget the fuel limit is reserved from
+	// while the module runs, nil if the node has none.
+	fuelBudget *fuelBudget
+
+	// exitMessages maps the main function exit codes to the messages the
+	// exit result is reported with.
+	exitMessages map[int]string
+
+	// verifyInstance runs the module again to verify its determinism, if
+	// enabled.
+	verifyInstance interfaces.WasmInstance
+
+	// destroyedCh is closed once the task is destroyed, stopping the goroutines
+	// serving its stats and wait channels.
+	destroyedCh chan struct{}
+
+	// newExecInstance instantiates the module for exec commands calling its
+	// functions, with WASI output written to the given paths.
+	newExecInstance func(stdoutPath, stderrPath string) (interfaces.WasmInstance, error)
+	// execSlot is taken by the exec command calling a function, so the
+	// commands don't run concurrently.
+	execSlot chan struct{}
+	// execPolicy defines how an exec command calling a function while another
+	// one runs is handled.
+	execPolicy string
+	// validate runs the module validation suite before the task runs, until
+	// the context is canceled. Nil if the task has no validation cases.
+	validate func(ctx context.Context) error
+
+	instance      interfaces.WasmInstance
+	eventLog      *eventLog
+	metrics       *statsdSink
+	eventer       *eventer.Eventer
+	hostCalls     *hostimports.CallCounter
+	stopSignal    *hostimports.StopSignal
+	memdumps      *memdumps
+	overcommit    *memoryOvercommit
+	stdio         *taskStdio
+	preopenQuotas *preopenQuotas
+	fileModes     *preopenFileModes
+	completionCh  chan struct{}
+	resultSink    *resultSink
+	resultDB      *resultDatabase
+	output        []byte
+	engineName    string
+	modulePath    string
+	correlationID string
+	logWriteError string
+	moduleInfo    moduleInfo
+	mainFunc      Main
+	hooks         HooksConfig
+	ioBufferConf  IOBufferConfig
+	memoryConf    MemoryConfig
+	timeouts      TimeoutsConfig
+	alerts        AlertsConfig
+
+	// execTime accumulates the wall-clock time the main function ran for.
+	execTime time.Duration
+
+	// fuelLimit is the fuel the module can consume, zero if unlimited.
+	fuelLimit uint64
+
+	// mainExitCode is the task exit code taken from the main function result,
+	// if enabled.
+	mainExitCode int
+
+	// moduleCached reports whether the module was served from the modules
+	// cache rather than compiled for the task.
+	moduleCached bool
+
+	// memoryBytes is the module memory size recorded after the last execution
+	// phase.
+	memoryBytes atomic.Uint64
+
+	// watchers tracks the goroutines serving the task stats and wait
+	// channels, watchersLock syncs adding to them with destroying the task.
+	watchers     sync.WaitGroup
+	watchersLock sync.Mutex
 
 	// stateLock syncs access to all fields below
 	stateLock sync.RWMutex
@@ -37,13 +137,115 @@ func (h *taskHandle) TaskStatus() *drivers.TaskStatus {
 	h.stateLock.RLock()
 	defer h.stateLock.RUnlock()
 
-	return &drivers.TaskStatus{
+	status := &drivers.TaskStatus{
 		ID:          h.taskConfig.ID,
 		Name:        h.taskConfig.Name,
 		State:       h.procState,
 		StartedAt:   h.startedAt,
 		CompletedAt: h.completedAt,
 		ExitResult:  h.exitResult,
+		DriverAttributes: map[string]string{
+			"engine":          h.engineName,
+			"module_features": strings.Join(h.moduleInfo.features, ","),
+			"module_sha256":   h.moduleInfo.sha256,
+			"module_mtime":    h.moduleInfo.modTime.UTC().Format(time.RFC3339),
+			"module_source":   h.moduleInfo.source,
+			"correlation_id":  h.correlationID,
+		},
+	}
+
+	if h.hostCalls != nil {
+		status.DriverAttributes["host_import_calls"] = h.hostCalls.String()
+	}
+
+	for name, value := range h.limitAttributes() {
+		status.DriverAttributes[name] = value
+	}
+
+	if stats, ok := h.statistics(); ok {
+		for name, value := range statisticsDetails(stats) {
+			status.DriverAttributes[name] = value
+		}
+	}
+
+	return status
+}
+
+// watch runs fn in a goroutine serving the task, e.g. its stats or wait
+// channel. fn must return once destroyedCh is closed, destroying the task waits
+// for it.
+func (h *taskHandle) watch(fn func()) {
+	h.watchersLock.Lock()
+	defer h.watchersLock.Unlock()
+
+	select {
+	case <-h.destroyedCh:
+		// The task is already destroyed, fn returns right away and nobody waits
+		// for it.
+		go fn()
+
+		return
+	default:
+	}
+
+	h.watchers.Add(1)
+
+	go func() {
+		defer h.watchers.Done()
+
+		fn()
+	}()
+}
+
+// isDestroyed reports whether the task was destroyed.
+func (h *taskHandle) isDestroyed() bool {
+	select {
+	case <-h.destroyedCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// destroy stops the goroutines serving the task and waits for them to close
+// their channels. It returns false if they didn't stop within the timeout.
+func (h *taskHandle) destroy(timeout time.Duration) bool {
+	h.watchersLock.Lock()
+	select {
+	case <-h.destroyedCh:
+	default:
+		close(h.destroyedCh)
+	}
+	h.watchersLock.Unlock()
+
+	stopped := make(chan struct{})
+
+	go func() {
+		h.watchers.Wait()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// limitAttributes reports the limits the task runs under, after defaults and
+// the task memory resource are applied. Zero means no limit.
+func (h *taskHandle) limitAttributes() map[string]string {
+	return map[string]string{
+		"memory_limit_mb":    strconv.FormatInt(h.memoryConf.LimitMB, 10),
+		"memory_limit_pages": strconv.FormatUint(h.memoryConf.limitPages(), 10),
+		"fuel_limit":         strconv.FormatUint(h.fuelLimit, 10),
+		"init_timeout":       h.timeouts.InitTimeout.String(),
+		"run_timeout":        h.timeouts.RunTimeout.String(),
+		"post_timeout":       h.timeouts.PostTimeout.String(),
 	}
 }
 
@@ -54,9 +256,94 @@ func (h *taskHandle) IsRunning() bool {
 	return h.procState == drivers.TaskStateRunning
 }
 
+// memoryUsage returns the size of the module memory in bytes, as recorded
+// after the last execution phase. The size is only reported while the task is
+// running.
+func (h *taskHandle) memoryUsage() (uint64, bool) {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+
+	if h.procState != drivers.TaskStateRunning {
+		return 0, false
+	}
+
+	return h.memoryBytes.Load(), true
+}
+
+// recordMemoryUsage records the size of the module memory. Instances aren't
+// safe for concurrent use, so it must be called by the goroutine running the
+// module, between function calls.
+func (h *taskHandle) recordMemoryUsage() {
+	pages, err := h.instance.MemoryPages()
+	if err != nil {
+		return
+	}
+
+	h.memoryBytes.Store(pages * wasmPageSize)
+}
+
+// memdump returns a copy of the module memory range. The instance is released
+// once the task finishes, so memory can only be dumped while the task is
+// running. The range is read by the goroutine running the module once the
+// module returns or calls a host function.
+func (h *taskHandle) memdump(ctx context.Context, offset, length int32) ([]byte, error) {
+	if h.memdumps == nil || !h.IsRunning() {
+		return nil, errors.New("memory can only be dumped while the task is running")
+	}
+
+	return h.memdumps.dump(ctx, h.completionCh, offset, length)
+}
+
+// checkpoint records the module memory size and execution statistics, checks
+// the memory overcommit and serves pending memdumps. It is called by the goroutine running the module
+// between execution phases.
+func (h *taskHandle) checkpoint() {
+	h.recordMemoryUsage()
+	h.overcommit.observe(h.memoryBytes.Load())
+	h.recordStatistics()
+	h.memdumps.serve(h.instance.GetMemoryRange)
+}
+
+// mainExecTime returns the wall-clock time the main function has run for,
+// which approximates the task CPU time.
+func (h *taskHandle) mainExecTime() time.Duration {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+
+	if h.execStartedAt.IsZero() {
+		return h.execTime
+	}
+
+	return h.execTime + time.Since(h.execStartedAt)
+}
+
+func (h *taskHandle) setExecuting(executing bool) {
+	h.stateLock.Lock()
+	defer h.stateLock.Unlock()
+
+	if executing {
+		h.execStartedAt = time.Now()
+
+		return
+	}
+
+	h.execTime += time.Since(h.execStartedAt)
+	h.execStartedAt = time.Time{}
+}
+
 func (h *taskHandle) run() {
 	defer close(h.completionCh)
-	defer h.instance.Cleanup()
+	defer h.cancel()
+	defer h.cleanupInstances()
+	defer h.closeEventLog()
+	defer h.logSummary()
+	defer h.sendMetrics()
+	defer h.reportResult()
+
+	// The module is interrupted wherever it runs once the task context is
+	// canceled, and is no longer interrupted after it finishes.
+	stopInterrupt := context.AfterFunc(h.ctx, h.stop)
+	defer stopInterrupt()
 
 	h.stateLock.Lock()
 	if h.exitResult == nil {
@@ -64,83 +351,698 @@ func (h *taskHandle) run() {
 	}
 	h.stateLock.Unlock()
 
-	var ioBuffer []byte
+	h.recordEvent(eventTypeStarted, "", map[string]string{
+		"engine": h.engineName,
+		"module": h.modulePath,
+	})
 
-	if h.ioBufferConf.Enabled {
-		inputByte := []byte(h.ioBufferConf.InputValue)
-		if len(inputByte) > int(h.ioBufferConf.Size) {
-			h.reportError(fmt.Errorf("input must be less than %d bytes to fit IO buffer", h.ioBufferConf.Size))
+	if err := h.reserveFuel(); err != nil {
+		h.reportError(err)
+
+		return
+	}
+	defer h.fuelBudget.release(h.fuelLimit)
+
+	// The validation suite runs in the task goroutine, so stopping the task
+	// interrupts it like the task run.
+	if h.validate != nil {
+		if err := h.validate(h.ctx); err != nil {
+			h.reportError(err)
 
 			return
 		}
+	}
 
-		h.ioBufferConf.Args = append([]int32{h.ioBufferConf.Size}, h.ioBufferConf.Args...)
+	// Execution changes the IO buffer and main configuration, so the original
+	// ones are kept for the determinism verification run.
+	ioBufferConf, mainFunc := h.ioBufferConf, h.mainFunc
 
-		ptr, err := h.instance.CallFunc(h.ioBufferConf.IOBufFuncName, intListToIfaceList(h.ioBufferConf.Args)...)
+	h.checkpoint()
+
+	h.preopenQuotas.start()
+	h.fileModes.start()
+
+	out, err := h.execute()
+
+	h.fileModes.apply()
+
+	h.recordStatistics()
+
+	// Like a shell pipeline, the module output piped to another task is
+	// delivered before the task finishes, even if the module failed.
+	h.stdio.finish()
+
+	// A sustained memory overuse or an exceeded quota fails the task even if
+	// the module returned right as it was interrupted.
+	if limitErr := h.finishLimits(); limitErr != nil {
 		if err != nil {
-			h.reportError(fmt.Errorf("unable to call %s function: %w", h.ioBufferConf.IOBufFuncName, err))
+			limitErr = fmt.Errorf("%w: %v", limitErr, err)
+		}
+
+		h.reportError(limitErr)
+
+		return
+	}
+
+	if err != nil {
+		h.reportError(h.stderrTailError(h.memoryLimitError(err)))
+
+		return
+	}
+
+	if h.verifyInstance != nil {
+		if err = h.verifyDeterminism(out, ioBufferConf, mainFunc); err != nil {
+			h.reportError(err)
 
 			return
 		}
+	}
 
-		offset := ptr.(int32)
+	h.output = out
 
-		ioBuffer, err = h.instance.GetMemoryRange(offset, h.ioBufferConf.Size)
-		if err != nil {
-			h.reportError(fmt.Errorf("unable to get memory: %w", err))
+	stdio, err := h.openStdout()
+	if err != nil {
+		h.reportError(err)
 
+		return
+	}
+	defer stdio.Close()
+
+	n, err := stdio.Write(out)
+	if err != nil {
+		h.reportError(err)
+
+		return
+	}
+
+	h.logger.Debug("wrote data to stdout", "bytes", n)
+
+	if h.ioBufferConf.Enabled && h.ioBufferConf.OutputEvent {
+		h.emitOutputEvent(out)
+	}
+
+	h.reportCompletion()
+}
+
+// finishLimits stops enforcing the limits checked while the module runs and
+// returns the error of the first limit the module exceeded.
+func (h *taskHandle) finishLimits() error {
+	overuseErr := h.overcommit.finish()
+	quotaErr := h.preopenQuotas.finish()
+
+	if overuseErr != nil {
+		return overuseErr
+	}
+
+	return quotaErr
+}
+
+// reserveFuel reserves the task fuel limit from the node fuel budget, waiting
+// while the budget left doesn't cover it. Waiting tasks are reported with a
+// task event, and stopping the task stops waiting.
+func (h *taskHandle) reserveFuel() error {
+	err := h.fuelBudget.reserve(h.ctx, h.fuelLimit, func(remaining uint64) {
+		h.logger.Info("waiting for node fuel budget", "task_id", h.taskConfig.ID, "fuel_limit", h.fuelLimit,
+			"fuel_budget_remaining", remaining)
+
+		if h.eventer == nil {
 			return
 		}
 
-		n := copy(ioBuffer, inputByte)
+		err := h.eventer.EmitEvent(&drivers.TaskEvent{
+			TaskID:    h.taskConfig.ID,
+			AllocID:   h.taskConfig.AllocID,
+			TaskName:  h.taskConfig.Name,
+			Timestamp: time.Now(),
+			Message:   "Waiting for node fuel budget",
+			Annotations: map[string]string{
+				"fuel_limit":            strconv.FormatUint(h.fuelLimit, 10),
+				"fuel_budget_remaining": strconv.FormatUint(remaining, 10),
+			},
+		})
+		if err != nil {
+			h.logger.Warn("unable to emit fuel budget event", "task_id", h.taskConfig.ID, "error", hclog.Fmt("%+v", err))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("stopped while waiting for node fuel budget: %w", err)
+	}
 
-		h.logger.Debug("copied data from task config to IO buffer", "bytes", n)
+	return nil
+}
 
-		//nolint:gosec
-		h.mainFunc.Args = append([]int32{offset, int32(n)}, h.mainFunc.Args...)
+// execute runs the module: initializes it, calls the main function and
+// returns the output.
+func (h *taskHandle) execute() ([]byte, error) {
+	var (
+		ioBufferOffset int32
+		result         interface{}
+	)
+
+	err := h.withTimeout("init", h.timeouts.InitTimeout, func() (initErr error) {
+		ioBufferOffset, initErr = h.initialize()
+
+		return initErr
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	result, err := h.instance.CallFunc(h.mainFunc.MainFuncName, intListToIfaceList(h.mainFunc.Args)...)
+	h.checkpoint()
+
+	mainFuncName := h.mainFunc.MainFuncName
+	if h.ioBufferConf.Enabled && h.ioBufferConf.ProcessFuncName != "" {
+		mainFuncName = h.ioBufferConf.ProcessFuncName
+	}
+
+	err = h.withTimeout("run", h.timeouts.RunTimeout, func() (runErr error) {
+		h.setExecuting(true)
+		result, runErr = callFunc(h.instance, mainFuncName, intListToIfaceList(h.mainFunc.Args)...)
+		h.setExecuting(false)
+
+		if runErr != nil {
+			return fmt.Errorf("failed to call %s: %w", mainFuncName, runErr)
+		}
+
+		return nil
+	})
 	if err != nil {
-		h.reportError(fmt.Errorf("failed to call %s: %w", h.mainFunc.MainFuncName, err))
+		return nil, err
+	}
 
-		return
+	// The post main hook has its own budget, so a main function finishing
+	// close to the run timeout still gets its hook called.
+	err = h.withTimeout("post", h.timeouts.PostTimeout, func() error {
+		return h.callHook(h.hooks.PostMainFuncName)
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	h.checkpoint()
+
 	var out []byte
 
 	if h.ioBufferConf.Enabled {
-		resultSize := result.(int32)
-		if resultSize == 0 {
-			h.reportError(fmt.Errorf("unsuccessful WASM call"))
-
-			return
+		out, err = h.readOutput(ioBufferOffset, result)
+		if err != nil {
+			return nil, err
 		}
 
-		out = make([]byte, resultSize)
-		_ = copy(out, ioBuffer[:resultSize])
+		if len(out) == 0 && h.ioBufferConf.NoOutput != ioBufferNoOutputEmpty {
+			return nil, fmt.Errorf("%w: %s returned without writing a result to the IO buffer", errNoOutput, mainFuncName)
+		}
 	} else {
 		out = []byte(fmt.Sprintf("%v", result))
+
+		if h.mainFunc.ResultAsExitCode {
+			h.stateLock.Lock()
+			h.mainExitCode = resultExitCode(result)
+			h.stateLock.Unlock()
+		}
 	}
 
-	stdio, err := fifo.OpenWriter(h.taskConfig.StdoutPath)
+	return out, nil
+}
+
+// readOutput reads the output the main function wrote to the IO buffer at the
+// offset. The buffer is read again after the call, as the module may have grown
+// its memory, which may move the memory on the host side.
+func (h *taskHandle) readOutput(offset int32, result interface{}) ([]byte, error) {
+	ioBuffer, err := h.instance.GetMemoryRange(offset, h.ioBufferConf.Size)
 	if err != nil {
-		h.reportError(err)
+		return nil, fmt.Errorf("unable to get memory: %w", err)
+	}
 
+	if h.ioBufferConf.OutputSize == ioBufferOutputSizeBuffer {
+		return bytes.Clone(bytes.TrimRight(ioBuffer, "\x00")), nil
+	}
+
+	resultSize, ok := result.(int32)
+	if !ok {
+		return nil, fmt.Errorf("main function must return the i32 output size, but returned %v", result)
+	}
+
+	if resultSize < 0 || int(resultSize) > len(ioBuffer) {
+		return nil, fmt.Errorf("result size %d exceeds IO buffer size %d", resultSize, len(ioBuffer))
+	}
+
+	return bytes.Clone(ioBuffer[:resultSize]), nil
+}
+
+// emitOutputEvent emits a task event annotated with the output, truncated to
+// outputEventMaxBytes. The output is assumed to be UTF-8 text.
+func (h *taskHandle) emitOutputEvent(out []byte) {
+	if h.eventer == nil {
 		return
 	}
-	defer stdio.Close()
 
-	n, err := stdio.Write(out)
+	output := out[:min(len(out), outputEventMaxBytes)]
+
+	err := h.eventer.EmitEvent(&drivers.TaskEvent{
+		TaskID:    h.taskConfig.ID,
+		AllocID:   h.taskConfig.AllocID,
+		TaskName:  h.taskConfig.Name,
+		Timestamp: time.Now(),
+		Message:   "Module output",
+		Annotations: map[string]string{
+			"output":       strings.ToValidUTF8(string(output), "\uFFFD"),
+			"output_bytes": strconv.Itoa(len(out)),
+		},
+	})
 	if err != nil {
-		h.reportError(err)
+		h.logger.Warn("unable to emit output event", "task_id", h.taskConfig.ID, "error", hclog.Fmt("%+v", err))
+	}
+}
+
+// verifyDeterminism runs the module again in the verification instance with
+// the original inputs and fails if the output differs from the first run.
+func (h *taskHandle) verifyDeterminism(out []byte, ioBufferConf IOBufferConfig, mainFunc Main) error {
+	h.stateLock.Lock()
+	h.instance, h.verifyInstance = h.verifyInstance, h.instance
+	h.stateLock.Unlock()
+
+	h.ioBufferConf, h.mainFunc = ioBufferConf, mainFunc
+
+	verifyOut, err := h.execute()
+	if err != nil {
+		return fmt.Errorf("determinism verification run failed: %w", err)
+	}
+
+	if bytes.Equal(out, verifyOut) {
+		h.logger.Debug("module output verified to be deterministic", "task_id", h.taskConfig.ID)
+
+		return nil
+	}
+
+	return fmt.Errorf("module is not deterministic: outputs of %d and %d bytes diverge at byte %d",
+		len(out), len(verifyOut), divergenceOffset(out, verifyOut))
+}
+
+// resultExitCode maps the main function result to the task exit code: the
+// integer result, or 0 if the function returns nothing or a float.
+func resultExitCode(result interface{}) int {
+	switch value := result.(type) {
+	case int32:
+		return int(value)
+	case int64:
+		//nolint:gosec
+		return int(value)
+	default:
+		return 0
+	}
+}
+
+// parseExitCodeMessages returns the messages of the main function exit codes
+// the task is configured with.
+func parseExitCodeMessages(conf Main) (map[int]string, error) {
+	if len(conf.ExitCodeMessages) == 0 {
+		return nil, nil
+	}
+
+	if !conf.ResultAsExitCode {
+		return nil, errors.New("main exitCodeMessages requires resultAsExitCode to be enabled")
+	}
+
+	messages := make(map[int]string, len(conf.ExitCodeMessages))
+
+	for key, message := range conf.ExitCodeMessages {
+		code, err := strconv.Atoi(key)
+		if err != nil || code == 0 {
+			return nil, fmt.Errorf("main exitCodeMessages keys must be non-zero integer exit codes, but specified %q", key)
+		}
+
+		messages[code] = message
+	}
+
+	return messages, nil
+}
+
+// divergenceOffset returns the offset of the first byte which differs between
+// the outputs.
+func divergenceOffset(a, b []byte) int {
+	offset := 0
+	for offset < len(a) && offset < len(b) && a[offset] == b[offset] {
+		offset++
+	}
 
+	return offset
+}
+
+// stop interrupts the instance the module currently runs in.
+func (h *taskHandle) stop() {
+	h.stateLock.RLock()
+	instance := h.instance
+	h.stateLock.RUnlock()
+
+	instance.Stop()
+}
+
+// raiseStopSignal tells the module the task is stopped with the signal, so it
+// can finish before the kill timeout. Recovered tasks have no module to tell.
+func (h *taskHandle) raiseStopSignal(signal int32) {
+	if h.stopSignal == nil {
 		return
 	}
 
-	h.logger.Debug("wrote data to stdout", "bytes", n)
+	h.logger.Debug("raising stop signal", "task_id", h.taskConfig.ID, "signal", signal)
 
-	h.reportCompletion()
+	h.stopSignal.Raise(signal)
+}
+
+// cleanupInstances releases the task instances.
+func (h *taskHandle) cleanupInstances() {
+	h.instance.Cleanup()
+
+	if h.verifyInstance != nil {
+		h.verifyInstance.Cleanup()
+	}
+}
+
+// recordStatistics records the execution statistics of the task run, if the
+// engine collects them. Like recordMemoryUsage, it must be called by the
+// goroutine running the module, between function calls.
+func (h *taskHandle) recordStatistics() {
+	stats, ok := h.instance.Statistics()
+	if !ok {
+		return
+	}
+
+	h.stateLock.Lock()
+	h.execStats = &stats
+	h.stateLock.Unlock()
+}
+
+// statistics returns the engine execution statistics of the task recorded
+// last, so they may lag behind a running phase. The caller must hold
+// stateLock.
+func (h *taskHandle) statistics() (interfaces.ExecutionStatistics, bool) {
+	if h.execStats == nil {
+		return interfaces.ExecutionStatistics{}, false
+	}
+
+	return *h.execStats, true
+}
+
+// statisticsDetails formats the collected execution statistics as task
+// attributes and event details.
+func statisticsDetails(stats interfaces.ExecutionStatistics) map[string]string {
+	details := make(map[string]string)
+
+	if stats.Instructions {
+		details["instructions"] = strconv.FormatUint(stats.InstrCount, 10)
+		details["cost"] = strconv.FormatUint(stats.TotalCost, 10)
+		details["instructions_per_second"] = strconv.FormatFloat(stats.InstrPerSecond, 'f', 0, 64)
+	}
+
+	if stats.Fuel {
+		details["fuel_consumed"] = strconv.FormatUint(stats.FuelConsumed, 10)
+		details["fuel_remaining"] = strconv.FormatUint(stats.FuelRemaining, 10)
+	}
+
+	return details
+}
+
+// initialize prepares the module for the main function call: grows its
+// memory, runs the reactor initialization function, fills the IO buffer and
+// calls the pre main hook. It returns the IO buffer offset if it is enabled.
+func (h *taskHandle) initialize() (int32, error) {
+	if err := h.preGrowMemory(); err != nil {
+		return 0, err
+	}
+
+	// Reactor modules export _initialize, which must be called before any other
+	// export. It is only called for reactors, other modules may export a
+	// function of the same name for their own purposes.
+	if h.moduleInfo.isReactor() {
+		if _, err := callFunc(h.instance, reactorInitFuncName); err != nil {
+			return 0, fmt.Errorf("failed to call %s: %w", reactorInitFuncName, err)
+		}
+	}
+
+	var offset int32
+
+	if h.ioBufferConf.Enabled {
+		inputByte := []byte(h.ioBufferConf.InputValue)
+
+		// In auto size mode the module allocates a buffer which fits the input
+		// when it is larger than the configured size. The configured size stays
+		// the minimum, so a short or empty input leaves room for the result.
+		if h.ioBufferConf.AutoSize {
+			//nolint:gosec
+			h.ioBufferConf.Size = max(int32(len(inputByte)), h.ioBufferConf.Size)
+		}
+
+		if len(inputByte) > int(h.ioBufferConf.Size) {
+			if h.ioBufferConf.Overflow != ioBufferOverflowTruncate {
+				return 0, fmt.Errorf("input must be less than %d bytes to fit IO buffer", h.ioBufferConf.Size)
+			}
+
+			h.logger.Warn("input doesn't fit IO buffer, truncating it", "task_id", h.taskConfig.ID,
+				"input_bytes", len(inputByte), "buffer_bytes", h.ioBufferConf.Size)
+
+			inputByte = inputByte[:h.ioBufferConf.Size]
+		}
+
+		h.ioBufferConf.Args = append([]int32{h.ioBufferConf.Size}, h.ioBufferConf.Args...)
+
+		if err := h.checkArgs(h.ioBufferConf.IOBufFuncName, h.ioBufferConf.Args); err != nil {
+			return 0, err
+		}
+
+		if err := h.checkOffsetResult(h.ioBufferConf.IOBufFuncName); err != nil {
+			return 0, err
+		}
+
+		ptr, err := callFunc(h.instance, h.ioBufferConf.IOBufFuncName, intListToIfaceList(h.ioBufferConf.Args)...)
+		if err != nil {
+			return 0, fmt.Errorf("unable to call %s function: %w", h.ioBufferConf.IOBufFuncName, err)
+		}
+
+		var ok bool
+		if offset, ok = ptr.(int32); !ok {
+			return 0, fmt.Errorf("%s function returned %T, but the i32 IO buffer offset is expected", h.ioBufferConf.IOBufFuncName, ptr)
+		}
+
+		if err = h.ensureMemory(int64(offset) + int64(h.ioBufferConf.Size)); err != nil {
+			return 0, err
+		}
+
+		ioBuffer, err := h.instance.GetMemoryRange(offset, h.ioBufferConf.Size)
+		if err != nil {
+			return 0, fmt.Errorf("unable to get memory: %w", err)
+		}
+
+		n := copy(ioBuffer, inputByte)
+
+		h.logger.Debug("copied data from task config to IO buffer", "bytes", n)
+
+		//nolint:gosec
+		h.mainFunc.Args = append([]int32{offset, int32(n)}, h.mainFunc.Args...)
+	}
+
+	if err := h.callHook(h.hooks.PreMainFuncName); err != nil {
+		return 0, err
+	}
+
+	return offset, nil
+}
+
+// callFunc calls the exported function of the instance. Engines report
+// functions which return nothing with engines.ErrNoResult, which isn't a
+// failure for the driver, so their result is nil.
+func callFunc(instance interfaces.WasmInstance, funcName string, args ...interface{}) (interface{}, error) {
+	result, err := instance.CallFunc(funcName, args...)
+	if errors.Is(err, engines.ErrNoResult) {
+		return nil, nil
+	}
+
+	return result, err
+}
+
+// withTimeout runs a task execution phase and interrupts the module if the
+// phase doesn't finish within the timeout. A zero timeout disables the limit.
+func (h *taskHandle) withTimeout(phase string, timeout Duration, fn func() error) error {
+	if timeout == 0 {
+		return fn()
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, time.Duration(timeout))
+	defer cancel()
+
+	stopInterrupt := context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			h.logger.Debug("phase timeout reached, interrupting task", "task_id", h.taskConfig.ID, "phase", phase, "timeout", timeout)
+		}
+
+		h.stop()
+	})
+
+	err := fn()
+
+	// The module wasn't interrupted, or was interrupted because the task itself
+	// was canceled rather than the phase timing out.
+	if stopInterrupt() || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s phase exceeded timeout of %v: %w", phase, timeout, err)
+	}
+
+	// The phase finished right as the module was interrupted, which leaves the
+	// instance unusable for the next phase.
+	return fmt.Errorf("%s phase exceeded timeout of %v", phase, timeout)
+}
+
+// checkArgs fails if the arguments don't match the parameters of the exported
+// function, so a mismatch is reported clearly instead of trapping the module.
+func (h *taskHandle) checkArgs(funcName string, args []int32) error {
+	params, err := h.instance.FuncParams(funcName)
+	if err != nil {
+		return fmt.Errorf("unable to get %s function parameters: %w", funcName, err)
+	}
+
+	if len(params) != len(args) {
+		return fmt.Errorf("%s function takes %d parameters, but %d arguments are passed", funcName, len(params), len(args))
+	}
+
+	for i, param := range params {
+		if param != interfaces.ValueTypeI32 {
+			return fmt.Errorf("%s function parameter %d is %s, but only i32 arguments are supported", funcName, i, param)
+		}
+	}
+
+	return nil
+}
+
+// checkOffsetResult fails if the exported function doesn't return a single
+// i32, the offset of the IO buffer it allocates.
+func (h *taskHandle) checkOffsetResult(funcName string) error {
+	results, err := h.instance.FuncResults(funcName)
+	if err != nil {
+		return fmt.Errorf("unable to get %s function results: %w", funcName, err)
+	}
+
+	if len(results) != 1 || results[0] != interfaces.ValueTypeI32 {
+		return fmt.Errorf("%s function must return the i32 IO buffer offset, but it returns %v", funcName, results)
+	}
+
+	return nil
+}
+
+// memoryLimitError names the memory limit in the error of a module which ran
+// out of memory, i.e. whose memory reached the limit and overcommit engines
+// don't let it grow past.
+func (h *taskHandle) memoryLimitError(err error) error {
+	maxPages := h.memoryConf.maxPages()
+	if maxPages == 0 {
+		return err
+	}
+
+	pages, pagesErr := h.instance.MemoryPages()
+	if pagesErr != nil || pages < maxPages {
+		return err
+	}
+
+	if h.overcommit != nil {
+		return fmt.Errorf("%w: module memory reached the memory limit of %d MB and its overcommit of %d MB", err,
+			h.memoryConf.LimitMB, h.memoryConf.OvercommitMB)
+	}
+
+	return fmt.Errorf("%w: module memory reached the memory limit of %d MB", err, h.memoryConf.LimitMB)
+}
+
+// stderrTailError appends the last lines the module wrote to stderr to the
+// error of a failed module call, which for toolchains writing a panic message
+// before trapping tells why the module trapped.
+func (h *taskHandle) stderrTailError(err error) error {
+	lines := h.stdio.stderrTail()
+	if len(lines) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%w, module stderr:\n%s", err, strings.Join(lines, "\n"))
+}
+
+// ensureMemory checks that the module memory holds at least size bytes and,
+// if IO buffer auto grow is enabled, grows the memory when it doesn't.
+func (h *taskHandle) ensureMemory(size int64) error {
+	pages, err := h.instance.MemoryPages()
+	if err != nil {
+		return fmt.Errorf("unable to get memory size: %w", err)
+	}
+
+	//nolint:gosec
+	memorySize := int64(pages) * wasmPageSize
+	if size <= memorySize {
+		return nil
+	}
+
+	if !h.ioBufferConf.AutoGrow {
+		return fmt.Errorf("IO buffer end %d exceeds module memory size %d", size, memorySize)
+	}
+
+	//nolint:gosec
+	deltaPages := uint64((size - memorySize + wasmPageSize - 1) / wasmPageSize)
+
+	if limitPages := h.memoryConf.limitPages(); limitPages > 0 && pages+deltaPages > limitPages {
+		return fmt.Errorf("IO buffer end %d exceeds memory limit of %d MB", size, h.memoryConf.LimitMB)
+	}
+
+	if err := h.instance.GrowMemory(deltaPages); err != nil {
+		return fmt.Errorf("unable to grow memory by %d pages to fit IO buffer: %w", deltaPages, err)
+	}
+
+	h.logger.Debug("memory grown to fit IO buffer", "delta_pages", deltaPages)
+
+	return nil
+}
+
+// preGrowMemory grows the module memory to the configured initial size, so a
+// module known to need a lot of memory doesn't have to grow it step by step.
+func (h *taskHandle) preGrowMemory() error {
+	if h.memoryConf.InitialPages == 0 {
+		return nil
+	}
+
+	pages, err := h.instance.MemoryPages()
+	if err != nil {
+		return fmt.Errorf("unable to get memory size: %w", err)
+	}
+
+	if pages >= h.memoryConf.InitialPages {
+		return nil
+	}
+
+	if err := h.instance.GrowMemory(h.memoryConf.InitialPages - pages); err != nil {
+		return fmt.Errorf("unable to grow memory to %d pages: %w", h.memoryConf.InitialPages, err)
+	}
+
+	h.logger.Debug("memory pre-grown", "from_pages", pages, "to_pages", h.memoryConf.InitialPages)
+
+	return nil
+}
+
+// callHook calls the hook function if hooks are enabled and the module exports
+// it. Hooks are optional, so a missing export is not an error.
+func (h *taskHandle) callHook(funcName string) error {
+	if !h.hooks.Enabled {
+		return nil
+	}
+
+	_, err := callFunc(h.instance, funcName)
+
+	switch {
+	case err == nil:
+		h.logger.Debug("called hook function", "function", funcName)
+	case errors.Is(err, engines.ErrNotFound):
+		h.logger.Debug("module doesn't export hook function, skipping", "function", funcName)
+	default:
+		return fmt.Errorf("failed to call %s hook: %w", funcName, err)
+	}
+
+	return nil
 }
 
 func (h *taskHandle) reportError(err error) {
@@ -157,9 +1059,220 @@ func (h *taskHandle) reportCompletion() {
 	defer h.stateLock.Unlock()
 
 	h.procState = drivers.TaskStateExited
-	h.exitResult.ExitCode = 0
+	h.exitResult.ExitCode = h.mainExitCode
 	h.exitResult.Signal = 0
 	h.completedAt = time.Now()
+
+	// Nomad reports the exit result error as the exit message of the task.
+	if message, ok := h.exitMessages[h.mainExitCode]; ok {
+		h.exitResult.Err = fmt.Errorf("exit code %d: %s", h.mainExitCode, message)
+	}
+}
+
+// notApplicable is logged for the summary statistics the engine doesn't
+// collect.
+const notApplicable = "n/a"
+
+// logSummary writes a single info level record describing how the task
+// finished, so operators get a per-task summary without enabling trace logs.
+func (h *taskHandle) logSummary() {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+
+	var errMsg string
+	if h.exitResult.Err != nil {
+		errMsg = h.exitResult.Err.Error()
+	}
+
+	// The summary always has the same keys, whatever the engine collects.
+	var (
+		instructions, cost, instrPerSecond interface{} = notApplicable, notApplicable, notApplicable
+		fuelConsumed, fuelRemaining        interface{} = notApplicable, notApplicable
+	)
+
+	if stats := h.execStats; stats != nil && stats.Instructions {
+		instructions, cost, instrPerSecond = stats.InstrCount, stats.TotalCost, stats.InstrPerSecond
+	}
+
+	if stats := h.execStats; stats != nil && stats.Fuel {
+		fuelConsumed, fuelRemaining = stats.FuelConsumed, stats.FuelRemaining
+	}
+
+	// Module memories only grow, so the last recorded size is the peak one.
+	args := []interface{}{
+		"task_id", h.taskConfig.ID,
+		"engine", h.engineName,
+		"module", h.modulePath,
+		"state", h.procState,
+		"duration", h.completedAt.Sub(h.startedAt),
+		"exit_code", h.exitResult.ExitCode,
+		"error", errMsg,
+		"peak_memory_bytes", h.memoryBytes.Load(),
+		"cache_hit", h.moduleCached,
+		"instructions", instructions,
+		"cost", cost,
+		"instructions_per_second", instrPerSecond,
+		"fuel_consumed", fuelConsumed,
+		"fuel_remaining", fuelRemaining,
+	}
+
+	h.logger.Info("task finished", args...)
+}
+
+// recordEvent writes the event to the task event log, if enabled. Event log
+// failures are logged and never affect the task.
+func (h *taskHandle) recordEvent(eventType, message string, details map[string]string) {
+	if err := h.eventLog.record(eventType, message, details); err != nil {
+		h.logger.Warn("unable to record task event", "task_id", h.taskConfig.ID, "type", eventType,
+			"error", hclog.Fmt("%+v", err))
+	}
+}
+
+// closeEventLog records how the task finished and closes the task event log.
+func (h *taskHandle) closeEventLog() {
+	if h.eventLog == nil {
+		return
+	}
+
+	h.stateLock.RLock()
+
+	details := map[string]string{
+		"state":     string(h.procState),
+		"duration":  h.completedAt.Sub(h.startedAt).String(),
+		"exit_code": strconv.Itoa(h.exitResult.ExitCode),
+	}
+
+	if h.execStats != nil {
+		for name, value := range statisticsDetails(*h.execStats) {
+			details[name] = value
+		}
+	}
+
+	var errMsg string
+	if h.exitResult.Err != nil {
+		errMsg = h.exitResult.Err.Error()
+	}
+
+	h.stateLock.RUnlock()
+
+	h.recordEvent(eventTypeFinished, errMsg, details)
+
+	if err := h.eventLog.Close(); err != nil {
+		h.logger.Warn("unable to close task event log", "task_id", h.taskConfig.ID, "error", hclog.Fmt("%+v", err))
+	}
+}
+
+// sendMetrics reports how the task finished to statsd, if configured.
+func (h *taskHandle) sendMetrics() {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+
+	if h.procState == drivers.TaskStateExited {
+		h.metrics.incr("tasks.completed")
+	} else {
+		h.metrics.incr("tasks.failed")
+	}
+
+	h.metrics.timing("tasks.duration", h.completedAt.Sub(h.startedAt))
+}
+
+// reportResult sends the task result to the configured result sink and
+// result database in the background, so a slow or unavailable sink doesn't
+// delay task completion.
+func (h *taskHandle) reportResult() {
+	if h.resultSink == nil && h.resultDB == nil {
+		return
+	}
+
+	h.stateLock.RLock()
+
+	result := &taskResult{
+		StartedAt:     h.startedAt,
+		CompletedAt:   h.completedAt,
+		TaskID:        h.taskConfig.ID,
+		CorrelationID: h.correlationID,
+		AllocID:       h.taskConfig.AllocID,
+		TaskName:      h.taskConfig.Name,
+		Engine:        h.engineName,
+		Module:        h.modulePath,
+		State:         string(h.procState),
+		Output:        string(h.output),
+		DurationMs:    h.completedAt.Sub(h.startedAt).Milliseconds(),
+		ExitCode:      h.exitResult.ExitCode,
+	}
+
+	if h.exitResult.Err != nil {
+		result.Error = h.exitResult.Err.Error()
+	}
+
+	h.stateLock.RUnlock()
+
+	h.resultDB.write(result)
+
+	if h.resultSink != nil {
+		go h.resultSink.send(h.logger, result)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// openLogWriter opens the task log FIFO. Nomad leaves the log paths empty when
+// log collection is disabled, in which case the output is discarded.
+func openLogWriter(path string) (io.WriteCloser, error) {
+	if path == "" {
+		return nopWriteCloser{io.Discard}, nil
+	}
+
+	return fifo.OpenWriter(path)
+}
+
+// openStdout opens the task stdout log. With the discard log write error
+// policy, output which can't be written to the log is discarded instead of
+// failing the task, e.g. when the log reader went away.
+func (h *taskHandle) openStdout() (io.WriteCloser, error) {
+	stdout, err := openLogWriter(h.taskConfig.StdoutPath)
+	if h.logWriteError != logWriteErrorDiscard {
+		return stdout, err
+	}
+
+	if err != nil {
+		h.logger.Warn("unable to open task stdout, discarding output", "task_id", h.taskConfig.ID,
+			"error", hclog.Fmt("%+v", err))
+
+		return nopWriteCloser{io.Discard}, nil
+	}
+
+	return &discardOnErrorWriter{WriteCloser: stdout, logger: h.logger, taskID: h.taskConfig.ID}, nil
+}
+
+// discardOnErrorWriter writes to the task log until a write fails and
+// discards the output after that. The failure is logged once.
+type discardOnErrorWriter struct {
+	io.WriteCloser
+	logger hclog.Logger
+	taskID string
+	failed bool
+}
+
+func (w *discardOnErrorWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		return len(p), nil
+	}
+
+	if _, err := w.WriteCloser.Write(p); err != nil {
+		w.failed = true
+
+		w.logger.Warn("unable to write task stdout, discarding output", "task_id", w.taskID,
+			"error", hclog.Fmt("%+v", err))
+	}
+
+	return len(p), nil
 }
 
 func intListToIfaceList(input []int32) []interface{} {
@@ -0,0 +1,944 @@
+package wasm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// logRecords returns the JSON log records with the message.
+func logRecords(t *testing.T, logs *lockedBuffer, message string) []map[string]interface{} {
+	t.Helper()
+
+	var records []map[string]interface{}
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("unable to parse log line %q: %v", line, err)
+		}
+
+		if record["@message"] == message {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+func TestTaskSummaryLogged(t *testing.T) {
+	logs := &lockedBuffer{}
+	d := newTestDriver(t, testPluginConfig, logs)
+
+	// The first task has fuel statistics and a cached module, the second one
+	// neither.
+	var fuel atomic.Bool
+
+	fuel.Store(true)
+
+	useFakeInstances(t, func() *fakeInstance {
+		instance := newFakeInstance(2).withFunc("handle_buffer", returnValue(int32(7)))
+
+		if fuel.Load() {
+			instance.stats = &interfaces.ExecutionStatistics{Fuel: true, FuelConsumed: 30, FuelRemaining: 70}
+			instance.cached = true
+		}
+
+		return instance
+	})
+
+	for _, id := range []string{"summary-fuel", "summary-plain"} {
+		cfg := newTestTask(t, id, `engine = "fake"
+main {
+  resultAsExitCode = true
+}`)
+
+		if result := runTask(t, d, cfg); result.Err != nil || result.ExitCode != 7 {
+			t.Fatalf("unexpected exit result %+v", result)
+		}
+
+		fuel.Store(false)
+	}
+
+	records := logRecords(t, logs, "task finished")
+	if len(records) != 2 {
+		t.Fatalf("expected a summary line per task, but got %d", len(records))
+	}
+
+	expectedKeys := []string{"cache_hit", "correlation_id", "cost", "duration", "engine", "error", "exit_code",
+		"fuel_consumed", "fuel_remaining", "instructions", "instructions_per_second", "module", "peak_memory_bytes", "state",
+		"task_id"}
+
+	for _, summary := range records {
+		var keys []string
+
+		for key := range summary {
+			if !strings.HasPrefix(key, "@") {
+				keys = append(keys, key)
+			}
+		}
+
+		slices.Sort(keys)
+
+		if !slices.Equal(keys, expectedKeys) {
+			t.Errorf("expected summary keys %v, but got %v", expectedKeys, keys)
+		}
+
+		if summary["@level"] != "info" || summary["exit_code"] != float64(7) ||
+			summary["peak_memory_bytes"] != float64(2*wasmPageSize) || summary["instructions"] != notApplicable {
+			t.Errorf("unexpected summary line %v", summary)
+		}
+	}
+
+	if summary := records[0]; summary["task_id"] != "summary-fuel" || summary["fuel_consumed"] != float64(30) ||
+		summary["cache_hit"] != true {
+		t.Errorf("unexpected summary line %v", summary)
+	}
+
+	if summary := records[1]; summary["task_id"] != "summary-plain" || summary["fuel_consumed"] != notApplicable ||
+		summary["cache_hit"] != false {
+		t.Errorf("unexpected summary line %v", summary)
+	}
+}
+
+// withExitCodeMessages sets the task exit code messages, which the HCL1 test
+// parser can't decode as a map.
+func withExitCodeMessages(t *testing.T, cfg *drivers.TaskConfig, messages map[string]string) *drivers.TaskConfig {
+	t.Helper()
+
+	var driverConfig TaskConfig
+	if err := cfg.DecodeDriverConfig(&driverConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	driverConfig.Main.ExitCodeMessages = messages
+
+	if err := cfg.EncodeConcreteDriverConfig(&driverConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func TestExitCodeMessageReported(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		name     string
+		expected string
+		code     int32
+	}{
+		{name: "mapped", code: 3, expected: "exit code 3: input schema mismatch"},
+		{name: "unmapped", code: 4},
+		{name: "success", code: 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(1).withFunc("handle_buffer", returnValue(test.code))
+			})
+
+			cfg := withExitCodeMessages(t, newTestTask(t, test.name, `engine = "fake"
+main {
+  resultAsExitCode = true
+}`), map[string]string{"3": "input schema mismatch"})
+
+			result := runTask(t, d, cfg)
+			if result.ExitCode != int(test.code) {
+				t.Fatalf("expected exit code %d, but got %+v", test.code, result)
+			}
+
+			checkError(t, "exit result", result.Err, test.expected)
+		})
+	}
+
+	cfg := withExitCodeMessages(t, newTestTask(t, "invalid", `engine = "fake"
+main {
+  resultAsExitCode = true
+}`), map[string]string{"0": "success"})
+
+	_, _, err := d.StartTask(cfg)
+	checkError(t, "start", err, `main exitCodeMessages keys must be non-zero integer exit codes, but specified "0"`)
+}
+
+func TestReactorInitialized(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		name        string
+		exports     []string
+		initialized bool
+	}{
+		{name: "reactor", exports: []string{"_initialize", "handle_buffer"}, initialized: true},
+		{name: "command", exports: []string{"_initialize", "_start", "handle_buffer"}},
+		{name: "unknown"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var instance *fakeInstance
+
+			useFakeInstances(t, func() *fakeInstance {
+				instance = newFakeInstance(1).
+					withFunc("_initialize", returnNothing()).
+					withFunc("handle_buffer", returnValue(int32(0)))
+
+				return instance
+			})
+
+			cfg := newTestTask(t, test.name, `engine = "fake"`)
+
+			if test.exports != nil {
+				writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), wasmModule(test.exports...))
+			}
+
+			if result := runTask(t, d, cfg); result.Err != nil {
+				t.Fatalf("unexpected exit result %+v", result)
+			}
+
+			if initialized := slices.Contains(instance.calls, "_initialize"); initialized != test.initialized {
+				t.Errorf("expected _initialize called %v, but calls are %v", test.initialized, instance.calls)
+			}
+		})
+	}
+}
+
+func TestPhaseTimeouts(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	sleep := func(duration time.Duration) fakeFunc {
+		return func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+			select {
+			case <-instance.stopCh:
+				return nil, errInterrupted
+			case <-time.After(duration):
+				return int32(0), nil
+			}
+		}
+	}
+
+	taskConfig := `engine = "fake"
+hooks {
+  enabled = true
+}
+timeouts {
+  initTimeout = 1
+  runTimeout = 1
+  postTimeout = 1
+}`
+
+	// Each phase has its own budget, so a slow initialization doesn't consume
+	// the run timeout and a slow main function doesn't consume the post one.
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("pre_main", sleep(600*time.Millisecond)).
+			withFunc("handle_buffer", sleep(600*time.Millisecond)).
+			withFunc("post_main", sleep(600*time.Millisecond))
+	})
+
+	if result := runTask(t, d, newTestTask(t, "within", taskConfig)); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	for _, test := range []struct {
+		newInstance func() *fakeInstance
+		phase       string
+	}{
+		{
+			phase: "run",
+			newInstance: func() *fakeInstance {
+				return newFakeInstance(1).withFunc("handle_buffer", blockUntilStopped())
+			},
+		},
+		{
+			phase: "post",
+			newInstance: func() *fakeInstance {
+				return newFakeInstance(1).
+					withFunc("handle_buffer", returnValue(int32(0))).
+					withFunc("post_main", blockUntilStopped())
+			},
+		},
+	} {
+		useFakeInstances(t, test.newInstance)
+
+		result := runTask(t, d, newTestTask(t, "exceeded-"+test.phase, taskConfig))
+		if result.Err == nil || !strings.Contains(result.Err.Error(), test.phase+" phase exceeded timeout") {
+			t.Fatalf("expected the %s phase to time out, but got exit result %+v", test.phase, result)
+		}
+	}
+}
+
+func TestHooksCalledAroundMain(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	instances := make(chan *fakeInstance, 1)
+
+	useFakeInstances(t, func() *fakeInstance {
+		instance := newFakeInstance(1).
+			withFunc("pre_main", returnValue(int32(0))).
+			withFunc("handle_buffer", returnValue(int32(0))).
+			withFunc("post_main", returnValue(int32(0)))
+		instances <- instance
+
+		return instance
+	})
+
+	cfg := newTestTask(t, "hooks", `engine = "fake"
+hooks {
+  enabled = true
+}
+timeouts {
+  runTimeout = 1
+  postTimeout = 1
+}`)
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	expected := []string{"pre_main", "handle_buffer", "post_main"}
+	if calls := (<-instances).calls; !slices.Equal(calls, expected) {
+		t.Errorf("expected calls %v, but got %v", expected, calls)
+	}
+}
+
+func TestIOBufferCustomProcessFunc(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("alloc", allocAt(1024, nil), interfaces.ValueTypeI32).
+			withFunc("process", upperCase(), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+	})
+
+	cfg := newTestTask(t, "process", `engine = "fake"
+main {
+  mainFuncName = ""
+}
+ioBuffer {
+  enabled = true
+  inputValue = "hello"
+  processFuncName = "process"
+}`)
+	writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), wasmModule("alloc", "process"))
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	if out := readStdout(t, cfg); out != "HELLO" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestIOBufferAutoSize(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		name  string
+		input string
+		size  int32
+	}{
+		{name: "larger", input: strings.Repeat("a", 20), size: 20},
+		{name: "smaller", input: "abc", size: 16},
+		{name: "empty", size: 16},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var sizes []int32
+
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(1).
+					withFunc("alloc", allocAt(0, &sizes), interfaces.ValueTypeI32).
+					withFunc("handle_buffer", upperCase(), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+			})
+
+			cfg := newTestTask(t, test.name, `engine = "fake"
+ioBuffer {
+  enabled = true
+  size = 16
+  autoSize = true
+  inputValue = "`+test.input+`"
+  noOutput = "empty"
+}`)
+
+			if result := runTask(t, d, cfg); result.Err != nil {
+				t.Fatalf("unexpected exit result %+v", result)
+			}
+
+			if !slices.Equal(sizes, []int32{test.size}) {
+				t.Errorf("expected a buffer of %d bytes, but allocated %v", test.size, sizes)
+			}
+		})
+	}
+}
+
+func TestIOBufferBeyondMemory(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		name     string
+		err      string
+		autoGrow bool
+	}{
+		{name: "error", err: "IO buffer end 65552 exceeds module memory size 65536"},
+		{name: "grow", autoGrow: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var instance *fakeInstance
+
+			useFakeInstances(t, func() *fakeInstance {
+				instance = newFakeInstance(1).
+					withFunc("alloc", allocAt(wasmPageSize-16, nil), interfaces.ValueTypeI32).
+					withFunc("handle_buffer", upperCase(), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+
+				return instance
+			})
+
+			cfg := newTestTask(t, test.name, fmt.Sprintf(`engine = "fake"
+ioBuffer {
+  enabled = true
+  size = 32
+  inputValue = "hello"
+  autoGrow = %v
+}`, test.autoGrow))
+
+			result := runTask(t, d, cfg)
+
+			if test.err != "" {
+				if result.Err == nil || !strings.Contains(result.Err.Error(), test.err) {
+					t.Fatalf("expected error %q, but got exit result %+v", test.err, result)
+				}
+
+				return
+			}
+
+			if result.Err != nil {
+				t.Fatalf("unexpected exit result %+v", result)
+			}
+
+			if pages, _ := instance.MemoryPages(); pages != 2 {
+				t.Errorf("expected memory grown to 2 pages, but it has %d", pages)
+			}
+
+			if out := readStdout(t, cfg); !strings.HasPrefix(out, "HELLO") {
+				t.Errorf("unexpected output %q", out)
+			}
+		})
+	}
+}
+
+func TestIOBufferOverflow(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("alloc", allocAt(0, nil), interfaces.ValueTypeI32).
+			withFunc("handle_buffer", upperCase(), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+	})
+
+	for _, test := range []struct {
+		name     string
+		overflow string
+		output   string
+		err      string
+	}{
+		{name: "error", overflow: "error", err: "input must be less than 4 bytes to fit IO buffer"},
+		{name: "truncate", overflow: "truncate", output: "HELL"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestTask(t, test.name, `engine = "fake"
+ioBuffer {
+  enabled = true
+  size = 4
+  inputValue = "hello"
+  overflow = "`+test.overflow+`"
+}`)
+
+			result := runTask(t, d, cfg)
+
+			if test.err != "" {
+				if result.Err == nil || !strings.Contains(result.Err.Error(), test.err) {
+					t.Fatalf("expected error %q, but got exit result %+v", test.err, result)
+				}
+
+				return
+			}
+
+			if result.Err != nil {
+				t.Fatalf("unexpected exit result %+v", result)
+			}
+
+			if out := readStdout(t, cfg); out != test.output {
+				t.Errorf("expected output %q, but got %q", test.output, out)
+			}
+		})
+	}
+}
+
+func TestIOBufferSizeMustBePositive(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("alloc", allocAt(0, nil), interfaces.ValueTypeI32)
+	})
+
+	cfg := newTestTask(t, "negative", `engine = "fake"
+ioBuffer {
+  enabled = true
+  size = -1
+}`)
+
+	if _, _, err := d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), "IO buffer size must be > 0") {
+		t.Fatalf("expected the negative IO buffer size to be rejected, but got %v", err)
+	}
+}
+
+func TestIOBufferAllocSignatureChecked(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		newInstance func() *fakeInstance
+		name        string
+		err         string
+	}{
+		{
+			name: "args",
+			newInstance: func() *fakeInstance {
+				return newFakeInstance(1).withFunc("alloc", allocAt(0, nil), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+			},
+			err: "alloc function takes 2 parameters, but 1 arguments are passed",
+		},
+		{
+			name: "results",
+			newInstance: func() *fakeInstance {
+				return newFakeInstance(1).
+					withFunc("alloc", allocAt(0, nil), interfaces.ValueTypeI32).
+					withResults("alloc", interfaces.ValueTypeI64)
+			},
+			err: "alloc function must return the i32 IO buffer offset",
+		},
+		{
+			name: "value",
+			newInstance: func() *fakeInstance {
+				return newFakeInstance(1).withFunc("alloc", returnValue(int64(0)), interfaces.ValueTypeI32)
+			},
+			err: "alloc function returned int64, but the i32 IO buffer offset is expected",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var instance *fakeInstance
+
+			useFakeInstances(t, func() *fakeInstance {
+				instance = test.newInstance().
+					withFunc("handle_buffer", upperCase(), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+
+				return instance
+			})
+
+			result := runTask(t, d, newTestTask(t, test.name, `engine = "fake"
+ioBuffer {
+  enabled = true
+}`))
+			if result.Err == nil || !strings.Contains(result.Err.Error(), test.err) {
+				t.Fatalf("expected error %q, but got exit result %+v", test.err, result)
+			}
+
+			if test.name != "value" && slices.Contains(instance.calls, "alloc") {
+				t.Error("alloc function with a mismatching signature was called")
+			}
+		})
+	}
+}
+
+func TestNoOutputReportedDistinctly(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		handle   fakeFunc
+		name     string
+		noOutput string
+		noOutErr bool
+		failed   bool
+	}{
+		{name: "silent", handle: returnValue(int32(0)), noOutput: "error", noOutErr: true, failed: true},
+		{name: "trap", handle: func(*fakeInstance, []interface{}) (interface{}, error) {
+			return nil, errors.New("wasm trap: unreachable")
+		}, noOutput: "error", failed: true},
+		{name: "empty", handle: returnValue(int32(0)), noOutput: "empty"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(1).
+					withFunc("alloc", allocAt(0, nil), interfaces.ValueTypeI32).
+					withFunc("handle_buffer", test.handle, interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+			})
+
+			result := runTask(t, d, newTestTask(t, test.name, `engine = "fake"
+ioBuffer {
+  enabled = true
+  inputValue = "hello"
+  noOutput = "`+test.noOutput+`"
+}`))
+
+			if failed := result.Err != nil; failed != test.failed {
+				t.Fatalf("expected the task failed %v, but got exit result %+v", test.failed, result)
+			}
+
+			if noOutErr := errors.Is(result.Err, errNoOutput); noOutErr != test.noOutErr {
+				t.Errorf("expected no output reported %v, but got error %v", test.noOutErr, result.Err)
+			}
+		})
+	}
+}
+
+// writeOutput returns a buffer processing function writing the output computed
+// by the instance to the IO buffer and returning its size.
+func writeOutput(output func(instance *fakeInstance) (string, error)) fakeFunc {
+	return func(instance *fakeInstance, args []interface{}) (interface{}, error) {
+		out, err := output(instance)
+		if err != nil {
+			return nil, err
+		}
+
+		buffer, err := instance.GetMemoryRange(args[0].(int32), int32(len(out)))
+		if err != nil {
+			return nil, err
+		}
+
+		return int32(copy(buffer, out)), nil
+	}
+}
+
+const determinismTaskConfig = `engine = "fake"
+verifyDeterminism = true
+main {
+  mainFuncName = ""
+}
+ioBuffer {
+  enabled = true
+  processFuncName = "process"
+}`
+
+func TestDeterminismVerified(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	var instances int32
+
+	for _, test := range []struct {
+		output func(*fakeInstance) (string, error)
+		name   string
+		err    string
+	}{
+		{
+			name:   "deterministic",
+			output: func(*fakeInstance) (string, error) { return "same", nil },
+		},
+		{
+			name: "nondeterministic",
+			output: func(*fakeInstance) (string, error) {
+				return fmt.Sprintf("run %d", atomic.AddInt32(&instances, 1)), nil
+			},
+			err: "module is not deterministic: outputs of 5 and 5 bytes diverge at byte 4",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(1).
+					withFunc("alloc", allocAt(1024, nil), interfaces.ValueTypeI32).
+					withFunc("process", writeOutput(test.output), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+			})
+
+			cfg := newTestTask(t, test.name, determinismTaskConfig)
+			writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), wasmModule("alloc", "process"))
+
+			result := runTask(t, d, cfg)
+
+			switch {
+			case test.err == "" && result.Err != nil:
+				t.Fatalf("unexpected exit result %+v", result)
+			case test.err != "" && (result.Err == nil || !strings.Contains(result.Err.Error(), test.err)):
+				t.Fatalf("expected the task to fail with %q, but got exit result %+v", test.err, result)
+			}
+		})
+	}
+}
+
+func TestDeterminismRunUsesScratchPreopenDirs(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	// The module outputs the content of its preopened counter file and then
+	// appends to it, so a run seeing the file changed by the other diverges.
+	instantiateWith(t, func(conf interfaces.InstanceConfig) (*fakeInstance, error) {
+		counterPath := filepath.Join(conf.Wasi.PreopenDirs[0].HostPath, "counter")
+
+		return newFakeInstance(1).
+			withFunc("alloc", allocAt(1024, nil), interfaces.ValueTypeI32).
+			withFunc("process", writeOutput(func(*fakeInstance) (string, error) {
+				counter, err := os.ReadFile(counterPath)
+				if err != nil {
+					return "", err
+				}
+
+				return string(counter), os.WriteFile(counterPath, append(counter, 'x'), 0o600)
+			}), interfaces.ValueTypeI32, interfaces.ValueTypeI32), nil
+	})
+
+	cfg := newTestTask(t, "scratch", determinismTaskConfig+`
+wasi {
+  enabled = true
+  preopenDirs {
+    hostPath  = "data"
+    guestPath = "/data"
+  }
+}`)
+	writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), wasmModule("alloc", "process"))
+
+	dataDir := filepath.Join(cfg.TaskDir().Dir, "data")
+	if err := os.Mkdir(dataDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, filepath.Join(dataDir, "counter"), "0")
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	handle, _ := d.tasks.Get(cfg.ID)
+
+	select {
+	case <-handle.completionCh:
+	case <-time.After(testTimeout):
+		t.Fatal("task didn't complete before timeout")
+	}
+
+	if result := handle.exitResult; result == nil || result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	if counter, err := os.ReadFile(filepath.Join(dataDir, "counter")); err != nil || string(counter) != "0x" {
+		t.Errorf("expected only the task run to change the task files, but got counter %q (%v)", counter, err)
+	}
+
+	confs := testEngine.instanceConfs()
+	if len(confs) != 2 {
+		t.Fatalf("expected the task and verification instances, but got %d instances", len(confs))
+	}
+
+	scratchDir := confs[1].Wasi.PreopenDirs[0].HostPath
+	if _, err := os.Stat(scratchDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the scratch dir %s to be removed, but got %v", scratchDir, err)
+	}
+}
+
+func TestMemoryPreGrownBeforeMain(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		name         string
+		initialPages uint64
+		modulePages  int
+		mainPages    int
+	}{
+		{name: "grown", initialPages: 4, modulePages: 1, mainPages: 4},
+		{name: "disabled", modulePages: 1, mainPages: 1},
+		// Memory already larger than the initial size isn't shrunk.
+		{name: "larger", initialPages: 1, modulePages: 2, mainPages: 2},
+	} {
+		// The main function exits with the memory pages it sees.
+		useFakeInstances(t, func() *fakeInstance {
+			return newFakeInstance(test.modulePages).
+				withFunc("handle_buffer", func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+					//nolint:gosec
+					return int32(len(instance.memory) / wasmPageSize), nil
+				})
+		})
+
+		cfg := newTestTask(t, test.name, fmt.Sprintf(`engine = "fake"
+main {
+  resultAsExitCode = true
+}
+memory {
+  initialPages = %d
+}`, test.initialPages))
+
+		if result := runTask(t, d, cfg); result.ExitCode != test.mainPages {
+			t.Errorf("%s: expected main to run with %d memory pages, but got exit result %+v",
+				test.name, test.mainPages, result)
+		}
+	}
+}
+
+func TestMemoryLimitNamedInError(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		name  string
+		err   string
+		pages int
+	}{
+		{name: "reached", pages: 16, err: "failed to call handle_buffer: trap: module memory reached the memory limit of 1 MB"},
+		{name: "below", pages: 15, err: "failed to call handle_buffer: trap"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(test.pages).withFunc("handle_buffer", func(*fakeInstance, []interface{}) (interface{}, error) {
+					return nil, errors.New("trap")
+				})
+			})
+
+			cfg := newTestTask(t, test.name, `engine = "fake"
+memory {
+  limitMB = 1
+}`)
+
+			if result := runTask(t, d, cfg); result.Err == nil || result.Err.Error() != test.err {
+				t.Errorf("expected the task to fail with %q, but got exit result %+v", test.err, result)
+			}
+		})
+	}
+}
+
+func TestBrokenStdoutHandledByLogWriteErrorPolicy(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		name   string
+		policy string
+		// fullStdout makes writing the output fail instead of removing the
+		// stdout log while the module runs.
+		fullStdout bool
+		fails      bool
+	}{
+		{name: "removed-discard", policy: "discard"},
+		{name: "full-discard", policy: "discard", fullStdout: true},
+		{name: "removed-fail", policy: "fail", fails: true},
+		{name: "full-fail", policy: "fail", fullStdout: true, fails: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestTask(t, test.name, `engine = "fake"
+logWriteError = "`+test.policy+`"
+main {
+  mainFuncName = ""
+}
+ioBuffer {
+  enabled = true
+  inputValue = "hello"
+  processFuncName = "process"
+}`)
+			writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), wasmModule("alloc", "process"))
+
+			if test.fullStdout {
+				cfg.StdoutPath = "/dev/full"
+			}
+
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(1).
+					withFunc("alloc", allocAt(0, nil), interfaces.ValueTypeI32).
+					withFunc("process", func(instance *fakeInstance, args []interface{}) (interface{}, error) {
+						if !test.fullStdout {
+							if err := os.Remove(cfg.StdoutPath); err != nil {
+								return nil, err
+							}
+						}
+
+						return upperCase()(instance, args)
+					}, interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+			})
+
+			result := runTask(t, d, cfg)
+
+			if test.fails && result.Err == nil {
+				t.Errorf("expected the task to fail, but got %+v", result)
+			}
+
+			if !test.fails && (result.Err != nil || result.ExitCode != 0) {
+				t.Errorf("expected the task to complete, but got %+v", result)
+			}
+		})
+	}
+}
+
+func TestEffectiveLimitsReported(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	for _, test := range []struct {
+		expected   map[string]string
+		name       string
+		taskConfig string
+		// memoryMB is the task memory resource, the memory limit defaults to
+		// it.
+		memoryMB int64
+	}{
+		{
+			name: "configured",
+			taskConfig: `memory {
+  limitMB = 2
+}
+timeouts {
+  initTimeout = 3
+  runTimeout = 5
+  postTimeout = 7
+}`,
+			memoryMB: 64,
+			expected: map[string]string{
+				"memory_limit_mb":    "2",
+				"memory_limit_pages": "32",
+				"fuel_limit":         "0",
+				"init_timeout":       "3s",
+				"run_timeout":        "5s",
+				"post_timeout":       "7s",
+			},
+		},
+		{
+			name:     "derived",
+			memoryMB: 4,
+			expected: map[string]string{
+				"memory_limit_mb":    "4",
+				"memory_limit_pages": "64",
+				"fuel_limit":         "0",
+				"init_timeout":       "0s",
+				"run_timeout":        "0s",
+				"post_timeout":       "0s",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestTask(t, "limits-"+test.name, `engine = "fake"
+`+test.taskConfig)
+			cfg.Resources = &drivers.Resources{
+				NomadResources: &structs.AllocatedTaskResources{
+					Memory: structs.AllocatedMemoryResources{MemoryMB: test.memoryMB},
+				},
+			}
+
+			if result := runTask(t, d, cfg); result.Err != nil {
+				t.Fatalf("unexpected exit result %+v", result)
+			}
+
+			status, err := d.InspectTask(cfg.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, expected := range test.expected {
+				if value := status.DriverAttributes[name]; value != expected {
+					t.Errorf("expected %s attribute %s, but got %s", name, expected, value)
+				}
+			}
+		})
+	}
+}
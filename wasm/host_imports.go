@@ -0,0 +1,67 @@
+package wasm
+
+import (
+	"fmt"
+
+	"huawei.com/wasm-task-driver/wasm/hostimports"
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// maxConfigBytes bounds the total size of keys and values of the config
+// exposed to modules through the config_get host import.
+const maxConfigBytes = 65536
+
+// buildHostFuncs returns the host functions provided to the task's module
+// according to its hostImports configuration, and the counter of their calls.
+func buildHostFuncs(conf HostImportsConfig, correlationID string, stopSignal *hostimports.StopSignal,
+) ([]interfaces.HostFunc, *hostimports.CallCounter, error) {
+	if !conf.Enabled {
+		return nil, nil, nil
+	}
+
+	if conf.StateMaxBytes <= 0 {
+		return nil, nil, fmt.Errorf("host imports state size must be > 0, but specified %v", conf.StateMaxBytes)
+	}
+
+	var configSize int
+	for key, value := range conf.Config {
+		configSize += len(key) + len(value)
+	}
+
+	if configSize > maxConfigBytes {
+		return nil, nil, fmt.Errorf("host imports config must be <= %d bytes, but specified %d bytes", maxConfigBytes, configSize)
+	}
+
+	var hostFuncs []interfaces.HostFunc
+
+	hostFuncs = append(hostFuncs, hostimports.NewState(conf.StateMaxBytes).HostFuncs()...)
+	hostFuncs = append(hostFuncs, hostimports.NewClock(conf.ClockStart, conf.ClockTick).HostFuncs()...)
+	hostFuncs = append(hostFuncs, hostimports.NewRand(conf.RandSeed).HostFuncs()...)
+	hostFuncs = append(hostFuncs, hostimports.NewConfig(conf.Config).HostFuncs()...)
+	hostFuncs = append(hostFuncs, hostimports.NewCorrelationID(correlationID).HostFuncs()...)
+	hostFuncs = append(hostFuncs, stopSignal.HostFuncs()...)
+
+	for name, limit := range conf.CallLimits {
+		if limit <= 0 {
+			return nil, nil, fmt.Errorf("host import %s call rate limit must be > 0, but specified %v", name, limit)
+		}
+
+		if !hasHostFunc(hostFuncs, name) {
+			return nil, nil, fmt.Errorf("unable to limit calls of unknown host import %s", name)
+		}
+	}
+
+	calls := hostimports.NewCallCounter(conf.CallLimits)
+
+	return calls.Wrap(hostFuncs), calls, nil
+}
+
+func hasHostFunc(hostFuncs []interfaces.HostFunc, name string) bool {
+	for _, hostFunc := range hostFuncs {
+		if hostFunc.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,69 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// incrementCounter returns a function incrementing the counter kept in the
+// host state under the key "counter" and returning its new value.
+func incrementCounter() fakeFunc {
+	const (
+		keyPtr   = 0
+		valuePtr = 16
+	)
+
+	return func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+		key := "counter"
+		copy(instance.memory[keyPtr:], key)
+
+		results, err := instance.callHost("state_get", int32(keyPtr), int32(len(key)), int32(valuePtr), int32(4))
+		if err != nil {
+			return nil, err
+		}
+
+		var counter uint32
+		if results[0].(int32) == 4 {
+			counter = binary.LittleEndian.Uint32(instance.memory[valuePtr:])
+		}
+
+		counter++
+		binary.LittleEndian.PutUint32(instance.memory[valuePtr:], counter)
+
+		if _, err := instance.callHost("state_set", int32(keyPtr), int32(len(key)), int32(valuePtr), int32(4)); err != nil {
+			return nil, err
+		}
+
+		return int32(counter), nil
+	}
+}
+
+func TestHostStateKeptAcrossCalls(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("pre_main", incrementCounter()).
+			withFunc("handle_buffer", incrementCounter())
+	})
+
+	taskConfig := `engine = "fake"
+main {
+  resultAsExitCode = true
+}
+hooks {
+  enabled = true
+}
+hostImports {
+  enabled = true
+}`
+
+	// The state is scoped to the task, so every task counts from zero.
+	for _, id := range []string{"first", "second"} {
+		cfg := newTestTask(t, id, taskConfig)
+
+		if result := runTask(t, d, cfg); result.Err != nil || result.ExitCode != 2 {
+			t.Fatalf("expected task %s to count 2 calls, but got exit result %+v", id, result)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package hostimports
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// CallCounter counts host function calls of a single task and enforces
+// optional per function call rate limits, protecting the host from chatty
+// modules.
+type CallCounter struct {
+	counts   map[string]int64
+	limiters map[string]*rate.Limiter
+	lock     sync.Mutex
+}
+
+// NewCallCounter returns a counter enforcing limits, which map host function
+// names to the maximum number of calls per second. Each function has a token
+// bucket holding a second worth of calls, so short bursts up to the limit are
+// allowed.
+func NewCallCounter(limits map[string]int64) *CallCounter {
+	limiters := make(map[string]*rate.Limiter, len(limits))
+	for name, limit := range limits {
+		limiters[name] = rate.NewLimiter(rate.Limit(limit), int(limit))
+	}
+
+	return &CallCounter{
+		counts:   make(map[string]int64),
+		limiters: limiters,
+	}
+}
+
+// Wrap returns the host functions with calls counted and limited by the
+// counter.
+func (c *CallCounter) Wrap(hostFuncs []interfaces.HostFunc) []interfaces.HostFunc {
+	wrapped := make([]interfaces.HostFunc, len(hostFuncs))
+
+	for i, hostFunc := range hostFuncs {
+		call := hostFunc.Call
+		name := hostFunc.Name
+
+		wrapped[i] = hostFunc
+		wrapped[i].Call = func(memory []byte, args []interface{}) ([]interface{}, error) {
+			if err := c.count(name); err != nil {
+				return nil, err
+			}
+
+			return call(memory, args)
+		}
+	}
+
+	return wrapped
+}
+
+func (c *CallCounter) count(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if limiter, ok := c.limiters[name]; ok && !limiter.Allow() {
+		return fmt.Errorf("call rate limit of %d per second exceeded", limiter.Burst())
+	}
+
+	c.counts[name]++
+
+	return nil
+}
+
+// String returns the call counts as comma separated name=count pairs sorted
+// by name.
+func (c *CallCounter) String() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	names := make([]string, 0, len(c.counts))
+	for name := range c.counts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%d", name, c.counts[name])
+	}
+
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,40 @@
+package hostimports
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallRateLimited(t *testing.T) {
+	calls := NewCallCounter(map[string]int64{"clock_now": 10})
+	hostFunc := calls.Wrap(NewClock(0, 1).HostFuncs())[0]
+
+	call := func() error {
+		_, err := hostFunc.Call(nil, nil)
+
+		return err
+	}
+
+	// A burst of a second worth of calls is allowed.
+	for i := 0; i < 10; i++ {
+		if err := call(); err != nil {
+			t.Fatalf("call %d of the burst failed: %v", i, err)
+		}
+	}
+
+	if err := call(); err == nil || !strings.Contains(err.Error(), "call rate limit of 10 per second exceeded") {
+		t.Fatalf("expected the call over the limit to fail, but got %v", err)
+	}
+
+	// The limit is a rate, calls are allowed again once tokens are refilled.
+	time.Sleep(200 * time.Millisecond)
+
+	if err := call(); err != nil {
+		t.Fatalf("expected the call after the refill to succeed, but got %v", err)
+	}
+
+	if counts := calls.String(); counts != "clock_now=11" {
+		t.Errorf("unexpected call counts %q", counts)
+	}
+}
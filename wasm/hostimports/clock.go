@@ -0,0 +1,52 @@
+package hostimports
+
+import (
+	"sync"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// Clock is a driver managed logical clock backing the env.clock_now host
+// import. It is independent of the wall clock, so simulations get the same
+// timestamps on every run.
+type Clock struct {
+	now  int64
+	tick int64
+	lock sync.Mutex
+}
+
+// NewClock returns a clock starting at start and advancing by tick on every
+// read.
+func NewClock(start, tick int64) *Clock {
+	return &Clock{
+		now:  start,
+		tick: tick,
+	}
+}
+
+// Now returns the current clock value and advances the clock by one tick.
+func (c *Clock) Now() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now
+	c.now += c.tick
+
+	return now
+}
+
+// HostFuncs returns the host functions exposing the clock to modules:
+//
+//	clock_now() i64
+func (c *Clock) HostFuncs() []interfaces.HostFunc {
+	return []interfaces.HostFunc{
+		{
+			Module:  hostModuleName,
+			Name:    "clock_now",
+			Results: []interfaces.ValueType{interfaces.ValueTypeI64},
+			Call: func(_memory []byte, _args []interface{}) ([]interface{}, error) {
+				return []interface{}{c.Now()}, nil
+			},
+		},
+	}
+}
@@ -0,0 +1,32 @@
+package hostimports
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClockAdvancesByTick(t *testing.T) {
+	clock := NewClock(1000, 10)
+	hostFunc := clock.HostFuncs()[0]
+
+	var values []int64
+
+	for i := 0; i < 3; i++ {
+		results, err := hostFunc.Call(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values = append(values, results[0].(int64))
+	}
+
+	// The clock doesn't depend on the wall clock, every run reads the same
+	// timestamps.
+	if expected := []int64{1000, 1010, 1020}; !slices.Equal(values, expected) {
+		t.Errorf("expected clock values %v, but got %v", expected, values)
+	}
+
+	if now := NewClock(1000, 10).Now(); now != values[0] {
+		t.Errorf("expected a new clock to start at %d again, but got %d", values[0], now)
+	}
+}
@@ -0,0 +1,64 @@
+package hostimports
+
+import (
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// configNotFound is returned by config_get when the key is not set.
+const configNotFound int32 = -1
+
+// Config is a read-only key/value map provided by the task configuration. It
+// backs the env.config_get host import, so modules can be configured without
+// being recompiled.
+type Config struct {
+	values map[string]string
+}
+
+// NewConfig returns a config exposing values.
+func NewConfig(values map[string]string) *Config {
+	return &Config{
+		values: values,
+	}
+}
+
+// HostFuncs returns the host functions exposing the config to modules:
+//
+//	config_get(key_ptr, key_len, out_ptr, out_len i32) i32
+//
+// config_get copies at most out_len bytes of the value and returns its full
+// length, or -1 if the key is not set.
+func (c *Config) HostFuncs() []interfaces.HostFunc {
+	i32 := interfaces.ValueTypeI32
+
+	return []interfaces.HostFunc{
+		{
+			Module:  hostModuleName,
+			Name:    "config_get",
+			Params:  []interfaces.ValueType{i32, i32, i32, i32},
+			Results: []interfaces.ValueType{i32},
+			Call:    c.configGet,
+		},
+	}
+}
+
+func (c *Config) configGet(memory []byte, args []interface{}) ([]interface{}, error) {
+	key, err := memoryRange(memory, args[0].(int32), args[1].(int32))
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := memoryRange(memory, args[2].(int32), args[3].(int32))
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := c.values[string(key)]
+	if !ok {
+		return []interface{}{configNotFound}, nil
+	}
+
+	copy(out, value)
+
+	//nolint:gosec
+	return []interface{}{int32(len(value))}, nil
+}
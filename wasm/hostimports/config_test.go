@@ -0,0 +1,46 @@
+package hostimports
+
+import (
+	"testing"
+)
+
+func TestConfigGet(t *testing.T) {
+	configGet := NewConfig(map[string]string{"greeting": "hello"}).HostFuncs()[0]
+
+	memory := make([]byte, 32)
+	copy(memory, "greetingmissing")
+
+	for _, test := range []struct {
+		name   string
+		out    string
+		key    []int32
+		outLen int32
+		result int32
+	}{
+		{name: "set", key: []int32{0, 8}, outLen: 8, result: 5, out: "hello"},
+		// The value is truncated to the output, its full length is returned.
+		{name: "truncated", key: []int32{0, 8}, outLen: 2, result: 5, out: "he"},
+		{name: "missing", key: []int32{8, 7}, outLen: 8, result: configNotFound},
+	} {
+		out := memory[16:]
+		clear(out)
+
+		results, err := configGet.Call(memory, []interface{}{test.key[0], test.key[1], int32(16), test.outLen})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if results[0] != test.result {
+			t.Errorf("%s: expected config_get to return %d, but got %v", test.name, test.result, results[0])
+		}
+
+		if value := string(out[:len(test.out)]); value != test.out || out[len(test.out)] != 0 {
+			t.Errorf("%s: expected value %q written to the output, but got %q", test.name, test.out, out)
+		}
+	}
+
+	// Keys outside of the module memory fail the call.
+	if _, err := configGet.Call(memory, []interface{}{int32(30), int32(8), int32(16), int32(8)}); err == nil {
+		t.Error("expected config_get of a key outside of the memory to fail")
+	}
+}
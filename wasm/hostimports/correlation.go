@@ -0,0 +1,50 @@
+package hostimports
+
+import (
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// CorrelationID exposes the task correlation ID, so modules can tag their own
+// output and calls with it.
+type CorrelationID struct {
+	id string
+}
+
+// NewCorrelationID returns the host import exposing id.
+func NewCorrelationID(id string) *CorrelationID {
+	return &CorrelationID{
+		id: id,
+	}
+}
+
+// HostFuncs returns the host functions exposing the correlation ID:
+//
+//	correlation_id(out_ptr, out_len i32) i32
+//
+// correlation_id copies at most out_len bytes of the ID and returns its full
+// length.
+func (c *CorrelationID) HostFuncs() []interfaces.HostFunc {
+	i32 := interfaces.ValueTypeI32
+
+	return []interfaces.HostFunc{
+		{
+			Module:  hostModuleName,
+			Name:    "correlation_id",
+			Params:  []interfaces.ValueType{i32, i32},
+			Results: []interfaces.ValueType{i32},
+			Call:    c.correlationID,
+		},
+	}
+}
+
+func (c *CorrelationID) correlationID(memory []byte, args []interface{}) ([]interface{}, error) {
+	out, err := memoryRange(memory, args[0].(int32), args[1].(int32))
+	if err != nil {
+		return nil, err
+	}
+
+	copy(out, c.id)
+
+	//nolint:gosec
+	return []interface{}{int32(len(c.id))}, nil
+}
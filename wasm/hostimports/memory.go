@@ -0,0 +1,24 @@
+package hostimports
+
+import (
+	"fmt"
+)
+
+// hostModuleName is the import module name all driver host functions are
+// provided under.
+const hostModuleName = "env"
+
+// memoryRange returns the [ptr, ptr+length) range of the module memory, failing
+// if the module doesn't export memory or the range is out of bounds.
+func memoryRange(memory []byte, ptr, length int32) ([]byte, error) {
+	if memory == nil {
+		return nil, fmt.Errorf("module doesn't export memory")
+	}
+
+	if ptr < 0 || length < 0 || int64(ptr)+int64(length) > int64(len(memory)) {
+		return nil, fmt.Errorf("memory range [%d, %d) is out of bounds (memory size %d)",
+			ptr, int64(ptr)+int64(length), len(memory))
+	}
+
+	return memory[ptr : ptr+length], nil
+}
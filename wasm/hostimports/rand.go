@@ -0,0 +1,51 @@
+package hostimports
+
+import (
+	"math/rand"
+	"sync"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// Rand is a seeded pseudo-random number generator backing the
+// env.rand_seeded host import. The same seed yields the same sequence, so
+// simulations and tests get reproducible randomness.
+type Rand struct {
+	rng  *rand.Rand
+	lock sync.Mutex
+}
+
+// NewRand returns a generator seeded with seed.
+func NewRand(seed int64) *Rand {
+	return &Rand{
+		// Reproducibility is the point, the generator must not be used for
+		// anything security sensitive.
+		//nolint:gosec
+		rng: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Next returns the next pseudo-random value of the sequence.
+func (r *Rand) Next() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	//nolint:gosec
+	return int64(r.rng.Uint64())
+}
+
+// HostFuncs returns the host functions exposing the generator to modules:
+//
+//	rand_seeded() i64
+func (r *Rand) HostFuncs() []interfaces.HostFunc {
+	return []interfaces.HostFunc{
+		{
+			Module:  hostModuleName,
+			Name:    "rand_seeded",
+			Results: []interfaces.ValueType{interfaces.ValueTypeI64},
+			Call: func(_memory []byte, _args []interface{}) ([]interface{}, error) {
+				return []interface{}{r.Next()}, nil
+			},
+		},
+	}
+}
@@ -0,0 +1,36 @@
+package hostimports
+
+import (
+	"slices"
+	"testing"
+)
+
+func sequence(r *Rand, n int) []int64 {
+	values := make([]int64, n)
+	for i := range values {
+		values[i] = r.Next()
+	}
+
+	return values
+}
+
+func TestRandSequenceReproducible(t *testing.T) {
+	first, second := sequence(NewRand(42), 8), sequence(NewRand(42), 8)
+	if !slices.Equal(first, second) {
+		t.Errorf("sequences of the same seed differ: %v and %v", first, second)
+	}
+
+	if other := sequence(NewRand(43), 8); slices.Equal(first, other) {
+		t.Errorf("sequences of different seeds are equal: %v", first)
+	}
+
+	// Modules get the values through the host import.
+	results, err := NewRand(42).HostFuncs()[0].Call(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0] != first[0] {
+		t.Errorf("host import returned %v, expected %d", results[0], first[0])
+	}
+}
@@ -0,0 +1,43 @@
+package hostimports
+
+import (
+	"sync/atomic"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// StopSignal is the signal the task is asked to stop with. It backs the
+// env.stop_signal host import, so modules polling it can finish on their own
+// before the kill timeout, like processes handling the stop signal.
+type StopSignal struct {
+	signal atomic.Int32
+}
+
+// NewStopSignal returns a stop signal which isn't raised.
+func NewStopSignal() *StopSignal {
+	return &StopSignal{}
+}
+
+// Raise records the number of the signal the task is asked to stop with.
+func (s *StopSignal) Raise(signal int32) {
+	s.signal.Store(signal)
+}
+
+// HostFuncs returns the host functions exposing the stop signal to modules:
+//
+//	stop_signal() i32
+//
+// stop_signal returns the number of the signal the task is asked to stop with,
+// or 0 if it isn't stopped.
+func (s *StopSignal) HostFuncs() []interfaces.HostFunc {
+	return []interfaces.HostFunc{
+		{
+			Module:  hostModuleName,
+			Name:    "stop_signal",
+			Results: []interfaces.ValueType{interfaces.ValueTypeI32},
+			Call: func(_memory []byte, _args []interface{}) ([]interface{}, error) {
+				return []interface{}{s.signal.Load()}, nil
+			},
+		},
+	}
+}
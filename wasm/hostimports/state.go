@@ -0,0 +1,132 @@
+package hostimports
+
+import (
+	"sync"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+const (
+	// stateNotFound is returned by state_get when the key is not set.
+	stateNotFound int32 = -1
+	// stateLimitExceeded is returned by state_set when storing the value would
+	// exceed the configured state size.
+	stateLimitExceeded int32 = -1
+)
+
+// State is a bounded key/value store scoped to a single task. It backs the
+// env.state_get and env.state_set host imports.
+type State struct {
+	values   map[string][]byte
+	size     int
+	maxBytes int
+	lock     sync.Mutex
+}
+
+// NewState returns an empty state which can hold up to maxBytes of keys and
+// values in total.
+func NewState(maxBytes int) *State {
+	return &State{
+		values:   make(map[string][]byte),
+		maxBytes: maxBytes,
+	}
+}
+
+// Get returns the value stored under key.
+func (s *State) Get(key string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	value, ok := s.values[key]
+
+	return value, ok
+}
+
+// Set stores value under key and reports whether it fits the size bound.
+func (s *State) Set(key string, value []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	newSize := s.size + len(key) + len(value)
+	if old, ok := s.values[key]; ok {
+		newSize -= len(key) + len(old)
+	}
+
+	if newSize > s.maxBytes {
+		return false
+	}
+
+	s.values[key] = append([]byte(nil), value...)
+	s.size = newSize
+
+	return true
+}
+
+// HostFuncs returns the host functions exposing the state to modules:
+//
+//	state_get(key_ptr, key_len, out_ptr, out_len i32) i32
+//	state_set(key_ptr, key_len, val_ptr, val_len i32) i32
+//
+// state_get copies at most out_len bytes of the value and returns its full
+// length, or -1 if the key is not set. state_set returns 0 on success and -1
+// if the state size limit would be exceeded.
+func (s *State) HostFuncs() []interfaces.HostFunc {
+	i32 := interfaces.ValueTypeI32
+
+	return []interfaces.HostFunc{
+		{
+			Module:  hostModuleName,
+			Name:    "state_get",
+			Params:  []interfaces.ValueType{i32, i32, i32, i32},
+			Results: []interfaces.ValueType{i32},
+			Call:    s.stateGet,
+		},
+		{
+			Module:  hostModuleName,
+			Name:    "state_set",
+			Params:  []interfaces.ValueType{i32, i32, i32, i32},
+			Results: []interfaces.ValueType{i32},
+			Call:    s.stateSet,
+		},
+	}
+}
+
+func (s *State) stateGet(memory []byte, args []interface{}) ([]interface{}, error) {
+	key, err := memoryRange(memory, args[0].(int32), args[1].(int32))
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := memoryRange(memory, args[2].(int32), args[3].(int32))
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := s.Get(string(key))
+	if !ok {
+		return []interface{}{stateNotFound}, nil
+	}
+
+	copy(out, value)
+
+	//nolint:gosec
+	return []interface{}{int32(len(value))}, nil
+}
+
+func (s *State) stateSet(memory []byte, args []interface{}) ([]interface{}, error) {
+	key, err := memoryRange(memory, args[0].(int32), args[1].(int32))
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := memoryRange(memory, args[2].(int32), args[3].(int32))
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.Set(string(key), value) {
+		return []interface{}{stateLimitExceeded}, nil
+	}
+
+	return []interface{}{int32(0)}, nil
+}
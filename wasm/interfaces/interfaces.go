@@ -1,20 +1,217 @@
 package interfaces
 
 import (
+	"time"
+
 	"github.com/bluele/gcache"
 	"github.com/hashicorp/go-hclog"
 )
 
 type Engine interface {
 	Name() string
-	Init(logger hclog.Logger, moduleCache gcache.Cache)
-	InstantiateModule(modulePath string) (WasmInstance, error)
-	PrePopulateCache(modulesDir string) (int, error)
+	Init(logger hclog.Logger, moduleCache gcache.Cache, cacheOpts CacheOptions)
+	InstantiateModule(modulePath string, conf InstanceConfig) (WasmInstance, error)
+	PrePopulateCache(modulesDir string, policy PreCachePolicy) (int, error)
+	// VerifyCache checks that the cached modules still load, removes the ones
+	// which don't and returns their number.
+	VerifyCache() (int, error)
+	// Info describes the runtime backing the engine.
+	Info() EngineInfo
+}
+
+// EngineInfo describes the runtime backing an engine, so jobs can be
+// constrained to nodes supporting what their modules need.
+type EngineInfo struct {
+	// Features reports whether the engine runs modules using the named WASM
+	// proposals.
+	Features map[string]bool
+	// Version is the version of the runtime library.
+	Version string
+	// FuelPerMs is the fuel instances consume per millisecond on the node,
+	// zero if the engine doesn't support fuel or isn't calibrated yet.
+	FuelPerMs uint64
+	// Fuel reports whether the engine supports limiting the fuel instances
+	// consume.
+	Fuel bool
 }
 
 type WasmInstance interface {
 	CallFunc(funcName string, args ...interface{}) (interface{}, error)
 	GetMemoryRange(start int32, size int32) ([]byte, error)
+	// MemoryPages returns the current size of the exported memory in pages.
+	MemoryPages() (uint64, error)
+	// GrowMemory grows the exported memory by the given number of pages.
+	GrowMemory(deltaPages uint64) error
+	// FuncParams returns the parameter types of the exported function.
+	FuncParams(funcName string) ([]ValueType, error)
+	// FuncResults returns the result types of the exported function.
+	FuncResults(funcName string) ([]ValueType, error)
+	// Statistics returns the execution statistics collected by the engine,
+	// reporting false if the instance doesn't collect them.
+	Statistics() (ExecutionStatistics, bool)
+	// ModuleCached reports whether the module was served from the modules
+	// cache rather than compiled for the instance.
+	ModuleCached() bool
 	Stop()
 	Cleanup()
 }
+
+// Modules cache key strategies.
+const (
+	// CacheKeyPath keys cached modules by their path only, so a module changed
+	// on disk keeps being served from cache until it is evicted.
+	CacheKeyPath = "path"
+	// CacheKeyMtime keys cached modules by their path, size and modification
+	// time, so a changed module is detected without reading its content.
+	CacheKeyMtime = "mtime"
+	// CacheKeyContent keys cached modules by the SHA-256 of their content, so
+	// identical modules at different paths share an entry and a module changed
+	// in place is always compiled again.
+	CacheKeyContent = "content"
+)
+
+// CacheOptions defines how an engine uses its modules cache.
+type CacheOptions struct {
+	// KeyStrategy is one of the CacheKey* strategies.
+	KeyStrategy string
+	// DiskPath is the directory compiled modules are persisted to, so they
+	// survive plugin restarts. Empty disables the disk cache.
+	DiskPath string
+	// MaxModuleSize is the size in bytes above which modules are loaded from
+	// file instead of being cached. Zero means no limit.
+	MaxModuleSize int64
+	// DiskMaxSize is the size in bytes above which the least recently used
+	// modules are removed from the disk cache. Zero means no limit.
+	DiskMaxSize int64
+}
+
+// Pre-cache error handling modes.
+const (
+	// PreCacheOnErrorFail aborts pre-caching on the first module failure.
+	PreCacheOnErrorFail = "fail"
+	// PreCacheOnErrorSkip logs the failed module and continues with the rest.
+	PreCacheOnErrorSkip = "skip"
+	// PreCacheOnErrorRetry retries the failed module with backoff and skips it
+	// once the retries are exhausted.
+	PreCacheOnErrorRetry = "retry"
+)
+
+// PreCachePolicy defines how module failures are handled during pre-caching.
+type PreCachePolicy struct {
+	// OnError is one of the PreCacheOnError* modes.
+	OnError string
+	// Retries is the number of additional attempts in retry mode.
+	Retries int
+	// Backoff is the delay before the first retry, doubled on each attempt.
+	Backoff time.Duration
+}
+
+// InstanceConfig holds per-instance settings passed to an engine when a module
+// is instantiated.
+type InstanceConfig struct {
+	// Wasi enables WASI for the instance if set.
+	Wasi *WasiOptions
+	// ModuleSHA256 is the hex encoded SHA-256 of Module, if already known,
+	// so the module isn't hashed again to key it in the modules cache by
+	// content.
+	ModuleSHA256 string
+	// HostFuncs are linked into the instance as imports.
+	HostFuncs []HostFunc
+	// Module is the module binary, if the driver already read the module
+	// file, so engines compile the content the driver checked instead of
+	// reading the file again.
+	Module []byte
+	// MaxMemoryPages is the number of pages the instance memory can't grow
+	// past, if the engine supports limiting it. Zero means no limit.
+	MaxMemoryPages uint64
+	// FuelLimit is the fuel the instance can consume before it traps, if the
+	// engine supports fuel metering. Zero disables fuel metering.
+	FuelLimit uint64
+	// Statistics enables collecting execution statistics, if the engine
+	// supports it.
+	Statistics bool
+}
+
+// ExecutionStatistics describe the execution of an instance.
+type ExecutionStatistics struct {
+	// InstrCount is the number of executed instructions.
+	InstrCount uint64
+	// TotalCost is the gas consumed by the executed instructions.
+	TotalCost uint64
+	// InstrPerSecond is the execution speed in instructions per second.
+	InstrPerSecond float64
+	// FuelConsumed and FuelRemaining are the fuel the instance consumed and
+	// has left.
+	FuelConsumed  uint64
+	FuelRemaining uint64
+	// Instructions reports whether InstrCount, TotalCost and InstrPerSecond
+	// are collected.
+	Instructions bool
+	// Fuel reports whether FuelConsumed and FuelRemaining are collected.
+	Fuel bool
+}
+
+// WasiOptions defines the WASI environment of an instance.
+type WasiOptions struct {
+	// Env defines the module environment variables.
+	Env map[string]string
+	// StdoutPath and StderrPath are the files the module stdout and stderr are
+	// written to. Output is discarded if a path is empty.
+	StdoutPath string
+	StderrPath string
+	// StdinPath is the file the module stdin is read from, the module reads
+	// no input if it is empty.
+	StdinPath string
+	// PreopenDirs defines the host directories the module can access.
+	PreopenDirs []PreopenDir
+}
+
+// PreopenDir maps a host directory to the path the module accesses it with.
+type PreopenDir struct {
+	HostPath  string
+	GuestPath string
+}
+
+// ValueType is a WASM value type used in host function signatures.
+type ValueType int
+
+const (
+	ValueTypeI32 ValueType = iota
+	ValueTypeI64
+	// ValueTypeOther is any value type the driver doesn't pass, e.g. floats.
+	ValueTypeOther
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case ValueTypeI32:
+		return "i32"
+	case ValueTypeI64:
+		return "i64"
+	default:
+		return "other"
+	}
+}
+
+// HostFunc is a function implemented by the driver that WASM modules can import.
+type HostFunc struct {
+	// Call receives the linear memory exported by the calling module (nil if
+	// the module doesn't export one) and the call arguments as int32/int64
+	// values. A returned error traps the calling module.
+	Call func(memory []byte, args []interface{}) ([]interface{}, error)
+	// Module and Name define the import the function is provided as.
+	Module  string
+	Name    string
+	Params  []ValueType
+	Results []ValueType
+}
+
+// ModuleLoader acquires modules from a source, selected by the scheme of the
+// task modulePath (e.g. "file" for "file:///modules/sum.wasm").
+type ModuleLoader interface {
+	// Scheme returns the modulePath scheme handled by the loader.
+	Scheme() string
+	// Load makes the module available on the local file system and returns the
+	// path of the module file.
+	Load(modulePath string) (string, error)
+}
@@ -0,0 +1,55 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"huawei.com/wasm-task-driver/wasm/loaders"
+)
+
+const schemePrefix = loaders.DefaultScheme + "://"
+
+func init() {
+	loaders.Register(&localLoader{})
+}
+
+// localLoader loads modules from the local file system. Module paths may be
+// plain paths or file URLs.
+type localLoader struct{}
+
+func (l *localLoader) Scheme() string {
+	return loaders.DefaultScheme
+}
+
+// Load checks that the module file exists, is readable and isn't empty, so a
+// mistyped path fails the task right away with an error naming it.
+func (l *localLoader) Load(modulePath string) (string, error) {
+	path := strings.TrimPrefix(modulePath, schemePrefix)
+
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("module path %s doesn't exist", path)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if stat.IsDir() {
+		return "", fmt.Errorf("module path %s is a directory", path)
+	}
+
+	if stat.Size() == 0 {
+		return "", fmt.Errorf("module path %s is an empty file", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("module path %s isn't readable: %w", path, err)
+	}
+
+	_ = file.Close()
+
+	return path, nil
+}
@@ -0,0 +1,41 @@
+package loaders
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// DefaultScheme is the scheme of module paths which don't specify one.
+const DefaultScheme = "file"
+
+var loaders = make(map[string]interfaces.ModuleLoader)
+
+func Register(loader interfaces.ModuleLoader) {
+	loaders[loader.Scheme()] = loader
+}
+
+// Get returns the loader handling the scheme of the module path.
+func Get(modulePath string) (interfaces.ModuleLoader, error) {
+	scheme := Scheme(modulePath)
+
+	loader, found := loaders[scheme]
+	if !found {
+		return nil, errors.Errorf("unable to find module loader for scheme: %s", scheme)
+	}
+
+	return loader, nil
+}
+
+// Scheme returns the scheme of the module path, or DefaultScheme if it has
+// none.
+func Scheme(modulePath string) string {
+	scheme, _, found := strings.Cut(modulePath, "://")
+	if !found {
+		return DefaultScheme
+	}
+
+	return scheme
+}
@@ -0,0 +1,149 @@
+package wasm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// memoryOvercommit lets the module memory grow past the memory limit for a
+// grace period, like container runtimes allowing brief bursts. WASM memory
+// never shrinks, so a burst lasts until the task finishes: a task finishing
+// within the grace period is only warned about, a task still running once it
+// expires is stopped. Instances aren't safe for concurrent use, so the memory
+// size is observed by the goroutine running the module, between execution
+// phases and whenever the module calls a host function. A nil overcommit
+// observes nothing.
+type memoryOvercommit struct {
+	logger hclog.Logger
+	// stop interrupts the module once the grace period expires.
+	stop  func()
+	timer *time.Timer
+	// err is set once the grace period expires while the module runs.
+	err        error
+	exceededAt time.Time
+	grace      time.Duration
+	limitBytes uint64
+	limitMB    int64
+	lock       sync.Mutex
+	exceeded   bool
+	finished   bool
+}
+
+// newMemoryOvercommit returns the overcommit of the memory configuration, or
+// nil if memory is unlimited or can't be overcommitted.
+func newMemoryOvercommit(logger hclog.Logger, memoryConf MemoryConfig) *memoryOvercommit {
+	if memoryConf.LimitMB <= 0 || memoryConf.OvercommitMB <= 0 {
+		return nil
+	}
+
+	return &memoryOvercommit{
+		logger:     logger,
+		grace:      time.Duration(memoryConf.OvercommitGrace) * time.Second,
+		limitBytes: memoryConf.limitPages() * wasmPageSize,
+		limitMB:    memoryConf.LimitMB,
+	}
+}
+
+// wrapHostFuncs returns the host functions observing the memory of the calling
+// module before each call, so a module which runs for long can't exceed the
+// limit unnoticed while it calls host functions.
+func (o *memoryOvercommit) wrapHostFuncs(hostFuncs []interfaces.HostFunc) []interfaces.HostFunc {
+	if o == nil {
+		return hostFuncs
+	}
+
+	wrapped := make([]interfaces.HostFunc, len(hostFuncs))
+
+	for i, hostFunc := range hostFuncs {
+		call := hostFunc.Call
+
+		wrapped[i] = hostFunc
+		wrapped[i].Call = func(memory []byte, args []interface{}) ([]interface{}, error) {
+			o.observe(uint64(len(memory)))
+
+			return call(memory, args)
+		}
+	}
+
+	return wrapped
+}
+
+// observe starts the grace period the first time the module memory is seen
+// exceeding the limit.
+func (o *memoryOvercommit) observe(memoryBytes uint64) {
+	if o == nil || memoryBytes <= o.limitBytes {
+		return
+	}
+
+	o.lock.Lock()
+
+	if o.exceeded || o.finished {
+		o.lock.Unlock()
+
+		return
+	}
+
+	o.exceeded, o.exceededAt = true, time.Now()
+	o.timer = time.AfterFunc(o.grace, o.expire)
+
+	o.lock.Unlock()
+
+	o.logger.Warn("module memory exceeds the memory limit, overcommit grace period started",
+		"memory_bytes", memoryBytes, "limit_mb", o.limitMB, "grace", o.grace)
+}
+
+// expire fails the task whose memory still exceeds the limit once the grace
+// period expired and interrupts the module.
+func (o *memoryOvercommit) expire() {
+	o.lock.Lock()
+
+	if o.finished {
+		o.lock.Unlock()
+
+		return
+	}
+
+	o.err = fmt.Errorf("module memory exceeded the memory limit of %d MB for longer than the overcommit grace of %v",
+		o.limitMB, o.grace)
+	stop := o.stop
+
+	o.lock.Unlock()
+
+	o.logger.Warn("module memory overuse is sustained, interrupting task", "limit_mb", o.limitMB, "grace", o.grace)
+
+	if stop != nil {
+		stop()
+	}
+}
+
+// finish ends the observation once the module finished running and returns the
+// error of a sustained overuse, nil if the module memory stayed within the
+// limit or the burst ended within the grace period.
+func (o *memoryOvercommit) finish() error {
+	if o == nil {
+		return nil
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.finished = true
+
+	if !o.exceeded {
+		return nil
+	}
+
+	o.timer.Stop()
+
+	if o.err == nil {
+		o.logger.Info("module memory burst past the memory limit ended within the overcommit grace",
+			"limit_mb", o.limitMB, "burst", time.Since(o.exceededAt))
+	}
+
+	return o.err
+}
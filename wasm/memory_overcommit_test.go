@@ -0,0 +1,67 @@
+package wasm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemoryOvercommitGrace(t *testing.T) {
+	logs := &lockedBuffer{}
+	d := newTestDriver(t, testPluginConfig, logs)
+
+	// The pre main hook grows the memory past the 1 MB limit, within the 1 MB
+	// overcommit.
+	growPastLimit := func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+		return nil, instance.GrowMemory(20)
+	}
+
+	for _, test := range []struct {
+		main fakeFunc
+		name string
+		err  string
+	}{
+		{name: "burst", main: returnValue(int32(0))},
+		{name: "sustained", main: blockUntilStopped(), err: "for longer than the overcommit grace of 1s"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(1).
+					withFunc("pre_main", growPastLimit).
+					withFunc("handle_buffer", test.main)
+			})
+
+			cfg := newTestTask(t, test.name, `engine = "fake"
+memory {
+  limitMB         = 1
+  overcommitMB    = 1
+  overcommitGrace = 1
+}
+hooks {
+  enabled         = true
+  preMainFuncName = "pre_main"
+}`)
+
+			result := runTask(t, d, cfg)
+
+			if confs := testEngine.instanceConfs(); len(confs) != 1 || confs[0].MaxMemoryPages != 32 {
+				t.Errorf("expected the memory to be limited to the limit and overcommit, but got %+v", confs)
+			}
+
+			if test.err == "" {
+				if result.Err != nil {
+					t.Fatalf("expected a burst within the grace to be allowed, but got %v", result.Err)
+				}
+
+				if len(logRecords(t, logs, "module memory burst past the memory limit ended within the overcommit grace")) != 1 {
+					t.Error("expected the burst to be logged")
+				}
+
+				return
+			}
+
+			if result.Err == nil || !strings.Contains(result.Err.Error(), test.err) {
+				t.Errorf("expected the sustained overuse to fail the task with %q, but got %v", test.err, result.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package modinfo
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+// exportKindFunc is the export section encoding of a function export.
+const exportKindFunc = 0x00
+
+// FuncExports returns the sorted names of the functions a WASM module binary
+// exports.
+func FuncExports(wasm []byte) ([]string, error) {
+	if !bytes.HasPrefix(wasm, wasmHeader) {
+		return nil, errors.New("not a WASM module binary")
+	}
+
+	exports := make([]string, 0)
+
+	err := walkSections(wasm, func(id byte, r *reader) error {
+		if id != sectionExport {
+			return nil
+		}
+
+		n, err := r.vecLen()
+		if err != nil {
+			return err
+		}
+
+		for i := uint64(0); i < n; i++ {
+			var (
+				name string
+				kind byte
+			)
+
+			if name, err = r.str(); err != nil {
+				return err
+			}
+
+			if kind, err = r.byte(); err != nil {
+				return err
+			}
+
+			if _, err = r.uleb(); err != nil {
+				return err
+			}
+
+			if kind == exportKindFunc {
+				exports = append(exports, name)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(exports)
+
+	return exports, nil
+}
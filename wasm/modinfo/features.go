@@ -0,0 +1,435 @@
+// Package modinfo inspects WASM module binaries without instantiating them.
+package modinfo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Feature names of the WASM proposals reported by Features.
+const (
+	FeatureSIMD              = "simd"
+	FeatureThreads           = "threads"
+	FeatureMemory64          = "memory64"
+	FeatureMultiMemory       = "multi-memory"
+	FeatureBulkMemory        = "bulk-memory"
+	FeatureReferenceTypes    = "reference-types"
+	FeatureMultiValue        = "multi-value"
+	FeatureExceptionHandling = "exception-handling"
+
+	// FeatureComponentModel is never reported by Features, components aren't
+	// core modules. It names engine support for components.
+	FeatureComponentModel = "component-model"
+)
+
+// Section IDs of the WASM binary format.
+const (
+	sectionType      = 1
+	sectionImport    = 2
+	sectionTable     = 4
+	sectionMemory    = 5
+	sectionGlobal    = 6
+	sectionExport    = 7
+	sectionCode      = 10
+	sectionData      = 11
+	sectionDataCount = 12
+	sectionTag       = 13
+)
+
+// Value and type encodings of the WASM binary format.
+const (
+	valTypeV128      = 0x7b
+	refTypeExternRef = 0x6f
+	funcTypeForm     = 0x60
+
+	limitsHasMax   = 0x01
+	limitsShared   = 0x02
+	limitsMemory64 = 0x04
+
+	importKindFunc   = 0x00
+	importKindTable  = 0x01
+	importKindMemory = 0x02
+	importKindGlobal = 0x03
+	importKindTag    = 0x04
+)
+
+var wasmHeader = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// Features scans the sections of a WASM module binary and returns the sorted
+// names of the WASM proposals the module uses.
+//
+// Detection is based on the module structure: types, imports, tables,
+// memories, globals, locals and data segments. Instructions aren't decoded:
+// SIMD is detected only through v128 values in function types, globals and
+// locals, a module which uses SIMD instructions without any of them isn't
+// reported as using SIMD.
+func Features(wasm []byte) ([]string, error) {
+	if !bytes.HasPrefix(wasm, wasmHeader) {
+		return nil, errors.New("not a WASM module binary")
+	}
+
+	s := &scanner{features: make(map[string]bool)}
+
+	if err := walkSections(wasm, s.section); err != nil {
+		return nil, err
+	}
+
+	if s.memories > 1 {
+		s.features[FeatureMultiMemory] = true
+	}
+
+	if s.tables > 1 {
+		s.features[FeatureReferenceTypes] = true
+	}
+
+	result := make([]string, 0, len(s.features))
+	for feature := range s.features {
+		result = append(result, feature)
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// walkSections calls fn with the ID and the content of every section of the
+// module binary, which must start with the WASM header.
+func walkSections(wasm []byte, fn func(id byte, r *reader) error) error {
+	r := &reader{data: wasm, pos: len(wasmHeader)}
+
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		size, err := r.uleb()
+		if err != nil {
+			return err
+		}
+
+		content, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(id, &reader{data: content}); err != nil {
+			return fmt.Errorf("unable to parse section %d: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+type scanner struct {
+	features map[string]bool
+	memories int
+	tables   int
+}
+
+func (s *scanner) section(id byte, r *reader) error {
+	switch id {
+	case sectionType:
+		return s.typeSection(r)
+	case sectionImport:
+		return s.importSection(r)
+	case sectionTable:
+		return s.vec(r, s.table)
+	case sectionMemory:
+		return s.vec(r, s.memory)
+	case sectionGlobal:
+		return s.vec(r, s.global)
+	case sectionCode:
+		return s.vec(r, s.code)
+	case sectionData:
+		return s.vec(r, s.data)
+	case sectionDataCount:
+		s.features[FeatureBulkMemory] = true
+	case sectionTag:
+		s.features[FeatureExceptionHandling] = true
+	}
+
+	return nil
+}
+
+func (s *scanner) vec(r *reader, item func(r *reader) error) error {
+	n, err := r.vecLen()
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if err := item(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *scanner) valType(t byte) {
+	switch t {
+	case valTypeV128:
+		s.features[FeatureSIMD] = true
+	case refTypeExternRef:
+		s.features[FeatureReferenceTypes] = true
+	}
+}
+
+func (s *scanner) valTypes(r *reader) (int, error) {
+	n, err := r.vecLen()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		t, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		s.valType(t)
+	}
+
+	return int(n), nil
+}
+
+func (s *scanner) typeSection(r *reader) error {
+	return s.vec(r, func(r *reader) error {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		if form != funcTypeForm {
+			return fmt.Errorf("unsupported type form 0x%x", form)
+		}
+
+		if _, err = s.valTypes(r); err != nil {
+			return err
+		}
+
+		results, err := s.valTypes(r)
+		if err != nil {
+			return err
+		}
+
+		if results > 1 {
+			s.features[FeatureMultiValue] = true
+		}
+
+		return nil
+	})
+}
+
+func (s *scanner) importSection(r *reader) error {
+	return s.vec(r, func(r *reader) error {
+		if err := r.name(); err != nil {
+			return err
+		}
+
+		if err := r.name(); err != nil {
+			return err
+		}
+
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case importKindFunc:
+			_, err = r.uleb()
+		case importKindTable:
+			err = s.table(r)
+		case importKindMemory:
+			err = s.memory(r)
+		case importKindGlobal:
+			err = s.globalType(r)
+		case importKindTag:
+			s.features[FeatureExceptionHandling] = true
+
+			if _, err = r.byte(); err == nil {
+				_, err = r.uleb()
+			}
+		default:
+			err = fmt.Errorf("unsupported import kind 0x%x", kind)
+		}
+
+		return err
+	})
+}
+
+// limits reads table or memory limits and returns their flags.
+func (s *scanner) limits(r *reader) (byte, error) {
+	flags, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := r.uleb(); err != nil {
+		return 0, err
+	}
+
+	if flags&limitsHasMax != 0 {
+		if _, err := r.uleb(); err != nil {
+			return 0, err
+		}
+	}
+
+	return flags, nil
+}
+
+func (s *scanner) table(r *reader) error {
+	s.tables++
+
+	refType, err := r.byte()
+	if err != nil {
+		return err
+	}
+
+	s.valType(refType)
+
+	_, err = s.limits(r)
+
+	return err
+}
+
+func (s *scanner) memory(r *reader) error {
+	s.memories++
+
+	flags, err := s.limits(r)
+	if err != nil {
+		return err
+	}
+
+	if flags&limitsShared != 0 {
+		s.features[FeatureThreads] = true
+	}
+
+	if flags&limitsMemory64 != 0 {
+		s.features[FeatureMemory64] = true
+	}
+
+	return nil
+}
+
+func (s *scanner) globalType(r *reader) error {
+	valType, err := r.byte()
+	if err != nil {
+		return err
+	}
+
+	s.valType(valType)
+
+	// mutability
+	_, err = r.byte()
+
+	return err
+}
+
+func (s *scanner) global(r *reader) error {
+	if err := s.globalType(r); err != nil {
+		return err
+	}
+
+	return s.constExpr(r)
+}
+
+// constExpr skips a constant expression terminated by the end opcode.
+func (s *scanner) constExpr(r *reader) error {
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case 0x0b: // end
+			return nil
+		case 0x41, 0x42: // i32.const, i64.const
+			err = r.sleb()
+		case 0x43: // f32.const
+			err = r.skip(4)
+		case 0x44: // f64.const
+			err = r.skip(8)
+		case 0x23, 0xd2: // global.get, ref.func
+			_, err = r.uleb()
+		case 0xd0: // ref.null
+			var refType byte
+
+			refType, err = r.byte()
+			s.valType(refType)
+		case 0xfd: // v128.const
+			s.features[FeatureSIMD] = true
+
+			if _, err = r.uleb(); err == nil {
+				err = r.skip(16)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *scanner) code(r *reader) error {
+	size, err := r.uleb()
+	if err != nil {
+		return err
+	}
+
+	body, err := r.bytes(size)
+	if err != nil {
+		return err
+	}
+
+	return s.vec(&reader{data: body}, func(r *reader) error {
+		if _, err := r.uleb(); err != nil {
+			return err
+		}
+
+		valType, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		s.valType(valType)
+
+		return nil
+	})
+}
+
+func (s *scanner) data(r *reader) error {
+	flags, err := r.uleb()
+	if err != nil {
+		return err
+	}
+
+	switch flags {
+	case 0:
+		err = s.constExpr(r)
+	case 1:
+		s.features[FeatureBulkMemory] = true
+	case 2:
+		if _, err = r.uleb(); err == nil {
+			err = s.constExpr(r)
+		}
+	default:
+		err = fmt.Errorf("unsupported data segment flags 0x%x", flags)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	n, err := r.uleb()
+	if err != nil {
+		return err
+	}
+
+	return r.skip(n)
+}
@@ -0,0 +1,102 @@
+package modinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// CapMemory returns the WASM module binary with the maximum size of the
+// memories it defines capped to maxPages, so engines refuse to grow them past
+// it. Memories without a maximum get maxPages as their maximum. It fails if a
+// memory needs more than maxPages initially. Imported memories aren't
+// changed, the driver never provides them.
+func CapMemory(wasm []byte, maxPages uint64) ([]byte, error) {
+	if !bytes.HasPrefix(wasm, wasmHeader) {
+		return nil, errors.New("not a WASM module binary")
+	}
+
+	capped := append(make([]byte, 0, len(wasm)+8), wasmHeader...)
+	r := &reader{data: wasm, pos: len(wasmHeader)}
+
+	for !r.eof() {
+		start := r.pos
+
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := r.uleb()
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+
+		if id != sectionMemory {
+			capped = append(capped, wasm[start:r.pos]...)
+
+			continue
+		}
+
+		section, err := capMemorySection(&reader{data: content}, maxPages)
+		if err != nil {
+			return nil, fmt.Errorf("unable to cap memory section: %w", err)
+		}
+
+		capped = append(capped, id)
+		capped = binary.AppendUvarint(capped, uint64(len(section)))
+		capped = append(capped, section...)
+	}
+
+	return capped, nil
+}
+
+// capMemorySection returns the memory section with the memory limits capped.
+func capMemorySection(r *reader, maxPages uint64) ([]byte, error) {
+	n, err := r.vecLen()
+	if err != nil {
+		return nil, err
+	}
+
+	section := binary.AppendUvarint(nil, n)
+
+	for i := uint64(0); i < n; i++ {
+		flags, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		initial, err := r.uleb()
+		if err != nil {
+			return nil, err
+		}
+
+		limit := maxPages
+
+		if flags&limitsHasMax != 0 {
+			declared, err := r.uleb()
+			if err != nil {
+				return nil, err
+			}
+
+			limit = min(declared, maxPages)
+		}
+
+		if initial > maxPages {
+			return nil, fmt.Errorf("memory %d needs %d pages initially, more than the limit of %d pages", i, initial,
+				maxPages)
+		}
+
+		section = append(section, flags|limitsHasMax)
+		section = binary.AppendUvarint(section, initial)
+		section = binary.AppendUvarint(section, limit)
+	}
+
+	return section, nil
+}
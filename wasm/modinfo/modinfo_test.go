@@ -0,0 +1,207 @@
+package modinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// module returns a WASM module binary made of the sections.
+func module(sections ...[]byte) []byte {
+	wasm := slices.Clone(wasmHeader)
+	for _, section := range sections {
+		wasm = append(wasm, section...)
+	}
+
+	return wasm
+}
+
+// section returns a section of the ID with the content.
+func section(id byte, content ...byte) []byte {
+	return append(binary.AppendUvarint([]byte{id}, uint64(len(content))), content...)
+}
+
+// v128Const is a v128.const instruction with a zero immediate.
+var v128Const = append([]byte{0xfd, 0x0c}, make([]byte, 16)...)
+
+func TestFeatures(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		wasm     []byte
+		features []string
+	}{
+		{name: "empty", wasm: module(), features: []string{}},
+		{
+			name:     "v128 param",
+			wasm:     module(section(sectionType, 0x01, funcTypeForm, 0x01, valTypeV128, 0x00)),
+			features: []string{FeatureSIMD},
+		},
+		{
+			name:     "v128 global",
+			wasm:     module(section(sectionGlobal, append([]byte{0x01, valTypeV128, 0x00}, append(v128Const, 0x0b)...)...)),
+			features: []string{FeatureSIMD},
+		},
+		{
+			name:     "v128 local",
+			wasm:     module(section(sectionCode, 0x01, 0x04, 0x01, 0x01, valTypeV128, 0x0b)),
+			features: []string{FeatureSIMD},
+		},
+		{
+			// Instructions aren't decoded, a v128 value which only lives on the
+			// operand stack goes unnoticed.
+			name: "v128 instructions only",
+			wasm: module(section(sectionCode,
+				append([]byte{0x01, byte(len(v128Const) + 3), 0x00}, append(v128Const, 0x1a, 0x0b)...)...)),
+			features: []string{},
+		},
+		{
+			name:     "multiple results",
+			wasm:     module(section(sectionType, 0x01, funcTypeForm, 0x00, 0x02, 0x7f, 0x7f)),
+			features: []string{FeatureMultiValue},
+		},
+		{
+			name:     "shared memories",
+			wasm:     module(section(sectionMemory, 0x02, limitsHasMax|limitsShared, 0x01, 0x02, 0x00, 0x01)),
+			features: []string{FeatureMultiMemory, FeatureThreads},
+		},
+	} {
+		features, err := Features(test.wasm)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+
+			continue
+		}
+
+		if !slices.Equal(features, test.features) {
+			t.Errorf("%s: expected features %v, but got %v", test.name, test.features, features)
+		}
+	}
+}
+
+func TestMalformedModulesRejected(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		err  string
+		wasm []byte
+		// memory is set if the error is in the memory section, which
+		// FuncExports skips.
+		memory bool
+	}{
+		{name: "not a module", wasm: []byte("not a module"), err: "not a WASM module binary"},
+		{name: "truncated section size", wasm: module([]byte{sectionType, 0x80}), err: errUnexpectedEnd.Error()},
+		{name: "section past end", wasm: module([]byte{sectionType, 0x05, 0x01}), err: errUnexpectedEnd.Error()},
+		{
+			name: "overlong section size",
+			wasm: module(append([]byte{sectionType}, bytes.Repeat([]byte{0x80}, 10)...)),
+			err:  "LEB128 value is too long",
+		},
+		{
+			name:   "vector past end",
+			wasm:   module(section(sectionMemory, 0x05, 0x00)),
+			err:    errUnexpectedEnd.Error(),
+			memory: true,
+		},
+		{
+			name:   "truncated memory limit",
+			wasm:   module(section(sectionMemory, 0x01, limitsHasMax, 0x01, 0x80)),
+			err:    errUnexpectedEnd.Error(),
+			memory: true,
+		},
+	} {
+		if _, err := Features(test.wasm); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected Features to fail with %q, but got %v", test.name, test.err, err)
+		}
+
+		if _, err := FuncExports(test.wasm); !test.memory && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected FuncExports to fail with %q, but got %v", test.name, test.err, err)
+		}
+
+		if _, err := CapMemory(test.wasm, 4); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected CapMemory to fail with %q, but got %v", test.name, test.err, err)
+		}
+	}
+}
+
+// memoryLimits returns the maximum pages of the memories the module defines.
+func memoryLimits(t *testing.T, wasm []byte) []uint64 {
+	t.Helper()
+
+	var limits []uint64
+
+	err := walkSections(wasm, func(id byte, r *reader) error {
+		if id != sectionMemory {
+			return nil
+		}
+
+		n, err := r.vecLen()
+		if err != nil {
+			return err
+		}
+
+		for i := uint64(0); i < n; i++ {
+			flags, err := r.byte()
+			if err != nil {
+				return err
+			}
+
+			if flags&limitsHasMax == 0 {
+				t.Errorf("memory %d has no maximum", i)
+			}
+
+			if _, err = r.uleb(); err != nil {
+				return err
+			}
+
+			limit, err := r.uleb()
+			if err != nil {
+				return err
+			}
+
+			limits = append(limits, limit)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return limits
+}
+
+func TestCapMemory(t *testing.T) {
+	exports := section(sectionExport, 0x01, 0x03, 'r', 'u', 'n', exportKindFunc, 0x00)
+
+	// Memories without a maximum, with a larger one and with a smaller one.
+	memories := section(sectionMemory, 0x03, 0x00, 0x01, limitsHasMax, 0x01, 0x08, limitsHasMax, 0x01, 0x02)
+
+	for _, maxPages := range []uint64{4, 65536} {
+		wasm := module(memories, exports)
+
+		capped, err := CapMemory(wasm, maxPages)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []uint64{maxPages, min(8, maxPages), 2}
+		if limits := memoryLimits(t, capped); !slices.Equal(limits, expected) {
+			t.Errorf("expected memories capped to %v, but got %v", expected, limits)
+		}
+
+		// The other sections are kept, and capping again changes nothing.
+		if funcs, err := FuncExports(capped); err != nil || !slices.Equal(funcs, []string{"run"}) {
+			t.Errorf("expected the exports to be kept, but got %v (%v)", funcs, err)
+		}
+
+		if again, err := CapMemory(capped, maxPages); err != nil || !bytes.Equal(again, capped) {
+			t.Errorf("expected capping the capped module to keep it, but got %v (%v)", again, err)
+		}
+	}
+
+	if _, err := CapMemory(module(section(sectionMemory, 0x01, 0x00, 0x05)), 4); err == nil ||
+		!strings.Contains(err.Error(), "memory 0 needs 5 pages initially, more than the limit of 4 pages") {
+		t.Errorf("expected a memory larger than the limit to be rejected, but got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package modinfo
+
+import (
+	"errors"
+)
+
+var errUnexpectedEnd = errors.New("unexpected end of module")
+
+// reader decodes the primitive values of the WASM binary format.
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) eof() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.eof() {
+		return 0, errUnexpectedEnd
+	}
+
+	b := r.data[r.pos]
+	r.pos++
+
+	return b, nil
+}
+
+func (r *reader) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errUnexpectedEnd
+	}
+
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+
+	return b, nil
+}
+
+func (r *reader) skip(n uint64) error {
+	_, err := r.bytes(n)
+
+	return err
+}
+
+// uleb reads an unsigned LEB128 encoded integer.
+func (r *reader) uleb() (uint64, error) {
+	var (
+		result uint64
+		shift  uint
+	)
+
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+
+		shift += 7
+		if shift >= 64 {
+			return 0, errors.New("LEB128 value is too long")
+		}
+	}
+}
+
+// sleb skips a signed LEB128 encoded integer, its value is never needed.
+func (r *reader) sleb() error {
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		if b&0x80 == 0 {
+			return nil
+		}
+	}
+}
+
+// vecLen reads the length of a vector and checks it can fit the remaining data.
+func (r *reader) vecLen() (uint64, error) {
+	n, err := r.uleb()
+	if err != nil {
+		return 0, err
+	}
+
+	if n > uint64(len(r.data)-r.pos) {
+		return 0, errUnexpectedEnd
+	}
+
+	return n, nil
+}
+
+// name skips a UTF-8 name.
+func (r *reader) name() error {
+	n, err := r.uleb()
+	if err != nil {
+		return err
+	}
+
+	return r.skip(n)
+}
+
+// str reads a UTF-8 name.
+func (r *reader) str() (string, error) {
+	n, err := r.uleb()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := r.bytes(n)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,159 @@
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// permGroupWrite and permWorldWrite are the group and others write bits.
+	permGroupWrite fs.FileMode = 0o020
+	permWorldWrite fs.FileMode = 0o002
+)
+
+// readModuleFile reads the module file, refusing module files whose ownership
+// or permissions would allow them to be tampered with, according to the plugin
+// policy. The file is opened once and checked through the opened descriptor,
+// so it can't be replaced between the check and the read, and the returned
+// content is what engines run.
+func readModuleFile(policy ModuleFilePolicyConfig, modulePath string) ([]byte, fs.FileInfo, error) {
+	if !policy.Enabled {
+		file, err := os.Open(modulePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+
+		return readOpenedModule(file)
+	}
+
+	// Symlinks are resolved once, the resolved path is then opened without
+	// following symlinks, so the checked directories are the ones the file is
+	// read from.
+	resolved, err := filepath.EvalSymlinks(modulePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for dir := filepath.Dir(resolved); ; dir = filepath.Dir(dir) {
+		if err = checkModuleDirPolicy(policy, dir); err != nil {
+			return nil, nil, err
+		}
+
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	file, err := os.OpenFile(resolved, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	perm := stat.Mode().Perm()
+
+	if !policy.AllowWorldWritable && perm&permWorldWrite != 0 {
+		return nil, nil, fmt.Errorf("module %s is world-writable (%v), which the module file policy denies", modulePath, perm)
+	}
+
+	if !policy.AllowGroupWritable && perm&permGroupWrite != 0 {
+		return nil, nil, fmt.Errorf("module %s is group-writable (%v), which the module file policy denies", modulePath, perm)
+	}
+
+	uid, err := fileOwner(stat)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get owner of module %s: %w", modulePath, err)
+	}
+
+	if !ownerAllowed(policy, uid) {
+		return nil, nil, fmt.Errorf("module %s is owned by uid %d, which the module file policy doesn't allow", modulePath, uid)
+	}
+
+	return readOpenedModule(file)
+}
+
+// readOpenedModule reads the opened module file and returns its content and
+// info.
+func readOpenedModule(file *os.File) ([]byte, fs.FileInfo, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, stat, nil
+}
+
+// checkModuleDirPolicy refuses directories containing module files if they
+// would allow the module to be replaced by another user. Directories writable
+// by other users are allowed with the sticky bit set, like /tmp, as only
+// their owner can then replace a file, and root owned directories are always
+// allowed.
+func checkModuleDirPolicy(policy ModuleFilePolicyConfig, dir string) error {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	perm := stat.Mode().Perm()
+	sticky := stat.Mode()&fs.ModeSticky != 0
+
+	if !policy.AllowWorldWritable && !sticky && perm&permWorldWrite != 0 {
+		return fmt.Errorf("module directory %s is world-writable (%v), which the module file policy denies", dir, perm)
+	}
+
+	if !policy.AllowGroupWritable && !sticky && perm&permGroupWrite != 0 {
+		return fmt.Errorf("module directory %s is group-writable (%v), which the module file policy denies", dir, perm)
+	}
+
+	uid, err := fileOwner(stat)
+	if err != nil {
+		return fmt.Errorf("unable to get owner of module directory %s: %w", dir, err)
+	}
+
+	if uid != 0 && !ownerAllowed(policy, uid) {
+		return fmt.Errorf("module directory %s is owned by uid %d, which the module file policy doesn't allow", dir, uid)
+	}
+
+	return nil
+}
+
+func fileOwner(stat fs.FileInfo) (uint32, error) {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unexpected file info %T", stat.Sys())
+	}
+
+	return sys.Uid, nil
+}
+
+// ownerAllowed reports whether the policy allows files owned by the UID. Any
+// owner is allowed if the policy doesn't list allowed owners.
+func ownerAllowed(policy ModuleFilePolicyConfig, uid uint32) bool {
+	if len(policy.AllowedOwners) == 0 {
+		return true
+	}
+
+	for _, owner := range policy.AllowedOwners {
+		//nolint:gosec
+		if uint32(owner) == uid {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package wasm
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModuleFilePolicy(t *testing.T) {
+	strict := ModuleFilePolicyConfig{Enabled: true}
+
+	for _, test := range []struct {
+		name     string
+		err      string
+		policy   ModuleFilePolicyConfig
+		dirPerm  fs.FileMode
+		filePerm fs.FileMode
+	}{
+		{name: "allowed", policy: strict, dirPerm: 0o755, filePerm: 0o644},
+		{name: "disabled", dirPerm: 0o777, filePerm: 0o666},
+		{name: "world-writable module", policy: strict, dirPerm: 0o755, filePerm: 0o666, err: "is world-writable"},
+		{name: "group-writable module", policy: strict, dirPerm: 0o755, filePerm: 0o664, err: "is group-writable"},
+		{
+			name:     "group-writable module allowed",
+			policy:   ModuleFilePolicyConfig{Enabled: true, AllowGroupWritable: true},
+			dirPerm:  0o755,
+			filePerm: 0o664,
+		},
+		{name: "world-writable dir", policy: strict, dirPerm: 0o777, filePerm: 0o644, err: "directory"},
+		{name: "sticky dir", policy: strict, dirPerm: 0o777 | fs.ModeSticky, filePerm: 0o644},
+		{
+			name:     "owner",
+			policy:   ModuleFilePolicyConfig{Enabled: true, AllowedOwners: []int64{int64(os.Getuid()) + 1}},
+			dirPerm:  0o755,
+			filePerm: 0o644,
+			err:      "the module file policy doesn't allow",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "modules")
+			modulePath := filepath.Join(dir, "module.wasm")
+
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			writeTestFile(t, modulePath, "module")
+
+			// Permissions are set explicitly, the umask would clear write bits.
+			if err := os.Chmod(modulePath, test.filePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.Chmod(dir, test.dirPerm); err != nil {
+				t.Fatal(err)
+			}
+
+			data, _, err := readModuleFile(test.policy, modulePath)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, but got %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(data) != "module" {
+				t.Errorf("unexpected module content %q", data)
+			}
+		})
+	}
+}
+
+func TestWorldWritableModuleRejected(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig+`
+moduleFilePolicy {
+  enabled = true
+}`, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	cfg := newTestTask(t, "writable", `engine = "fake"`)
+
+	if err := os.Chmod(filepath.Join(cfg.TaskDir().Dir, "module.wasm"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), "is world-writable") {
+		t.Fatalf("expected the world-writable module to be rejected, but got %v", err)
+	}
+}
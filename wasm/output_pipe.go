@@ -0,0 +1,82 @@
+package wasm
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// outputDrainTimeout bounds the wait for the module output written before it
+// returned to be relayed.
+const outputDrainTimeout = 500 * time.Millisecond
+
+// outputPipe is a pipe engines write the module output to, given to them as a
+// path, which the driver relays the output from. Engines keep the pipe open
+// until the instance is garbage collected, so the end of the output is
+// detected by the pipe staying empty once the module returned.
+type outputPipe struct {
+	reader *os.File
+	writer *os.File
+	done   chan struct{}
+	// path is the path of the pipe the module writes to.
+	path     string
+	draining atomic.Bool
+}
+
+func newOutputPipe() (*outputPipe, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return &outputPipe{
+		reader: reader,
+		writer: writer,
+		done:   make(chan struct{}),
+		path:   "/dev/fd/" + strconv.FormatUint(uint64(writer.Fd()), 10),
+	}, nil
+}
+
+// relay passes the output to write until the pipe is drained or closed.
+func (p *outputPipe) relay(write func(data []byte)) {
+	defer close(p.done)
+
+	buf := make([]byte, 4096)
+
+	for {
+		// Each read gets the whole timeout, so output buffered while write
+		// blocked is still relayed.
+		if p.draining.Load() {
+			_ = p.reader.SetReadDeadline(time.Now().Add(outputDrainTimeout))
+		}
+
+		n, err := p.reader.Read(buf)
+		if n > 0 {
+			write(buf[:n])
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// drain waits for the output written so far to be relayed and stops relaying.
+// It must be called once the module returned.
+func (p *outputPipe) drain() {
+	p.draining.Store(true)
+	_ = p.reader.SetReadDeadline(time.Now().Add(outputDrainTimeout))
+
+	<-p.done
+}
+
+// close stops relaying and closes the pipe. The output isn't drained.
+func (p *outputPipe) close() {
+	_ = p.writer.Close()
+	_ = p.reader.SetReadDeadline(time.Now())
+
+	<-p.done
+
+	_ = p.reader.Close()
+}
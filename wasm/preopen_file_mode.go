@@ -0,0 +1,112 @@
+package wasm
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// preopenFileMode is the mode applied to the files the module writes to a
+// preopened directory.
+type preopenFileMode struct {
+	hostPath string
+	mode     fs.FileMode
+}
+
+// preopenFileModes gives the module outputs predictable permissions: the
+// engines create files with their own modes, so the configured modes are
+// applied to the files the module wrote once it returns. Nil modes apply
+// nothing.
+type preopenFileModes struct {
+	startedAt time.Time
+	logger    hclog.Logger
+	modes     []preopenFileMode
+}
+
+// newPreopenFileModes returns the file modes of the task preopened
+// directories, or nil if none has a file mode.
+func newPreopenFileModes(logger hclog.Logger, cfg *drivers.TaskConfig, conf WasiConfig) (*preopenFileModes, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	var modes []preopenFileMode
+
+	for _, dir := range conf.PreopenDirs {
+		if dir.FileMode == "" {
+			continue
+		}
+
+		mode, err := strconv.ParseUint(dir.FileMode, 8, 32)
+		if err != nil || mode > uint64(fs.ModePerm) {
+			return nil, fmt.Errorf("WASI preopen dir %s file mode must be octal permissions, e.g. 0640, but specified %q",
+				dir.HostPath, dir.FileMode)
+		}
+
+		hostPath, err := resolvePreopenDir(cfg, dir.HostPath)
+		if err != nil {
+			return nil, err
+		}
+
+		modes = append(modes, preopenFileMode{hostPath: hostPath, mode: fs.FileMode(mode)})
+	}
+
+	if len(modes) == 0 {
+		return nil, nil
+	}
+
+	return &preopenFileModes{logger: logger, modes: modes}, nil
+}
+
+// start records the time the module starts at, the files modified since are
+// its outputs.
+func (m *preopenFileModes) start() {
+	if m == nil {
+		return
+	}
+
+	// File times may be truncated to the second by the file system.
+	m.startedAt = time.Now().Truncate(time.Second)
+}
+
+// apply applies the file modes to the files the module wrote, even if it
+// failed, as its partial outputs may be read. It must be called once the
+// module returned.
+func (m *preopenFileModes) apply() {
+	if m == nil {
+		return
+	}
+
+	for _, dir := range m.modes {
+		err := filepath.WalkDir(dir.hostPath, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+
+			if info.ModTime().Before(m.startedAt) || info.Mode().Perm() == dir.mode {
+				return nil
+			}
+
+			return os.Chmod(path, dir.mode)
+		})
+		if err != nil {
+			m.logger.Warn("unable to apply WASI preopen dir file mode", "path", dir.hostPath,
+				"error", hclog.Fmt("%+v", err))
+		}
+	}
+}
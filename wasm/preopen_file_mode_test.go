@@ -0,0 +1,89 @@
+package wasm
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreopenFileModeAppliedToModuleOutputs(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	cfg := newTestTask(t, "outputs", `engine = "fake"
+wasi {
+  enabled = true
+  preopenDirs {
+    hostPath  = "out"
+    guestPath = "/out"
+    fileMode  = "0640"
+  }
+}`)
+
+	outDir := filepath.Join(cfg.TaskDir().Dir, "out")
+	if err := os.MkdirAll(filepath.Join(outDir, "nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files written before the task started aren't outputs of the module.
+	input := filepath.Join(outDir, "input")
+	if err := os.WriteFile(input, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(input, time.Now(), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", func(*fakeInstance, []interface{}) (interface{}, error) {
+			for _, name := range []string{"result", filepath.Join("nested", "result")} {
+				if err := os.WriteFile(filepath.Join(outDir, name), nil, 0o600); err != nil {
+					return nil, err
+				}
+			}
+
+			return int32(0), nil
+		})
+	})
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	for name, expected := range map[string]fs.FileMode{
+		"result":                          0o640,
+		filepath.Join("nested", "result"): 0o640,
+		"input":                           0o600,
+	} {
+		info, err := os.Stat(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != expected {
+			t.Errorf("expected %s to have mode %v, but got %v", name, expected, info.Mode().Perm())
+		}
+	}
+}
+
+func TestInvalidPreopenFileModeRejected(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	cfg := newTestTask(t, "invalid", `engine = "fake"
+wasi {
+  enabled = true
+  preopenDirs {
+    hostPath  = "out"
+    guestPath = "/out"
+    fileMode  = "rw-r-----"
+  }
+}`)
+
+	_, _, err := d.StartTask(cfg)
+	if err == nil || !strings.Contains(err.Error(), "file mode must be octal permissions") {
+		t.Errorf("expected the task to fail to start, but got %v", err)
+	}
+}
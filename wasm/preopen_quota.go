@@ -0,0 +1,176 @@
+package wasm
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// preopenQuotaCheckInterval is the interval the size of the preopened
+// directories with a quota is checked at while the module runs.
+const preopenQuotaCheckInterval = time.Second
+
+// preopenQuota is the quota of a preopened directory.
+type preopenQuota struct {
+	hostPath   string
+	guestPath  string
+	quotaBytes int64
+}
+
+// preopenQuotas keeps a runaway module from filling the disk through its
+// preopened directories: their size is checked while the module runs, and the
+// module is interrupted once a directory exceeds its quota. They are checked
+// once more after the module returns, as a module may exceed a quota between
+// two checks. Nil quotas check nothing.
+type preopenQuotas struct {
+	logger hclog.Logger
+	// stop interrupts the module once a quota is exceeded.
+	stop   func()
+	done   chan struct{}
+	err    error
+	quotas []preopenQuota
+	wg     sync.WaitGroup
+	lock   sync.Mutex
+}
+
+// newPreopenQuotas returns the quotas of the task preopened directories, or
+// nil if none has a quota.
+func newPreopenQuotas(logger hclog.Logger, cfg *drivers.TaskConfig, conf WasiConfig) (*preopenQuotas, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	var quotas []preopenQuota
+
+	for _, dir := range conf.PreopenDirs {
+		if dir.QuotaBytes < 0 {
+			return nil, fmt.Errorf("WASI preopen dir %s quota must be >= 0, but specified %d", dir.HostPath, dir.QuotaBytes)
+		}
+
+		if dir.QuotaBytes == 0 {
+			continue
+		}
+
+		hostPath, err := resolvePreopenDir(cfg, dir.HostPath)
+		if err != nil {
+			return nil, err
+		}
+
+		quotas = append(quotas, preopenQuota{hostPath: hostPath, guestPath: dir.GuestPath, quotaBytes: dir.QuotaBytes})
+	}
+
+	if len(quotas) == 0 {
+		return nil, nil
+	}
+
+	return &preopenQuotas{logger: logger, quotas: quotas, done: make(chan struct{})}, nil
+}
+
+// start checks the quotas until finish is called.
+func (q *preopenQuotas) start() {
+	if q == nil {
+		return
+	}
+
+	q.wg.Add(1)
+
+	go func() {
+		defer q.wg.Done()
+
+		ticker := time.NewTicker(preopenQuotaCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-q.done:
+				return
+			case <-ticker.C:
+			}
+
+			if q.check() {
+				q.logger.Warn("WASI preopen dir quota exceeded, interrupting task", "error", q.err)
+
+				q.stop()
+
+				return
+			}
+		}
+	}()
+}
+
+// check records the error of the first exceeded quota and returns whether a
+// quota is exceeded.
+func (q *preopenQuotas) check() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.err != nil {
+		return true
+	}
+
+	for _, quota := range q.quotas {
+		size, err := dirSize(quota.hostPath)
+		if err != nil {
+			q.logger.Warn("unable to check WASI preopen dir quota", "guest_path", quota.guestPath,
+				"error", hclog.Fmt("%+v", err))
+
+			continue
+		}
+
+		if size > quota.quotaBytes {
+			q.err = fmt.Errorf("WASI preopen dir %s exceeded its quota of %d bytes with %d bytes",
+				quota.guestPath, quota.quotaBytes, size)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// finish stops checking the quotas once the module returned and returns the
+// error of an exceeded quota.
+func (q *preopenQuotas) finish() error {
+	if q == nil {
+		return nil
+	}
+
+	close(q.done)
+	q.wg.Wait()
+
+	q.check()
+
+	return q.err
+}
+
+// dirSize returns the size of the regular files in the directory and its
+// subdirectories.
+func dirSize(dir string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
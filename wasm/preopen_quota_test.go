@@ -0,0 +1,54 @@
+package wasm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModuleExceedingPreopenQuotaStopped(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	for _, test := range []struct {
+		main fakeFunc
+		name string
+	}{
+		{name: "running", main: blockUntilStopped()},
+		{name: "returned", main: returnValue(int32(0))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestTask(t, test.name, `engine = "fake"
+wasi {
+  enabled = true
+  preopenDirs {
+    hostPath   = "out"
+    guestPath  = "/out"
+    quotaBytes = 100
+  }
+}`)
+
+			outDir := filepath.Join(cfg.TaskDir().Dir, "out")
+			if err := os.Mkdir(outDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			useFakeInstances(t, func() *fakeInstance {
+				return newFakeInstance(1).withFunc("handle_buffer", func(instance *fakeInstance, args []interface{}) (interface{}, error) {
+					if err := os.WriteFile(filepath.Join(outDir, "result"), make([]byte, 150), 0o600); err != nil {
+						return nil, err
+					}
+
+					return test.main(instance, args)
+				})
+			})
+
+			result := runTask(t, d, cfg)
+
+			expected := "WASI preopen dir /out exceeded its quota of 100 bytes with 150 bytes"
+			if result.Err == nil || !strings.Contains(result.Err.Error(), expected) {
+				t.Errorf("expected the task to fail with %q, but got %v", expected, result.Err)
+			}
+		})
+	}
+}
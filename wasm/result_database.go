@@ -0,0 +1,125 @@
+package wasm
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	// Registers the postgres database/sql driver.
+	_ "github.com/lib/pq"
+)
+
+const (
+	// resultDatabaseDriverPostgres is the only supported result database driver.
+	resultDatabaseDriverPostgres = "postgres"
+
+	// resultDatabaseTimeout bounds writing a single task result.
+	resultDatabaseTimeout = 10 * time.Second
+)
+
+// resultTableName matches table names, optionally qualified with a schema.
+// The name is put into SQL statements as is, so nothing else is allowed.
+var resultTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// resultDatabase writes task results to a SQL table for audit and analytics
+// pipelines. Writes are best effort: failures are logged and never affect the
+// task. A nil resultDatabase discards all results.
+type resultDatabase struct {
+	logger hclog.Logger
+	db     *sql.DB
+	insert string
+	writes sync.WaitGroup
+	// lock guards closed, results written after Close are dropped
+	lock   sync.Mutex
+	closed bool
+}
+
+func newResultDatabase(logger hclog.Logger, conf ResultDatabaseConfig) (*resultDatabase, error) {
+	if conf.Driver != resultDatabaseDriverPostgres {
+		return nil, fmt.Errorf("result database driver must be %s, but specified %v",
+			resultDatabaseDriverPostgres, conf.Driver)
+	}
+
+	if !resultTableName.MatchString(conf.Table) {
+		return nil, fmt.Errorf("invalid result database table name %q", conf.Table)
+	}
+
+	return openResultDatabase(logger, conf)
+}
+
+// openResultDatabase opens the database of the validated configuration with
+// its database/sql driver.
+func openResultDatabase(logger hclog.Logger, conf ResultDatabaseConfig) (*resultDatabase, error) {
+	// Open doesn't connect, so an unavailable database doesn't fail the plugin
+	// configuration and is reported on writes instead.
+	db, err := sql.Open(conf.Driver, conf.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open result database: %w", err)
+	}
+
+	return &resultDatabase{
+		logger: logger,
+		db:     db,
+		insert: fmt.Sprintf("INSERT INTO %s (task_id, alloc_id, module, exit_code, duration_ms, output_sha256, completed_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7)", conf.Table),
+	}, nil
+}
+
+// write stores the task result in the background.
+func (d *resultDatabase) write(result *taskResult) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		return
+	}
+
+	d.writes.Add(1)
+
+	go func() {
+		defer d.writes.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), resultDatabaseTimeout)
+		defer cancel()
+
+		digest := sha256.Sum256([]byte(result.Output))
+
+		_, err := d.db.ExecContext(ctx, d.insert, result.TaskID, result.AllocID, result.Module, result.ExitCode,
+			result.DurationMs, hex.EncodeToString(digest[:]), result.CompletedAt)
+		if err != nil {
+			d.logger.Error("unable to write task result to database", "task_id", result.TaskID,
+				"error", hclog.Fmt("%+v", err))
+
+			return
+		}
+
+		d.logger.Debug("task result written to database", "task_id", result.TaskID)
+	}()
+}
+
+// Close waits for the pending writes and closes the database.
+func (d *resultDatabase) Close() {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	d.closed = true
+	d.lock.Unlock()
+
+	d.writes.Wait()
+
+	if err := d.db.Close(); err != nil {
+		d.logger.Debug("unable to close result database", "error", hclog.Fmt("%+v", err))
+	}
+}
@@ -0,0 +1,133 @@
+package wasm
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// memDatabase is a fake in-memory database/sql driver, registered as memdb,
+// keeping the arguments of executed statements as table rows. It doesn't parse
+// SQL, so the tests check the values written and the write error handling,
+// not the statement against PostgreSQL or any other real database.
+type memDatabase struct {
+	tables map[string][][]driver.Value
+	lock   sync.Mutex
+}
+
+var testDatabase = &memDatabase{tables: make(map[string][][]driver.Value)}
+
+func init() {
+	sql.Register("memdb", testDatabase)
+}
+
+func (db *memDatabase) Open(string) (driver.Conn, error) {
+	return &memConn{db: db}, nil
+}
+
+// rows returns the rows inserted into the table.
+func (db *memDatabase) rows(table string) [][]driver.Value {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.tables[table]
+}
+
+type memConn struct {
+	db *memDatabase
+}
+
+func (c *memConn) Prepare(query string) (driver.Stmt, error) {
+	table, ok := strings.CutPrefix(query, "INSERT INTO ")
+	if !ok {
+		return nil, errors.New("only inserts are supported")
+	}
+
+	table, _, _ = strings.Cut(table, " ")
+
+	return &memStmt{db: c.db, table: table}, nil
+}
+
+func (c *memConn) Close() error {
+	return nil
+}
+
+func (c *memConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type memStmt struct {
+	db    *memDatabase
+	table string
+}
+
+func (s *memStmt) Close() error {
+	return nil
+}
+
+func (s *memStmt) NumInput() int {
+	return -1
+}
+
+func (s *memStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+
+	s.db.tables[s.table] = append(s.db.tables[s.table], args)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *memStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+func TestResultWrittenToDatabase(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	resultDB, err := openResultDatabase(hclog.NewNullLogger(), ResultDatabaseConfig{Driver: "memdb", Table: "results"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.resultDB = resultDB
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("alloc", allocAt(0, nil), interfaces.ValueTypeI32).
+			withFunc("handle_buffer", upperCase(), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+	})
+
+	cfg := newTestTask(t, "database", `engine = "fake"
+ioBuffer {
+  enabled = true
+  inputValue = "hello"
+}`)
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	// Close waits for the pending writes.
+	resultDB.Close()
+
+	rows := testDatabase.rows("results")
+	if len(rows) != 1 {
+		t.Fatalf("expected a single row, but got %v", rows)
+	}
+
+	digest := sha256.Sum256([]byte("HELLO"))
+
+	if row := rows[0]; row[0] != "database" || row[1] != "alloc" || row[5] != hex.EncodeToString(digest[:]) {
+		t.Errorf("unexpected row %v", row)
+	}
+}
@@ -0,0 +1,226 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// resultSinkTimeout bounds a single attempt to deliver a task result.
+	resultSinkTimeout = 10 * time.Second
+
+	// resultSinkBackoff is the delay before the first retry, doubled on each attempt.
+	resultSinkBackoff = time.Second
+)
+
+// taskResult is the record delivered to the result sink when a task finishes.
+type taskResult struct {
+	StartedAt     time.Time `json:"started_at"`
+	CompletedAt   time.Time `json:"completed_at"`
+	TaskID        string    `json:"task_id"`
+	CorrelationID string    `json:"correlation_id"`
+	AllocID       string    `json:"alloc_id"`
+	TaskName      string    `json:"task_name"`
+	Engine        string    `json:"engine"`
+	Module        string    `json:"module"`
+	State         string    `json:"state"`
+	Error         string    `json:"error,omitempty"`
+	Output        string    `json:"output"`
+	DurationMs    int64     `json:"duration_ms"`
+	ExitCode      int       `json:"exit_code"`
+}
+
+// resultSink delivers the result of a task to the sink configured by the
+// task.
+type resultSink struct {
+	// ctx is the driver context, so retries stop when the driver shuts down.
+	ctx     context.Context
+	client  *http.Client
+	taskDir string
+	conf    ResultSinkConfig
+}
+
+// newResultSink validates the sink configured by the task. Tasks are
+// controlled by job authors, so URLs must be allowed by the plugin
+// configuration and files must be within the task directory.
+func newResultSink(ctx context.Context, conf ResultSinkConfig, taskDir string, allowedURLs []string,
+) (*resultSink, error) {
+	if (conf.URL == "") == (conf.Path == "") {
+		return nil, errors.New("exactly one of result sink url or path must be specified")
+	}
+
+	if conf.Retries < 0 {
+		return nil, fmt.Errorf("result sink retries must be >= 0, but specified %v", conf.Retries)
+	}
+
+	if conf.URL != "" && !urlAllowed(conf.URL, allowedURLs) {
+		return nil, fmt.Errorf("result sink url %s is not allowed by the plugin configuration", conf.URL)
+	}
+
+	if conf.Path != "" {
+		if filepath.IsAbs(conf.Path) {
+			return nil, fmt.Errorf("result sink path %s must be relative to the task directory", conf.Path)
+		}
+
+		if path := filepath.Join(taskDir, conf.Path); path == taskDir || !isWithin(path, taskDir) {
+			return nil, fmt.Errorf("result sink path %s is outside of the task directory", conf.Path)
+		}
+	}
+
+	return &resultSink{
+		ctx:     ctx,
+		taskDir: taskDir,
+		conf:    conf,
+		client: &http.Client{
+			// A redirect could lead requests to a URL which isn't allowed.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// urlAllowed reports whether the URL has the scheme and host of one of the
+// allowed URLs and a path within its path.
+func urlAllowed(rawURL string, allowedURLs []string) bool {
+	target, err := url.Parse(rawURL)
+	if err != nil || target.User != nil {
+		return false
+	}
+
+	for _, rawAllowed := range allowedURLs {
+		allowed, err := url.Parse(rawAllowed)
+		if err != nil {
+			continue
+		}
+
+		if !strings.EqualFold(target.Scheme, allowed.Scheme) || !strings.EqualFold(target.Host, allowed.Host) {
+			continue
+		}
+
+		allowedPath := strings.TrimSuffix(allowed.Path, "/")
+		if target.Path == allowedPath || strings.HasPrefix(target.Path, allowedPath+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// send delivers the task result to the sink. Sink failures are logged and
+// never affect the task outcome.
+func (s *resultSink) send(logger hclog.Logger, result *taskResult) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		logger.Error("unable to encode task result", "error", hclog.Fmt("%+v", err))
+
+		return
+	}
+
+	backoff := resultSinkBackoff
+
+	for attempt := 0; ; attempt++ {
+		if s.conf.URL != "" {
+			err = s.post(body)
+		} else {
+			err = s.append(body)
+		}
+
+		if err == nil {
+			logger.Debug("task result sent to sink", "task_id", result.TaskID)
+
+			return
+		}
+
+		if attempt >= s.conf.Retries {
+			break
+		}
+
+		select {
+		case <-s.ctx.Done():
+			logger.Error("driver shut down before task result was sent to sink", "task_id", result.TaskID,
+				"error", hclog.Fmt("%+v", err))
+
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+
+	logger.Error("unable to send task result to sink", "task_id", result.TaskID, "error", hclog.Fmt("%+v", err))
+}
+
+func (s *resultSink) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(s.ctx, resultSinkTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if s.conf.AuthHeader != "" {
+		req.Header.Set("Authorization", s.conf.AuthHeader)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// append appends the result as a JSON line to the sink file. The task can
+// replace directories of its task directory with symlinks while it runs, so
+// the file directory is resolved and checked again on every attempt and the
+// file itself is opened without following symlinks.
+func (s *resultSink) append(body []byte) error {
+	taskDir, err := filepath.EvalSymlinks(s.taskDir)
+	if err != nil {
+		return fmt.Errorf("unable to resolve task directory: %w", err)
+	}
+
+	path := filepath.Join(s.taskDir, s.conf.Path)
+
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("unable to resolve result sink directory: %w", err)
+	}
+
+	if !isWithin(dir, taskDir) {
+		return fmt.Errorf("result sink path %s is outside of the task directory", s.conf.Path)
+	}
+
+	//nolint:gosec
+	f, err := os.OpenFile(filepath.Join(dir, filepath.Base(path)),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY|syscall.O_NOFOLLOW, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(body, '\n'))
+
+	return err
+}
@@ -0,0 +1,153 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestResultPostedToSink(t *testing.T) {
+	results := make(chan taskResult, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result taskResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			t.Errorf("unable to decode task result: %v", err)
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+
+		results <- result
+	}))
+	defer server.Close()
+
+	d := newTestDriver(t, `engines = [{ name = "fake" }]
+resultSink {
+  allowedURLs = ["`+server.URL+`/results"]
+}`, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(3)))
+	})
+
+	cfg := newTestTask(t, "sink", `engine = "fake"
+main {
+  resultAsExitCode = true
+}
+resultSink {
+  url = "`+server.URL+`/results/batch"
+  authHeader = "Bearer token"
+}`)
+
+	runTask(t, d, cfg)
+
+	select {
+	case result := <-results:
+		if result.TaskID != "sink" || result.ExitCode != 3 || result.State != "exited" {
+			t.Errorf("unexpected task result %+v", result)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("task result wasn't posted to the sink")
+	}
+}
+
+func TestResultSinkURLMustBeAllowed(t *testing.T) {
+	d := newTestDriver(t, `engines = [{ name = "fake" }]
+resultSink {
+  allowedURLs = ["http://127.0.0.1:8080/results"]
+}`, nil)
+
+	for _, url := range []string{
+		"http://127.0.0.1:8080/other",
+		"http://127.0.0.1:8080/results-other",
+		"http://127.0.0.1:8081/results",
+		"https://127.0.0.1:8080/results",
+		"http://169.254.169.254/latest/meta-data",
+	} {
+		cfg := newTestTask(t, "sink", `engine = "fake"
+resultSink {
+  url = "`+url+`"
+}`)
+
+		if _, _, err := d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("expected url %s to be rejected, but got %v", url, err)
+		}
+	}
+}
+
+func TestResultSinkPathWithinTaskDir(t *testing.T) {
+	taskDir := t.TempDir()
+	outside := t.TempDir()
+
+	for _, path := range []string{"/etc/results.json", "../results.json", "."} {
+		if _, err := newResultSink(context.Background(), ResultSinkConfig{Path: path}, taskDir, nil); err == nil {
+			t.Errorf("expected path %s to be rejected", path)
+		}
+	}
+
+	// The task can replace a directory with a symlink after it started.
+	if err := os.Symlink(outside, filepath.Join(taskDir, "results")); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := newResultSink(context.Background(), ResultSinkConfig{Path: "results/result.json"}, taskDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sink.append([]byte("{}")); err == nil {
+		t.Error("expected result written through a symlink outside of the task directory to fail")
+	}
+
+	if err = os.Symlink(filepath.Join(outside, "result.json"), filepath.Join(taskDir, "result.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err = newResultSink(context.Background(), ResultSinkConfig{Path: "result.json"}, taskDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.append([]byte("{}")); err == nil {
+		t.Error("expected result written to a symlink to fail")
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "result.json")); !os.IsNotExist(err) {
+		t.Errorf("result was written outside of the task directory: %v", err)
+	}
+}
+
+func TestResultSinkRetriesStopOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sink, err := newResultSink(ctx, ResultSinkConfig{Path: "missing/result.json", Retries: 100}, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		sink.send(hclog.NewNullLogger(), &taskResult{TaskID: "sink"})
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("result sink kept retrying after shutdown")
+	}
+}
@@ -30,8 +30,26 @@ func (ts *taskStore) Get(id string) (*taskHandle, bool) {
 	return t, ok
 }
 
-func (ts *taskStore) Delete(id string) {
+// DeleteHandle deletes the task only if it is still stored with the handle,
+// so a task started again with the same ID isn't deleted.
+func (ts *taskStore) DeleteHandle(id string, handle *taskHandle) {
 	ts.lock.Lock()
 	defer ts.lock.Unlock()
-	delete(ts.store, id)
+
+	if ts.store[id] == handle {
+		delete(ts.store, id)
+	}
+}
+
+// List returns all stored task handles.
+func (ts *taskStore) List() []*taskHandle {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+
+	handles := make([]*taskHandle, 0, len(ts.store))
+	for _, handle := range ts.store {
+		handles = append(handles, handle)
+	}
+
+	return handles
 }
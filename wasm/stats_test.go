@@ -0,0 +1,235 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+func TestMemoryAlertEmitted(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("pre_main", func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+				return nil, instance.GrowMemory(1)
+			}).
+			withFunc("handle_buffer", blockUntilStopped())
+	})
+
+	cfg := newTestTask(t, "alert", `engine = "fake"
+hooks {
+  enabled = true
+}
+alerts {
+  enabled = true
+  memoryThreshold = 10
+}`)
+	cfg.Resources = &drivers.Resources{
+		NomadResources: &structs.AllocatedTaskResources{
+			Memory: structs.AllocatedMemoryResources{MemoryMB: 1},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	stats, err := d.TaskStats(ctx, cfg.ID, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The memory grown by the pre main hook is reported while the main
+	// function still runs.
+	for alerted := false; !alerted; {
+		select {
+		case event := <-events:
+			alerted = strings.HasPrefix(event.Message, "module memory usage")
+		case usage := <-stats:
+			if memory := usage.ResourceUsage.MemoryStats.RSS; memory > 2*wasmPageSize {
+				t.Errorf("unexpected memory usage %d", memory)
+			}
+		case <-ctx.Done():
+			t.Fatal("memory alert wasn't emitted")
+		}
+	}
+
+	if err = d.DestroyTask(cfg.ID, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatisticsSnapshotWhileRunning(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	started := make(chan struct{})
+
+	useFakeInstances(t, func() *fakeInstance {
+		instance := newFakeInstance(1).
+			withFunc("handle_buffer", func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+				close(started)
+
+				// The module keeps consuming fuel until it is stopped.
+				for {
+					select {
+					case <-instance.stopCh:
+						return nil, errInterrupted
+					default:
+						instance.stats.FuelConsumed++
+					}
+				}
+			})
+		instance.stats = &interfaces.ExecutionStatistics{Fuel: true, FuelRemaining: 100}
+
+		return instance
+	})
+
+	cfg := newTestTask(t, "snapshot", `engine = "fake"`)
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	<-started
+
+	// The statistics recorded before the main function are reported while it
+	// runs, instead of reading the instance the module runs in.
+	for i := 0; i < 10; i++ {
+		status, err := d.InspectTask(cfg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fuel := status.DriverAttributes["fuel_consumed"]; fuel != "0" {
+			t.Fatalf("expected the fuel consumed before the main function, but got %s", fuel)
+		}
+	}
+
+	if err := d.StopTask(cfg.ID, 0, "SIGKILL"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitTask(t, d, cfg.ID)
+
+	status, err := d.InspectTask(cfg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fuel := status.DriverAttributes["fuel_consumed"]; fuel == "0" {
+		t.Error("expected the fuel consumed by the main function recorded once it returned")
+	}
+}
+
+func TestFirstStatsSampleSentImmediately(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", blockUntilStopped())
+	})
+
+	cfg := newTestTask(t, "first-sample", `engine = "fake"`)
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	// The interval is longer than the test timeout, so only the first sample
+	// can be received.
+	stats, err := d.TaskStats(ctx, cfg.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case usage := <-stats:
+		if usage == nil || usage.ResourceUsage == nil {
+			t.Errorf("unexpected stats sample %+v", usage)
+		}
+	case <-ctx.Done():
+		t.Fatal("no stats sample was sent before the first interval")
+	}
+
+	if err = d.DestroyTask(cfg.ID, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstructionStatisticsReported(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		instance := newFakeInstance(1).
+			withFunc("handle_buffer", func(instance *fakeInstance, _ []interface{}) (interface{}, error) {
+				instance.stats.InstrCount += 42
+				instance.stats.TotalCost += 84
+
+				return int32(0), nil
+			})
+		instance.stats = &interfaces.ExecutionStatistics{Instructions: true}
+
+		return instance
+	})
+
+	cfg := newTestTask(t, "instructions", `engine = "fake"
+eventLog {
+  enabled = true
+}`)
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	handle, _ := d.tasks.Get(cfg.ID)
+
+	select {
+	case <-handle.completionCh:
+	case <-time.After(testTimeout):
+		t.Fatal("task didn't complete before timeout")
+	}
+
+	status, err := d.InspectTask(cfg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instructions := status.DriverAttributes["instructions"]; instructions != "42" {
+		t.Errorf("expected 42 instructions reported in the task status, but got %q", instructions)
+	}
+
+	data, err := os.ReadFile(eventLogPath(cfg.TaskDir().LogDir, cfg.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	var finished eventLogEntry
+	if err = json.Unmarshal([]byte(lines[len(lines)-1]), &finished); err != nil {
+		t.Fatal(err)
+	}
+
+	if finished.Type != eventTypeFinished || finished.Details["instructions"] != "42" || finished.Details["cost"] != "84" {
+		t.Errorf("expected the instruction statistics in the finished event, but got %+v", finished)
+	}
+}
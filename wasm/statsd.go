@@ -0,0 +1,118 @@
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// statsdMaxPacketSize keeps batched metrics within a single UDP packet on
+// common networks.
+const statsdMaxPacketSize = 1432
+
+// statsdSink batches task metrics and sends them to a statsd server over UDP.
+// Metrics are best effort: send failures are logged and dropped. A nil
+// statsdSink discards all metrics.
+type statsdSink struct {
+	logger hclog.Logger
+	conn   net.Conn
+	stopCh chan struct{}
+	prefix string
+	buf    bytes.Buffer
+	lock   sync.Mutex
+}
+
+func newStatsdSink(logger hclog.Logger, conf StatsdConfig) (*statsdSink, error) {
+	conn, err := net.Dial("udp", conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to statsd %s: %w", conf.Address, err)
+	}
+
+	s := &statsdSink{
+		logger: logger,
+		conn:   conn,
+		stopCh: make(chan struct{}),
+		prefix: conf.Prefix,
+	}
+
+	go s.run(time.Second * time.Duration(conf.FlushInterval))
+
+	return s, nil
+}
+
+// incr increments the counter by one.
+func (s *statsdSink) incr(name string) {
+	s.add(name, "1|c")
+}
+
+// timing records the duration in milliseconds.
+func (s *statsdSink) timing(name string, d time.Duration) {
+	s.add(name, fmt.Sprintf("%d|ms", d.Milliseconds()))
+}
+
+func (s *statsdSink) add(name, value string) {
+	if s == nil {
+		return
+	}
+
+	line := fmt.Sprintf("%s.%s:%s\n", s.prefix, name, value)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.buf.Len()+len(line) > statsdMaxPacketSize {
+		s.flushLocked()
+	}
+
+	s.buf.WriteString(line)
+}
+
+func (s *statsdSink) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			s.flushLocked()
+			s.lock.Unlock()
+		}
+	}
+}
+
+func (s *statsdSink) flushLocked() {
+	if s.buf.Len() == 0 {
+		return
+	}
+
+	if _, err := s.conn.Write(s.buf.Bytes()); err != nil {
+		s.logger.Debug("unable to send metrics to statsd", "error", err)
+	}
+
+	s.buf.Reset()
+}
+
+// Close flushes the batched metrics and closes the connection.
+func (s *statsdSink) Close() {
+	if s == nil {
+		return
+	}
+
+	close(s.stopCh)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.flushLocked()
+
+	if err := s.conn.Close(); err != nil {
+		s.logger.Debug("unable to close statsd connection", "error", err)
+	}
+}
@@ -0,0 +1,60 @@
+package wasm
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsSentToStatsd(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	d := newTestDriver(t, `engines = [{ name = "fake" }]
+statsd {
+  address = "`+listener.LocalAddr().String()+`"
+  prefix = "test"
+}`, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	runTask(t, d, newTestTask(t, "metrics", `engine = "fake"`))
+
+	expected := []string{"test.tasks.started:1|c", "test.tasks.instantiate:", "test.tasks.completed:1|c",
+		"test.tasks.duration:"}
+
+	var received string
+
+	buf := make([]byte, statsdMaxPacketSize)
+
+	for deadline := time.Now().Add(testTimeout); !hasMetrics(received, expected); {
+		if err = listener.SetReadDeadline(deadline); err != nil {
+			t.Fatal(err)
+		}
+
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected metrics %v, but received %q: %v", expected, received, err)
+		}
+
+		received += string(buf[:n])
+	}
+}
+
+// hasMetrics reports whether the received statsd lines start with each of the
+// expected prefixes.
+func hasMetrics(received string, expected []string) bool {
+	for _, prefix := range expected {
+		if !strings.Contains("\n"+received, "\n"+prefix) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,98 @@
+package wasm
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// stderrTail relays the module WASI stderr to the task stderr log and keeps
+// its last lines, so the panic message toolchains write to stderr before
+// trapping can be reported with the trap.
+type stderrTail struct {
+	pipe    *outputPipe
+	log     io.WriteCloser
+	lines   []string
+	partial []byte
+
+	maxLines  int
+	lock      sync.Mutex
+	closeOnce sync.Once
+}
+
+// newStderrTail opens the task stderr log and starts relaying the module
+// stderr to it, keeping the last maxLines lines.
+func newStderrTail(stderrPath string, maxLines int) (*stderrTail, error) {
+	log, err := openLogWriter(stderrPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe, err := newOutputPipe()
+	if err != nil {
+		_ = log.Close()
+
+		return nil, err
+	}
+
+	t := &stderrTail{pipe: pipe, log: log, maxLines: maxLines}
+
+	// Log write failures don't stop recording the lines.
+	go pipe.relay(func(data []byte) {
+		_, _ = log.Write(data)
+
+		t.record(data)
+	})
+
+	return t, nil
+}
+
+// record appends the complete lines of data to the kept lines.
+func (t *stderrTail) record(data []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.partial = append(t.partial, data...)
+
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		t.lines = append(t.lines, string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+
+	if len(t.lines) > t.maxLines {
+		t.lines = t.lines[len(t.lines)-t.maxLines:]
+	}
+}
+
+// drain stops relaying once the stderr the module wrote so far is relayed and
+// returns its last lines. It must be called once the module returned.
+func (t *stderrTail) drain() []string {
+	if t == nil {
+		return nil
+	}
+
+	t.pipe.drain()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	lines := append([]string(nil), t.lines...)
+	if len(t.partial) > 0 {
+		lines = append(lines, string(t.partial))
+	}
+
+	return lines[max(len(lines)-t.maxLines, 0):]
+}
+
+// close stops relaying and closes the pipe and the task stderr log.
+func (t *stderrTail) close() {
+	t.closeOnce.Do(func() {
+		t.pipe.close()
+		_ = t.log.Close()
+	})
+}
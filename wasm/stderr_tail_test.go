@@ -0,0 +1,59 @@
+package wasm
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPanicMessageInExitResult(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	panicMessage := "thread 'main' panicked at src/lib.rs:7:5:\nindex out of bounds: the len is 3 but the index is 5\n" +
+		"note: run with `RUST_BACKTRACE=1` environment variable to display a backtrace\n"
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", func(*fakeInstance, []interface{}) (interface{}, error) {
+			// Like a Rust module, the panic message is written to stderr before
+			// the module traps.
+			stderr, err := os.OpenFile(testEngine.instanceConfs()[0].Wasi.StderrPath, os.O_WRONLY, 0)
+			if err != nil {
+				return nil, err
+			}
+			defer stderr.Close()
+
+			if _, err = stderr.WriteString("starting\n" + panicMessage); err != nil {
+				return nil, err
+			}
+
+			return nil, errors.New("wasm trap: wasm `unreachable` instruction executed")
+		})
+	})
+
+	cfg := newTestTask(t, "panic", `engine = "fake"
+wasi {
+  enabled         = true
+  stderrTailLines = 3
+}`)
+
+	result := runTask(t, d, cfg)
+	if result.Err == nil {
+		t.Fatal("expected the trap to fail the task")
+	}
+
+	expected := "unreachable` instruction executed, module stderr:\n" + strings.TrimSuffix(panicMessage, "\n")
+	if !strings.HasSuffix(result.Err.Error(), expected) {
+		t.Errorf("expected the panic message in the exit result, but got %q", result.Err)
+	}
+
+	// The whole stderr is still written to the task log.
+	stderr, err := os.ReadFile(cfg.StderrPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(stderr) != "starting\n"+panicMessage {
+		t.Errorf("expected the module stderr in the task log, but got %q", stderr)
+	}
+}
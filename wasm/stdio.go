@@ -0,0 +1,151 @@
+package wasm
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// taskStdio relays the module stdio the driver handles instead of the engine:
+// the stderr tail and the named pipes connecting tasks. A nil stdio relays
+// nothing.
+type taskStdio struct {
+	stderr *stderrTail
+	stdin  *pipeInput
+	stdout *pipeOutput
+}
+
+// newTaskStdio starts relaying the module stdio the task configuration asks
+// the driver to handle and points the WASI options to the relays. It returns
+// nil if the engine handles all of the module stdio.
+func newTaskStdio(logger hclog.Logger, cfg *drivers.TaskConfig, conf WasiConfig, wasi *interfaces.WasiOptions,
+) (*taskStdio, error) {
+	if wasi == nil {
+		return nil, nil
+	}
+
+	if conf.StderrTailLines < 0 {
+		return nil, fmt.Errorf("wasi stderr tail lines must be >= 0, but specified %d", conf.StderrTailLines)
+	}
+
+	if conf.StderrTailLines == 0 && conf.StdinPipe == "" && conf.StdoutPipe == "" {
+		return nil, nil
+	}
+
+	stdio := &taskStdio{}
+
+	if err := stdio.open(logger, cfg, conf, wasi); err != nil {
+		stdio.close()
+
+		return nil, err
+	}
+
+	return stdio, nil
+}
+
+func (s *taskStdio) open(logger hclog.Logger, cfg *drivers.TaskConfig, conf WasiConfig, wasi *interfaces.WasiOptions,
+) error {
+	var err error
+
+	if conf.StderrTailLines > 0 {
+		if s.stderr, err = newStderrTail(wasi.StderrPath, conf.StderrTailLines); err != nil {
+			return fmt.Errorf("failed to open task stderr: %v", err)
+		}
+
+		wasi.StderrPath = s.stderr.pipe.path
+	}
+
+	if conf.StdinPipe != "" {
+		fifoPath, err := taskPipePath(cfg, conf.StdinPipe)
+		if err != nil {
+			return err
+		}
+
+		if s.stdin, err = newPipeInput(logger, fifoPath); err != nil {
+			return fmt.Errorf("failed to open WASI stdin pipe %s: %v", conf.StdinPipe, err)
+		}
+
+		wasi.StdinPath = s.stdin.path
+	}
+
+	if conf.StdoutPipe != "" {
+		fifoPath, err := taskPipePath(cfg, conf.StdoutPipe)
+		if err != nil {
+			return err
+		}
+
+		if s.stdout, err = newPipeOutput(logger, fifoPath); err != nil {
+			return fmt.Errorf("failed to open WASI stdout pipe %s: %v", conf.StdoutPipe, err)
+		}
+
+		wasi.StdoutPath = s.stdout.pipe.path
+	}
+
+	return nil
+}
+
+// stderrTail returns the last lines of the module stderr, if kept. It must be
+// called once the module returned.
+func (s *taskStdio) stderrTail() []string {
+	if s == nil {
+		return nil
+	}
+
+	return s.stderr.drain()
+}
+
+// finish delivers the module stdout to the task reading it, waiting for the
+// task to open the pipe. It must be called once the module returned.
+func (s *taskStdio) finish() {
+	if s == nil || s.stdout == nil {
+		return
+	}
+
+	s.stdout.finish()
+}
+
+// interrupt stops waiting for the tasks at the other end of the pipes, so a
+// module blocked reading its stdin returns.
+func (s *taskStdio) interrupt() {
+	if s.stdin != nil {
+		s.stdin.interrupt()
+	}
+
+	if s.stdout != nil {
+		s.stdout.interrupt()
+	}
+}
+
+func (s *taskStdio) close() {
+	if s.stderr != nil {
+		s.stderr.close()
+	}
+
+	if s.stdin != nil {
+		s.stdin.close()
+	}
+
+	if s.stdout != nil {
+		s.stdout.close()
+	}
+}
+
+// stdioInstance interrupts and closes the stdio relays of the instance along
+// with it.
+type stdioInstance struct {
+	interfaces.WasmInstance
+	stdio *taskStdio
+}
+
+func (i stdioInstance) Stop() {
+	i.WasmInstance.Stop()
+	i.stdio.interrupt()
+}
+
+func (i stdioInstance) Cleanup() {
+	i.WasmInstance.Cleanup()
+	i.stdio.close()
+}
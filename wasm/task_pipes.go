@@ -0,0 +1,247 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// taskPipesDir is the directory of the shared allocation directory the named
+// pipes connecting the tasks of an allocation are created in.
+const taskPipesDir = "pipes"
+
+// fifoOpenRetryInterval is the interval a pending named pipe open is retried
+// to be unblocked at.
+const fifoOpenRetryInterval = 10 * time.Millisecond
+
+// taskPipePath returns the path of the named pipe, creating it if the task at
+// its other end hasn't yet.
+func taskPipePath(cfg *drivers.TaskConfig, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid WASI pipe name %q, it must be a file name", name)
+	}
+
+	dir := filepath.Join(cfg.TaskDir().SharedAllocDir, taskPipesDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("unable to create WASI pipes directory: %v", err)
+	}
+
+	path := filepath.Join(dir, name)
+
+	if err := syscall.Mkfifo(path, 0o600); err != nil && !errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("unable to create WASI pipe %s: %v", name, err)
+	}
+
+	return path, nil
+}
+
+// openFIFO opens the named pipe, which blocks until its other end is opened,
+// or until the context is done.
+func openFIFO(ctx context.Context, path string, flag int) (*os.File, error) {
+	otherFlag := os.O_WRONLY
+	if flag == os.O_WRONLY {
+		otherFlag = os.O_RDONLY
+	}
+
+	opened := make(chan struct{})
+	defer close(opened)
+
+	// The pending open is unblocked by opening the other end, which is retried
+	// as the open may not be pending yet.
+	stop := context.AfterFunc(ctx, func() {
+		for {
+			if other, err := os.OpenFile(path, otherFlag|syscall.O_NONBLOCK, 0); err == nil {
+				_ = other.Close()
+			}
+
+			select {
+			case <-opened:
+				return
+			case <-time.After(fifoOpenRetryInterval):
+			}
+		}
+	})
+	defer stop()
+
+	fifo, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ctx.Err(); err != nil {
+		_ = fifo.Close()
+
+		return nil, err
+	}
+
+	return fifo, nil
+}
+
+// pipeOutput relays the module stdout to the named pipe another task of the
+// allocation reads its stdin from. The module output waits in the pipe until
+// the reading task opens it, and the reading task reads the end of the input
+// once the output is drained.
+type pipeOutput struct {
+	pipe   *outputPipe
+	cancel context.CancelFunc
+	// done is closed once the named pipe is closed.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeOutput(logger hclog.Logger, fifoPath string) (*pipeOutput, error) {
+	pipe, err := newOutputPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &pipeOutput{pipe: pipe, cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(p.done)
+
+		// The output is discarded if the pipe can't be written to, so the module
+		// doesn't block writing it.
+		var out io.Writer = io.Discard
+
+		fifo, err := openFIFO(ctx, fifoPath, os.O_WRONLY)
+		if err != nil {
+			logger.Warn("unable to open WASI stdout pipe, discarding module stdout", "path", fifoPath,
+				"error", hclog.Fmt("%+v", err))
+		} else {
+			defer fifo.Close()
+
+			// A task which stopped reading doesn't keep the relay blocked.
+			stopWrite := context.AfterFunc(ctx, func() {
+				_ = fifo.SetWriteDeadline(time.Now())
+			})
+			defer stopWrite()
+
+			out = fifo
+		}
+
+		pipe.relay(func(data []byte) {
+			if _, err := out.Write(data); err != nil {
+				logger.Warn("unable to write to WASI stdout pipe, discarding module stdout", "path", fifoPath,
+					"error", hclog.Fmt("%+v", err))
+
+				out = io.Discard
+			}
+		})
+	}()
+
+	return p, nil
+}
+
+// finish waits for the module output to be read by the reading task and closes
+// the named pipe, unless the output is interrupted. It must be called once the
+// module returned.
+func (p *pipeOutput) finish() {
+	p.pipe.drain()
+
+	<-p.done
+}
+
+// interrupt stops waiting for the reading task and discards the output.
+func (p *pipeOutput) interrupt() {
+	p.cancel()
+}
+
+func (p *pipeOutput) close() {
+	p.closeOnce.Do(func() {
+		p.cancel()
+		p.pipe.close()
+
+		<-p.done
+	})
+}
+
+// pipeInput relays the named pipe another task of the allocation writes its
+// stdout to to the module stdin. The module reads the end of its input once
+// the writing task closes the pipe.
+type pipeInput struct {
+	reader *os.File
+	cancel context.CancelFunc
+	done   chan struct{}
+	// path is the path of the pipe the module reads from.
+	path      string
+	closeOnce sync.Once
+}
+
+func newPipeInput(logger hclog.Logger, fifoPath string) (*pipeInput, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &pipeInput{
+		reader: reader,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		path:   "/dev/fd/" + strconv.FormatUint(uint64(reader.Fd()), 10),
+	}
+
+	go func() {
+		defer close(p.done)
+		defer writer.Close()
+
+		fifo, err := openFIFO(ctx, fifoPath, os.O_RDONLY)
+		if err != nil {
+			logger.Warn("unable to open WASI stdin pipe", "path", fifoPath, "error", hclog.Fmt("%+v", err))
+
+			return
+		}
+		defer fifo.Close()
+
+		// A module which stopped reading doesn't keep the relay blocked.
+		stopCopy := context.AfterFunc(ctx, func() {
+			_ = fifo.SetReadDeadline(time.Now())
+			_ = writer.SetWriteDeadline(time.Now())
+		})
+		defer stopCopy()
+
+		if _, err = io.Copy(writer, fifo); err != nil {
+			if ctx.Err() == nil {
+				logger.Warn("unable to relay WASI stdin pipe", "path", fifoPath, "error", hclog.Fmt("%+v", err))
+			}
+
+			return
+		}
+
+		// The reading task is the last one to use the pipe.
+		if err = os.Remove(fifoPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("unable to remove WASI stdin pipe", "path", fifoPath, "error", hclog.Fmt("%+v", err))
+		}
+	}()
+
+	return p, nil
+}
+
+// interrupt ends the module input, so a module blocked reading it returns.
+func (p *pipeInput) interrupt() {
+	p.cancel()
+}
+
+func (p *pipeInput) close() {
+	p.closeOnce.Do(func() {
+		p.cancel()
+
+		<-p.done
+
+		_ = p.reader.Close()
+	})
+}
@@ -0,0 +1,146 @@
+package wasm
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTaskStdoutPipedToTaskStdin(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	// The producer writes to the stdout it was given and the consumer reads the
+	// stdin it was given, like WASI modules do.
+	openStdio := func(stdin bool) (*os.File, error) {
+		for _, conf := range testEngine.instanceConfs() {
+			switch {
+			case stdin && conf.Wasi.StdinPath != "":
+				return os.Open(conf.Wasi.StdinPath)
+			case !stdin && conf.Wasi.StdinPath == "":
+				return os.OpenFile(conf.Wasi.StdoutPath, os.O_WRONLY, 0)
+			}
+		}
+
+		return nil, os.ErrNotExist
+	}
+
+	consumed := make(chan string, 1)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("produce", func(*fakeInstance, []interface{}) (interface{}, error) {
+				stdout, err := openStdio(false)
+				if err != nil {
+					return nil, err
+				}
+				defer stdout.Close()
+
+				_, err = stdout.WriteString("records from the producer\n")
+
+				return int32(0), err
+			}).
+			withFunc("consume", func(*fakeInstance, []interface{}) (interface{}, error) {
+				stdin, err := openStdio(true)
+				if err != nil {
+					return nil, err
+				}
+				defer stdin.Close()
+
+				input, err := io.ReadAll(stdin)
+				consumed <- string(input)
+
+				return int32(0), err
+			})
+	})
+
+	producer := newTestTask(t, "producer", `engine = "fake"
+main {
+  mainFuncName = "produce"
+}
+wasi {
+  enabled    = true
+  stdoutPipe = "records"
+}`)
+
+	// The consumer is another task of the producer allocation.
+	consumer := newTestTask(t, "consumer", `engine = "fake"
+main {
+  mainFuncName = "consume"
+}
+wasi {
+  enabled   = true
+  stdinPipe = "records"
+}`)
+	consumerModule := filepath.Join(consumer.TaskDir().Dir, "module.wasm")
+
+	consumer.AllocDir, consumer.Name = producer.AllocDir, "consumer"
+
+	if err := os.MkdirAll(consumer.TaskDir().Dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(consumerModule, filepath.Join(consumer.TaskDir().Dir, "module.wasm")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The producer output waits for the consumer to start.
+	if _, _, err := d.StartTask(producer); err != nil {
+		t.Fatalf("unable to start producer: %v", err)
+	}
+
+	if result := runTask(t, d, consumer); result.Err != nil {
+		t.Fatalf("unexpected consumer exit result %+v", result)
+	}
+
+	if input := <-consumed; input != "records from the producer\n" {
+		t.Errorf("expected the consumer to read the producer output, but got %q", input)
+	}
+
+	if result := waitTask(t, d, producer.ID); result.Err != nil {
+		t.Fatalf("unexpected producer exit result %+v", result)
+	}
+
+	pipePath := filepath.Join(producer.TaskDir().SharedAllocDir, taskPipesDir, "records")
+	if _, err := os.Stat(pipePath); !os.IsNotExist(err) {
+		t.Errorf("expected the pipe to be removed, but got %v", err)
+	}
+}
+
+func TestTaskWaitingForPipeStopped(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", func(*fakeInstance, []interface{}) (interface{}, error) {
+			stdin, err := os.Open(testEngine.instanceConfs()[0].Wasi.StdinPath)
+			if err != nil {
+				return nil, err
+			}
+			defer stdin.Close()
+
+			// Reading blocks the module until the input ends, which the module
+			// can't be interrupted in.
+			_, err = io.ReadAll(stdin)
+
+			return int32(0), err
+		})
+	})
+
+	cfg := newTestTask(t, "consumer", `engine = "fake"
+wasi {
+  enabled   = true
+  stdinPipe = "records"
+}`)
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	// No task writes to the pipe, stopping the task ends the module input.
+	if err := d.StopTask(cfg.ID, time.Second, "SIGINT"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitTask(t, d, cfg.ID)
+}
@@ -0,0 +1,125 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+)
+
+// Duration is a config duration, specified either as a number of seconds or
+// as a string with units, e.g. "500ms" or "2s".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// CodecEncodeSelf encodes the duration as a string with units.
+func (d *Duration) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(time.Duration(*d).String())
+}
+
+// CodecDecodeSelf decodes a number of seconds or a string with units.
+func (d *Duration) CodecDecodeSelf(dec *codec.Decoder) {
+	value, err := parseDuration(decodeUnitValue(dec))
+	if err != nil {
+		panic(err)
+	}
+
+	*d = Duration(value)
+}
+
+// parseDuration parses a number of seconds or a string with units.
+func parseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("duration must be a number of seconds or have units, e.g. 500ms or 2s, but specified %q", s)
+	}
+
+	return d, nil
+}
+
+// Quantity is a config count, specified either as a number or as a string
+// with a metric suffix, e.g. "1M" for a million.
+type Quantity uint64
+
+// quantitySuffixes maps the metric suffixes of quantities to their multipliers.
+var quantitySuffixes = map[string]float64{
+	"k": 1e3,
+	"K": 1e3,
+	"M": 1e6,
+	"G": 1e9,
+	"T": 1e12,
+}
+
+// CodecEncodeSelf encodes the quantity as a number.
+func (q *Quantity) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(uint64(*q))
+}
+
+// CodecDecodeSelf decodes a number or a string with a metric suffix.
+func (q *Quantity) CodecDecodeSelf(dec *codec.Decoder) {
+	value, err := parseQuantity(decodeUnitValue(dec))
+	if err != nil {
+		panic(err)
+	}
+
+	*q = Quantity(value)
+}
+
+// parseQuantity parses a number or a string with a metric suffix.
+func parseQuantity(s string) (uint64, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n, nil
+	}
+
+	number, multiplier := s, 1.0
+
+	for suffix, m := range quantitySuffixes {
+		if trimmed, ok := strings.CutSuffix(s, suffix); ok {
+			number, multiplier = trimmed, m
+
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(number, 64)
+	if f *= multiplier; err != nil || f < 0 || f >= math.MaxUint64 || f != math.Trunc(f) {
+		return 0, fmt.Errorf("quantity must be a whole number, optionally with a k, M, G or T suffix, e.g. 10M, "+
+			"but specified %q", s)
+	}
+
+	return uint64(f), nil
+}
+
+// decodeUnitValue decodes a number or a string as a string. HCL converts
+// numbers to strings for string attributes, but encoded configs may hold
+// numbers.
+func decodeUnitValue(dec *codec.Decoder) string {
+	var value interface{}
+
+	dec.MustDecode(&value)
+
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,98 @@
+package wasm
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUnits(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		err      string
+		expected time.Duration
+	}{
+		{value: "2", expected: 2 * time.Second},
+		{value: "0.5", expected: 500 * time.Millisecond},
+		{value: "500ms", expected: 500 * time.Millisecond},
+		{value: "1m30s", expected: 90 * time.Second},
+		{value: "2 seconds", err: "duration must be a number of seconds or have units"},
+	} {
+		d, err := parseDuration(test.value)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected %q to be rejected with %q, but got %v", test.value, test.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil || d != test.expected {
+			t.Errorf("expected %q to be parsed as %v, but got %v (%v)", test.value, test.expected, d, err)
+		}
+	}
+
+	for _, test := range []struct {
+		value    string
+		err      string
+		expected uint64
+	}{
+		{value: "1000", expected: 1000},
+		{value: "18446744073709551615", expected: 18446744073709551615},
+		{value: "2k", expected: 2000},
+		{value: "1M", expected: 1000000},
+		{value: "1.5M", expected: 1500000},
+		{value: "10G", expected: 10000000000},
+		{value: "1.5", err: "quantity must be a whole number"},
+		{value: "-1M", err: "quantity must be a whole number"},
+		{value: "1Mi", err: "quantity must be a whole number"},
+	} {
+		q, err := parseQuantity(test.value)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected %q to be rejected with %q, but got %v", test.value, test.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil || q != test.expected {
+			t.Errorf("expected %q to be parsed as %d, but got %d (%v)", test.value, test.expected, q, err)
+		}
+	}
+}
+
+func TestConfigUnitsDecoded(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig+`
+fuelBudget {
+  total = "10M"
+}`, nil)
+
+	if total := d.config.FuelBudget.Total; total != 10000000 {
+		t.Errorf("expected a fuel budget of 10M, but got %d", total)
+	}
+
+	cfg := newTestTask(t, "units", `engine = "fake"
+fuelLimit = "1M"
+timeouts {
+  initTimeout = 2
+  runTimeout = "500ms"
+}`)
+
+	var driverConfig TaskConfig
+
+	if err := cfg.DecodeDriverConfig(&driverConfig); err != nil {
+		t.Fatalf("unable to decode task config: %v", err)
+	}
+
+	if driverConfig.FuelLimit != 1000000 {
+		t.Errorf("expected a fuel limit of 1M, but got %d", driverConfig.FuelLimit)
+	}
+
+	timeouts := driverConfig.Timeouts
+	if timeouts.InitTimeout != Duration(2*time.Second) || timeouts.RunTimeout != Duration(500*time.Millisecond) ||
+		timeouts.PostTimeout != 0 {
+		t.Errorf("expected timeouts of 2s, 500ms and 0s, but got %v, %v and %v",
+			timeouts.InitTimeout, timeouts.RunTimeout, timeouts.PostTimeout)
+	}
+}
@@ -0,0 +1,141 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// loadValidationCases returns the cases of the task configuration followed by
+// the cases of the cases file. The cases file must be within the allocation
+// directory.
+func loadValidationCases(cfg *drivers.TaskConfig, conf ValidationConfig) ([]ValidationCase, error) {
+	cases := append([]ValidationCase(nil), conf.Cases...)
+
+	if conf.CasesFile == "" {
+		return cases, nil
+	}
+
+	path, err := resolveTaskPath(cfg, conf.CasesFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve validation cases file %s: %v", conf.CasesFile, err)
+	}
+
+	if !taskPathAllowed(path, cfg, nil) {
+		return nil, fmt.Errorf("validation cases file %s is outside of the task directories", conf.CasesFile)
+	}
+
+	//nolint:gosec
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read validation cases file %s: %v", conf.CasesFile, err)
+	}
+
+	var fileCases []ValidationCase
+	if err := json.Unmarshal(data, &fileCases); err != nil {
+		return nil, fmt.Errorf("unable to parse validation cases file %s: %v", conf.CasesFile, err)
+	}
+
+	return append(cases, fileCases...), nil
+}
+
+// runValidationSuite runs every validation case in its own isolated instance
+// of the module and fails if any output differs from the expected one, so a
+// module which doesn't behave as expected is never deployed. The running case
+// is interrupted once the context is canceled.
+func runValidationSuite(ctx context.Context, logger hclog.Logger, cfg *drivers.TaskConfig, driverConfig TaskConfig,
+	engineName string, info moduleInfo, instanceConf interfaces.InstanceConfig, correlationID string,
+	cases []ValidationCase,
+) error {
+	var failed []string
+
+	for i, validationCase := range cases {
+		name := validationCase.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+
+		err := runValidationCase(ctx, logger, cfg, driverConfig, engineName, info, instanceConf, correlationID,
+			validationCase)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return fmt.Errorf("module validation interrupted in case %s: %w", name, ctxErr)
+			}
+
+			logger.Warn("module validation case failed", "case", name, "error", hclog.Fmt("%+v", err))
+
+			failed = append(failed, name)
+
+			continue
+		}
+
+		logger.Debug("module validation case passed", "case", name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("module validation failed: %d of %d cases failed: [%s]", len(failed), len(cases),
+			strings.Join(failed, ", "))
+	}
+
+	logger.Info("module validation passed", "cases", len(cases))
+
+	return nil
+}
+
+func runValidationCase(ctx context.Context, logger hclog.Logger, cfg *drivers.TaskConfig, driverConfig TaskConfig,
+	engineName string, info moduleInfo, instanceConf interfaces.InstanceConfig, correlationID string,
+	validationCase ValidationCase,
+) error {
+	instance, err := instantiateIsolatedInstance(driverConfig, engineName, instanceConf, correlationID, "", "")
+	if err != nil {
+		return err
+	}
+	defer instance.Cleanup()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ioBufferConf := driverConfig.IOBuffer
+	ioBufferConf.InputValue = validationCase.Input
+
+	// The case runs like the task would, with the case input.
+	h := &taskHandle{
+		ctx:          ctx,
+		cancel:       cancel,
+		taskConfig:   cfg,
+		logger:       logger,
+		instance:     instance,
+		moduleInfo:   info,
+		ioBufferConf: ioBufferConf,
+		mainFunc:     driverConfig.Main,
+		hooks:        driverConfig.Hooks,
+		memoryConf:   driverConfig.Memory,
+		timeouts:     driverConfig.Timeouts,
+	}
+
+	stopInterrupt := context.AfterFunc(ctx, h.stop)
+	defer stopInterrupt()
+
+	out, err := h.execute()
+	if err != nil && !errors.Is(err, errNoOutput) {
+		return err
+	}
+
+	expected := []byte(validationCase.ExpectedOutput)
+	if !bytes.Equal(out, expected) {
+		return fmt.Errorf("output of %d bytes differs from the expected output of %d bytes at byte %d",
+			len(out), len(expected), divergenceOffset(out, expected))
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package wasm
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// validationTaskConfig runs the process function with the validation cases.
+const validationTaskConfig = `engine = "fake"
+main {
+  mainFuncName = ""
+}
+ioBuffer {
+  enabled = true
+  inputValue = "task"
+  processFuncName = "process"
+}
+validation {
+  cases {
+    name           = "upper"
+    input          = "abc"
+    expectedOutput = "%s"
+  }
+}`
+
+func TestValidationSuite(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("alloc", allocAt(1024, nil), interfaces.ValueTypeI32).
+			withFunc("process", upperCase(), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+	})
+
+	for _, test := range []struct {
+		name     string
+		expected string
+		err      string
+	}{
+		{name: "passed", expected: "ABC"},
+		{name: "failed", expected: "abc", err: "module validation failed: 1 of 1 cases failed: [upper]"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestTask(t, test.name, strings.Replace(validationTaskConfig, "%s", test.expected, 1))
+			writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), wasmModule("alloc", "process"))
+
+			result := runTask(t, d, cfg)
+
+			switch {
+			case test.err == "" && result.Err != nil:
+				t.Fatalf("unexpected exit result %+v", result)
+			case test.err != "" && (result.Err == nil || result.Err.Error() != test.err):
+				t.Fatalf("expected the task to fail with %q, but got exit result %+v", test.err, result)
+			}
+		})
+	}
+}
+
+func TestValidationInterruptedByStopTask(t *testing.T) {
+	d := newTestDriver(t, testPluginConfig, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).
+			withFunc("alloc", allocAt(1024, nil), interfaces.ValueTypeI32).
+			withFunc("process", blockUntilStopped(), interfaces.ValueTypeI32, interfaces.ValueTypeI32)
+	})
+
+	cfg := newTestTask(t, "interrupted", strings.Replace(validationTaskConfig, "%s", "ABC", 1))
+	writeTestFile(t, filepath.Join(cfg.TaskDir().Dir, "module.wasm"), wasmModule("alloc", "process"))
+
+	// The validation case blocks, so the task only starts if validation runs
+	// apart from StartTask.
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	handle, _ := d.tasks.Get(cfg.ID)
+
+	if err := d.StopTask(cfg.ID, 0, "SIGKILL"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-handle.completionCh:
+	case <-time.After(testTimeout):
+		t.Fatal("validation wasn't interrupted when the task was stopped")
+	}
+
+	if result := handle.exitResult; result == nil || result.Err == nil ||
+		!strings.Contains(result.Err.Error(), "module validation interrupted in case upper") {
+		t.Errorf("expected the interrupted validation to fail the task, but got exit result %+v", result)
+	}
+}
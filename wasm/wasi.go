@@ -0,0 +1,227 @@
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+// buildWasiOptions returns the WASI environment of the task module, or nil if
+// WASI is disabled. The default preopened directories of the plugin
+// configuration come first, the task can't replace them. Preopened directories
+// of the task must be within the task directories or one of the directories
+// allowed by the plugin configuration.
+func buildWasiOptions(cfg *drivers.TaskConfig, conf WasiConfig, pluginConf PluginWasiConfig, correlationID string,
+) (*interfaces.WasiOptions, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	env := make(map[string]string, len(cfg.Env)+1)
+	for key, value := range cfg.Env {
+		env[key] = value
+	}
+
+	env[correlationIDEnvVar] = correlationID
+
+	opts := &interfaces.WasiOptions{
+		Env:        env,
+		StdoutPath: cfg.StdoutPath,
+		StderrPath: cfg.StderrPath,
+	}
+
+	defaultGuestPaths := make(map[string]bool, len(pluginConf.DefaultPreopenDirs))
+
+	for _, dir := range pluginConf.DefaultPreopenDirs {
+		hostPath, err := resolvePreopenDir(cfg, dir.HostPath)
+		if err != nil {
+			return nil, fmt.Errorf("plugin configuration: %v", err)
+		}
+
+		defaultGuestPaths[dir.GuestPath] = true
+
+		opts.PreopenDirs = append(opts.PreopenDirs, interfaces.PreopenDir{
+			HostPath:  hostPath,
+			GuestPath: dir.GuestPath,
+		})
+	}
+
+	for _, dir := range conf.PreopenDirs {
+		if defaultGuestPaths[dir.GuestPath] {
+			return nil, fmt.Errorf("WASI preopen dir guest path %s is preopened by the plugin configuration", dir.GuestPath)
+		}
+
+		hostPath, err := resolvePreopenDir(cfg, dir.HostPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if !taskPathAllowed(hostPath, cfg, pluginConf.AllowedPreopenDirs) {
+			return nil, fmt.Errorf("WASI preopen dir %s is outside of the task directories and not allowed by the plugin configuration",
+				dir.HostPath)
+		}
+
+		opts.PreopenDirs = append(opts.PreopenDirs, interfaces.PreopenDir{
+			HostPath:  hostPath,
+			GuestPath: dir.GuestPath,
+		})
+	}
+
+	return opts, nil
+}
+
+// resolvePreopenDir returns the resolved host path of the preopened directory,
+// which must exist.
+func resolvePreopenDir(cfg *drivers.TaskConfig, path string) (string, error) {
+	hostPath, err := resolveTaskPath(cfg, path)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve WASI preopen dir %s: %v", path, err)
+	}
+
+	stat, err := os.Stat(hostPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to access WASI preopen dir %s: %v", path, err)
+	}
+
+	if !stat.IsDir() {
+		return "", fmt.Errorf("WASI preopen dir %s is not a directory", path)
+	}
+
+	return hostPath, nil
+}
+
+// resolveTaskPath returns the host path with its symlinks resolved, so a link
+// within the allowed directories can't expose a path outside of them. Relative
+// paths are relative to the task directory.
+func resolveTaskPath(cfg *drivers.TaskConfig, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.TaskDir().Dir, path)
+	}
+
+	return filepath.EvalSymlinks(path)
+}
+
+// taskPathAllowed reports whether the resolved host path is within the task
+// directory, the shared allocation directory or one of the allowed
+// directories. Other tasks of the allocation have their own directories, which
+// the task can't access.
+func taskPathAllowed(hostPath string, cfg *drivers.TaskConfig, allowedDirs []string) bool {
+	taskDir := cfg.TaskDir()
+
+	for _, dir := range append([]string{taskDir.Dir, taskDir.SharedAllocDir}, allowedDirs...) {
+		if dir == "" {
+			continue
+		}
+
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+
+		if isWithin(hostPath, resolved) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isWithin reports whether the path is the directory or is nested in it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// scratchPreopenDirs copies the preopened directories into a new scratch
+// directory, so a run isolated from the task starts from the files the task
+// sees without changing them. It returns the copies and the scratch directory
+// to remove once the run is done.
+func scratchPreopenDirs(dirs []interfaces.PreopenDir) ([]interfaces.PreopenDir, string, error) {
+	scratchDir, err := os.MkdirTemp("", "wasm-scratch-")
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to create scratch dir: %v", err)
+	}
+
+	scratchDirs := make([]interfaces.PreopenDir, 0, len(dirs))
+
+	for i, dir := range dirs {
+		hostPath := filepath.Join(scratchDir, strconv.Itoa(i))
+
+		if err = copyDir(dir.HostPath, hostPath); err != nil {
+			os.RemoveAll(scratchDir)
+
+			return nil, "", fmt.Errorf("unable to copy WASI preopen dir %s: %v", dir.HostPath, err)
+		}
+
+		scratchDirs = append(scratchDirs, interfaces.PreopenDir{
+			HostPath:  hostPath,
+			GuestPath: dir.GuestPath,
+		})
+	}
+
+	return scratchDirs, scratchDir, nil
+}
+
+// copyDir copies the directories and regular files of the source directory
+// into the destination. Other files, like symlinks, aren't copied.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case entry.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+
+		return err
+	}
+
+	return out.Close()
+}
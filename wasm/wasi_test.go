@@ -0,0 +1,181 @@
+package wasm
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+
+	"huawei.com/wasm-task-driver/wasm/interfaces"
+)
+
+func TestTaskPathsConfinedToTaskDirectories(t *testing.T) {
+	cfg := &drivers.TaskConfig{Name: "task", AllocDir: t.TempDir()}
+	taskDir := cfg.TaskDir()
+	otherTaskDir := filepath.Join(cfg.AllocDir, "other")
+	allowedDir := t.TempDir()
+
+	for _, dir := range []string{
+		filepath.Join(taskDir.Dir, "data"),
+		filepath.Join(taskDir.SharedAllocDir, "data"),
+		filepath.Join(otherTaskDir, "data"),
+	} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		writeTestFile(t, filepath.Join(dir, "cases.json"), "[]")
+	}
+
+	writeTestFile(t, filepath.Join(cfg.AllocDir, "cases.json"), "[]")
+
+	if err := os.Symlink(otherTaskDir, filepath.Join(taskDir.Dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		path string
+		err  string
+	}{
+		{name: "task", path: "data"},
+		{name: "shared", path: filepath.Join(taskDir.SharedAllocDir, "data")},
+		{name: "allowed", path: allowedDir},
+		{name: "other task", path: filepath.Join(otherTaskDir, "data"), err: "outside of the task directories"},
+		{name: "alloc", path: cfg.AllocDir, err: "outside of the task directories"},
+		{name: "symlink", path: "link/data", err: "outside of the task directories"},
+		{name: "escape", path: "../other/data", err: "outside of the task directories"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := WasiConfig{
+				Enabled:     true,
+				PreopenDirs: []PreopenDirConfig{{HostPath: test.path, GuestPath: "/data"}},
+			}
+
+			_, err := buildWasiOptions(cfg, conf, PluginWasiConfig{AllowedPreopenDirs: []string{allowedDir}}, "id")
+			checkError(t, "preopen dir", err, test.err)
+
+			// The same directories are allowed for validation cases files.
+			if test.name == "allowed" {
+				return
+			}
+
+			_, err = loadValidationCases(cfg, ValidationConfig{CasesFile: filepath.Join(test.path, "cases.json")})
+			checkError(t, "cases file", err, test.err)
+		})
+	}
+}
+
+func TestDefaultPreopenDirsMergedIntoTaskPreopens(t *testing.T) {
+	referenceDir := t.TempDir()
+
+	invalid, _ := NewPlugin(hclog.NewNullLogger()).(*WasmTaskDriverPlugin)
+
+	if err := setTestConfig(t, invalid, testPluginConfig+`
+wasi {
+  defaultPreopenDirs {
+    hostPath  = "reference"
+    guestPath = "/reference"
+  }
+}`); err == nil || !strings.Contains(err.Error(), "must be absolute") {
+		t.Errorf("expected a relative default preopen dir to be rejected, but got %v", err)
+	}
+
+	d := newTestDriver(t, testPluginConfig+`
+wasi {
+  defaultPreopenDirs {
+    hostPath  = "`+referenceDir+`"
+    guestPath = "/reference"
+  }
+}`, nil)
+
+	useFakeInstances(t, func() *fakeInstance {
+		return newFakeInstance(1).withFunc("handle_buffer", returnValue(int32(0)))
+	})
+
+	cfg := newTestTask(t, "default-preopen", `engine = "fake"
+wasi {
+  enabled = true
+  preopenDirs {
+    hostPath  = "data"
+    guestPath = "/data"
+  }
+}`)
+
+	dataDir := filepath.Join(cfg.TaskDir().Dir, "data")
+	if err := os.Mkdir(dataDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := runTask(t, d, cfg); result.Err != nil {
+		t.Fatalf("unexpected exit result %+v", result)
+	}
+
+	confs := testEngine.instanceConfs()
+	if len(confs) != 1 || confs[0].Wasi == nil {
+		t.Fatalf("expected a WASI instance, but got %+v", confs)
+	}
+
+	resolved := func(path string) string {
+		resolvedPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resolvedPath
+	}
+
+	expected := []interfaces.PreopenDir{
+		{HostPath: resolved(referenceDir), GuestPath: "/reference"},
+		{HostPath: resolved(dataDir), GuestPath: "/data"},
+	}
+
+	if preopens := confs[0].Wasi.PreopenDirs; !reflect.DeepEqual(preopens, expected) {
+		t.Errorf("expected preopen dirs %+v, but got %+v", expected, preopens)
+	}
+
+	// The task can neither replace the default preopen dir nor preopen its host
+	// directory, which isn't allowed for tasks.
+	for _, test := range []struct {
+		name string
+		dir  string
+		err  string
+	}{
+		{name: "replace", dir: `hostPath = "data"
+guestPath = "/reference"`, err: "is preopened by the plugin configuration"},
+		{name: "escape", dir: `hostPath = "` + referenceDir + `"
+guestPath = "/escape"`, err: "outside of the task directories"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestTask(t, test.name, `engine = "fake"
+wasi {
+  enabled = true
+  preopenDirs {
+    `+test.dir+`
+  }
+}`)
+
+			if err := os.Mkdir(filepath.Join(cfg.TaskDir().Dir, "data"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err := d.StartTask(cfg)
+			checkError(t, "start", err, test.err)
+		})
+	}
+}
+
+func checkError(t *testing.T, name string, err error, expected string) {
+	t.Helper()
+
+	switch {
+	case expected == "" && err != nil:
+		t.Errorf("unexpected %s error: %v", name, err)
+	case expected != "" && (err == nil || !strings.Contains(err.Error(), expected)):
+		t.Errorf("expected %s error %q, but got %v", name, expected, err)
+	}
+}